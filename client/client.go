@@ -0,0 +1,211 @@
+// Package client is a small typed client for the GoChat WebSocket protocol.
+//
+// Join is the usual entry point: it returns a Room that delivers messages
+// to OnMessage callbacks, sends heartbeats and reconnects on its own,
+// resuming its membership. Dial opens a single bare connection instead,
+// for tools that want to manage it themselves.
+//
+//	room, err := client.Join(ctx, "ws://localhost:8080/ws", "1234", client.Options{Name: "bot"})
+//	if err != nil {
+//		return err
+//	}
+//	defer room.Close()
+//	room.OnMessage(func(m client.Message) {
+//		if m.Type == "chat" && m.Msg == "!ping" {
+//			_ = room.Chat("pong")
+//		}
+//	})
+//	<-room.Done()
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const writeWait = 10 * time.Second
+
+// Message mirrors the server's JSON envelope.
+type Message struct {
+	Type         string            `json:"type"`
+	ID           string            `json:"id,omitempty"`
+	Room         string            `json:"room,omitempty"`
+	User         string            `json:"user,omitempty"`
+	Msg          string            `json:"msg,omitempty"`
+	Avatar       string            `json:"avatar,omitempty"`
+	TS           string            `json:"ts,omitempty"`
+	Edited       string            `json:"edited,omitempty"`
+	Emote        bool              `json:"emote,omitempty"`
+	Name         string            `json:"name,omitempty"`
+	ContentType  string            `json:"contentType,omitempty"`
+	To           string            `json:"to,omitempty"`
+	From         string            `json:"from,omitempty"`
+	Seq          uint64            `json:"seq,omitempty"`
+	Channel      string            `json:"channel,omitempty"`
+	ParentID     string            `json:"parent_id,omitempty"`
+	Mentions     []string          `json:"mentions,omitempty"`
+	Preview      *LinkPreview      `json:"preview,omitempty"`
+	Reason       string            `json:"reason,omitempty"`
+	Resume       string            `json:"resume,omitempty"`
+	Resumed      bool              `json:"resumed,omitempty"`
+	ClientMsgID  string            `json:"client_msg_id,omitempty"`
+	ServerID     string            `json:"server_id,omitempty"`
+	MsgID        string            `json:"msg_id,omitempty"`
+	Emoji        string            `json:"emoji,omitempty"`
+	Remove       bool              `json:"remove,omitempty"`
+	Counts       map[string]int    `json:"counts,omitempty"`
+	URL          string            `json:"url,omitempty"`
+	ThumbURL     string            `json:"thumb_url,omitempty"`
+	Width        int               `json:"width,omitempty"`
+	Height       int               `json:"height,omitempty"`
+	FileName     string            `json:"filename,omitempty"`
+	Size         int64             `json:"size,omitempty"`
+	Session      string            `json:"session,omitempty"`
+	Code         string            `json:"code,omitempty"`
+	Detail       string            `json:"detail,omitempty"`
+	RetryAfter   int               `json:"retry_after,omitempty"`
+	DisconnectIn int               `json:"disconnect_in,omitempty"`
+	Meta         map[string]string `json:"meta,omitempty"`
+	Features     map[string]bool   `json:"features,omitempty"`
+	Members      []Member          `json:"members,omitempty"`
+}
+
+// LinkPreview summarises the first link in a chat message.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// ConnInfo is a member's connection details, redacted in presence.
+type ConnInfo struct {
+	RemoteIP    string    `json:"remote_ip"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	Transport   string    `json:"transport"`
+	Compression bool      `json:"compression,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Member is one entry of a room's presence list.
+type Member struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	UserID    string            `json:"user_id,omitempty"`
+	Avatar    string            `json:"avatar,omitempty"`
+	Conn      *ConnInfo         `json:"conn,omitempty"`
+	Owner     bool              `json:"owner,omitempty"`
+	Spectator bool              `json:"spectator,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+}
+
+// Conn is a connection to one room. Send is safe for concurrent use;
+// Read must be called from a single goroutine.
+type Conn struct {
+	ws  *websocket.Conn
+	pin string
+
+	writeMu sync.Mutex
+}
+
+// Dial connects to the server's WebSocket endpoint (e.g. ws://host/ws) and
+// joins the room identified by pin.
+func Dial(ctx context.Context, endpoint, pin string) (*Conn, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("client: parse endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("pin", pin)
+	u.RawQuery = q.Encode()
+	return dial(ctx, websocket.DefaultDialer, u, nil)
+}
+
+// dial opens a connection to u, whose query names the room.
+func dial(ctx context.Context, dialer *websocket.Dialer, u *url.URL, header http.Header) (*Conn, error) {
+	ws, resp, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			he := &HandshakeError{Host: u.Host, StatusCode: resp.StatusCode}
+			var body struct {
+				Code   string `json:"code"`
+				Detail string `json:"detail"`
+			}
+			if json.NewDecoder(resp.Body).Decode(&body) == nil {
+				he.Code, he.Detail = body.Code, body.Detail
+			}
+			return nil, he
+		}
+		return nil, fmt.Errorf("client: dial %s: %w", u.Host, err)
+	}
+	return &Conn{ws: ws, pin: u.Query().Get("pin")}, nil
+}
+
+// HandshakeError is returned when the server answers the upgrade with an
+// HTTP error instead, such as 503 while it is in maintenance or 403 for a
+// banned client. Code and Detail are the server's error code and message,
+// if it sent them.
+type HandshakeError struct {
+	Host       string
+	StatusCode int
+	Code       string
+	Detail     string
+}
+
+func (e *HandshakeError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("client: dial %s: upgrade refused with HTTP %d: %s: %s", e.Host, e.StatusCode, e.Code, e.Detail)
+	}
+	return fmt.Sprintf("client: dial %s: upgrade refused with HTTP %d", e.Host, e.StatusCode)
+}
+
+// Pin returns the room this connection joined.
+func (c *Conn) Pin() string { return c.pin }
+
+// Send writes a message to the room.
+func (c *Conn) Send(m Message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteMessage(websocket.TextMessage, b)
+}
+
+// Chat sends a plain-text chat message as user.
+func (c *Conn) Chat(user, text string) error {
+	return c.Send(Message{Type: "chat", User: user, Msg: text})
+}
+
+// Read blocks for the next message from the server.
+func (c *Conn) Read() (Message, error) {
+	var m Message
+	_, b, err := c.ws.ReadMessage()
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("client: decode: %w", err)
+	}
+	return m, nil
+}
+
+// Close sends a normal close frame and closes the connection.
+func (c *Conn) Close() error {
+	c.writeMu.Lock()
+	_ = c.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(writeWait))
+	c.writeMu.Unlock()
+	return c.ws.Close()
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EJ-Edwards/GoChat/server"
+	"github.com/gorilla/websocket"
+)
+
+// testWait bounds how long a test waits for something that should happen.
+const testWait = 5 * time.Second
+
+// startServer runs a GoChat server, changed by configure if set, and
+// returns its WebSocket endpoint.
+func startServer(t *testing.T, configure func(*server.Config)) (*server.Server, string) {
+	t.Helper()
+	cfg := server.DefaultConfig()
+	cfg.StaticDir, cfg.UploadDir = "", ""
+	if configure != nil {
+		configure(cfg)
+	}
+	s, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), testWait)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+	return s, "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+}
+
+// readUntil reads from c until a message of type typ arrives.
+func readUntil(t *testing.T, c *Conn, typ string) Message {
+	t.Helper()
+	_ = c.ws.SetReadDeadline(time.Now().Add(testWait))
+	for {
+		m, err := c.Read()
+		if err != nil {
+			t.Fatalf("waiting for %q: %v", typ, err)
+		}
+		if m.Type == typ {
+			return m
+		}
+	}
+}
+
+func TestDial(t *testing.T) {
+	_, endpoint := startServer(t, nil)
+	ctx := context.Background()
+
+	amy, err := Dial(ctx, endpoint+"?name=amy", "1234")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer amy.Close()
+	if amy.Pin() != "1234" {
+		t.Errorf("Pin = %q, want 1234", amy.Pin())
+	}
+	if welcome := readUntil(t, amy, "system"); welcome.User != "amy" || welcome.Resume == "" {
+		t.Errorf("welcome = %+v, want amy with a resume token", welcome)
+	}
+
+	bob, err := Dial(ctx, endpoint+"?name=bob", "1234")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer bob.Close()
+	readUntil(t, bob, "system")
+	if err := amy.Chat("amy", "hello bob"); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if m := readUntil(t, bob, "chat"); m.User != "amy" || m.Msg != "hello bob" || m.Seq == 0 {
+		t.Errorf("bob got %+v, want amy's chat with a seq", m)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantCode   string
+		wantDetail string
+	}{
+		{name: "with error body", status: http.StatusServiceUnavailable, body: `{"error":"x","code":"maintenance","detail":"back soon"}`, wantCode: "maintenance", wantDetail: "back soon"},
+		{name: "without body", status: http.StatusForbidden},
+		{name: "not json", status: http.StatusBadGateway, body: "<html>bad gateway</html>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer ts.Close()
+
+			_, err := Dial(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", "1234")
+			var he *HandshakeError
+			if !errors.As(err, &he) {
+				t.Fatalf("Dial error = %v, want a HandshakeError", err)
+			}
+			if he.StatusCode != tt.status || he.Code != tt.wantCode || he.Detail != tt.wantDetail {
+				t.Errorf("HandshakeError = %+v, want status %d code %q detail %q", he, tt.status, tt.wantCode, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network error", err: errors.New("connection reset"), want: true},
+		{name: "going away", err: &websocket.CloseError{Code: websocket.CloseGoingAway}, want: true},
+		{name: "service restart", err: &websocket.CloseError{Code: websocket.CloseServiceRestart}, want: true},
+		{name: "try again later", err: &websocket.CloseError{Code: websocket.CloseTryAgainLater}, want: true},
+		{name: "timed out", err: &websocket.CloseError{Code: 4004}, want: true},
+		{name: "normal closure", err: &websocket.CloseError{Code: websocket.CloseNormalClosure}, want: false},
+		{name: "flooding", err: &websocket.CloseError{Code: websocket.ClosePolicyViolation}, want: false},
+		{name: "auth failed", err: &websocket.CloseError{Code: closeAuthFailed}, want: false},
+		{name: "kicked", err: &websocket.CloseError{Code: closeKicked}, want: false},
+		{name: "banned", err: &websocket.CloseError{Code: closeBanned}, want: false},
+		{name: "name taken", err: &websocket.CloseError{Code: closeNameTaken}, want: false},
+		{name: "room closed", err: &websocket.CloseError{Code: closeRoomClosed}, want: false},
+		{name: "idle", err: &websocket.CloseError{Code: closeIdle}, want: false},
+		{name: "refused", err: &RefusedError{Code: "name_taken"}, want: false},
+		{name: "maintenance", err: &HandshakeError{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "too many connections", err: &HandshakeError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "server error", err: &HandshakeError{StatusCode: http.StatusInternalServerError}, want: true},
+		{name: "forbidden", err: &HandshakeError{StatusCode: http.StatusForbidden}, want: false},
+		{name: "bad request", err: &HandshakeError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "wrapped", err: fmt.Errorf("serve: %w", &websocket.CloseError{Code: closeBanned}), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
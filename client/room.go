@@ -0,0 +1,403 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Defaults for Options left zero.
+const (
+	defaultPingInterval = 30 * time.Second
+	defaultMaxBackoff   = 30 * time.Second
+	minBackoff          = 500 * time.Millisecond
+)
+
+// ErrNotConnected is returned by Send while a Room is reconnecting.
+var ErrNotConnected = errors.New("client: not connected")
+
+// ErrClosed is returned by Send after Close.
+var ErrClosed = errors.New("client: room closed")
+
+// Options configure Join. The zero value joins under a generated guest
+// name with the default heartbeat and reconnect backoff.
+type Options struct {
+	// Name is the display name; the server assigns a guest name if empty.
+	Name string
+	// Token is a JWT from the server's /api/token, for signed-in users.
+	Token string
+	// Password is the room password, if it has one.
+	Password string
+	// Channels are the channels to follow; the server's default if empty.
+	Channels []string
+	// Header is sent with every upgrade request, e.g. an Origin.
+	Header http.Header
+	// Dialer opens the connections; websocket.DefaultDialer if nil.
+	Dialer *websocket.Dialer
+
+	// PingInterval is how often a heartbeat ping is sent. A connection
+	// that hears nothing for twice this long is treated as dead.
+	PingInterval time.Duration
+	// MaxBackoff caps the wait between reconnect attempts.
+	MaxBackoff time.Duration
+	// NoReconnect closes the Room on the first disconnect instead.
+	NoReconnect bool
+
+	// OnMessage, if set, is registered as if by Room.OnMessage before the
+	// first connection is read, so it also sees the welcome.
+	OnMessage func(Message)
+}
+
+// Room is a member's seat in one room that survives dropped connections:
+// it sends heartbeats, and when the connection fails it reconnects with
+// backoff, resuming the same membership and replaying the chat it missed
+// where the server allows. Methods are safe for concurrent use.
+type Room struct {
+	endpoint *url.URL
+	opts     Options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error // why the Room closed; set before done is closed
+
+	mu           sync.Mutex
+	conn         *Conn
+	resume       string
+	lastSeq      uint64
+	onMessage    []func(Message)
+	onDisconnect []func(error)
+	onReconnect  []func()
+}
+
+// Join connects to the server's WebSocket endpoint (e.g. ws://host/ws) as
+// a member of the room identified by pin, returning once the first
+// connection is up. Messages that arrive before Room.OnMessage is called
+// are not delivered to it; use Options.OnMessage to see every one.
+func Join(ctx context.Context, endpoint, pin string, opts Options) (*Room, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("client: parse endpoint: %w", err)
+	}
+	if opts.Dialer == nil {
+		opts.Dialer = websocket.DefaultDialer
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = defaultPingInterval
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+	q := u.Query()
+	q.Set("pin", pin)
+	if opts.Name != "" {
+		q.Set("name", opts.Name)
+	}
+	if opts.Token != "" {
+		q.Set("token", opts.Token)
+	}
+	if opts.Password != "" {
+		q.Set("password", opts.Password)
+	}
+	if len(opts.Channels) > 0 {
+		q.Set("channels", strings.Join(opts.Channels, ","))
+	}
+	u.RawQuery = q.Encode()
+
+	r := &Room{endpoint: u, opts: opts, done: make(chan struct{})}
+	if opts.OnMessage != nil {
+		r.onMessage = append(r.onMessage, opts.OnMessage)
+	}
+	conn, err := r.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.conn = conn
+	go r.run(conn)
+	return r, nil
+}
+
+// Pin returns the room's PIN.
+func (r *Room) Pin() string { return r.endpoint.Query().Get("pin") }
+
+// OnMessage registers fn to be called with every message from the server,
+// in order, on the Room's reader goroutine. fn must not block for long:
+// heartbeats are not answered while it runs.
+func (r *Room) OnMessage(fn func(Message)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onMessage = append(r.onMessage, fn)
+}
+
+// OnDisconnect registers fn to be called with the error that ended each
+// connection, before any reconnect.
+func (r *Room) OnDisconnect(fn func(error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onDisconnect = append(r.onDisconnect, fn)
+}
+
+// OnReconnect registers fn to be called each time a new connection is up.
+func (r *Room) OnReconnect(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onReconnect = append(r.onReconnect, fn)
+}
+
+// Send writes a message to the room, or returns ErrNotConnected while the
+// Room is between connections. Messages are not queued across reconnects;
+// set ClientMsgID and watch for the ack to know one was accepted.
+func (r *Room) Send(m Message) error {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	select {
+	case <-r.done:
+		return ErrClosed
+	default:
+	}
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return conn.Send(m)
+}
+
+// Chat sends a plain-text chat message.
+func (r *Room) Chat(text string) error {
+	return r.Send(Message{Type: "chat", Msg: text})
+}
+
+// Done is closed once the Room has stopped for good.
+func (r *Room) Done() <-chan struct{} { return r.done }
+
+// Err returns why the Room stopped, once Done is closed: nil after Close,
+// otherwise the error that ended the last connection.
+func (r *Room) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+// Close leaves the room and stops reconnecting.
+func (r *Room) Close() error {
+	r.cancel()
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	var err error
+	if conn != nil {
+		// Leaving, rather than just closing, frees the name at once
+		// instead of holding it for a resume.
+		_ = conn.Send(Message{Type: "leave"})
+		err = conn.Close()
+	}
+	<-r.done
+	return err
+}
+
+// run reads from conn until it fails, then reconnects until the Room is
+// closed or a reconnect is pointless.
+func (r *Room) run(conn *Conn) {
+	defer close(r.done)
+	for {
+		err := r.serve(conn)
+		r.mu.Lock()
+		r.conn = nil
+		handlers := r.onDisconnect
+		r.mu.Unlock()
+		if r.ctx.Err() != nil {
+			return
+		}
+		for _, fn := range handlers {
+			fn(err)
+		}
+		if r.opts.NoReconnect || !retryable(err) {
+			r.err = err
+			return
+		}
+
+		// 1012 means the server is draining and others are ready now.
+		var ce *websocket.CloseError
+		first := errors.As(err, &ce) && ce.Code == websocket.CloseServiceRestart
+		if conn, err = r.reconnect(first); err != nil {
+			if r.ctx.Err() == nil {
+				r.err = err
+			}
+			return
+		}
+		r.mu.Lock()
+		r.conn = conn
+		reconnected := r.onReconnect
+		r.mu.Unlock()
+		// Close may have run before conn was stored.
+		if r.ctx.Err() != nil {
+			_ = conn.Close()
+			return
+		}
+		for _, fn := range reconnected {
+			fn()
+		}
+	}
+}
+
+// reconnect dials until it succeeds, the Room is closed or the server
+// refuses for good. With now set the first attempt is made at once.
+func (r *Room) reconnect(now bool) (*Conn, error) {
+	backoff := minBackoff
+	for {
+		if !now {
+			// Jittered, so a room's members do not all return together.
+			wait := rand.N(backoff) + backoff/2
+			select {
+			case <-time.After(wait):
+			case <-r.ctx.Done():
+				return nil, r.ctx.Err()
+			}
+			backoff = min(backoff*2, r.opts.MaxBackoff)
+		}
+		now = false
+		conn, err := r.dial(r.ctx)
+		if err == nil {
+			return conn, nil
+		}
+		if !retryable(err) {
+			return nil, err
+		}
+	}
+}
+
+// dial opens a connection, resuming the previous one if there was one.
+func (r *Room) dial(ctx context.Context) (*Conn, error) {
+	u := *r.endpoint
+	r.mu.Lock()
+	if r.resume != "" {
+		q := u.Query()
+		q.Set("resume", r.resume)
+		if r.lastSeq > 0 {
+			q.Set("last_seq", strconv.FormatUint(r.lastSeq, 10))
+		}
+		u.RawQuery = q.Encode()
+	}
+	r.mu.Unlock()
+	return dial(ctx, r.opts.Dialer, &u, r.opts.Header)
+}
+
+// serve reads messages from conn, with heartbeats, until it fails.
+func (r *Room) serve(conn *Conn) error {
+	deadline := 2 * r.opts.PingInterval
+	_ = conn.ws.SetReadDeadline(time.Now().Add(deadline))
+	conn.ws.SetPingHandler(func(data string) error {
+		_ = conn.ws.SetReadDeadline(time.Now().Add(deadline))
+		return conn.ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.heartbeat(conn, stop)
+
+	var refusal *RefusedError
+	for {
+		m, err := conn.Read()
+		if err != nil {
+			_ = conn.ws.Close()
+			if refusal != nil {
+				return refusal
+			}
+			return err
+		}
+		if m.Type == "error" && m.Code == "name_taken" {
+			refusal = &RefusedError{Code: m.Code, Msg: m.Detail}
+		}
+		_ = conn.ws.SetReadDeadline(time.Now().Add(deadline))
+		r.mu.Lock()
+		if m.Resume != "" {
+			r.resume = m.Resume
+		}
+		if m.Seq > r.lastSeq && m.Type != "ack" {
+			r.lastSeq = m.Seq
+		}
+		handlers := r.onMessage
+		r.mu.Unlock()
+		for _, fn := range handlers {
+			fn(m)
+		}
+	}
+}
+
+// heartbeat sends an application ping every PingInterval until stop is
+// closed, so a connection that has silently died is noticed.
+func (r *Room) heartbeat(conn *Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(r.opts.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			ts, _ := json.Marshal(now.UnixMilli())
+			if err := conn.Send(Message{Type: "ping", TS: string(ts)}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// RefusedError ends a Room whose join the server turned down after the
+// upgrade, such as for a name already in use.
+type RefusedError struct {
+	Code string
+	Msg  string
+}
+
+func (e *RefusedError) Error() string { return "client: join refused: " + e.Msg }
+
+// Close codes the server uses for refusals that reconnecting cannot fix.
+const (
+	// closeAuthFailed is a wrong room password or a missing sign-in.
+	closeAuthFailed = 4001
+	closeKicked     = 4002
+	closeBanned     = 4003
+	closeNameTaken  = 4005
+	// closeRoomClosed is a room an admin closed or that expired.
+	closeRoomClosed = 4006
+	// closeIdle is a member who sent no chat for the server's idle timeout.
+	closeIdle = 4007
+)
+
+// retryable reports whether reconnecting after err could help. A server
+// that refuses the client (kicked, banned, wrong password, name taken,
+// flooding, room closed, idle) is not asked again, nor is one that closed
+// the connection normally.
+func retryable(err error) bool {
+	var re *RefusedError
+	if errors.As(err, &re) {
+		return false
+	}
+	var he *HandshakeError
+	if errors.As(err, &he) {
+		return he.StatusCode == http.StatusServiceUnavailable || he.StatusCode == http.StatusTooManyRequests || he.StatusCode >= 500
+	}
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		switch ce.Code {
+		case websocket.CloseNormalClosure, websocket.ClosePolicyViolation,
+			closeAuthFailed, closeKicked, closeBanned, closeNameTaken, closeRoomClosed, closeIdle:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,248 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/EJ-Edwards/GoChat/server"
+	"github.com/gorilla/websocket"
+)
+
+// recorder collects what a Room's OnMessage callback is given.
+type recorder chan Message
+
+func newRecorder() recorder { return make(recorder, 1024) }
+
+func (r recorder) add(m Message) { r <- m }
+
+// wait returns the first message for which match is true.
+func (r recorder) wait(t *testing.T, what string, match func(Message) bool) Message {
+	t.Helper()
+	timeout := time.After(testWait)
+	for {
+		select {
+		case m := <-r:
+			if match(m) {
+				return m
+			}
+		case <-timeout:
+			t.Fatalf("never got %s", what)
+		}
+	}
+}
+
+func ofType(typ string) func(Message) bool {
+	return func(m Message) bool { return m.Type == typ }
+}
+
+func chatText(text string) func(Message) bool {
+	return func(m Message) bool { return m.Type == "chat" && m.Msg == text }
+}
+
+// join joins pin as name, recording every message, and closes the Room
+// when the test ends.
+func join(t *testing.T, endpoint, pin string, opts Options) (*Room, recorder) {
+	t.Helper()
+	rec := newRecorder()
+	opts.OnMessage = rec.add
+	r, err := Join(context.Background(), endpoint, pin, opts)
+	if err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, rec
+}
+
+// waitDone waits for r to stop by itself.
+func waitDone(t *testing.T, r *Room) {
+	t.Helper()
+	select {
+	case <-r.Done():
+	case <-time.After(testWait):
+		t.Fatal("the Room never stopped")
+	}
+}
+
+func TestRoomChat(t *testing.T) {
+	_, endpoint := startServer(t, nil)
+	amy, amyRec := join(t, endpoint, "1234", Options{Name: "amy"})
+	if welcome := amyRec.wait(t, "amy's welcome", ofType("system")); welcome.User != "amy" {
+		t.Errorf("welcome for %q, want amy", welcome.User)
+	}
+	if amy.Pin() != "1234" {
+		t.Errorf("Pin = %q, want 1234", amy.Pin())
+	}
+	bob, bobRec := join(t, endpoint, "1234", Options{Name: "bob"})
+	bobRec.wait(t, "bob's welcome", ofType("system"))
+
+	// Callbacks registered later see later messages.
+	later := newRecorder()
+	bob.OnMessage(later.add)
+	if err := amy.Chat("hello bob"); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	for _, rec := range []recorder{bobRec, later} {
+		if m := rec.wait(t, "amy's chat", chatText("hello bob")); m.User != "amy" {
+			t.Errorf("chat from %q, want amy", m.User)
+		}
+	}
+
+	if err := amy.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := amy.Err(); err != nil {
+		t.Errorf("Err after Close = %v, want nil", err)
+	}
+	if err := amy.Chat("too late"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Chat after Close = %v, want ErrClosed", err)
+	}
+	// Close leaves, so bob sees a departure rather than a drop.
+	left := bobRec.wait(t, "amy leaving", func(m Message) bool { return m.Type == "left" && m.User == "amy" })
+	if left.Reason != "client_leave" {
+		t.Errorf("amy left with reason %q, want client_leave", left.Reason)
+	}
+}
+
+func TestRoomHeartbeat(t *testing.T) {
+	_, endpoint := startServer(t, nil)
+	_, rec := join(t, endpoint, "1234", Options{Name: "amy", PingInterval: 20 * time.Millisecond})
+	for range 3 {
+		rec.wait(t, "a pong", ofType("pong"))
+	}
+}
+
+func TestRoomReconnects(t *testing.T) {
+	_, endpoint := startServer(t, nil)
+	amy, amyRec := join(t, endpoint, "1234", Options{Name: "amy", MaxBackoff: time.Second})
+	amyRec.wait(t, "amy's welcome", ofType("system"))
+	bob, bobRec := join(t, endpoint, "1234", Options{Name: "bob"})
+	bobRec.wait(t, "bob's welcome", ofType("system"))
+	// Chat amy has seen gives her a cursor to resume from.
+	if err := bob.Chat("hi amy"); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	amyRec.wait(t, "bob's greeting", chatText("hi amy"))
+
+	disconnected, reconnected := make(chan error, 1), make(chan struct{}, 1)
+	amy.OnDisconnect(func(err error) { disconnected <- err })
+	amy.OnReconnect(func() { reconnected <- struct{}{} })
+
+	// Drop amy's connection under her, as a network failure would.
+	amy.mu.Lock()
+	amy.conn.ws.UnderlyingConn().Close()
+	amy.mu.Unlock()
+	select {
+	case <-disconnected:
+	case <-time.After(testWait):
+		t.Fatal("OnDisconnect was not called")
+	}
+	if err := bob.Chat("while you were out"); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	select {
+	case <-reconnected:
+	case <-time.After(testWait):
+		t.Fatal("OnReconnect was not called")
+	}
+	// Missed chat is replayed ahead of the welcome; what comes after
+	// arrives live.
+	amyRec.wait(t, "the missed chat", chatText("while you were out"))
+	if welcome := amyRec.wait(t, "amy's second welcome", ofType("system")); !welcome.Resumed || welcome.User != "amy" {
+		t.Errorf("second welcome = %+v, want amy resumed", welcome)
+	}
+	if err := bob.Chat("welcome back"); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	amyRec.wait(t, "chat after the reconnect", chatText("welcome back"))
+	if err := amy.Chat("thanks"); err != nil {
+		t.Errorf("Chat after the reconnect: %v", err)
+	}
+}
+
+func TestRoomStops(t *testing.T) {
+	const token = "secret"
+	tests := []struct {
+		name string
+		// stop makes the server end amy's connection.
+		stop    func(t *testing.T, endpoint string, amy *Room)
+		opts    Options
+		wantErr func(error) bool
+	}{
+		{
+			name: "name taken",
+			stop: func(t *testing.T, endpoint string, _ *Room) {
+				imposter, err := Join(context.Background(), endpoint, "1234", Options{Name: "amy"})
+				if err != nil {
+					t.Fatalf("Join: %v", err)
+				}
+				waitDone(t, imposter)
+				var re *RefusedError
+				if err := imposter.Err(); !errors.As(err, &re) || re.Code != "name_taken" {
+					t.Errorf("imposter stopped with %v, want name_taken", err)
+				}
+			},
+			wantErr: nil, // amy keeps her seat
+		},
+		{
+			name: "room closed",
+			stop: func(t *testing.T, endpoint string, _ *Room) {
+				req, _ := http.NewRequest("DELETE", "http"+strings.TrimPrefix(strings.TrimSuffix(endpoint, "/ws"), "ws")+"/api/admin/rooms/1234", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			},
+			wantErr: func(err error) bool {
+				var ce *websocket.CloseError
+				return errors.As(err, &ce) && ce.Code == closeRoomClosed
+			},
+		},
+		{
+			name: "drop without reconnect",
+			stop: func(t *testing.T, _ string, amy *Room) {
+				amy.mu.Lock()
+				amy.conn.ws.UnderlyingConn().Close()
+				amy.mu.Unlock()
+			},
+			opts:    Options{NoReconnect: true},
+			wantErr: func(err error) bool { return err != nil },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, endpoint := startServer(t, func(cfg *server.Config) { cfg.AdminToken = token })
+			opts := tt.opts
+			opts.Name = "amy"
+			amy, rec := join(t, endpoint, "1234", opts)
+			rec.wait(t, "amy's welcome", ofType("system"))
+			var reconnects atomic.Int32
+			amy.OnReconnect(func() { reconnects.Add(1) })
+
+			tt.stop(t, endpoint, amy)
+			if tt.wantErr == nil {
+				if err := amy.Chat("still here"); err != nil {
+					t.Fatalf("Chat: %v", err)
+				}
+				rec.wait(t, "amy's own chat", chatText("still here"))
+				return
+			}
+			waitDone(t, amy)
+			if err := amy.Err(); !tt.wantErr(err) {
+				t.Errorf("Err = %v", err)
+			}
+			if n := reconnects.Load(); n != 0 {
+				t.Errorf("reconnected %d times, want none", n)
+			}
+			if err := amy.Chat("anyone?"); !errors.Is(err, ErrClosed) {
+				t.Errorf("Chat after stopping = %v, want ErrClosed", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+// Command gochat-cli is a line-based terminal client for GoChat. It joins
+// one room, prints what arrives and sends each line typed as chat.
+//
+// Lines starting with / go to the server as commands (/nick, /who, /me,
+// /help and the rest), except for two handled locally: /members lists the
+// room from the last presence update, and /quit leaves.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/EJ-Edwards/GoChat/client"
+)
+
+func main() {
+	var opts client.Options
+	endpoint := flag.String("url", "ws://localhost:8080/ws", "WebSocket endpoint")
+	pin := flag.String("pin", "", "room PIN to join (required)")
+	channels := flag.String("channels", "", "comma-separated channels to follow")
+	flag.StringVar(&opts.Name, "name", "", "display name; the server picks a guest name if empty")
+	flag.StringVar(&opts.Token, "token", "", "JWT from /api/token, for a signed-in user")
+	flag.StringVar(&opts.Password, "password", "", "room password")
+	flag.Parse()
+	if *pin == "" {
+		fmt.Fprintln(os.Stderr, "usage: gochat-cli -pin <room> [-name <name>] [-url ws://host/ws]")
+		os.Exit(2)
+	}
+	if *channels != "" {
+		opts.Channels = strings.Split(*channels, ",")
+	}
+
+	t := &terminal{out: os.Stdout}
+	opts.OnMessage = t.show
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	room, err := client.Join(ctx, *endpoint, *pin, opts)
+	cancel()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	room.OnDisconnect(func(err error) { t.printf("* disconnected: %v", err) })
+	room.OnReconnect(func() { t.printf("* reconnected") })
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	for {
+		select {
+		case <-sig:
+			_ = room.Close()
+			return
+		case <-room.Done():
+			fmt.Fprintln(os.Stderr, "connection closed:", room.Err())
+			os.Exit(1)
+		case line, ok := <-lines:
+			if !ok || strings.TrimSpace(line) == "/quit" {
+				_ = room.Close()
+				return
+			}
+			if strings.TrimSpace(line) == "/members" {
+				t.members()
+				continue
+			}
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if err := room.Chat(line); err != nil {
+				t.printf("* not sent: %v", err)
+			}
+		}
+	}
+}
+
+// terminal prints messages as they arrive and remembers the room's
+// presence list for /members.
+type terminal struct {
+	mu       sync.Mutex
+	out      io.Writer
+	presence []client.Member
+}
+
+func (t *terminal) printf(format string, args ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.out, format+"\n", args...)
+}
+
+// show prints one message from the server.
+func (t *terminal) show(m client.Message) {
+	at := time.Now().Format("15:04")
+	if ts, err := time.Parse(time.RFC3339Nano, m.TS); err == nil {
+		at = ts.Local().Format("15:04")
+	}
+	switch m.Type {
+	case "chat":
+		channel := ""
+		if m.Channel != "" && m.Channel != "general" {
+			channel = "#" + m.Channel + " "
+		}
+		if m.Emote {
+			t.printf("[%s] %s* %s %s", at, channel, m.User, m.Msg)
+		} else {
+			t.printf("[%s] %s<%s> %s", at, channel, m.User, m.Msg)
+		}
+	case "dm":
+		t.printf("[%s] [dm] <%s> %s", at, m.User, m.Msg)
+	case "file":
+		t.printf("[%s] <%s> shared %s (%d bytes): %s", at, m.User, m.FileName, m.Size, m.URL)
+	case "system", "announcement", "motd", "idle_warning":
+		t.printf("* %s", m.Msg)
+	case "joined":
+		t.printf("* %s joined", m.User)
+	case "left":
+		t.printf("* %s left", m.User)
+	case "renamed":
+		t.printf("* %s is now %s", m.User, m.Name)
+	case "mention":
+		t.printf("\a* %s mentioned you", m.User)
+	case "error":
+		t.printf("! %s (%s)", m.Detail, m.Code)
+	case "presence":
+		t.mu.Lock()
+		t.presence = m.Members
+		t.mu.Unlock()
+	}
+}
+
+// members prints the room's last known presence list.
+func (t *terminal) members() {
+	t.mu.Lock()
+	names := make([]string, 0, len(t.presence))
+	for _, m := range t.presence {
+		names = append(names, m.Name)
+	}
+	t.mu.Unlock()
+	sort.Strings(names)
+	t.printf("* %d in the room: %s", len(names), strings.Join(names, ", "))
+}
@@ -0,0 +1,231 @@
+// Command loadtest opens many GoChat connections across several rooms,
+// sends chat traffic at a fixed rate and reports connect success, message
+// round-trip latency percentiles, delivery throughput, drops and error
+// counts.
+//
+// Drops are counted two ways: messages a connection sent that never came
+// back to it, and gaps in the room's seq numbers as each connection saw
+// them, which is chat the server accepted but did not deliver.
+//
+// With -slow, extra connections join each room and never read, so their
+// buffers fill up. Latency for everyone else should not change while the
+// server sheds them.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/EJ-Edwards/GoChat/client"
+)
+
+type config struct {
+	url      string
+	conns    int
+	rooms    int
+	rate     float64
+	slow     int
+	duration time.Duration
+	grace    time.Duration
+}
+
+type report struct {
+	attempted, connected int64
+	sent, received       int64
+	echoed               int64
+	gaps                 int64
+	errors               int64
+	elapsed              time.Duration
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.url, "url", "ws://localhost:8080/ws", "WebSocket endpoint")
+	flag.IntVar(&cfg.conns, "conns", 50, "number of connections")
+	flag.IntVar(&cfg.rooms, "rooms", 5, "number of rooms to spread connections across")
+	flag.Float64Var(&cfg.rate, "rate", 1, "messages per second per connection")
+	flag.IntVar(&cfg.slow, "slow", 0, "extra connections per room that never read")
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long to send traffic")
+	flag.DurationVar(&cfg.grace, "grace", time.Second, "how long to wait for in-flight messages after sending stops")
+	flag.Parse()
+
+	if cfg.conns < 1 || cfg.rooms < 1 || cfg.rate <= 0 || cfg.duration <= 0 || cfg.slow < 0 || cfg.grace < 0 {
+		fmt.Fprintln(os.Stderr, "conns, rooms, rate and duration must be positive and slow and grace not negative")
+		os.Exit(2)
+	}
+
+	r := run(context.Background(), cfg)
+	r.print(os.Stdout)
+	if r.errors > 0 || r.connected < r.attempted {
+		os.Exit(1)
+	}
+}
+
+// run drives the load and blocks until every connection has finished.
+func run(ctx context.Context, cfg config) *report {
+	r := &report{}
+	ctx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.rooms*cfg.slow; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			r.sluggard(ctx, cfg, id)
+		}(i)
+	}
+	for i := 0; i < cfg.conns; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			r.worker(ctx, cfg, id)
+		}(i)
+	}
+	wg.Wait()
+	r.elapsed = time.Since(start)
+	return r
+}
+
+// sluggard joins a room and never reads, standing in for a client on a
+// stalled network.
+func (r *report) sluggard(ctx context.Context, cfg config, id int) {
+	pin := "load-" + strconv.Itoa(id%cfg.rooms)
+	conn, err := client.Dial(ctx, withName(cfg.url, "slow"+strconv.Itoa(id)), pin)
+	if err != nil {
+		log.Printf("slow conn %d: %v", id, err)
+		return
+	}
+	defer conn.Close()
+	<-ctx.Done()
+}
+
+// withName adds a display name to the endpoint's query.
+func withName(endpoint, name string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	q := u.Query()
+	q.Set("name", name)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// worker is one simulated user. It times its own messages as the room
+// echoes them back.
+func (r *report) worker(ctx context.Context, cfg config, id int) {
+	atomic.AddInt64(&r.attempted, 1)
+	pin := "load-" + strconv.Itoa(id%cfg.rooms)
+	user := "load" + strconv.Itoa(id)
+	conn, err := client.Dial(ctx, withName(cfg.url, user), pin)
+	if err != nil {
+		atomic.AddInt64(&r.errors, 1)
+		log.Printf("conn %d: %v", id, err)
+		return
+	}
+	atomic.AddInt64(&r.connected, 1)
+	defer conn.Close()
+
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		// lastSeq is the newest chat seen; history replayed on join
+		// comes first, in order, so gaps count from there.
+		var lastSeq uint64
+		for {
+			m, err := conn.Read()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&r.received, 1)
+			if m.Type == "error" {
+				atomic.AddInt64(&r.errors, 1)
+				continue
+			}
+			if m.Type != "chat" {
+				continue
+			}
+			if lastSeq > 0 && m.Seq > lastSeq+1 {
+				atomic.AddInt64(&r.gaps, int64(m.Seq-lastSeq-1))
+			}
+			lastSeq = max(lastSeq, m.Seq)
+			if m.User != user {
+				continue
+			}
+			if sent, ok := strings.CutPrefix(m.Msg, "t="); ok {
+				if ns, err := strconv.ParseInt(sent, 10, 64); err == nil {
+					atomic.AddInt64(&r.echoed, 1)
+					r.observe(time.Since(time.Unix(0, ns)))
+				}
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.rate))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Give in-flight echoes a moment before closing.
+			select {
+			case <-time.After(cfg.grace):
+			case <-readDone:
+			}
+			return
+		case <-readDone:
+			atomic.AddInt64(&r.errors, 1)
+			return
+		case <-ticker.C:
+			if err := conn.Chat(user, "t="+strconv.FormatInt(time.Now().UnixNano(), 10)); err != nil {
+				atomic.AddInt64(&r.errors, 1)
+				return
+			}
+			atomic.AddInt64(&r.sent, 1)
+		}
+	}
+}
+
+func (r *report) observe(d time.Duration) {
+	r.mu.Lock()
+	r.latencies = append(r.latencies, d)
+	r.mu.Unlock()
+}
+
+func (r *report) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(r.latencies)-1))
+	return r.latencies[i]
+}
+
+func (r *report) print(w *os.File) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+
+	fmt.Fprintf(w, "connections: %d/%d (%.1f%%)\n", r.connected, r.attempted,
+		100*float64(r.connected)/float64(max(r.attempted, 1)))
+	fmt.Fprintf(w, "messages:    sent=%d received=%d timed=%d\n", r.sent, r.received, len(r.latencies))
+	fmt.Fprintf(w, "latency:     p50=%v p90=%v p99=%v max=%v\n",
+		r.percentile(50), r.percentile(90), r.percentile(99), r.percentile(100))
+	fmt.Fprintf(w, "throughput:  %.0f msg/s delivered\n", float64(r.received)/max(r.elapsed.Seconds(), 1e-9))
+	fmt.Fprintf(w, "drops:       unechoed=%d (%.2f%%) seq_gaps=%d\n", r.sent-r.echoed,
+		100*float64(r.sent-r.echoed)/float64(max(r.sent, 1)), r.gaps)
+	fmt.Fprintf(w, "errors:      %d\n", r.errors)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EJ-Edwards/GoChat/server"
+)
+
+func TestRunAgainstServer(t *testing.T) {
+	tests := []struct {
+		name  string
+		conns int
+		rooms int
+	}{
+		{name: "one room", conns: 4, rooms: 1},
+		{name: "several rooms", conns: 6, rooms: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scfg := server.DefaultConfig()
+			scfg.StaticDir, scfg.UploadDir = "", ""
+			s, err := server.New(scfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts := httptest.NewServer(s.Handler())
+			defer func() {
+				ts.Close()
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				s.Shutdown(ctx)
+			}()
+
+			r := run(context.Background(), config{
+				url:      "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws",
+				conns:    tt.conns,
+				rooms:    tt.rooms,
+				rate:     20,
+				duration: 500 * time.Millisecond,
+			})
+			if r.connected != r.attempted || r.attempted != int64(tt.conns) {
+				t.Errorf("connected %d of %d, want all %d", r.connected, r.attempted, tt.conns)
+			}
+			if r.errors != 0 {
+				t.Errorf("errors = %d, want none", r.errors)
+			}
+			if r.sent == 0 || int64(len(r.latencies)) != r.sent {
+				t.Errorf("timed %d of %d sent, want all", len(r.latencies), r.sent)
+			}
+		})
+	}
+}
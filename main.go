@@ -2,280 +2,175 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
-	"strings"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/EJ-Edwards/GoChat/server"
 )
 
-const (
-	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = 54 * time.Second // < pongWait
-	maxMessageSize = 1024 * 8
-)
-
-// --- Origin check ---
-func allowOrigin(r *http.Request) bool {
-	origin := r.Header.Get("Origin")
-	if origin == "" {
-		return true // same-origin or CLI
+func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "TOML config file; environment variables override it")
+	vapidKeys := flag.Bool("vapid-keys", false, "print a new VAPID key pair for Web Push and exit")
+	flag.Parse()
+	if *vapidKeys {
+		public, private, err := server.GenerateVAPIDKeys()
+		if err != nil {
+			fatal("generating VAPID keys failed", err)
+		}
+		fmt.Printf("VAPID_PRIVATE_KEY=%s\n# public key, served at /api/push/key: %s\n", private, public)
+		return
 	}
-
-	u, err := url.Parse(origin)
+	cfg, err := server.LoadConfig(*configPath)
 	if err != nil {
-		return false
+		fatal("cannot start", err)
 	}
+	slog.SetDefault(newLogger(cfg.LogLevel, cfg.LogFormat))
+	slog.Info("config loaded", "file", *configPath, "config", cfg.Summary())
+	addr := ":" + cfg.Port
 
-	originHost := u.Host
-	reqHost := r.Host
-
-	if strings.Contains(originHost, "localhost") || strings.Contains(originHost, "127.0.0.1") {
-		return true
+	chat, err := server.New(cfg)
+	if err != nil {
+		fatal("starting server failed", err)
 	}
 
-	if strings.EqualFold(originHost, reqHost) {
-		return true
+	// Every request context derives from baseCtx, which is cancelled as soon
+	// as Shutdown begins so long-lived WebSocket handlers can return.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	httpServer := &http.Server{
+		Addr:         addr,
+		Handler:      chat.Handler(),
+		ReadTimeout:  cfg.HTTPReadTimeout,
+		WriteTimeout: cfg.HTTPWriteTimeout,
+		IdleTimeout:  cfg.HTTPIdleTimeout,
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
 	}
-
-	// Allow Render subdomains if needed
-	if strings.HasSuffix(originHost, ".onrender.com") || originHost == "onrender.com" {
-		return true
+	httpServer.RegisterOnShutdown(cancelBase)
+
+	// With TLS on, plain HTTP (if enabled) only redirects, and answers ACME
+	// challenges when certificates come from Let's Encrypt.
+	var redirect *http.Server
+	if cfg.HTTPRedirectPort != "" {
+		redirect = &http.Server{
+			Addr:         ":" + cfg.HTTPRedirectPort,
+			Handler:      httpsRedirect(cfg.Port),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
 	}
-
-	return false
-}
-
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:    1024,
-	WriteBufferSize:   1024,
-	EnableCompression: true,
-	CheckOrigin: func(r *http.Request) bool {
-		ok := allowOrigin(r)
-		log.Printf("Incoming WebSocket from Origin=%q Host=%q -> allow=%v", r.Header.Get("Origin"), r.Host, ok)
-		return ok
-	},
-}
-
-type Client struct {
-	conn *websocket.Conn
-	send chan []byte
-	hub  *Hub
-}
-
-type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	pin        string
-}
-
-func newHub(pin string) *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		pin:        pin,
+	if len(cfg.TLSDomains) > 0 {
+		tlsConfig, challenge := server.Autocert(cfg.TLSDomains, cfg.TLSCacheDir, cfg.TLSEmail)
+		httpServer.TLSConfig = tlsConfig
+		if redirect != nil {
+			redirect.Handler = challenge(redirect.Handler)
+		}
 	}
-}
 
-func (h *Hub) run(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case client := <-h.register:
-			h.clients[client] = true
-			client.send <- []byte(`{"type":"system","msg":"👋 Welcome to room ` + h.pin + `"}`)
-		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				if len(h.clients) == 0 {
-					return
-				}
-			}
-		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
+	var grpcServer server.RPCServer
+	if cfg.GRPCPort != "" {
+		var grpcTLS *tls.Config
+		switch {
+		case len(cfg.TLSDomains) > 0:
+			grpcTLS = httpServer.TLSConfig
+		case cfg.TLSCertFile != "":
+			cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+			if err != nil {
+				fatal("loading TLS certificate for gRPC failed", err)
 			}
+			grpcTLS = &tls.Config{Certificates: []tls.Certificate{cert}}
 		}
+		grpcServer = chat.GRPC(grpcTLS)
 	}
-}
-
-type HubManager struct {
-	hubs map[string]*Hub
-	mu   sync.Mutex
-}
-
-func newHubManager() *HubManager {
-	return &HubManager{hubs: make(map[string]*Hub)}
-}
-
-func (m *HubManager) getHub(pin string) *Hub {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	hub, exists := m.hubs[pin]
-	if !exists {
-		hub = newHub(pin)
-		m.hubs[pin] = hub
-
-		ctx, cancel := context.WithCancel(context.Background())
-		go func(p string, h *Hub) {
-			h.run(ctx)
-			m.mu.Lock()
-			delete(m.hubs, p)
-			m.mu.Unlock()
-			cancel()
-		}(pin, hub)
-	}
-	return hub
-}
-
-func serveWs(manager *HubManager, w http.ResponseWriter, r *http.Request) {
-	pin := r.URL.Query().Get("pin")
-	if pin == "" {
-		http.Error(w, "PIN required", http.StatusBadRequest)
-		return
-	}
-
-	log.Printf("New WebSocket connection for room PIN: %s", pin)
 
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
-		return
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	// signal.Notify with no signals would relay every signal.
+	if len(drainSignals) > 0 {
+		drainSig := make(chan os.Signal, 1)
+		signal.Notify(drainSig, drainSignals...)
+		go func() {
+			<-drainSig
+			chat.RequestDrain()
+		}()
 	}
 
-	hub := manager.getHub(pin)
-	client := &Client{conn: conn, send: make(chan []byte, 256), hub: hub}
-	hub.register <- client
-
-	go client.writePump()
-	client.readPump()
-}
-
-func (c *Client) readPump() {
-	defer func() {
-		c.hub.unregister <- c
-		_ = c.conn.Close()
-	}()
-
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
-		return nil
-	})
-
-	for {
-		_, message, err := c.conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("readPump unexpected close: %v", err)
-			}
-			break
+	serveErr := make(chan error, 3)
+	go func() {
+		slog.Info("server running", "addr", addr, "tls", cfg.TLSEnabled())
+		if cfg.TLSEnabled() {
+			// Files are empty under autocert, which supplies certificates
+			// through TLSConfig.
+			serveErr <- httpServer.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			serveErr <- httpServer.ListenAndServe()
 		}
-
-		trim := strings.TrimSpace(string(message))
-		if strings.Contains(trim, `"type":"ping"`) {
-			c.send <- []byte(`{"type":"pong","ts":"` + time.Now().UTC().Format(time.RFC3339) + `"}`)
-			continue
-		}
-
-		c.hub.broadcast <- message
-	}
-}
-
-func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
-	defer func() {
-		ticker.Stop()
-		_ = c.conn.Close()
 	}()
-
-	for {
-		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-			w, err := c.conn.NextWriter(websocket.TextMessage)
+	if redirect != nil {
+		go func() {
+			slog.Info("redirecting to https", "addr", redirect.Addr)
+			serveErr <- redirect.ListenAndServe()
+		}()
+	}
+	if grpcServer != nil {
+		go func() {
+			ln, err := net.Listen("tcp", ":"+cfg.GRPCPort)
 			if err != nil {
+				serveErr <- err
 				return
 			}
-			if _, err := w.Write(message); err != nil {
-				_ = w.Close()
-				return
-			}
-			_ = w.Close()
+			slog.Info("grpc running", "addr", ln.Addr().String())
+			serveErr <- grpcServer.Serve(ln)
+		}()
+	}
 
-		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				return
-			}
+	select {
+	case err := <-serveErr:
+		fatal("server failed", err)
+	case <-sigCtx.Done():
+	case <-chat.DrainRequested():
+		// SIGINT or SIGTERM during the drain cuts it short.
+		ctx, cancel := context.WithTimeout(sigCtx, cfg.DrainDelay+cfg.ShutdownTimeout)
+		if err := chat.Drain(ctx, cfg.DrainDelay); err != nil {
+			slog.Warn("drain did not finish", "err", err)
 		}
+		cancel()
 	}
-}
-
-func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	stopSignals() // a second signal kills the process outright
+
+	slog.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	// Rooms close first, so their sockets get a close frame before the
+	// listeners stop; the store is flushed and closed with them.
+	if err := chat.Shutdown(ctx); err != nil {
+		slog.Warn("chat shutdown incomplete", "err", err)
 	}
-	addr := ":" + port
-
-	manager := newHubManager()
-	mux := http.NewServeMux()
-
-	// --- Serve static files ---
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-
-	// --- Serve root & fallback routes ---
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		path := "static" + r.URL.Path
-		if _, err := os.Stat(path); os.IsNotExist(err) || strings.HasSuffix(r.URL.Path, "/") {
-			http.ServeFile(w, r, "static/index.html")
-			return
+	if err := httpServer.Shutdown(ctx); err != nil {
+		slog.Warn("http shutdown", "err", err)
+	}
+	if redirect != nil {
+		_ = redirect.Shutdown(ctx)
+	}
+	if grpcServer != nil {
+		// Streams end once their rooms have closed; GracefulStop waits for
+		// the rest, up to the same deadline.
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
 		}
-		http.ServeFile(w, r, path)
-	})
-
-	// --- WebSocket route ---
-	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		serveWs(manager, w, r)
-	})
-
-	// --- Health check ---
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("OK"))
-	})
-
-	server := &http.Server{
-		Addr:         addr,
-		Handler:      mux,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  60 * time.Second,
 	}
-
-	log.Printf("✅ Server running on %s", addr)
-	log.Fatal(server.ListenAndServe())
+	slog.Info("server stopped")
 }
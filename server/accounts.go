@@ -0,0 +1,378 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// accountTimeout bounds each account read or write in the store.
+const accountTimeout = 5 * time.Second
+
+// accountPrefix marks an account holder's user id, "user:<username>".
+// OAuth identities use their provider's name instead, so the two never
+// collide.
+const accountPrefix = "user:"
+
+// Password limits. bcrypt reads at most 72 bytes.
+const (
+	minAccountPassword = 8
+	maxAccountPassword = 72
+)
+
+// Login attempts are limited per address: a burst of
+// loginBurst, then one every 1/loginRate seconds.
+const (
+	loginRate  = 0.2
+	loginBurst = 10
+	// loginLimiterCap bounds the addresses tracked; past it the limiter
+	// starts afresh.
+	loginLimiterCap = 10000
+)
+
+var (
+	errAccountExists   = errors.New("username is taken")
+	errAccountNotFound = errors.New("account not found")
+)
+
+// usernamePattern is 3 to 32 lowercase letters, digits, '.', '_' or '-',
+// starting with a letter or digit.
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,31}$`)
+
+// Account is a registered user. Username is the lowercased login and the
+// stable part of the user id; Name and Avatar are the profile used in
+// rooms.
+type Account struct {
+	Username     string
+	Name         string
+	Avatar       string
+	PasswordHash []byte
+	Created      time.Time
+}
+
+// userID returns the account's id in tokens, presence and bans.
+func (a Account) userID() string { return accountPrefix + a.Username }
+
+// AccountProfile is an account as its holder sees it.
+type AccountProfile struct {
+	Username string    `json:"username"`
+	UserID   string    `json:"user_id"`
+	Name     string    `json:"name"`
+	Avatar   string    `json:"avatar,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+func (a Account) profile() AccountProfile {
+	return AccountProfile{Username: a.Username, UserID: a.userID(), Name: a.Name, Avatar: a.Avatar, Created: a.Created}
+}
+
+// accountUsername returns the username in an account holder's user id.
+func accountUsername(userID string) (string, bool) {
+	return strings.CutPrefix(userID, accountPrefix)
+}
+
+// accounts serves registration, login and the account endpoints.
+type accounts struct {
+	signin *signIn
+	store  Store
+	proxy  proxyTrust
+
+	mu       sync.Mutex
+	attempts map[string]*tokenBucket // by client address
+}
+
+func newAccounts(signin *signIn, store Store, proxy proxyTrust) *accounts {
+	return &accounts{signin: signin, store: store, proxy: proxy, attempts: make(map[string]*tokenBucket)}
+}
+
+// allow reports whether r's address may try another registration or
+// login now.
+func (a *accounts) allow(r *http.Request) bool {
+	ip := a.proxy.clientIP(r)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b := a.attempts[ip]
+	if b == nil {
+		if len(a.attempts) >= loginLimiterCap {
+			a.attempts = make(map[string]*tokenBucket)
+		}
+		b = newTokenBucket(loginRate, loginBurst)
+		a.attempts[ip] = b
+	}
+	return b.allow(time.Now())
+}
+
+// dummyHash is compared against when a login names no account, so a
+// missing account takes as long to refuse as a wrong password.
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("gochat-no-such-account"), bcrypt.DefaultCost)
+	return h
+})
+
+// loginCredentials is the body of registration and login.
+type loginCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+}
+
+func decodeCredentials(w http.ResponseWriter, r *http.Request) (loginCredentials, bool) {
+	var c loginCredentials
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&c); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body")
+		return c, false
+	}
+	c.Username = strings.ToLower(strings.TrimSpace(c.Username))
+	return c, true
+}
+
+// handleRegister serves POST /api/accounts with
+// {"username":"...","password":"...","name":"..."}, creating the account
+// and signing its holder in.
+func (a *accounts) handleRegister(w http.ResponseWriter, r *http.Request) {
+	c, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+	if !a.allow(r) {
+		writeError(w, http.StatusTooManyRequests, codeRateLimited, "too many attempts, try again later")
+		return
+	}
+	if !usernamePattern.MatchString(c.Username) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "username must be 3 to 32 lowercase letters, digits, '.', '_' or '-'")
+		return
+	}
+	if len(c.Password) < minAccountPassword || len(c.Password) > maxAccountPassword {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "password must be 8 to 72 bytes")
+		return
+	}
+	if c.Name == "" {
+		c.Name = c.Username
+	}
+	name, err := validateName(c.Name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(c.Password), bcrypt.DefaultCost)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not hash password")
+		return
+	}
+	acct := Account{Username: c.Username, Name: name, PasswordHash: hash, Created: time.Now().UTC()}
+	ctx, cancel := context.WithTimeout(r.Context(), accountTimeout)
+	defer cancel()
+	switch err := a.store.CreateAccount(ctx, acct); {
+	case errors.Is(err, errAccountExists):
+		writeError(w, http.StatusConflict, codeUsernameTaken, err.Error())
+		return
+	case err != nil:
+		slog.Error("creating account failed", "username", acct.Username, "err", err)
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "accounts unavailable")
+		return
+	}
+	slog.Info("account registered", "username", acct.Username)
+	a.signedIn(w, r, http.StatusCreated, acct)
+}
+
+// handleLogin serves POST /api/login with {"username":"...","password":"..."}.
+func (a *accounts) handleLogin(w http.ResponseWriter, r *http.Request) {
+	c, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+	if !a.allow(r) {
+		writeError(w, http.StatusTooManyRequests, codeRateLimited, "too many attempts, try again later")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), accountTimeout)
+	defer cancel()
+	acct, err := a.store.Account(ctx, c.Username)
+	if err != nil && !errors.Is(err, errAccountNotFound) {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "accounts unavailable")
+		return
+	}
+	hash := acct.PasswordHash
+	if err != nil {
+		hash = dummyHash()
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(c.Password)) != nil || err != nil {
+		writeError(w, http.StatusUnauthorized, codeAuthFailed, "wrong username or password")
+		return
+	}
+	a.signedIn(w, r, http.StatusOK, acct)
+}
+
+// signedIn starts a session for acct and answers with its token and
+// profile.
+func (a *accounts) signedIn(w http.ResponseWriter, r *http.Request, status int, acct Account) {
+	token, claims, err := a.signin.begin(w, r, Claims{Subject: acct.userID(), Name: acct.Name, Avatar: acct.Avatar})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not sign session")
+		return
+	}
+	writeJSON(w, status, map[string]any{"token": token, "expires_at": claims.ExpiresAt, "account": acct.profile()})
+}
+
+// account returns the account of r's caller, answering the request itself
+// if there is none.
+func (a *accounts) account(ctx context.Context, w http.ResponseWriter, r *http.Request) (Account, bool) {
+	claims := a.signin.caller(r)
+	if claims == nil {
+		writeError(w, http.StatusUnauthorized, codeAuthRequired, "not signed in")
+		return Account{}, false
+	}
+	username, ok := accountUsername(claims.Subject)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "no account for this sign-in")
+		return Account{}, false
+	}
+	acct, err := a.store.Account(ctx, username)
+	switch {
+	case errors.Is(err, errAccountNotFound):
+		writeError(w, http.StatusNotFound, codeNotFound, err.Error())
+		return acct, false
+	case err != nil:
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "accounts unavailable")
+		return acct, false
+	}
+	return acct, true
+}
+
+// handleGet serves GET /api/account, the caller's profile.
+func (a *accounts) handleGet(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), accountTimeout)
+	defer cancel()
+	if acct, ok := a.account(ctx, w, r); ok {
+		writeJSON(w, http.StatusOK, acct.profile())
+	}
+}
+
+// handleUpdate serves PUT /api/account, changing the fields given of
+// {"name":"...","avatar":"...","password":"...","current_password":"..."}.
+// A new password needs the current one. The session is reissued, so the
+// new name is used on the next join.
+func (a *accounts) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name            *string `json:"name"`
+		Avatar          *string `json:"avatar"`
+		Password        string  `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), accountTimeout)
+	defer cancel()
+	acct, ok := a.account(ctx, w, r)
+	if !ok {
+		return
+	}
+	if body.Name != nil {
+		name, err := validateName(*body.Name)
+		if err != nil || name == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid name")
+			return
+		}
+		acct.Name = name
+	}
+	if body.Avatar != nil {
+		if err := validateAvatar(*body.Avatar); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		acct.Avatar = *body.Avatar
+	}
+	if body.Password != "" {
+		if !a.allow(r) {
+			writeError(w, http.StatusTooManyRequests, codeRateLimited, "too many attempts, try again later")
+			return
+		}
+		if bcrypt.CompareHashAndPassword(acct.PasswordHash, []byte(body.CurrentPassword)) != nil {
+			writeError(w, http.StatusForbidden, codeAuthFailed, "current_password is wrong")
+			return
+		}
+		if len(body.Password) < minAccountPassword || len(body.Password) > maxAccountPassword {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "password must be 8 to 72 bytes")
+			return
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "could not hash password")
+			return
+		}
+		acct.PasswordHash = hash
+	}
+	if err := a.store.UpdateAccount(ctx, acct); err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "accounts unavailable")
+		return
+	}
+	a.signedIn(w, r, http.StatusOK, acct)
+}
+
+// dmKey names the stored conversation between two users, the same from
+// either side. Dms are stored apart from room messages, so no room
+// endpoint can read them whatever its PIN.
+func dmKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return "dm/" + a + "/" + b
+}
+
+// handleDMs serves GET /api/account/dms?with=<user id>&before=<id>&limit=50,
+// a page of the caller's stored dms with one other user, oldest first.
+// Dms are stored when both sides are signed in. The response's "before"
+// is the cursor for the next page, absent once the start is reached.
+func (a *accounts) handleDMs(w http.ResponseWriter, r *http.Request) {
+	claims := a.signin.caller(r)
+	if claims == nil {
+		writeError(w, http.StatusUnauthorized, codeAuthRequired, "not signed in")
+		return
+	}
+	q := r.URL.Query()
+	with := q.Get("with")
+	if with == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "with must name a user id")
+		return
+	}
+	limit := defaultPageSize
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "limit must be 1 to "+strconv.Itoa(maxPageSize))
+			return
+		}
+		limit = n
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), historyPageTimeout)
+	defer cancel()
+	msgs, err := a.store.DMs(ctx, dmKey(claims.Subject, with), q.Get("before"), limit)
+	if errors.Is(err, errMessageNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "no stored message with that id")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "history unavailable")
+		return
+	}
+	page := make([]json.RawMessage, 0, len(msgs))
+	for _, m := range msgs {
+		page = append(page, m.Frame)
+	}
+	resp := map[string]any{"with": with, "count": len(page), "messages": page}
+	if len(msgs) == limit {
+		resp["before"] = msgs[0].ID
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
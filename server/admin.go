@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// requireAdmin gates a handler behind "Authorization: Bearer <token>".
+// An empty token disables the admin surface entirely.
+func requireAdmin(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !isAdmin(token, r) {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether r carries the admin token, which must be set.
+func isAdmin(token string, r *http.Request) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// --- Maintenance mode ---
+func handleMaintenance(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body")
+			return
+		}
+		manager.maintenance.Store(body.Enabled)
+		slog.Info("maintenance mode changed", "enabled", body.Enabled)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, codeInvalidRequest, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"enabled": manager.maintenance.Load()})
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// errRoomClosed is the close reason for a room shut by an admin.
+var errRoomClosed = errors.New("room closed by admin")
+
+// roomCloseTimeout bounds how long closing a room from the admin API waits
+// for it to shut down.
+const roomCloseTimeout = 5 * time.Second
+
+// kickRequest asks run to remove one client; found reports whether it was
+// in the room.
+type kickRequest struct {
+	id     string
+	reason string
+	// banned marks the removal as a server ban rather than a kick.
+	banned bool
+	found  chan bool
+}
+
+// RoomInfo is a room as listed by the admin API.
+type RoomInfo struct {
+	Pin       string     `json:"pin"`
+	Name      string     `json:"name,omitempty"`
+	Count     int        `json:"count"`
+	Capacity  int        `json:"capacity"`
+	Draining  bool       `json:"draining,omitempty"`
+	Ephemeral bool       `json:"ephemeral,omitempty"`
+	Retention string     `json:"retention,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Members   []Member   `json:"members,omitempty"`
+}
+
+// hubList returns the live hubs, sorted by PIN.
+func (m *HubManager) hubList() []*Hub {
+	m.mu.Lock()
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for _, h := range m.hubs {
+		hubs = append(hubs, h)
+	}
+	m.mu.Unlock()
+	sort.Slice(hubs, func(i, j int) bool { return hubs[i].pin < hubs[j].pin })
+	return hubs
+}
+
+// info describes the hub from its last published member list, with each
+// member's full connection info.
+func (h *Hub) info(withMembers bool) RoomInfo {
+	var members []Member
+	if snap := h.presence.Load(); snap != nil {
+		members = withLatency(snap.admin)
+	}
+	ri := RoomInfo{Pin: h.pin, Name: h.title, Count: len(members), Capacity: int(h.capacity.Load()), Draining: h.draining.Load(), Ephemeral: h.ephemeral}
+	if h.retention > 0 {
+		ri.Retention = h.retention.String()
+	}
+	if !h.expiresAt.IsZero() {
+		ri.ExpiresAt = &h.expiresAt
+	}
+	if withMembers {
+		ri.Members = members
+		if ri.Members == nil {
+			ri.Members = []Member{}
+		}
+	}
+	return ri
+}
+
+// kickClient asks the room to remove the client with the given id.
+func (h *Hub) kickClient(id, reason string) bool {
+	return h.removeClient(kickRequest{id: id, reason: reason, found: make(chan bool, 1)})
+}
+
+// banClient asks the room to remove the client with the given id, which a
+// server ban covers.
+func (h *Hub) banClient(id, reason string) bool {
+	return h.removeClient(kickRequest{id: id, reason: reason, banned: true, found: make(chan bool, 1)})
+}
+
+func (h *Hub) removeClient(req kickRequest) bool {
+	select {
+	case h.kick <- req:
+		return <-req.found
+	case <-h.done:
+		return false
+	}
+}
+
+// kicked removes a client on an admin's request or for a server ban. Only
+// run may call it.
+func (h *Hub) kicked(req kickRequest) {
+	c := h.findClient(req.id)
+	req.found <- c != nil
+	switch {
+	case c == nil:
+	case req.banned:
+		// The ban itself is audited; this is only its enforcement.
+		h.expel(c, closeBanned, codeBanned, req.reason)
+	default:
+		h.expel(c, closeKicked, codeKicked, req.reason)
+		h.audit(nil, AuditEntry{Action: auditKick, Target: c.name, TargetID: c.userID, Reason: req.reason})
+	}
+}
+
+// handleAdminRooms serves GET /api/admin/rooms.
+func handleAdminRooms(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	rooms := []RoomInfo{}
+	for _, h := range manager.hubList() {
+		rooms = append(rooms, h.info(false))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"count": len(rooms), "rooms": rooms})
+}
+
+// handleAdminRoom serves GET /api/admin/rooms/{pin}, listing members even
+// when the room has presence turned off.
+func handleAdminRoom(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	hub := manager.lookup(r.PathValue("pin"))
+	if hub == nil {
+		writeError(w, http.StatusNotFound, codeRoomNotFound, "room not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, hub.info(true))
+}
+
+// handleAdminCloseRoom serves DELETE /api/admin/rooms/{pin}, answering
+// once the room has shut down and its clients are disconnected.
+func handleAdminCloseRoom(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	pin := r.PathValue("pin")
+	ctx, cancel := context.WithTimeout(r.Context(), roomCloseTimeout)
+	defer cancel()
+	switch err := manager.closeRoom(ctx, pin, errRoomClosed); {
+	case errors.Is(err, errRoomNotFound):
+		writeError(w, http.StatusNotFound, codeRoomNotFound, "room not found")
+	case err != nil:
+		writeError(w, http.StatusGatewayTimeout, codeUnavailable, "room is still closing")
+	default:
+		slog.Info("room closed by admin", "room", pin)
+		manager.audit(AuditEntry{Room: pin, Action: auditCloseRoom})
+		writeJSON(w, http.StatusOK, map[string]string{"status": "closed", "pin": pin})
+	}
+}
+
+// handleAdminKick serves DELETE /api/admin/rooms/{pin}/clients/{id}, with
+// an optional ?reason=.
+func handleAdminKick(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	hub := manager.lookup(r.PathValue("pin"))
+	if hub == nil {
+		writeError(w, http.StatusNotFound, codeRoomNotFound, "room not found")
+		return
+	}
+	reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+	if reason == "" {
+		reason = "removed by an administrator"
+	}
+	// Close frame reasons are limited to 123 bytes.
+	if len(reason) > 120 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "reason must be at most 120 bytes")
+		return
+	}
+	if !hub.kickClient(r.PathValue("id"), reason) {
+		writeError(w, http.StatusNotFound, codeNotFound, "client not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "kicked", "id": r.PathValue("id")})
+}
+
+// handleAdminAnnounce serves POST /api/admin/announce with {"msg":"..."},
+// sending an announcement to every room on this instance.
+func handleAdminAnnounce(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Msg string `json:"msg"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body")
+		return
+	}
+	body.Msg = strings.TrimSpace(body.Msg)
+	if body.Msg == "" || utf8.RuneCountInString(body.Msg) > maxBodyLen {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "msg must be 1 to 4000 characters")
+		return
+	}
+	rooms := 0
+	for _, h := range manager.hubList() {
+		if h.publish(&Message{Type: "announcement", Msg: body.Msg}) {
+			rooms++
+		}
+	}
+	slog.Info("admin announcement sent", "rooms", rooms)
+	writeJSON(w, http.StatusOK, map[string]int{"rooms": rooms})
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	const token = "secret"
+	_, ts := startServer(t, func(cfg *Config) { cfg.AdminToken = token })
+	member := dial(t, ts, "1234", "", nil)
+	member.expect("system")
+
+	steps := []struct {
+		name     string
+		body     string
+		wantJoin int
+	}{
+		{name: "off", wantJoin: http.StatusSwitchingProtocols},
+		{name: "turned on", body: `{"enabled":true}`, wantJoin: http.StatusServiceUnavailable},
+		{name: "turned off", body: `{"enabled":false}`, wantJoin: http.StatusSwitchingProtocols},
+	}
+	for _, step := range steps {
+		if step.body != "" {
+			if status, _ := call(t, ts, token, "POST", "/admin/maintenance", step.body); status != http.StatusOK {
+				t.Fatalf("%s: POST /admin/maintenance = %d", step.name, status)
+			}
+		}
+		status, body := dialStatus(t, ts, url.Values{"pin": {"1234"}})
+		if status != step.wantJoin {
+			t.Errorf("%s: join = %d, want %d", step.name, status, step.wantJoin)
+		}
+		if status == http.StatusServiceUnavailable && !strings.Contains(body, `"maintenance"`) {
+			t.Errorf("%s: refusal %s does not name maintenance", step.name, body)
+		}
+
+		// The member who joined first is never disturbed.
+		msg := "still here " + step.name
+		member.send(map[string]any{"type": "chat", "msg": msg})
+		member.expectMsg("chat", msg)
+	}
+
+	if status, _ := call(t, ts, "", "POST", "/admin/maintenance", `{"enabled":true}`); status != http.StatusUnauthorized {
+		t.Errorf("unauthenticated toggle = %d, want 401", status)
+	}
+}
+
+func TestAdminCloseRoom(t *testing.T) {
+	const token = "secret"
+	tests := []struct {
+		name    string
+		members int
+	}{
+		{name: "empty room", members: 0},
+		{name: "one member", members: 1},
+		{name: "busy room", members: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Rooms stay open while empty, so closing is the only way out.
+			s, ts := startServer(t, func(cfg *Config) {
+				cfg.AdminToken = token
+				cfg.RoomIdleTTL = time.Hour
+			})
+			http.DefaultClient.CloseIdleConnections()
+			baseline := runtime.NumGoroutine()
+
+			// The room's first member opens it; the empty room's leaves.
+			var members []*testConn
+			for i := range max(tt.members, 1) {
+				c := dial(t, ts, "1234", fmt.Sprint("member", i), nil)
+				c.expect("system")
+				members = append(members, c)
+			}
+			if tt.members == 0 {
+				members[0].send(map[string]any{"type": "leave"})
+				members[0].closeError()
+				members = nil
+			}
+			hub := s.manager.lookup("1234")
+
+			if status, body := call(t, ts, token, "DELETE", "/api/admin/rooms/1234", ""); status != http.StatusOK {
+				t.Fatalf("closing: %d %s", status, body)
+			}
+			select {
+			case <-hub.done:
+			default:
+				t.Error("the close returned before the room stopped")
+			}
+			for _, c := range members {
+				if ce := c.closeError(); ce.Code != closeRoomClosed {
+					t.Errorf("close code = %d, want %d", ce.Code, closeRoomClosed)
+				}
+			}
+			if s.manager.lookup("1234") != nil {
+				t.Error("the room is still registered")
+			}
+			if status, _ := call(t, ts, token, "DELETE", "/api/admin/rooms/1234", ""); status != http.StatusNotFound {
+				t.Errorf("closing again: %d, want %d", status, http.StatusNotFound)
+			}
+
+			// Every goroutine the room and its members started ends.
+			http.DefaultClient.CloseIdleConnections()
+			deadline := time.Now().Add(testWait)
+			for runtime.NumGoroutine() > baseline {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d goroutines, want at most %d as before the room opened", runtime.NumGoroutine(), baseline)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// The PIN opens a new room.
+			c := dial(t, ts, "1234", "late", nil)
+			c.expect("system")
+			if again := s.manager.lookup("1234"); again == nil || again == hub {
+				t.Error("joining did not open a new room")
+			}
+		})
+	}
+}
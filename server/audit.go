@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Moderation actions recorded in the audit log.
+const (
+	auditKick        = "kick"
+	auditBan         = "ban"
+	auditUnban       = "unban"
+	auditDelete      = "delete"
+	auditSettings    = "settings"
+	auditFeatures    = "features"
+	auditRole        = "role"
+	auditCloseRoom   = "close_room"
+	auditServerBan   = "server_ban"
+	auditServerUnban = "server_unban"
+)
+
+// auditAdmin is the actor of actions taken with the admin token.
+const auditAdmin = "admin"
+
+// maxAuditReason bounds a moderator's reason, in characters.
+const maxAuditReason = 200
+
+// memoryAuditLimit bounds the entries the memory store keeps.
+const memoryAuditLimit = 10000
+
+// AuditEntry is one moderation action. Room is empty for server-wide
+// actions. Target names the member or ban acted on, and Detail holds what
+// else the action needs: a deleted message's id, the new settings,
+// features or role, or a server ban's kind.
+type AuditEntry struct {
+	// ID is assigned by the store and grows with each entry.
+	ID       int64     `json:"id"`
+	Room     string    `json:"room,omitempty"`
+	Action   string    `json:"action"`
+	Actor    string    `json:"actor"`
+	ActorID  string    `json:"actor_id,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	TargetID string    `json:"target_id,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// audit records an action taken in the room by c, or by the admin if c is
+// nil. The write is queued, so it never stalls the room.
+func (h *Hub) audit(c *Client, e AuditEntry) {
+	e.Room = h.pin
+	if c != nil {
+		e.Actor, e.ActorID = c.name, c.userID
+	}
+	h.manager.audit(e)
+}
+
+// audit queues e for the store, stamped with the time and, if it names
+// no actor, the admin.
+func (m *HubManager) audit(e AuditEntry) {
+	if e.Actor == "" {
+		e.Actor = auditAdmin
+	}
+	e.At = time.Now().UTC()
+	m.persist.audit(e)
+}
+
+// auditJSON renders v for an entry's Detail.
+func auditJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// splitReason separates "name: reason" command arguments. Names cannot
+// contain ':', so everything after the first one is the reason.
+func splitReason(args string) (name, reason string) {
+	name, reason, _ = strings.Cut(args, ":")
+	reason = strings.Join(strings.Fields(stripControl(reason)), " ")
+	if utf8.RuneCountInString(reason) > maxAuditReason {
+		reason = string([]rune(reason)[:maxAuditReason])
+	}
+	return name, reason
+}
+
+// handleRoomAudit serves GET /api/rooms/{pin}/audit?before=<id>&limit=50,
+// the room's moderation actions newest first, for the admin token or the
+// room's owner.
+func handleRoomAudit(manager *HubManager, adminToken string, w http.ResponseWriter, r *http.Request) {
+	pin := r.PathValue("pin")
+	if !manager.moderates(adminToken, pin, r) {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+		return
+	}
+	serveAudit(manager, pin, w, r)
+}
+
+// handleAdminAudit serves GET /api/admin/audit?room=<pin>&before=<id>&limit=50,
+// the moderation actions of one room or, without room, of every room and
+// the server.
+func handleAdminAudit(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	serveAudit(manager, r.URL.Query().Get("room"), w, r)
+}
+
+// serveAudit writes a page of room's audit log. The response's "before"
+// is the cursor for the next page, absent once the start is reached.
+func serveAudit(manager *HubManager, room string, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := defaultPageSize
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "limit must be 1 to "+strconv.Itoa(maxPageSize))
+			return
+		}
+		limit = n
+	}
+	var before int64
+	if s := q.Get("before"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "before must be an entry id")
+			return
+		}
+		before = n
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), historyPageTimeout)
+	defer cancel()
+	entries, err := manager.store.Audit(ctx, room, before, limit)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "audit log unavailable")
+		return
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	resp := map[string]any{"count": len(entries), "entries": entries}
+	if len(entries) == limit {
+		resp["before"] = entries[len(entries)-1].ID
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
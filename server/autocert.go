@@ -0,0 +1,24 @@
+//go:build autocert
+
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Building with -tags autocert links Let's Encrypt support so TLS_DOMAINS
+// works.
+func init() {
+	autocertHook = func(domains []string, cacheDir, email string) (*tls.Config, func(http.Handler) http.Handler) {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      email,
+		}
+		return m.TLSConfig(), m.HTTPHandler
+	}
+}
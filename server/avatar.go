@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// identiconPath is where generated avatars are served. The rest of the
+// path is a hex seed derived from a name, and ".svg".
+const identiconPath = "/avatars/"
+
+// identiconSeedLen is the seed's length in bytes: two pick the colour and
+// two the pattern, the rest keep seeds of different names apart.
+const identiconSeedLen = 8
+
+// identiconURL returns the generated avatar for name. Names that differ
+// only in case share one.
+func identiconURL(name string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(name)))
+	return identiconPath + hex.EncodeToString(sum[:identiconSeedLen]) + ".svg"
+}
+
+// maxAvatarURL bounds a chosen avatar's URL.
+const maxAvatarURL = 512
+
+// validateAvatar checks an avatar URL; empty clears it.
+func validateAvatar(avatar string) error {
+	if avatar == "" {
+		return nil
+	}
+	u, err := url.Parse(avatar)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(avatar) > maxAvatarURL {
+		return errors.New("avatar must be an http(s) URL of at most 512 characters")
+	}
+	return nil
+}
+
+// avatarURL returns c's avatar: the one it chose or signed in with, else
+// the identicon of its current name.
+func (c *Client) avatarURL() string {
+	if c.avatar != "" {
+		return c.avatar
+	}
+	return identiconURL(c.name)
+}
+
+// handleIdenticon serves GET /avatars/{file}, the identicon for a seed: a
+// 5x5 grid, mirrored left to right, in a colour picked by the seed. The
+// image depends only on the URL, so it may be cached for good.
+func handleIdenticon(w http.ResponseWriter, r *http.Request) {
+	seed, ok := strings.CutSuffix(r.PathValue("file"), ".svg")
+	b, err := hex.DecodeString(seed)
+	if !ok || err != nil || len(b) != identiconSeedLen {
+		http.NotFound(w, r)
+		return
+	}
+	hue := (int(b[0])<<8 | int(b[1])) % 360
+	pattern := int(b[2])<<8 | int(b[3])
+
+	var svg strings.Builder
+	svg.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 5 5" width="64" height="64" shape-rendering="crispEdges">`)
+	svg.WriteString(`<rect width="5" height="5" fill="#f0f0f0"/>`)
+	fmt.Fprintf(&svg, `<g fill="hsl(%d,55%%,50%%)">`, hue)
+	// Fifteen bits fill the left three columns; the last two mirror them.
+	for col := range 3 {
+		for row := range 5 {
+			if pattern>>(col*5+row)&1 == 0 {
+				continue
+			}
+			fmt.Fprintf(&svg, `<rect x="%d" y="%d" width="1" height="1"/>`, col, row)
+			if col < 2 {
+				fmt.Fprintf(&svg, `<rect x="%d" y="%d" width="1" height="1"/>`, 4-col, row)
+			}
+		}
+	}
+	svg.WriteString(`</g></svg>`)
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write([]byte(svg.String()))
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+const (
+	backplaneQueueSize = 1024
+	backplaneRetryMax  = 30 * time.Second
+)
+
+// Broker relays room broadcasts between instances so clients of the same
+// PIN see each other wherever they landed.
+type Broker interface {
+	// Publish queues a frame for the other instances without blocking.
+	Publish(room string, frame []byte)
+	// PublishPresence queues this instance's presence in room likewise.
+	PublishPresence(room string, p instancePresence)
+	// Run keeps the broker connected until ctx ends.
+	Run(ctx context.Context)
+}
+
+// backplaneEnvelope is what instances exchange: a chat frame or a
+// presence announcement. Origin lets an instance ignore its own messages
+// echoed back by the broker.
+type backplaneEnvelope struct {
+	Origin   string            `json:"origin"`
+	Frame    json.RawMessage   `json:"frame,omitempty"`
+	Presence *instancePresence `json:"presence,omitempty"`
+}
+
+type outboundFrame struct {
+	room     string
+	frame    []byte
+	presence *instancePresence
+}
+
+// relay is the transport-independent half of a broker: the outbound
+// queue, the envelope and reconnecting with backoff.
+type relay struct {
+	broker   string // transport name, for logs
+	instance string
+	out      chan outboundFrame
+
+	// deliver hands a frame from another instance to the local room, and
+	// presence records another instance's presence.
+	deliver  func(room string, frame []byte)
+	presence func(room, instance string, p instancePresence)
+}
+
+func newRelay(broker string, deliver func(room string, frame []byte), presence func(room, instance string, p instancePresence)) relay {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	return relay{
+		broker:   broker,
+		instance: hex.EncodeToString(id[:]),
+		out:      make(chan outboundFrame, backplaneQueueSize),
+		deliver:  deliver,
+		presence: presence,
+	}
+}
+
+func (b *relay) Publish(room string, frame []byte) {
+	select {
+	case b.out <- outboundFrame{room: room, frame: frame}:
+	default:
+		slog.Warn("backplane queue full, dropping frame", "broker", b.broker, "room", room)
+	}
+}
+
+func (b *relay) PublishPresence(room string, p instancePresence) {
+	select {
+	case b.out <- outboundFrame{room: room, presence: &p}:
+	default:
+		slog.Warn("backplane queue full, dropping presence", "broker", b.broker, "room", room)
+	}
+}
+
+// envelope wraps a queued frame or announcement for the wire.
+func (b *relay) envelope(f outboundFrame) []byte {
+	payload, _ := json.Marshal(backplaneEnvelope{Origin: b.instance, Frame: f.frame, Presence: f.presence})
+	return payload
+}
+
+// receive unwraps a payload from the broker and delivers or records it,
+// unless it is malformed or this instance sent it.
+func (b *relay) receive(room string, payload []byte) {
+	var env backplaneEnvelope
+	if json.Unmarshal(payload, &env) != nil || env.Origin == b.instance {
+		return
+	}
+	if env.Presence != nil {
+		b.presence(room, env.Origin, *env.Presence)
+		return
+	}
+	b.deliver(room, env.Frame)
+}
+
+// retry reruns loop with exponential backoff until ctx is cancelled.
+func (b *relay) retry(ctx context.Context, name string, loop func(context.Context) error) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := loop(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > backplaneRetryMax {
+			backoff = time.Second
+		}
+		slog.Warn("backplane disconnected", "broker", b.broker, "conn", name, "err", err, "retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, backplaneRetryMax)
+	}
+}
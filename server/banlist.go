@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of server-wide ban.
+const (
+	banIP   = "ip"   // Value is a client IP address
+	banUser = "user" // Value is a verified user ID
+)
+
+// banStoreTimeout bounds the store writes behind one admin ban change.
+const banStoreTimeout = 5 * time.Second
+
+// GlobalBan keeps an address or signed-in user out of every room.
+type GlobalBan struct {
+	Kind   string    `json:"kind"`
+	Value  string    `json:"value"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+type banKey struct{ kind, value string }
+
+// globalBans is the server-wide banlist, loaded from the store at startup
+// and kept in step with it by the admin API. IP bans are held by
+// fingerprint so they match Client.fingerprint, counted because bans on
+// two addresses in one IPv6 /64 share one. Safe for concurrent use.
+type globalBans struct {
+	mu   sync.RWMutex
+	bans map[banKey]GlobalBan
+	fps  map[string]int
+}
+
+func newGlobalBans(bans []GlobalBan) *globalBans {
+	g := &globalBans{bans: make(map[banKey]GlobalBan), fps: make(map[string]int)}
+	for _, b := range bans {
+		g.add(b)
+	}
+	return g
+}
+
+func (g *globalBans) add(b GlobalBan) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := banKey{b.Kind, b.Value}
+	if _, ok := g.bans[key]; !ok && b.Kind == banIP {
+		g.fps[ipFingerprint(b.Value)]++
+	}
+	g.bans[key] = b
+}
+
+func (g *globalBans) remove(kind, value string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.bans[banKey{kind, value}]; !ok {
+		return false
+	}
+	delete(g.bans, banKey{kind, value})
+	if kind == banIP {
+		fp := ipFingerprint(value)
+		if g.fps[fp]--; g.fps[fp] <= 0 {
+			delete(g.fps, fp)
+		}
+	}
+	return true
+}
+
+// banned reports whether a client from the address with fingerprint fp,
+// signed in as userID if that is not empty, is banned.
+func (g *globalBans) banned(fp, userID string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.fps[fp] > 0 {
+		return true
+	}
+	_, ok := g.bans[banKey{banUser, userID}]
+	return userID != "" && ok
+}
+
+// list returns the bans, newest first.
+func (g *globalBans) list() []GlobalBan {
+	g.mu.RLock()
+	bans := make([]GlobalBan, 0, len(g.bans))
+	for _, b := range g.bans {
+		bans = append(bans, b)
+	}
+	g.mu.RUnlock()
+	sort.Slice(bans, func(i, j int) bool { return bans[i].At.After(bans[j].At) })
+	return bans
+}
+
+// loadBans reads the server-wide banlist from the store.
+func (m *HubManager) loadBans(ctx context.Context) error {
+	bans, err := m.store.Bans(ctx)
+	if err != nil {
+		return err
+	}
+	m.bans = newGlobalBans(bans)
+	return nil
+}
+
+// expelBanned disconnects every client on this instance that b covers.
+func (m *HubManager) expelBanned(b GlobalBan) {
+	for _, h := range m.hubList() {
+		snap := h.presence.Load()
+		if snap == nil {
+			continue
+		}
+		for _, member := range snap.admin {
+			c := member.client
+			if c == nil {
+				continue
+			}
+			if (b.Kind == banIP && c.fingerprint == ipFingerprint(b.Value)) ||
+				(b.Kind == banUser && c.userID == b.Value) {
+				h.banClient(c.id, "banned from this server")
+			}
+		}
+	}
+}
+
+// handleAdminBans serves GET /api/admin/bans.
+func handleAdminBans(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	bans := manager.bans.list()
+	writeJSON(w, http.StatusOK, map[string]any{"count": len(bans), "bans": bans})
+}
+
+// handleAdminBan serves POST /api/admin/bans with
+// {"ip":"..."} or {"user":"..."} and an optional "reason". It saves the
+// ban and disconnects anyone on this instance it covers.
+func handleAdminBan(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		IP     string `json:"ip"`
+		User   string `json:"user"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body")
+		return
+	}
+	b := GlobalBan{Reason: strings.TrimSpace(body.Reason), At: time.Now().UTC()}
+	switch body.IP, body.User = strings.TrimSpace(body.IP), strings.TrimSpace(body.User); {
+	case body.IP != "" && body.User != "":
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "give either ip or user, not both")
+		return
+	case body.IP != "":
+		ip := net.ParseIP(body.IP)
+		if ip == nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid ip")
+			return
+		}
+		b.Kind, b.Value = banIP, ip.String()
+	case body.User != "":
+		b.Kind, b.Value = banUser, body.User
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "ip or user required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), banStoreTimeout)
+	defer cancel()
+	if err := manager.store.SaveBan(ctx, b); err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "saving the ban failed")
+		return
+	}
+	manager.bans.add(b)
+	manager.audit(AuditEntry{Action: auditServerBan, Target: b.Value, Reason: b.Reason, Detail: b.Kind})
+	manager.expelBanned(b)
+	writeJSON(w, http.StatusCreated, b)
+}
+
+// handleAdminUnban serves DELETE /api/admin/bans/{kind}/{value}.
+func handleAdminUnban(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	kind, value := r.PathValue("kind"), r.PathValue("value")
+	if kind == banIP {
+		if ip := net.ParseIP(value); ip != nil {
+			value = ip.String()
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), banStoreTimeout)
+	defer cancel()
+	if err := manager.store.DeleteBan(ctx, kind, value); err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "removing the ban failed")
+		return
+	}
+	if !manager.bans.remove(kind, value) {
+		writeError(w, http.StatusNotFound, codeNotFound, "ban not found")
+		return
+	}
+	manager.audit(AuditEntry{Action: auditServerUnban, Target: value, Detail: kind})
+	writeJSON(w, http.StatusOK, map[string]string{"status": "unbanned", "kind": kind, "value": value})
+}
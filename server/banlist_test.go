@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobalBans(t *testing.T) {
+	tests := []struct {
+		name   string
+		bans   []GlobalBan
+		lift   []banKey
+		ip     string
+		userID string
+		want   bool
+	}{
+		{name: "no bans", ip: "203.0.113.7"},
+		{name: "banned address", bans: []GlobalBan{{Kind: banIP, Value: "203.0.113.7"}}, ip: "203.0.113.7", want: true},
+		{name: "neighbouring address", bans: []GlobalBan{{Kind: banIP, Value: "203.0.113.7"}}, ip: "203.0.113.8"},
+		{name: "ipv6 address", bans: []GlobalBan{{Kind: banIP, Value: "2001:db8::1"}}, ip: "2001:db8::1", want: true},
+		{name: "same ipv6 /64", bans: []GlobalBan{{Kind: banIP, Value: "2001:db8::1"}}, ip: "2001:db8::ffff:abcd:1", want: true},
+		{name: "next ipv6 /64", bans: []GlobalBan{{Kind: banIP, Value: "2001:db8::1"}}, ip: "2001:db8:0:1::1"},
+		{name: "one of two in a /64 lifted", bans: []GlobalBan{{Kind: banIP, Value: "2001:db8::1"}, {Kind: banIP, Value: "2001:db8::2"}}, lift: []banKey{{banIP, "2001:db8::1"}}, ip: "2001:db8::3", want: true},
+		{name: "both in a /64 lifted", bans: []GlobalBan{{Kind: banIP, Value: "2001:db8::1"}, {Kind: banIP, Value: "2001:db8::2"}}, lift: []banKey{{banIP, "2001:db8::1"}, {banIP, "2001:db8::2"}}, ip: "2001:db8::3"},
+		{name: "ban saved twice, lifted once", bans: []GlobalBan{{Kind: banIP, Value: "203.0.113.7"}, {Kind: banIP, Value: "203.0.113.7", Reason: "again"}}, lift: []banKey{{banIP, "203.0.113.7"}}, ip: "203.0.113.7"},
+		{name: "banned user", bans: []GlobalBan{{Kind: banUser, Value: "user:amy"}}, ip: "203.0.113.7", userID: "user:amy", want: true},
+		{name: "other user", bans: []GlobalBan{{Kind: banUser, Value: "user:amy"}}, ip: "203.0.113.7", userID: "user:bob"},
+		{name: "guest", bans: []GlobalBan{{Kind: banUser, Value: ""}}, ip: "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newGlobalBans(tt.bans)
+			for _, k := range tt.lift {
+				if !g.remove(k.kind, k.value) {
+					t.Fatalf("remove(%s, %s) found no ban", k.kind, k.value)
+				}
+			}
+			if got := g.banned(ipFingerprint(tt.ip), tt.userID); got != tt.want {
+				t.Errorf("banned(%s, %q) = %v, want %v", tt.ip, tt.userID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreBans(t *testing.T) {
+	at := time.Unix(1700000000, 0).UTC()
+	eachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		for _, b := range []GlobalBan{
+			{Kind: banIP, Value: "203.0.113.7", Reason: "spam", At: at},
+			{Kind: banUser, Value: "user:amy", At: at},
+			{Kind: banIP, Value: "203.0.113.7", Reason: "more spam", At: at.Add(time.Minute)},
+		} {
+			if err := s.SaveBan(ctx, b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.DeleteBan(ctx, banUser, "user:amy"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteBan(ctx, banUser, "user:nobody"); err != nil {
+			t.Fatalf("deleting a missing ban: %v", err)
+		}
+		bans, err := s.Bans(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bans) != 1 || bans[0].Kind != banIP || bans[0].Value != "203.0.113.7" || bans[0].Reason != "more spam" || !bans[0].At.Equal(at.Add(time.Minute)) {
+			t.Errorf("bans = %+v, want the replaced address ban", bans)
+		}
+	})
+}
+
+// TestServerBans bans a signed-in member and then the address every test
+// client shares, through the admin API.
+func TestServerBans(t *testing.T) {
+	const admin = "admin-token"
+	s, ts := startServer(t, func(cfg *Config) {
+		cfg.AdminToken = admin
+		cfg.JWTSecret = strings.Repeat("k", 32)
+	})
+	amyToken, _, err := s.manager.tokens.sign("user:amy", "amy", "", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	amy := dial(t, ts, "1234", "amy", url.Values{"token": {amyToken}})
+	amy.expect("system")
+	bob := dial(t, ts, "1234", "bob", nil)
+	bob.expect("system")
+
+	// restarted reports whether a server loading the store afresh would
+	// refuse a client from 127.0.0.1 signed in as userID.
+	restarted := func(userID string) bool {
+		m := newHubManager(s.cfg, s.store)
+		if err := m.loadBans(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return m.bans.banned(ipFingerprint("127.0.0.1"), userID)
+	}
+
+	if status, body := call(t, ts, admin, "POST", "/api/admin/bans", `{"user":"user:amy","reason":"spam"}`); status != http.StatusCreated {
+		t.Fatalf("banning amy = %d %s", status, body)
+	}
+	if ce := amy.closeError(); ce.Code != closeBanned {
+		t.Errorf("amy closed with %d, want %d", ce.Code, closeBanned)
+	}
+	if status, body := dialStatus(t, ts, url.Values{"pin": {"1234"}, "token": {amyToken}}); status != http.StatusForbidden || !strings.Contains(body, `"banned"`) {
+		t.Errorf("amy rejoining = %d %s, want 403 banned", status, body)
+	}
+	bob.send(map[string]any{"type": "chat", "msg": "still here"})
+	bob.expectMsg("chat", "still here")
+	if !restarted("user:amy") || restarted("") {
+		t.Error("after a restart the user ban is not what was saved")
+	}
+
+	if status, body := call(t, ts, admin, "POST", "/api/admin/bans", `{"ip":"127.0.0.1"}`); status != http.StatusCreated {
+		t.Fatalf("banning the address = %d %s", status, body)
+	}
+	if ce := bob.closeError(); ce.Code != closeBanned {
+		t.Errorf("bob closed with %d, want %d", ce.Code, closeBanned)
+	}
+	if status, _ := dialStatus(t, ts, url.Values{"pin": {"5678"}}); status != http.StatusForbidden {
+		t.Errorf("joining another room = %d, want 403", status)
+	}
+	if !restarted("") {
+		t.Error("after a restart the address is not banned")
+	}
+
+	for _, path := range []string{"/api/admin/bans/ip/127.0.0.1", "/api/admin/bans/user/user:amy"} {
+		if status, body := call(t, ts, admin, "DELETE", path, ""); status != http.StatusOK {
+			t.Fatalf("DELETE %s = %d %s", path, status, body)
+		}
+	}
+	if status, body := dialStatus(t, ts, url.Values{"pin": {"1234"}, "token": {amyToken}}); status != http.StatusSwitchingProtocols {
+		t.Errorf("amy rejoining after the bans were lifted = %d %s", status, body)
+	}
+	if restarted("user:amy") {
+		t.Error("after a restart a lifted ban still applies")
+	}
+	if status, _ := call(t, ts, admin, "DELETE", "/api/admin/bans/ip/127.0.0.1", ""); status != http.StatusNotFound {
+		t.Errorf("lifting a lifted ban = %d, want 404", status)
+	}
+}
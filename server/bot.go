@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Bot automates a room from inside the server. Its methods are called on
+// the room's run loop, one at a time, so a bot needs no locking of its own
+// but must return quickly. OnMessage sees every chat and file message
+// except those posted by bots.
+type Bot interface {
+	// Name is the sender shown on the bot's messages.
+	Name() string
+	OnMessage(room BotRoom, m *Message)
+	OnJoin(room BotRoom, user string)
+	OnLeave(room BotRoom, user string)
+}
+
+// BotRoom is a bot's handle on the room it serves. It is only valid during
+// the callback it was passed to.
+type BotRoom struct {
+	hub     *Hub
+	bot     Bot
+	channel string
+}
+
+// PIN returns the room's PIN.
+func (r BotRoom) PIN() string { return r.hub.pin }
+
+// Members returns the names of everyone in the room, sorted.
+func (r BotRoom) Members() []string {
+	names := make([]string, 0, len(r.hub.clients))
+	for c := range r.hub.clients {
+		names = append(names, c.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Channel returns the channel of the message being handled, or the
+// default channel outside OnMessage.
+func (r BotRoom) Channel() string {
+	if r.channel == "" {
+		return defaultChannel
+	}
+	return r.channel
+}
+
+// Say posts text to the room as the bot, in the channel it is handling.
+func (r BotRoom) Say(text string) {
+	msg := &Message{Type: "chat", Msg: text, Channel: r.channel}
+	if pe := validateChat(msg); pe != nil {
+		r.hub.log.Warn("bot message rejected", "bot", r.bot.Name(), "err", pe)
+		return
+	}
+	msg.User, msg.Bot = r.bot.Name(), true
+	r.hub.broadcastChat(msg)
+}
+
+// botFactories are the bots rooms can run, by name, filled by registerBot
+// from init functions.
+var botFactories = map[string]func() Bot{}
+
+// registerBot makes a bot available under name, panicking on a duplicate.
+func registerBot(name string, factory func() Bot) {
+	if _, dup := botFactories[name]; dup {
+		panic("duplicate bot " + name)
+	}
+	botFactories[name] = factory
+}
+
+// checkBots returns an error naming the first unknown bot in names.
+func checkBots(names []string) error {
+	for _, name := range names {
+		if botFactories[name] == nil {
+			return fmt.Errorf("unknown bot %q", name)
+		}
+	}
+	return nil
+}
+
+// addBots starts a fresh instance of each named bot in the room, skipping
+// any it already runs. Call it before run starts.
+func (h *Hub) addBots(names []string) {
+	for _, name := range names {
+		if h.botNames[name] {
+			continue
+		}
+		if h.botNames == nil {
+			h.botNames = make(map[string]bool)
+		}
+		h.botNames[name] = true
+		h.bots = append(h.bots, botFactories[name]())
+	}
+}
+
+// notifyBots calls fn for every bot, logging rather than propagating a
+// panic so a broken bot cannot take the room down. Only run may call it.
+func (h *Hub) notifyBots(fn func(b Bot, room BotRoom)) {
+	for _, b := range h.bots {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					h.log.Error("bot panicked", "bot", b.Name(), "panic", r)
+				}
+			}()
+			fn(b, BotRoom{hub: h, bot: b})
+		}()
+	}
+}
+
+// botsSee passes a member's message to the room's bots. Only run may call
+// it.
+func (h *Hub) botsSee(msg *Message) {
+	if len(h.bots) == 0 || msg.Bot || msg.opaque() {
+		return
+	}
+	h.notifyBots(func(b Bot, room BotRoom) {
+		seen := *msg
+		room.channel = msg.Channel
+		b.OnMessage(room, &seen)
+	})
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+)
+
+// parseCapacity validates a creator's ?capacity= request; zero means the
+// server default.
+func parseCapacity(raw string, max int) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > max {
+		return 0, errors.New("capacity must be between 1 and " + strconv.Itoa(max))
+	}
+	return n, nil
+}
+
+// full reports whether the room has no space left. It reads published
+// counters, so admit still has the final say.
+func (h *Hub) full() bool {
+	return h.occupants.Load() >= h.capacity.Load()
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultChannel is where chat goes when a message names no channel, and
+// the one every client is subscribed to unless it asks otherwise.
+const defaultChannel = "general"
+
+// maxChannels bounds how many channels one client may follow.
+const maxChannels = 16
+
+var channelPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// normalizeChannel lowercases a channel name and strips a leading "#". An
+// empty name means the default channel.
+func normalizeChannel(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+	if name == "" {
+		return defaultChannel, true
+	}
+	return name, channelPattern.MatchString(name)
+}
+
+// parseChannels reads the channels query parameter, a comma-separated
+// list of the channels to follow from the start.
+func parseChannels(raw string) (map[string]bool, error) {
+	channels := map[string]bool{}
+	for _, name := range strings.Split(raw, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		ch, ok := normalizeChannel(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid channel name %q", name)
+		}
+		channels[ch] = true
+	}
+	if len(channels) == 0 {
+		channels[defaultChannel] = true
+	}
+	if len(channels) > maxChannels {
+		return nil, fmt.Errorf("at most %d channels", maxChannels)
+	}
+	return channels, nil
+}
+
+// frameChannel returns the channel a stored chat frame was posted to.
+// Frames from before channels existed belong to the default one.
+func frameChannel(frame []byte) string {
+	var m struct {
+		Channel string `json:"channel"`
+	}
+	if json.Unmarshal(frame, &m) != nil || m.Channel == "" {
+		return defaultChannel
+	}
+	return m.Channel
+}
+
+// subscribed reports whether frames for channel should reach c. An empty
+// channel is room-wide.
+func (c *Client) subscribed(channel string) bool {
+	return channel == "" || c.channels[channel]
+}
+
+// channelList returns c's channels, sorted.
+func (c *Client) channelList() []string {
+	list := make([]string, 0, len(c.channels))
+	for ch := range c.channels {
+		list = append(list, ch)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// updateChannels handles subscribe and unsubscribe, answering with the
+// client's resulting channel list. A new subscription also replays the
+// channel's history. Only run may call it.
+func (h *Hub) updateChannels(msg *Message) {
+	c := msg.from
+	switch {
+	case msg.Type == "subscribe" && !c.channels[msg.Channel]:
+		if len(c.channels) >= maxChannels {
+			c.trySend(errorFrame(codeTooManyChannels, fmt.Sprintf("you can follow at most %d channels", maxChannels)))
+			return
+		}
+		c.channels[msg.Channel] = true
+		if h.features[featureHistory] {
+			for _, e := range h.history {
+				if e.channel == msg.Channel {
+					c.trySend(e.frame)
+				}
+			}
+		}
+	case msg.Type == "unsubscribe":
+		delete(c.channels, msg.Channel)
+	}
+	c.trySend(h.frame(&Message{Type: "channels", Channels: c.channelList()}))
+}
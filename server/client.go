@@ -0,0 +1,566 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type Client struct {
+	conn *websocket.Conn
+	// id identifies this connection, e.g. as the target of a dm.
+	id string
+	// name is the display name, unique within the room. Declared at join;
+	// once joined only run may change or read it.
+	name string
+	send *sendQueue
+	hub  *Hub
+	meta map[string]string
+
+	// wire holds the connection's timeouts and frame limits.
+	wire wireLimits
+
+	// log tags every line with the connection id, room, address and name.
+	log *slog.Logger
+
+	// done is closed by the hub's run loop when the client is removed.
+	done chan struct{}
+
+	// leaveReason records why the client left; set by leave before
+	// unregistering.
+	leaveReason string
+	leaveOnce   sync.Once
+
+	// left is set once readPump exits, so a join still sitting in the
+	// register queue is discarded rather than added after its unregister.
+	left atomic.Bool
+
+	// replay delivers the room history once, before any live frame.
+	replay chan [][]byte
+
+	// spectator clients are read-only.
+	spectator bool
+
+	// role is set by run at join and by /promote and /demote.
+	role role
+
+	// rtt is the last measured round trip in nanoseconds, set by the pong
+	// handler and read from anywhere.
+	rtt atomic.Int64
+
+	// spam tracks recent chat for the spam heuristics. Owned by run.
+	spam spamTracker
+
+	// lastChat is when the room last accepted chat from this client, for
+	// slow mode. Owned by run.
+	lastChat time.Time
+
+	// active is when the client joined or last sent chat, a dm or a file,
+	// and idleWarned whether it has been told it is about to be
+	// disconnected for going quiet since. Owned by run.
+	active     time.Time
+	idleWarned bool
+
+	// channels are the channels whose chat reaches this client. Declared
+	// at join; once joined owned by run.
+	channels map[string]bool
+
+	// ignored holds lowercased user names this client has muted for
+	// itself. Owned by run.
+	ignored map[string]bool
+
+	// publicKey is the client's published end-to-end key, base64; empty
+	// if it has none. Owned by run.
+	publicKey string
+
+	// lastTyping is when this client's last typing event was relayed, and
+	// limiter throttles its frames. Owned by whoever calls handleFrame.
+	lastTyping time.Time
+	limiter    *clientLimiter
+
+	// userID is the verified identity; empty for anonymous connections.
+	userID string
+	// avatar is the image URL the client chose or signed in with; empty
+	// for the identicon of its name. See avatarURL.
+	avatar string
+
+	// requireAuth asks, if this client creates the room, that only
+	// authenticated users may join.
+	requireAuth bool
+
+	// resumeToken is issued in the welcome and lets a reconnect within
+	// the resume window take this connection's place. resume and
+	// resumeSeq are the token and last seen seq this connection offered.
+	resumeToken string
+	resume      string
+	resumeSeq   uint64
+
+	// capacity is the room size requested at join, applied only if this
+	// client creates the room.
+	capacity int
+
+	// sessionKey authenticates HTTP requests made for this connection;
+	// postMu serialises the frames they carry for SSE clients.
+	sessionKey string
+	postMu     sync.Mutex
+
+	// binary clients negotiated gochat.v1.proto: frames are transcoded to
+	// and from protobuf at the socket. Set before the pumps start.
+	binary bool
+
+	// info is how the client connected; set before it joins.
+	info ConnInfo
+
+	// traceCtx holds the connection's span, the parent of the spans of
+	// its messages; nil if the transport does not trace connections.
+	traceCtx context.Context
+
+	// fingerprint identifies the client's address for bans.
+	fingerprint string
+
+	// password is the room password offered at join.
+	password string
+
+	// closeCode and closeReason, when set by run before closing done, are
+	// sent in the close frame.
+	closeCode   int
+	closeReason string
+}
+
+// newClientFromRequest runs the join checks shared by every transport and
+// builds the client, or writes an HTTP error and returns nil.
+func newClientFromRequest(manager *HubManager, w http.ResponseWriter, r *http.Request) (string, *Client) {
+	pin := r.URL.Query().Get("pin")
+	if pin == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "PIN required")
+		return "", nil
+	}
+	if strings.Contains(pin, "/") {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "PIN must not contain '/'")
+		return "", nil
+	}
+
+	if manager.maintenance.Load() {
+		writeError(w, http.StatusServiceUnavailable, codeMaintenance, "server is in maintenance mode, try again shortly")
+		return "", nil
+	}
+
+	hub := manager.lookup(pin)
+	if hub == nil && manager.requireCreated {
+		writeError(w, http.StatusNotFound, codeRoomNotFound, "no room with that PIN, create one with POST /api/rooms")
+		return "", nil
+	}
+	if hub != nil && hub.draining.Load() {
+		writeError(w, http.StatusServiceUnavailable, codeDraining, "room is migrating to another server")
+		return "", nil
+	}
+	if hub != nil && hub.full() {
+		writeError(w, http.StatusServiceUnavailable, codeRoomFull, "the room is full")
+		return "", nil
+	}
+
+	capacity, err := parseCapacity(r.URL.Query().Get("capacity"), manager.maxCapacity)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return "", nil
+	}
+
+	meta, err := parseClientMeta(r.URL.Query().Get("meta"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return "", nil
+	}
+
+	name, err := validateName(r.URL.Query().Get("name"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidName, err.Error())
+		return "", nil
+	}
+
+	avatar := r.URL.Query().Get("avatar")
+	if err := validateAvatar(avatar); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return "", nil
+	}
+
+	var userID string
+	if token := requestToken(r); token != "" {
+		if manager.tokens == nil {
+			writeError(w, http.StatusUnauthorized, codeInvalidToken, "token auth is not enabled")
+			return "", nil
+		}
+		claims, err := manager.tokens.verify(token, time.Now())
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, codeInvalidToken, err.Error())
+			return "", nil
+		}
+		userID = claims.Subject
+		if claims.Name != "" {
+			name = claims.Name
+		}
+		if avatar == "" {
+			avatar = claims.Avatar
+		}
+	} else if claims := cookieClaims(manager.tokens, r); claims != nil {
+		// Signed in through /auth/login. The session name is only a
+		// default: the member may join under another.
+		userID = claims.Subject
+		if name == "" {
+			name = claims.Name
+		}
+		if avatar == "" {
+			avatar = claims.Avatar
+		}
+	}
+	requireAuth := r.URL.Query().Get("auth") == "required"
+	if requireAuth && userID == "" {
+		writeError(w, http.StatusBadRequest, codeAuthRequired, "auth=required needs a token")
+		return "", nil
+	}
+
+	ip := manager.proxy.clientIP(r)
+	fingerprint := ipFingerprint(ip)
+	if manager.bans.banned(fingerprint, userID) {
+		writeError(w, http.StatusForbidden, codeBanned, "you are banned from this server")
+		return "", nil
+	}
+	if hub != nil && hub.bans.banned(name, fingerprint) {
+		writeError(w, http.StatusForbidden, codeBanned, "you are banned from this room")
+		return "", nil
+	}
+
+	channels, err := parseChannels(r.URL.Query().Get("channels"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return "", nil
+	}
+
+	var resumeSeq uint64
+	if s := r.URL.Query().Get("last_seq"); s != "" {
+		if resumeSeq, err = strconv.ParseUint(s, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "last_seq must be a number")
+			return "", nil
+		}
+	}
+
+	password := r.URL.Query().Get("password")
+	if err := validatePassword(password); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return "", nil
+	}
+
+	id := newClientID()
+	return pin, &Client{
+		id:          id,
+		log:         slog.With("conn", id, "room", pin, "remote_ip", ip, "user", name),
+		name:        name,
+		send:        newSendQueue(manager.wire.sendQueueSize, manager.wire.sendOverflow),
+		wire:        manager.wire,
+		meta:        meta,
+		done:        make(chan struct{}),
+		spectator:   r.URL.Query().Get("spectate") == "1",
+		channels:    channels,
+		replay:      make(chan [][]byte, 1),
+		password:    password,
+		fingerprint: fingerprint,
+		info:        newConnInfo(r, ip, "websocket"),
+		capacity:    capacity,
+		userID:      userID,
+		avatar:      avatar,
+		requireAuth: requireAuth,
+		resume:      r.URL.Query().Get("resume"),
+		resumeSeq:   resumeSeq,
+		limiter:     newClientLimiter(manager.clientLimits),
+	}
+}
+
+func serveWs(manager *HubManager, upgrader *websocket.Upgrader, w http.ResponseWriter, r *http.Request) {
+	pin, client := newClientFromRequest(manager, w, r)
+	if client == nil {
+		return
+	}
+
+	client.info.Compression = upgrader.EnableCompression && offersDeflate(r)
+	client.log.Info("websocket connection", "user_agent", client.info.UserAgent, "compression", client.info.Compression)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		client.log.Warn("websocket upgrade failed", "err", err)
+		return
+	}
+	client.conn = conn
+	client.binary = conn.Subprotocol() == subprotoProto
+
+	ctx, sp := startSpan(r.Context(), "gochat.connection",
+		spanAttr{"gochat.room", pin}, spanAttr{"gochat.conn", client.id}, spanAttr{"gochat.binary", client.binary})
+	defer func() {
+		sp.set(spanAttr{"gochat.leave_reason", client.leaveReason})
+		sp.end()
+	}()
+	client.traceCtx = ctx
+
+	manager.sessions.add(client)
+	defer manager.sessions.remove(client.id)
+	if err := manager.join(pin, client); err != nil {
+		sp.fail(err)
+		client.log.Warn("join rejected", "err", err)
+		client.refuse(closeTryAgain, codeRoomBusy, err.Error())
+		return
+	}
+
+	// On server shutdown the base context is cancelled; close the socket so
+	// readPump returns, unregisters, and lets Shutdown finish promptly.
+	stop := context.AfterFunc(r.Context(), func() {
+		client.closeWith(closeShutdown, "server shutting down")
+	})
+	defer stop()
+
+	manager.conns.Add(2)
+	go func() {
+		defer manager.conns.Done()
+		client.writePump()
+	}()
+	defer manager.conns.Done()
+	client.readPump()
+}
+
+// refuse turns away a client whose join never reached the room: it gets
+// an error frame, then a close frame with code. The pumps must not be
+// running.
+func (c *Client) refuse(code int, errCode, detail string) {
+	_ = c.writeFrame(errorFrame(errCode, detail))
+	c.closeWith(code, detail)
+}
+
+// closeWith sends a close frame with code and reason, then closes the socket.
+// Safe to call concurrently with the pumps.
+func (c *Client) closeWith(code int, reason string) {
+	_ = c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, closeText(reason)), time.Now().Add(c.wire.writeWait))
+	_ = c.conn.Close()
+}
+
+// Reasons a client leaves a room, reported in "left" events and logs.
+const (
+	leaveClient       = "client_leave"
+	leaveDisconnected = "disconnected"
+	leaveRateLimited  = "rate_limited"
+)
+
+// trySend queues a frame for this client only, dropping it if the client
+// is gone or its queue has no room for it.
+func (c *Client) trySend(b []byte) {
+	select {
+	case <-c.done:
+	default:
+		c.send.push(b)
+	}
+}
+
+// leave marks the client as gone and unregisters it, once, whichever of
+// its goroutines notices first.
+func (c *Client) leave(reason string) {
+	c.leaveOnce.Do(func() {
+		c.leaveReason = reason
+		c.left.Store(true)
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
+	})
+}
+
+func (c *Client) readPump() {
+	reason := leaveDisconnected
+	defer func() {
+		c.leave(reason)
+		if reason == leaveClient || reason == leaveRateLimited {
+			// writePump flushes, sends the close frame and closes the socket.
+			return
+		}
+		_ = c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(c.wire.frameReadLimit())
+	c.conn.SetReadDeadline(time.Now().Add(c.wire.pongWait))
+	c.conn.SetPongHandler(c.recordPong)
+
+	for {
+		mt, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				c.log.Info("unexpected close", "err", err)
+			}
+			// A missed pong leaves the client parked like any other drop,
+			// but if it is still listening it learns why.
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				c.closeWith(closeTimedOut, "no response to pings")
+			}
+			return
+		}
+		if c.binary && mt == websocket.BinaryMessage {
+			if message, err = protoToJSON(message); err != nil {
+				c.trySend(errorFrame(codeInvalidProto, err.Error()))
+				continue
+			}
+		}
+		if r := c.handleFrame(message); r != "" {
+			reason = r
+			return
+		}
+	}
+}
+
+// handleFrame processes one frame from the client. It returns a leave
+// reason when the client should be disconnected, or "" to keep reading.
+// Calls for one client must not overlap.
+func (c *Client) handleFrame(message []byte) string {
+	if ok, flooding := c.limiter.allow(time.Now()); flooding {
+		c.log.Warn("rate limit exceeded, disconnecting")
+		c.trySend(errorFrame(codeFlooding, "disconnected for sending too fast"))
+		return leaveRateLimited
+	} else if !ok {
+		c.trySend(errorFrame(codeRateLimited, "you are sending too fast, message dropped"))
+		return ""
+	}
+
+	if pe := c.wire.checkFrame(message); pe != nil {
+		c.trySend(errorFrame(pe.code, pe.detail))
+		return ""
+	}
+
+	if p, ok := parsePing(message); ok {
+		c.heartbeat(p)
+		return ""
+	}
+
+	msg, err := parseMessage(message)
+	if err != nil {
+		var pe *parseError
+		if errors.As(err, &pe) {
+			c.trySend(errorFrame(pe.code, pe.detail))
+		}
+		return ""
+	}
+
+	if msg.Type == "leave" {
+		return leaveClient
+	}
+
+	if c.spectator {
+		c.trySend(errorFrame(codeReadOnly, "spectators cannot send messages"))
+		return ""
+	}
+
+	if msg.Type == "typing" && !c.throttleTyping(time.Now()) {
+		return ""
+	}
+
+	if !c.hub.manager.allowed(c, actionForType[msg.Type]) {
+		c.trySend(errorFrame(codeAuthRequired, "sign in to "+actionForType[msg.Type]))
+		return ""
+	}
+
+	ctx, sp := startSpan(c.traceCtx, "gochat.message", spanAttr{"gochat.type", msg.Type})
+	defer sp.end()
+	msg.trace = ctx
+
+	// Server-held identity and metadata always win over anything the
+	// client put in the frame; run stamps the name.
+	msg.Meta = c.meta
+	msg.from = c
+	if !c.hub.publish(msg) {
+		return leaveDisconnected
+	}
+	return ""
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.wire.pingPeriod)
+	defer func() {
+		ticker.Stop()
+		_ = c.conn.Close()
+	}()
+
+	select {
+	case frames := <-c.replay:
+		for _, f := range frames {
+			if c.writeFrame(f) != nil {
+				return
+			}
+		}
+		// Measure latency from the start, not a ping period in.
+		if c.writePing() != nil {
+			return
+		}
+	case <-c.done:
+	}
+
+	for {
+		select {
+		case <-c.done:
+			// Flush whatever was queued before removal, then say goodbye.
+			if c.send.drain(c.writeFrame) != nil {
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(c.wire.writeWait))
+			_ = c.conn.WriteMessage(websocket.CloseMessage, c.closeFrame())
+			return
+
+		case <-c.send.ready:
+			if err := c.send.drain(c.writeFrame); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			if err := c.writePing(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// reject turns away a client that never joined: it gets an error frame,
+// then a close frame with code. Only run may call it.
+func (c *Client) reject(code int, errCode, detail string) {
+	c.closeCode, c.closeReason = code, detail
+	c.trySend(errorFrame(errCode, detail))
+	close(c.done)
+}
+
+// closeFrame is the close payload writePump sends once the client is
+// removed.
+func (c *Client) closeFrame() []byte {
+	if code, reason := c.closeStatus(); code != 0 {
+		return websocket.FormatCloseMessage(code, reason)
+	}
+	return []byte{}
+}
+
+func (c *Client) writeFrame(message []byte) error {
+	mt := websocket.TextMessage
+	if c.binary {
+		if b, err := frameCache.encode(message); err == nil {
+			mt, message = websocket.BinaryMessage, b
+		}
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.wire.writeWait))
+	w, err := c.conn.NextWriter(mt)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
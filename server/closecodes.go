@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Close codes. Every disconnect the server starts carries one of these and
+// a reason meant for people, so clients can decide from the code alone
+// whether reconnecting can help.
+const (
+	// The client asked to leave. Do not reconnect.
+	closeLeft = websocket.CloseNormalClosure // 1000
+	// The server is shutting down. Reconnect with backoff.
+	closeShutdown = websocket.CloseGoingAway // 1001
+	// The client sent too fast for too long. Do not reconnect automatically.
+	closeFlooding = websocket.ClosePolicyViolation // 1008
+	// The server is draining or the room moved. Reconnect at once.
+	closeRestart = websocket.CloseServiceRestart // 1012
+	// The room is full, busy or closed while the join waited. Reconnect
+	// with backoff.
+	closeTryAgain = websocket.CloseTryAgainLater // 1013
+
+	// A wrong or missing room password, or a room that needs a signed-in
+	// user. Reconnect only with other credentials.
+	closeAuthFailed = 4001
+	// A moderator or admin kicked the client. Do not reconnect automatically.
+	closeKicked = 4002
+	// The client is banned from the room or the server. Do not reconnect.
+	closeBanned = 4003
+	// The client stopped answering pings. Reconnect with backoff.
+	closeTimedOut = 4004
+	// The name is already in use in the room. Reconnect only with another
+	// name.
+	closeNameTaken = 4005
+	// An admin closed the room or it expired. Do not reconnect
+	// automatically; doing so would open the room again.
+	closeRoomClosed = 4006
+	// The client sent no chat for IDLE_TIMEOUT. Do not reconnect
+	// automatically.
+	closeIdle = 4007
+)
+
+// maxCloseReason is the most a close frame's reason may hold, in bytes.
+const maxCloseReason = 123
+
+// closeCodeFor returns the close code sent with a hub's stop cause.
+func closeCodeFor(cause error) int {
+	switch {
+	case errors.Is(cause, errServerDraining), errors.Is(cause, errRoomMigrated):
+		return closeRestart
+	case errors.Is(cause, errRoomClosed), errors.Is(cause, errRoomExpired):
+		return closeRoomClosed
+	}
+	return closeShutdown
+}
+
+// closeStatus returns the close code and reason for a client the server
+// removed, or 0 if it just went away. closeCode is written only by run and
+// leaveReason by leave, both before run closes done.
+func (c *Client) closeStatus() (int, string) {
+	if c.closeCode != 0 {
+		return c.closeCode, closeText(c.closeReason)
+	}
+	switch c.leaveReason {
+	case leaveClient:
+		return closeLeft, leaveClient
+	case leaveRateLimited:
+		return closeFlooding, closeRateLimited
+	}
+	return 0, ""
+}
+
+// closeText cuts reason to fit a close frame, on a character boundary.
+func closeText(reason string) string {
+	if len(reason) <= maxCloseReason {
+		return reason
+	}
+	cut := maxCloseReason
+	for cut > 0 && !utf8.RuneStart(reason[cut]) {
+		cut--
+	}
+	return reason[:cut]
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCloseCodeFor(t *testing.T) {
+	tests := []struct {
+		cause error
+		want  int
+	}{
+		{cause: errServerDraining, want: closeRestart},
+		{cause: errRoomMigrated, want: closeRestart},
+		{cause: fmt.Errorf("moving: %w", errRoomMigrated), want: closeRestart},
+		{cause: errRoomClosed, want: closeRoomClosed},
+		{cause: errRoomExpired, want: closeRoomClosed},
+		{cause: errors.New("something else"), want: closeShutdown},
+		{cause: nil, want: closeShutdown},
+	}
+	for _, tt := range tests {
+		if got := closeCodeFor(tt.cause); got != tt.want {
+			t.Errorf("closeCodeFor(%v) = %d, want %d", tt.cause, got, tt.want)
+		}
+	}
+}
+
+func TestCloseStatus(t *testing.T) {
+	long := strings.Repeat("é", maxCloseReason)
+	tests := []struct {
+		name       string
+		client     *Client
+		wantCode   int
+		wantReason string
+	}{
+		{name: "went away", client: &Client{}, wantCode: 0, wantReason: ""},
+		{name: "went away with a reason", client: &Client{leaveReason: "read error"}, wantCode: 0, wantReason: ""},
+		{name: "left", client: &Client{leaveReason: leaveClient}, wantCode: closeLeft, wantReason: leaveClient},
+		{name: "flooding", client: &Client{leaveReason: leaveRateLimited}, wantCode: closeFlooding, wantReason: closeRateLimited},
+		{name: "expelled", client: &Client{closeCode: closeKicked, closeReason: "cool off", leaveReason: leaveClient}, wantCode: closeKicked, wantReason: "cool off"},
+		{name: "long reason", client: &Client{closeCode: closeIdle, closeReason: long}, wantCode: closeIdle, wantReason: strings.Repeat("é", maxCloseReason/2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reason := tt.client.closeStatus()
+			if code != tt.wantCode || reason != tt.wantReason {
+				t.Errorf("closeStatus = %d %q, want %d %q", code, reason, tt.wantCode, tt.wantReason)
+			}
+			if len(reason) > maxCloseReason || !utf8.ValidString(reason) {
+				t.Errorf("reason %q does not fit a close frame", reason)
+			}
+		})
+	}
+}
+
+// TestDisconnectCloseCodes removes bob from a room in each way the server
+// can and checks the close frame he gets.
+func TestDisconnectCloseCodes(t *testing.T) {
+	const admin = "admin-token"
+	tests := []struct {
+		name string
+		// disconnect removes bob from a room amy owns; call makes an admin
+		// request, with {bob} in the path standing for his connection id.
+		disconnect func(amy, bob *testConn, call func(method, path string))
+		limits     ClientLimits
+		wantCode   int
+		wantReason string
+	}{
+		{name: "leave", disconnect: func(amy, bob *testConn, _ func(string, string)) {
+			bob.send(map[string]any{"type": "leave"})
+		}, wantCode: closeLeft, wantReason: leaveClient},
+		{name: "admin kick", disconnect: func(amy, bob *testConn, call func(string, string)) {
+			call("DELETE", "/api/admin/rooms/1234/clients/{bob}?reason=cool+off")
+		}, wantCode: closeKicked, wantReason: "cool off"},
+		{name: "moderator kick", disconnect: func(amy, bob *testConn, _ func(string, string)) {
+			amy.send(map[string]any{"type": "chat", "msg": "/kick bob: spamming"})
+		}, wantCode: closeKicked, wantReason: "kicked by amy"},
+		{name: "moderator ban", disconnect: func(amy, bob *testConn, _ func(string, string)) {
+			amy.send(map[string]any{"type": "chat", "msg": "/ban bob"})
+		}, wantCode: closeBanned, wantReason: "banned by amy"},
+		{name: "server ban", disconnect: func(amy, bob *testConn, call func(string, string)) {
+			call("POST", "/api/admin/bans")
+		}, wantCode: closeBanned, wantReason: "banned from this server"},
+		{name: "room closed", disconnect: func(amy, bob *testConn, call func(string, string)) {
+			call("DELETE", "/api/admin/rooms/1234")
+		}, wantCode: closeRoomClosed},
+		{name: "rate limited", disconnect: func(amy, bob *testConn, _ func(string, string)) {
+			for i := 0; i < 100; i++ {
+				bob.send(map[string]any{"type": "chat", "msg": "spam"})
+			}
+		}, limits: ClientLimits{Rate: 1, Burst: 2, Strikes: 3}, wantCode: closeFlooding, wantReason: closeRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := startServer(t, func(cfg *Config) {
+				cfg.AdminToken = admin
+				cfg.ClientLimits = tt.limits
+			})
+			amy := dial(t, ts, "1234", "amy", nil)
+			amy.expect("system")
+			bob := dial(t, ts, "1234", "bob", nil)
+			bobID := bob.expect("system")["from"].(string)
+
+			tt.disconnect(amy, bob, func(method, path string) {
+				body := ""
+				if method == "POST" {
+					body = `{"ip":"127.0.0.1"}`
+				}
+				if status, reply := call(t, ts, admin, method, strings.ReplaceAll(path, "{bob}", bobID), body); status >= http.StatusBadRequest {
+					t.Fatalf("%s %s = %d %s", method, path, status, reply)
+				}
+			})
+			ce := bob.closeError()
+			if ce.Code != tt.wantCode {
+				t.Errorf("close code = %d, want %d", ce.Code, tt.wantCode)
+			}
+			if tt.wantReason != "" && ce.Text != tt.wantReason {
+				t.Errorf("close reason = %q, want %q", ce.Text, tt.wantReason)
+			}
+		})
+	}
+}
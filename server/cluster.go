@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Every instance re-announces its rooms' presence this often, and forgets
+// another instance's announcement once it is presenceExpiry old, so the
+// members of an instance that died without saying so drop out.
+const (
+	presenceHeartbeat = 10 * time.Second
+	presenceExpiry    = 3 * presenceHeartbeat
+)
+
+// instancePresence is one instance's members of a room, as announced over
+// the backplane. Members is left out when the room has presence turned
+// off, and a zero Count withdraws the instance from the room.
+type instancePresence struct {
+	Count   int      `json:"count"`
+	Hidden  bool     `json:"hidden,omitempty"`
+	Members []Member `json:"members,omitempty"`
+}
+
+type remotePresence struct {
+	instancePresence
+	expires time.Time
+}
+
+// presenceRegistry holds the other instances' presence, by room and then
+// by instance. Safe for concurrent use.
+type presenceRegistry struct {
+	mu    sync.Mutex
+	rooms map[string]map[string]remotePresence
+}
+
+// update records an announcement from instance.
+func (r *presenceRegistry) update(room, instance string, p instancePresence, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p.Count == 0 {
+		delete(r.rooms[room], instance)
+		if len(r.rooms[room]) == 0 {
+			delete(r.rooms, room)
+		}
+		return
+	}
+	if r.rooms == nil {
+		r.rooms = make(map[string]map[string]remotePresence)
+	}
+	if r.rooms[room] == nil {
+		r.rooms[room] = make(map[string]remotePresence)
+	}
+	r.rooms[room][instance] = remotePresence{p, now.Add(presenceExpiry)}
+}
+
+// lookup returns the live remote presence of room: the members, how many
+// there are and on how many instances, and whether any instance hides its
+// member list.
+func (r *presenceRegistry) lookup(room string, now time.Time) (members []Member, count, instances int, hidden bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.rooms[room] {
+		if now.After(p.expires) {
+			continue
+		}
+		members = append(members, p.Members...)
+		count += p.Count
+		instances++
+		hidden = hidden || p.Hidden
+	}
+	return members, count, instances, hidden
+}
+
+// sweep forgets expired announcements.
+func (r *presenceRegistry) sweep(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for room, instances := range r.rooms {
+		for id, p := range instances {
+			if now.After(p.expires) {
+				delete(instances, id)
+			}
+		}
+		if len(instances) == 0 {
+			delete(r.rooms, room)
+		}
+	}
+}
+
+// announcePresence tells the other instances who is in h here, from its
+// last published snapshot.
+func (m *HubManager) announcePresence(h *Hub) {
+	if m.backplane == nil {
+		return
+	}
+	var p instancePresence
+	if snap := h.presence.Load(); snap != nil {
+		p.Count = len(snap.members)
+		if snap.enabled {
+			p.Members = snap.members
+		} else {
+			p.Hidden = true
+		}
+	}
+	m.backplane.PublishPresence(h.pin, p)
+}
+
+// remotePresence records another instance's announcement. It is the
+// backplane's presence callback.
+func (m *HubManager) remotePresence(room, instance string, p instancePresence) {
+	m.cluster.update(room, instance, p, time.Now())
+}
+
+// heartbeatPresence re-announces every room on a timer until ctx ends, so
+// other instances keep this one's members, and drops announcements that
+// have expired.
+func (m *HubManager) heartbeatPresence(ctx context.Context) {
+	ticker := time.NewTicker(presenceHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, h := range m.hubList() {
+				m.announcePresence(h)
+			}
+			m.cluster.sweep(now)
+		}
+	}
+}
+
+// clusterMembers merges local, a room's members here, with those other
+// instances announced, sorted by name.
+func clusterMembers(local, remote []Member) []Member {
+	if len(remote) == 0 {
+		return local
+	}
+	members := append(append(make([]Member, 0, len(local)+len(remote)), local...), remote...)
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"sort"
+	"strings"
+)
+
+// command is a chat command such as /who. A chat message whose text starts
+// with "/" runs the named command instead of being broadcast; "//" sends
+// the rest as ordinary chat.
+type command struct {
+	name string // without the slash
+	args string // argument synopsis for /help
+	help string
+	// perm, if set, is the permission the sender's role must grant.
+	perm permission
+	// run executes the command for msg.from with the text after the name.
+	// It is called on the room's run loop, so it may use run-only methods.
+	run func(h *Hub, msg *Message, args string)
+}
+
+// commands is the registry, filled by registerCommand from init functions.
+var commands = map[string]*command{}
+
+// registerCommand adds cmd to the registry, panicking on a duplicate name.
+func registerCommand(cmd *command) {
+	if _, dup := commands[cmd.name]; dup {
+		panic("duplicate command /" + cmd.name)
+	}
+	commands[cmd.name] = cmd
+}
+
+func init() {
+	registerCommand(&command{name: "help", help: "list commands", run: (*Hub).helpCommand})
+	registerCommand(&command{name: "who", help: "list who is in the room", run: (*Hub).whoCommand})
+	registerCommand(&command{name: "me", args: "<action>", help: "describe what you are doing", run: (*Hub).meCommand})
+	registerCommand(&command{name: "nick", args: "<name>", help: "change your display name", run: (*Hub).nickCommand})
+}
+
+// runCommand dispatches a chat message that is a command, reporting
+// whether it was one. Encrypted bodies and messages posted over HTTP never
+// are. Only run may call it.
+func (h *Hub) runCommand(msg *Message) bool {
+	if msg.from == nil || msg.opaque() {
+		return false
+	}
+	text := strings.TrimSpace(msg.Msg)
+	if !strings.HasPrefix(text, "/") {
+		return false
+	}
+	if strings.HasPrefix(text, "//") {
+		msg.Msg = strings.Replace(msg.Msg, "//", "/", 1)
+		return false
+	}
+	name, args, _ := strings.Cut(text[1:], " ")
+	name = strings.ToLower(name)
+	cmd := commands[name]
+	switch {
+	case cmd == nil:
+		msg.from.trySend(errorFrame(codeUnknownCommand, "unknown command /"+name+", try /help"))
+	case cmd.perm != "" && !msg.from.can(cmd.perm):
+		msg.from.trySend(errorFrame(codeForbidden, "your role does not allow /"+name))
+	default:
+		cmd.run(h, msg, strings.TrimSpace(args))
+	}
+	return true
+}
+
+// usageError tells the sender how to call cmd.
+func usageError(c *Client, name string) {
+	cmd := commands[name]
+	c.trySend(errorFrame(codeInvalidMessage, "usage: /"+cmd.name+" "+cmd.args))
+}
+
+// reply sends a system notice to the client alone.
+func (h *Hub) reply(c *Client, text string) {
+	c.trySend(h.frame(&Message{Type: "system", Msg: text}))
+}
+
+func (h *Hub) helpCommand(msg *Message, _ string) {
+	names := make([]string, 0, len(commands))
+	for name, cmd := range commands {
+		if cmd.perm == "" || msg.from.can(cmd.perm) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("Commands:")
+	for _, name := range names {
+		cmd := commands[name]
+		b.WriteString("\n/" + name)
+		if cmd.args != "" {
+			b.WriteString(" " + cmd.args)
+		}
+		b.WriteString(" — " + cmd.help)
+	}
+	h.reply(msg.from, b.String())
+}
+
+func (h *Hub) whoCommand(msg *Message, _ string) {
+	names := make([]string, 0, len(h.clients))
+	for c := range h.clients {
+		names = append(names, c.name+roleLabel(c))
+	}
+	sort.Strings(names)
+	h.reply(msg.from, "In this room: "+strings.Join(names, ", "))
+}
+
+func (h *Hub) meCommand(msg *Message, args string) {
+	if args == "" {
+		usageError(msg.from, "me")
+		return
+	}
+	msg.Msg, msg.Emote = args, true
+	h.broadcastChat(msg)
+}
+
+func (h *Hub) nickCommand(msg *Message, args string) {
+	c := msg.from
+	name, err := validateName(args)
+	if args == "" || err != nil {
+		if err == nil {
+			usageError(c, "nick")
+		} else {
+			c.trySend(errorFrame(codeInvalidName, err.Error()))
+		}
+		return
+	}
+	if name == c.name {
+		return
+	}
+	if !strings.EqualFold(name, c.name) && h.nameTaken(name) {
+		c.trySend(errorFrame(codeNameTaken, "the name "+`"`+name+`"`+" is already in use in this room"))
+		return
+	}
+	if h.bans.banned(name, "") {
+		c.trySend(errorFrame(codeForbidden, "that name is banned from this room"))
+		return
+	}
+	old := c.name
+	c.name = name
+	c.log.Info("renamed", "new_name", name)
+	h.presenceDirty = true
+	h.fanOut(h.frame(&Message{Type: "renamed", User: old, Name: name}))
+}
@@ -0,0 +1,788 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minAdminTokenLen is the shortest ADMIN_TOKEN accepted at boot.
+const minAdminTokenLen = 16
+
+// Config is every tunable the server reads from its config file and
+// environment, loaded once at startup by LoadConfig.
+type Config struct {
+	Port          string
+	AllowNoOrigin bool
+	AdminToken    string
+
+	// StaticDir holds the web client, served at / and /static/; empty
+	// serves only the API.
+	StaticDir string
+
+	// DebugEndpoints mounts /debug/pprof and /debug/hubs behind the admin
+	// token.
+	DebugEndpoints bool
+
+	// HTTP server timeouts.
+	HTTPReadTimeout  time.Duration
+	HTTPWriteTimeout time.Duration
+	HTTPIdleTimeout  time.Duration
+
+	// WebSocket tunables: how long a write may take, how long a silent
+	// connection lives, the largest frame read, the socket buffer sizes
+	// and how many frames may wait for a slow client.
+	WriteTimeout    time.Duration
+	PongTimeout     time.Duration
+	MaxMessageSize  int
+	ReadBufferSize  int
+	WriteBufferSize int
+	SendQueueSize   int
+
+	// SendOverflow is what a full send queue does: disconnect, coalesce
+	// or drop-oldest.
+	SendOverflow     overflowPolicy
+	sendOverflowName string
+
+	// RejectInvalidUTF8 refuses frames with bad UTF-8 rather than
+	// repairing them.
+	RejectInvalidUTF8 bool
+
+	// MaxConnsPerIP and MaxConns cap open WebSocket and SSE connections
+	// per client address and in total; zero is unlimited.
+	MaxConnsPerIP int
+	MaxConns      int
+
+	// LogLevel and LogFormat ("text" or "json") configure the logger.
+	LogLevel  slog.Level
+	LogFormat string
+	logLevel  string
+
+	// AllowedOrigins are the browser origins, besides the server's own,
+	// allowed to open WebSockets.
+	AllowedOrigins []originRule
+	originList     []string
+
+	// JWTSecret enables /api/token and token auth on /ws; JWTTTL is the
+	// lifetime of issued tokens.
+	JWTSecret string
+	JWTTTL    time.Duration
+
+	// OAuthProvider ("google", "github" or "oidc") enables sign-in at
+	// /auth/login. OAuthIssuer is the OIDC issuer URL for "oidc", and
+	// OAuthRedirectURL the absolute URL of /auth/callback as registered
+	// with the provider. SessionTTL is how long the session cookie lasts.
+	OAuthProvider     string
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthIssuer       string
+	OAuthRedirectURL  string
+	SessionTTL        time.Duration
+
+	// Accounts lets users register with a username and password at
+	// /api/accounts, so they keep their profile and dms across sessions.
+	Accounts bool
+
+	// VAPIDPrivateKey enables Web Push: signed-in users can subscribe
+	// their browsers at /api/push/subscriptions and are notified of
+	// mentions and dms in rooms they have left. VAPIDSubject is the
+	// mailto: or https: contact push services see.
+	VAPIDPrivateKey string
+	VAPIDSubject    string
+
+	// LinkPreviews fetches OpenGraph previews of links posted in chat.
+	LinkPreviews bool
+
+	// TrustProxyHeaders takes client addresses from X-Forwarded-For and
+	// the request scheme from X-Forwarded-Proto.
+	TrustProxyHeaders bool
+	// TrustedProxyHops is how many proxies in front of the server append
+	// to X-Forwarded-For; the client address is that many entries from
+	// the right.
+	TrustedProxyHops int
+
+	// AnonActions lists what anonymous clients may do; empty allows all.
+	AnonActions []string
+
+	MOTDURL      string
+	MOTDInterval time.Duration
+
+	MessageRetention time.Duration
+	// MessageRoomLimit is how many stored messages each room keeps; zero
+	// keeps them all.
+	MessageRoomLimit int
+	RoomDefaults     RoomSettings
+
+	// ClientLimits throttle each connection independently of the room.
+	ClientLimits ClientLimits
+
+	// SpamLimits mute members who repeat themselves or flood links.
+	SpamLimits SpamLimits
+	// IdleLimits disconnect members who send no chat for a while.
+	IdleLimits IdleLimits
+
+	// ResumeWindow is how long a dropped connection may be resumed with
+	// its resume token; zero disables resuming.
+	ResumeWindow time.Duration
+
+	// MailboxWindow is how long missed chat is held for a user who
+	// disconnected; zero disables it.
+	MailboxWindow time.Duration
+
+	// RoomIdleTTL keeps an empty room, with its history, bans and
+	// settings, for reconnects; zero closes it when the last client leaves.
+	RoomIdleTTL time.Duration
+
+	// RoomCapacity is the default member limit per room; RoomMaxCapacity
+	// bounds what a creator may request.
+	RoomCapacity    int
+	RoomMaxCapacity int
+
+	// RequireRoomCreate refuses joins to PINs that were not created with
+	// POST /api/rooms.
+	RequireRoomCreate bool
+
+	// RoomPasswordTTL is how long a room creator's password stays in
+	// force; zero keeps it for the life of the room.
+	RoomPasswordTTL time.Duration
+
+	// Store selects the history backend: "memory" or "sqlite".
+	Store          string
+	StoreDSN       string
+	StoreRoomLimit int
+
+	// UploadDir stores shared files; empty disables uploads.
+	UploadDir      string
+	UploadMaxBytes int64
+	UploadTypes    []string
+	// UploadThumbSize is the longest side of image thumbnails in pixels;
+	// 0 turns them off.
+	UploadThumbSize int
+
+	// Bots run in every room.
+	Bots []string
+
+	// FilterWords and the words in FilterWordList are masked, or with
+	// FilterAction "reject" refused, in every room.
+	FilterWords    []string
+	FilterWordList string
+	FilterAction   string
+
+	// RedisURL or NATSURL enables the multi-instance backplane when set.
+	RedisURL string
+	NATSURL  string
+
+	// TLSCertFile and TLSKeyFile serve HTTPS from a certificate on disk;
+	// TLSDomains instead obtains certificates from Let's Encrypt, cached in
+	// TLSCacheDir. HTTPRedirectPort, if set, serves plain HTTP there and
+	// redirects it to HTTPS.
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSDomains       []string
+	TLSCacheDir      string
+	TLSEmail         string
+	HTTPRedirectPort string
+
+	// GRPCPort, if set, serves the gRPC API there, over TLS when the
+	// server terminates TLS itself.
+	GRPCPort string
+
+	// OTLPEndpoint, if set, exports trace spans there over OTLP/HTTP, as
+	// host:port or a URL.
+	OTLPEndpoint string
+
+	// ShutdownTimeout bounds how long a SIGTERM waits for rooms to close
+	// and the store to flush.
+	ShutdownTimeout time.Duration
+
+	// DrainDelay is how long a drain keeps connections open after /readyz
+	// starts failing, before telling clients to reconnect.
+	DrainDelay time.Duration
+}
+
+// LoadConfig reads and validates the config file at path, if any, with
+// environment variables taking precedence over it. The returned error
+// lists every problem found, not just the first.
+func LoadConfig(path string) (*Config, error) {
+	file, err := readConfigFile(path)
+	if err != nil {
+		return nil, configError{err}
+	}
+	seen := make(map[string]bool)
+	cfg, err := loadConfig(func(key string) string {
+		seen[key] = true
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+		return file[key].value
+	})
+	var errs configError
+	if err != nil && !errors.As(err, &errs) {
+		return nil, err
+	}
+	for key, s := range file {
+		if !seen[key] {
+			errs = append(errs, fmt.Errorf("%s:%d: unknown setting %s", path, s.line, s.name))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+		return nil, errs
+	}
+	return cfg, nil
+}
+
+// DefaultConfig returns the settings used when nothing is configured, for
+// programs that build a Server without LoadConfig. Change fields as
+// needed; StaticDir and UploadDir name directories relative to the
+// working directory, and can be emptied to turn the web client and
+// uploads off.
+func DefaultConfig() *Config {
+	cfg, err := loadConfig(func(string) string { return "" })
+	if err != nil {
+		panic("server: default config is invalid: " + err.Error())
+	}
+	return cfg
+}
+
+func loadConfig(getenv func(string) string) (*Config, error) {
+	env := &envReader{getenv: getenv}
+	cfg := &Config{
+		Port:              env.str("PORT", "8080"),
+		StaticDir:         env.str("STATIC_DIR", "static"),
+		HTTPReadTimeout:   env.duration("HTTP_READ_TIMEOUT", 10*time.Second),
+		HTTPWriteTimeout:  env.duration("HTTP_WRITE_TIMEOUT", 10*time.Second),
+		HTTPIdleTimeout:   env.duration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+		WriteTimeout:      env.duration("WS_WRITE_TIMEOUT", 10*time.Second),
+		PongTimeout:       env.duration("WS_PONG_TIMEOUT", 60*time.Second),
+		MaxMessageSize:    env.integer("MAX_MESSAGE_SIZE", 8*1024),
+		ReadBufferSize:    env.integer("WS_READ_BUFFER", 1024),
+		WriteBufferSize:   env.integer("WS_WRITE_BUFFER", 1024),
+		SendQueueSize:     env.integer("SEND_QUEUE_SIZE", 256),
+		sendOverflowName:  env.str("SEND_OVERFLOW", "coalesce"),
+		RejectInvalidUTF8: env.boolean("REJECT_INVALID_UTF8", false),
+		MaxConnsPerIP:     env.integer("MAX_CONNS_PER_IP", 256),
+		MaxConns:          env.integer("MAX_CONNS", 10000),
+		logLevel:          env.str("LOG_LEVEL", "info"),
+		LogFormat:         env.str("LOG_FORMAT", "text"),
+		AllowNoOrigin:     env.boolean("ALLOW_NO_ORIGIN", true),
+		originList:        env.list("ALLOWED_ORIGINS"),
+		AdminToken:        env.str("ADMIN_TOKEN", ""),
+		DebugEndpoints:    env.boolean("DEBUG_ENDPOINTS", false),
+		TrustProxyHeaders: env.boolean("TRUST_PROXY_HEADERS", false),
+		TrustedProxyHops:  env.integer("TRUSTED_PROXY_HOPS", 1),
+		JWTSecret:         env.str("JWT_SECRET", ""),
+		JWTTTL:            env.duration("JWT_TTL", 15*time.Minute),
+		OAuthProvider:     env.str("OAUTH_PROVIDER", ""),
+		OAuthClientID:     env.str("OAUTH_CLIENT_ID", ""),
+		OAuthClientSecret: env.str("OAUTH_CLIENT_SECRET", ""),
+		OAuthIssuer:       env.str("OAUTH_ISSUER", ""),
+		OAuthRedirectURL:  env.str("OAUTH_REDIRECT_URL", ""),
+		SessionTTL:        env.duration("SESSION_TTL", 7*24*time.Hour),
+		Accounts:          env.boolean("ACCOUNTS", false),
+		VAPIDPrivateKey:   env.str("VAPID_PRIVATE_KEY", ""),
+		VAPIDSubject:      env.str("VAPID_SUBJECT", ""),
+		LinkPreviews:      env.boolean("LINK_PREVIEWS", false),
+		AnonActions:       env.list("ANON_ACTIONS"),
+		MOTDURL:           env.str("MOTD_URL", ""),
+		MOTDInterval:      env.duration("MOTD_INTERVAL", 5*time.Minute),
+		MessageRetention:  env.duration("MESSAGE_RETENTION", 0),
+		MessageRoomLimit:  env.integer("MESSAGE_ROOM_LIMIT", 0),
+		RoomDefaults: RoomSettings{
+			Rate:  env.float("ROOM_MSG_RATE", 0),
+			Burst: env.integer("ROOM_MSG_BURST", 0),
+		},
+		ClientLimits: ClientLimits{
+			Rate:    env.float("CLIENT_MSG_RATE", 5),
+			Burst:   env.integer("CLIENT_MSG_BURST", 10),
+			Strikes: env.integer("CLIENT_FLOOD_STRIKES", 20),
+		},
+		SpamLimits: SpamLimits{
+			Mute:    env.duration("SPAM_MUTE", time.Minute),
+			Window:  env.duration("SPAM_WINDOW", 30*time.Second),
+			Repeats: env.integer("SPAM_REPEATS", 3),
+			Links:   env.integer("SPAM_LINKS", 5),
+		},
+		IdleLimits: IdleLimits{
+			Timeout: env.duration("IDLE_TIMEOUT", 0),
+			Warning: env.duration("IDLE_WARNING", time.Minute),
+		},
+		RoomIdleTTL:       env.duration("ROOM_IDLE_TTL", 2*time.Minute),
+		MailboxWindow:     env.duration("MAILBOX_WINDOW", 10*time.Minute),
+		ResumeWindow:      env.duration("RESUME_WINDOW", 30*time.Second),
+		RoomCapacity:      env.integer("ROOM_CAPACITY", 100),
+		RoomMaxCapacity:   env.integer("ROOM_MAX_CAPACITY", 1000),
+		RoomPasswordTTL:   env.duration("ROOM_PASSWORD_TTL", 24*time.Hour),
+		RequireRoomCreate: env.boolean("REQUIRE_ROOM_CREATE", false),
+		Store:             env.str("STORE", "memory"),
+		StoreDSN:          env.str("STORE_DSN", "gochat.db"),
+		StoreRoomLimit:    env.integer("STORE_ROOM_LIMIT", 1000),
+		RedisURL:          env.str("REDIS_URL", ""),
+		NATSURL:           env.str("NATS_URL", ""),
+		UploadDir:         env.str("UPLOAD_DIR", "uploads"),
+		UploadMaxBytes:    int64(env.integer("UPLOAD_MAX_BYTES", 5<<20)),
+		UploadTypes:       env.list("UPLOAD_TYPES"),
+		UploadThumbSize:   env.integer("UPLOAD_THUMB_SIZE", defaultThumbSize),
+		Bots:              env.list("BOTS"),
+		FilterWords:       env.list("FILTER_WORDS"),
+		FilterWordList:    env.str("FILTER_WORDLIST", ""),
+		FilterAction:      env.str("FILTER_ACTION", "mask"),
+		TLSCertFile:       env.str("TLS_CERT_FILE", ""),
+		TLSKeyFile:        env.str("TLS_KEY_FILE", ""),
+		TLSDomains:        env.list("TLS_DOMAINS"),
+		TLSCacheDir:       env.str("TLS_CACHE_DIR", "certs"),
+		TLSEmail:          env.str("TLS_EMAIL", ""),
+		HTTPRedirectPort:  env.str("HTTP_REDIRECT_PORT", ""),
+		GRPCPort:          env.str("GRPC_PORT", ""),
+		OTLPEndpoint:      env.str("OTLP_ENDPOINT", ""),
+		ShutdownTimeout:   env.duration("SHUTDOWN_TIMEOUT", 10*time.Second),
+		DrainDelay:        env.duration("DRAIN_DELAY", 5*time.Second),
+	}
+	cfg.validate(env)
+	if len(env.errs) > 0 {
+		return nil, configError(env.errs)
+	}
+	return cfg, nil
+}
+
+// configError lists every configuration problem, one per line.
+type configError []error
+
+func (e configError) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid configuration:")
+	for _, err := range e {
+		b.WriteString("\n  - ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e configError) Unwrap() []error { return e }
+
+func (c *Config) validate(env *envReader) {
+	if len(c.originList) == 0 {
+		c.originList = strings.Split(defaultAllowedOrigins, ",")
+	}
+	rules, err := parseOriginList(c.originList)
+	if err != nil {
+		env.fail("ALLOWED_ORIGINS: %v", err)
+	}
+	c.AllowedOrigins = rules
+
+	if l, err := parseLogLevel(c.logLevel); err != nil {
+		env.fail("LOG_LEVEL must be debug, info, warn or error: %v", err)
+	} else {
+		c.LogLevel = l
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		env.fail("LOG_FORMAT must be text or json, got %q", c.LogFormat)
+	}
+
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		env.fail("PORT must be a number between 1 and 65535, got %q", c.Port)
+	}
+	for name, d := range map[string]time.Duration{
+		"HTTP_READ_TIMEOUT":  c.HTTPReadTimeout,
+		"HTTP_WRITE_TIMEOUT": c.HTTPWriteTimeout,
+		"HTTP_IDLE_TIMEOUT":  c.HTTPIdleTimeout,
+		"WS_WRITE_TIMEOUT":   c.WriteTimeout,
+	} {
+		if d <= 0 {
+			env.fail("%s must be positive, got %v", name, d)
+		}
+	}
+	if c.PongTimeout < 2*time.Second {
+		env.fail("WS_PONG_TIMEOUT must be at least 2s, got %v", c.PongTimeout)
+	}
+	if c.MaxMessageSize < 1024 || c.MaxMessageSize > 1<<20 {
+		env.fail("MAX_MESSAGE_SIZE must be between 1024 and 1048576")
+	}
+	if c.ReadBufferSize < 256 || c.ReadBufferSize > 1<<20 || c.WriteBufferSize < 256 || c.WriteBufferSize > 1<<20 {
+		env.fail("WS_READ_BUFFER and WS_WRITE_BUFFER must be between 256 and 1048576")
+	}
+	if c.SendQueueSize < 16 || c.SendQueueSize > 65536 {
+		env.fail("SEND_QUEUE_SIZE must be between 16 and 65536")
+	}
+	if p, err := parseOverflowPolicy(c.sendOverflowName); err != nil {
+		env.fail("SEND_OVERFLOW %v", err)
+	} else {
+		c.SendOverflow = p
+	}
+	if c.MaxConnsPerIP < 0 || c.MaxConns < 0 {
+		env.fail("MAX_CONNS_PER_IP and MAX_CONNS must not be negative")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		env.fail("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if len(c.TLSDomains) > 0 {
+		if c.TLSCertFile != "" {
+			env.fail("set TLS_DOMAINS or TLS_CERT_FILE, not both")
+		}
+		if autocertHook == nil {
+			env.fail("TLS_DOMAINS needs a binary built with -tags autocert")
+		}
+	}
+	if c.HTTPRedirectPort != "" {
+		if p, err := strconv.Atoi(c.HTTPRedirectPort); err != nil || p < 1 || p > 65535 || c.HTTPRedirectPort == c.Port {
+			env.fail("HTTP_REDIRECT_PORT must be a port other than PORT, got %q", c.HTTPRedirectPort)
+		}
+		if !c.TLSEnabled() {
+			env.fail("HTTP_REDIRECT_PORT needs TLS_CERT_FILE or TLS_DOMAINS")
+		}
+	}
+	if c.GRPCPort != "" {
+		if p, err := strconv.Atoi(c.GRPCPort); err != nil || p < 1 || p > 65535 || c.GRPCPort == c.Port || c.GRPCPort == c.HTTPRedirectPort {
+			env.fail("GRPC_PORT must be a port other than PORT and HTTP_REDIRECT_PORT, got %q", c.GRPCPort)
+		}
+		if grpcHook == nil {
+			env.fail("GRPC_PORT needs a binary built with -tags grpc")
+		}
+	}
+	if c.OTLPEndpoint != "" && otelHook == nil {
+		env.fail("OTLP_ENDPOINT needs a binary built with -tags otel")
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < minAdminTokenLen {
+		env.fail("ADMIN_TOKEN must be at least %d characters", minAdminTokenLen)
+	}
+	if c.DebugEndpoints && c.AdminToken == "" {
+		env.fail("DEBUG_ENDPOINTS requires ADMIN_TOKEN")
+	}
+	if c.JWTSecret != "" && len(c.JWTSecret) < minJWTSecretLen {
+		env.fail("JWT_SECRET must be at least %d characters", minJWTSecretLen)
+	}
+	if c.JWTTTL < time.Minute || c.JWTTTL > 24*time.Hour {
+		env.fail("JWT_TTL must be between 1m and 24h, got %v", c.JWTTTL)
+	}
+	if c.SessionTTL < time.Hour || c.SessionTTL > 90*24*time.Hour {
+		env.fail("SESSION_TTL must be between 1h and 2160h, got %v", c.SessionTTL)
+	}
+	c.validateOAuth(env)
+	if c.Accounts && c.JWTSecret == "" {
+		env.fail("ACCOUNTS requires JWT_SECRET, which signs sessions")
+	}
+	if c.VAPIDPrivateKey != "" {
+		if _, err := parseVAPIDKey(c.VAPIDPrivateKey); err != nil {
+			env.fail("VAPID_PRIVATE_KEY must be a base64url P-256 private key: %v", err)
+		}
+		if c.JWTSecret == "" {
+			env.fail("VAPID_PRIVATE_KEY requires JWT_SECRET; push subscriptions belong to signed-in users")
+		}
+		if !strings.HasPrefix(c.VAPIDSubject, "mailto:") && !strings.HasPrefix(c.VAPIDSubject, "https://") {
+			env.fail("VAPID_SUBJECT must be a mailto: or https:// URL, got %q", c.VAPIDSubject)
+		}
+	}
+	for _, a := range c.AnonActions {
+		if a != "none" && !knownActions[a] {
+			env.fail("ANON_ACTIONS: unknown action %q", a)
+		}
+	}
+	if c.MOTDURL != "" {
+		u, err := url.Parse(c.MOTDURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			env.fail("MOTD_URL must be an http(s) URL, got %q", c.MOTDURL)
+		}
+		if c.MOTDInterval < 10*time.Second {
+			env.fail("MOTD_INTERVAL must be at least 10s, got %v", c.MOTDInterval)
+		}
+	} else if env.getenv("MOTD_INTERVAL") != "" {
+		env.fail("MOTD_INTERVAL is set but MOTD_URL is empty")
+	}
+	if c.MessageRetention < 0 {
+		env.fail("MESSAGE_RETENTION must not be negative")
+	}
+	if c.MessageRoomLimit < 0 {
+		env.fail("MESSAGE_ROOM_LIMIT must not be negative")
+	}
+	if c.TrustedProxyHops < 1 {
+		env.fail("TRUSTED_PROXY_HOPS must be at least 1, got %d", c.TrustedProxyHops)
+	}
+	if c.ClientLimits.Rate < 0 || c.ClientLimits.Burst < 0 || c.ClientLimits.Strikes < 0 {
+		env.fail("CLIENT_MSG_RATE, CLIENT_MSG_BURST and CLIENT_FLOOD_STRIKES must not be negative")
+	}
+	if c.SpamLimits.Mute < 0 || c.SpamLimits.Repeats < 0 || c.SpamLimits.Links < 0 {
+		env.fail("SPAM_MUTE, SPAM_REPEATS and SPAM_LINKS must not be negative")
+	}
+	if c.SpamLimits.Mute > 0 && c.SpamLimits.Window <= 0 {
+		env.fail("SPAM_WINDOW must be positive when SPAM_MUTE is set")
+	}
+	if c.IdleLimits.Timeout > 0 {
+		if c.IdleLimits.Timeout < time.Minute {
+			env.fail("IDLE_TIMEOUT must be at least 1m, got %v", c.IdleLimits.Timeout)
+		}
+		if c.IdleLimits.Warning < 0 || c.IdleLimits.Warning >= c.IdleLimits.Timeout {
+			env.fail("IDLE_WARNING must be at least 0 and less than IDLE_TIMEOUT, got %v", c.IdleLimits.Warning)
+		}
+	} else if c.IdleLimits.Timeout < 0 {
+		env.fail("IDLE_TIMEOUT must not be negative")
+	} else if env.getenv("IDLE_WARNING") != "" {
+		env.fail("IDLE_WARNING is set but IDLE_TIMEOUT is 0")
+	}
+	if c.MailboxWindow < 0 {
+		env.fail("MAILBOX_WINDOW must not be negative")
+	}
+	if c.ResumeWindow < 0 {
+		env.fail("RESUME_WINDOW must not be negative")
+	}
+	if c.RoomIdleTTL < 0 {
+		env.fail("ROOM_IDLE_TTL must not be negative")
+	}
+	if c.RoomCapacity < 1 || c.RoomMaxCapacity < c.RoomCapacity {
+		env.fail("ROOM_CAPACITY must be at least 1 and at most ROOM_MAX_CAPACITY")
+	}
+	if len(c.UploadTypes) == 0 {
+		c.UploadTypes = strings.Split(defaultUploadTypes, ",")
+	}
+	for _, t := range c.UploadTypes {
+		if _, ok := uploadExt[t]; !ok {
+			env.fail("UPLOAD_TYPES: unsupported type %q", t)
+		}
+	}
+	if err := checkBots(c.Bots); err != nil {
+		env.fail("BOTS: %v", err)
+	}
+	if c.FilterAction != "mask" && c.FilterAction != "reject" {
+		env.fail("FILTER_ACTION must be mask or reject, got %q", c.FilterAction)
+	}
+	if c.FilterWordList != "" {
+		words, err := readWordList(c.FilterWordList)
+		if err != nil {
+			env.fail("FILTER_WORDLIST: %v", err)
+		}
+		c.FilterWords = append(c.FilterWords, words...)
+	}
+	if c.UploadMaxBytes < 1 || c.UploadMaxBytes > 100<<20 {
+		env.fail("UPLOAD_MAX_BYTES must be between 1 and 104857600")
+	}
+	if c.UploadThumbSize != 0 && (c.UploadThumbSize < 16 || c.UploadThumbSize > 2048) {
+		env.fail("UPLOAD_THUMB_SIZE must be 0 or between 16 and 2048")
+	}
+	if c.ShutdownTimeout <= 0 {
+		env.fail("SHUTDOWN_TIMEOUT must be positive")
+	}
+	if c.DrainDelay < 0 {
+		env.fail("DRAIN_DELAY must not be negative")
+	}
+	if c.RoomPasswordTTL < 0 {
+		env.fail("ROOM_PASSWORD_TTL must not be negative")
+	}
+	if pe := c.RoomDefaults.validate(); pe != nil {
+		env.fail("ROOM_MSG_RATE/ROOM_MSG_BURST: %s", pe.detail)
+	}
+	if c.RedisURL != "" {
+		if u, err := url.Parse(c.RedisURL); err != nil || u.Scheme != "redis" || u.Host == "" {
+			env.fail("REDIS_URL must look like redis://[:password@]host:port[/db]")
+		}
+	}
+	if c.NATSURL != "" {
+		if u, err := url.Parse(c.NATSURL); err != nil || u.Scheme != "nats" || u.Host == "" {
+			env.fail("NATS_URL must look like nats://[user:password@]host:port")
+		}
+		if c.RedisURL != "" {
+			env.fail("set at most one of REDIS_URL and NATS_URL")
+		}
+	}
+	switch c.Store {
+	case "memory":
+		if c.StoreRoomLimit < historySize {
+			env.fail("STORE_ROOM_LIMIT must be at least %d", historySize)
+		}
+	case "sqlite":
+	default:
+		env.fail("STORE must be memory or sqlite, got %q", c.Store)
+	}
+}
+
+// Summary is a one-line description of the effective configuration with
+// secrets redacted, for the boot log.
+func (c *Config) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "port=%s log_level=%v allow_no_origin=%v allowed_origins=%s admin_token=%s store=%s",
+		c.Port, c.LogLevel, c.AllowNoOrigin, strings.Join(c.originList, ","), redact(c.AdminToken), c.Store)
+	if c.TLSCertFile != "" {
+		fmt.Fprintf(&b, " tls_cert_file=%s", c.TLSCertFile)
+	}
+	if len(c.TLSDomains) > 0 {
+		fmt.Fprintf(&b, " tls_domains=%s", strings.Join(c.TLSDomains, ","))
+	}
+	if c.HTTPRedirectPort != "" {
+		fmt.Fprintf(&b, " http_redirect_port=%s", c.HTTPRedirectPort)
+	}
+	if c.GRPCPort != "" {
+		fmt.Fprintf(&b, " grpc_port=%s", c.GRPCPort)
+	}
+	if c.OTLPEndpoint != "" {
+		fmt.Fprintf(&b, " otlp_endpoint=%s", c.OTLPEndpoint)
+	}
+	if c.JWTSecret != "" {
+		fmt.Fprintf(&b, " jwt_secret=%s jwt_ttl=%v", redact(c.JWTSecret), c.JWTTTL)
+	}
+	if c.OAuthProvider != "" {
+		fmt.Fprintf(&b, " oauth_provider=%s oauth_client_id=%s oauth_client_secret=%s oauth_redirect_url=%s session_ttl=%v",
+			c.OAuthProvider, c.OAuthClientID, redact(c.OAuthClientSecret), c.OAuthRedirectURL, c.SessionTTL)
+		if c.OAuthIssuer != "" {
+			fmt.Fprintf(&b, " oauth_issuer=%s", c.OAuthIssuer)
+		}
+	}
+	if c.Accounts {
+		b.WriteString(" accounts=true")
+	}
+	if c.VAPIDPrivateKey != "" {
+		fmt.Fprintf(&b, " vapid_private_key=%s vapid_subject=%s", redact(c.VAPIDPrivateKey), c.VAPIDSubject)
+	}
+	if c.LinkPreviews {
+		b.WriteString(" link_previews=true")
+	}
+	if len(c.AnonActions) > 0 {
+		fmt.Fprintf(&b, " anon_actions=%s", strings.Join(c.AnonActions, ","))
+	}
+	if c.MOTDURL != "" {
+		fmt.Fprintf(&b, " motd_url=%s motd_interval=%v", c.MOTDURL, c.MOTDInterval)
+	}
+	if c.MessageRetention > 0 {
+		fmt.Fprintf(&b, " message_retention=%v", c.MessageRetention)
+	}
+	if c.MessageRoomLimit > 0 {
+		fmt.Fprintf(&b, " message_room_limit=%d", c.MessageRoomLimit)
+	}
+	if c.ResumeWindow > 0 {
+		fmt.Fprintf(&b, " resume_window=%v", c.ResumeWindow)
+	}
+	if c.RedisURL != "" {
+		fmt.Fprintf(&b, " redis=%s", redactURL(c.RedisURL))
+	}
+	if c.NATSURL != "" {
+		fmt.Fprintf(&b, " nats=%s", redactURL(c.NATSURL))
+	}
+	if c.UploadDir != "" {
+		fmt.Fprintf(&b, " upload_dir=%s upload_max_bytes=%d upload_thumb_size=%d", c.UploadDir, c.UploadMaxBytes, c.UploadThumbSize)
+	}
+	if c.DebugEndpoints {
+		b.WriteString(" debug_endpoints=true")
+	}
+	fmt.Fprintf(&b, " max_conns_per_ip=%d max_conns=%d send_overflow=%s", c.MaxConnsPerIP, c.MaxConns, c.sendOverflowName)
+	if len(c.FilterWords) > 0 {
+		fmt.Fprintf(&b, " filter_words=%d filter_action=%s", len(c.FilterWords), c.FilterAction)
+	}
+	if len(c.Bots) > 0 {
+		fmt.Fprintf(&b, " bots=%s", strings.Join(c.Bots, ","))
+	}
+	if c.ClientLimits.Rate > 0 {
+		fmt.Fprintf(&b, " client_msg_rate=%g client_msg_burst=%d", c.ClientLimits.Rate, c.ClientLimits.Burst)
+	}
+	if c.SpamLimits.Mute > 0 {
+		fmt.Fprintf(&b, " spam_mute=%v spam_window=%v spam_repeats=%d spam_links=%d", c.SpamLimits.Mute, c.SpamLimits.Window, c.SpamLimits.Repeats, c.SpamLimits.Links)
+	}
+	if c.IdleLimits.Timeout > 0 {
+		fmt.Fprintf(&b, " idle_timeout=%v idle_warning=%v", c.IdleLimits.Timeout, c.IdleLimits.Warning)
+	}
+	if c.RoomDefaults.Rate > 0 {
+		fmt.Fprintf(&b, " room_msg_rate=%g room_msg_burst=%d", c.RoomDefaults.Rate, c.RoomDefaults.Burst)
+	}
+	return b.String()
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return "unset"
+	}
+	return "[redacted]"
+}
+
+// redactURL hides any password in a connection URL.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[invalid]"
+	}
+	if _, ok := u.User.Password(); u.User != nil && !ok {
+		u.User = url.User("xxxxx") // a bare token
+	}
+	return u.Redacted()
+}
+
+// envReader parses typed values from the environment, collecting errors
+// instead of stopping at the first one.
+type envReader struct {
+	getenv func(string) string
+	errs   []error
+}
+
+func (e *envReader) fail(format string, args ...any) {
+	e.errs = append(e.errs, fmt.Errorf(format, args...))
+}
+
+func (e *envReader) str(key, def string) string {
+	if v := strings.TrimSpace(e.getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func (e *envReader) list(key string) []string {
+	var out []string
+	for _, v := range strings.Split(e.getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (e *envReader) boolean(key string, def bool) bool {
+	v := e.getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.fail("%s must be a boolean, got %q", key, v)
+		return def
+	}
+	return b
+}
+
+func (e *envReader) duration(key string, def time.Duration) time.Duration {
+	v := e.getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.fail("%s must be a duration like 30s or 24h, got %q", key, v)
+		return def
+	}
+	return d
+}
+
+func (e *envReader) float(key string, def float64) float64 {
+	v := e.getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		e.fail("%s must be a number, got %q", key, v)
+		return def
+	}
+	return f
+}
+
+func (e *envReader) integer(key string, def int) int {
+	v := e.getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.fail("%s must be an integer, got %q", key, v)
+		return def
+	}
+	return n
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantErrs []string // each must appear in the error
+		check    func(t *testing.T, c *Config)
+	}{
+		{name: "defaults", check: func(t *testing.T, c *Config) {
+			if c.Port != "8080" || !c.AllowNoOrigin || c.MOTDInterval != 5*time.Minute || c.MessageRoomLimit != 0 {
+				t.Errorf("unexpected defaults: %+v", c)
+			}
+		}},
+		{name: "valid overrides", env: map[string]string{
+			"PORT": "9000", "ALLOW_NO_ORIGIN": "false", "ANON_ACTIONS": "react, msg", "MOTD_URL": "https://example.com/motd",
+			"MOTD_INTERVAL": "30s", "MESSAGE_RETENTION": "24h", "MESSAGE_ROOM_LIMIT": "50", "ROOM_MSG_RATE": "2.5",
+		}, check: func(t *testing.T, c *Config) {
+			if c.Port != "9000" || c.AllowNoOrigin || strings.Join(c.AnonActions, ",") != "react,msg" ||
+				c.MOTDInterval != 30*time.Second || c.MessageRetention != 24*time.Hour || c.MessageRoomLimit != 50 || c.RoomDefaults.Rate != 2.5 {
+				t.Errorf("overrides not applied: %+v", c)
+			}
+		}},
+		{name: "port out of range", env: map[string]string{"PORT": "70000"}, wantErrs: []string{"PORT must be a number between 1 and 65535"}},
+		{name: "short admin token", env: map[string]string{"ADMIN_TOKEN": "short"}, wantErrs: []string{"ADMIN_TOKEN must be at least"}},
+		{name: "unknown action", env: map[string]string{"ANON_ACTIONS": "msg,shout"}, wantErrs: []string{`unknown action "shout"`}},
+		{name: "interval without url", env: map[string]string{"MOTD_INTERVAL": "1m"}, wantErrs: []string{"MOTD_INTERVAL is set but MOTD_URL is empty"}},
+		{name: "not a url", env: map[string]string{"MOTD_URL": "ftp://example.com"}, wantErrs: []string{"MOTD_URL must be an http(s) URL"}},
+		{name: "not a duration", env: map[string]string{"MESSAGE_RETENTION": "a day"}, wantErrs: []string{"MESSAGE_RETENTION must be a duration"}},
+		{name: "negative room limit", env: map[string]string{"MESSAGE_ROOM_LIMIT": "-1"}, wantErrs: []string{"MESSAGE_ROOM_LIMIT must not be negative"}},
+		{name: "not a boolean", env: map[string]string{"ALLOW_NO_ORIGIN": "maybe"}, wantErrs: []string{"ALLOW_NO_ORIGIN must be a boolean"}},
+		{name: "every problem listed", env: map[string]string{"PORT": "0", "ROOM_MSG_RATE": "-1", "MESSAGE_RETENTION": "-1h"}, wantErrs: []string{
+			"PORT must be", "ROOM_MSG_RATE", "MESSAGE_RETENTION must not be negative",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(func(key string) string { return tt.env[key] })
+			if len(tt.wantErrs) > 0 {
+				if err == nil {
+					t.Fatalf("loadConfig succeeded, want errors %q", tt.wantErrs)
+				}
+				for _, want := range tt.wantErrs {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error %q does not mention %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestSummaryRedactsSecrets(t *testing.T) {
+	admin := strings.Repeat("a", minAdminTokenLen)
+	cfg, err := loadConfig(func(key string) string {
+		return map[string]string{"ADMIN_TOKEN": admin}[key]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary := cfg.Summary(); strings.Contains(summary, admin) {
+		t.Errorf("summary leaks the admin token: %s", summary)
+	}
+}
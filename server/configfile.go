@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// fileSetting is one value from a config file, kept with where it came
+// from for error messages.
+type fileSetting struct {
+	value string
+	name  string // dotted name as written, e.g. room.capacity
+	line  int
+}
+
+var (
+	tableHeader = regexp.MustCompile(`^\[\s*([A-Za-z0-9_.-]+)\s*\]$`)
+	bareKey     = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// readConfigFile parses a TOML config file into settings keyed by the
+// environment variable each one stands for: port = 8080 sets PORT, and
+// capacity under [room] sets ROOM_CAPACITY. Arrays become comma-separated
+// lists. Only the subset of TOML the settings need is supported: tables,
+// bare or dotted keys, strings, numbers, booleans and one-line arrays. An
+// empty path returns no settings.
+func readConfigFile(path string) (map[string]fileSetting, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	settings := make(map[string]fileSetting)
+	table := ""
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if m := tableHeader.FindStringSubmatch(line); m != nil {
+			table = m[1]
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !bareKey.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", path, n, key, err)
+		}
+		env := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+		if prev, dup := settings[env]; dup {
+			return nil, fmt.Errorf("%s:%d: %s is already set on line %d", path, n, key, prev.line)
+		}
+		settings[env] = fileSetting{value: value, name: key, line: n}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// stripComment removes a trailing # comment that is not inside a string.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseTOMLValue converts a scalar or one-line array to the string an
+// environment variable would hold.
+func parseTOMLValue(raw string) (string, error) {
+	if strings.HasPrefix(raw, "[") {
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("arrays must fit on one line")
+		}
+		var items []string
+		for _, item := range splitArray(raw[1 : len(raw)-1]) {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			v, err := parseTOMLScalar(item)
+			if err != nil {
+				return "", err
+			}
+			if strings.Contains(v, ",") {
+				return "", fmt.Errorf("array items may not contain commas")
+			}
+			items = append(items, v)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return parseTOMLScalar(raw)
+}
+
+func parseTOMLScalar(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return raw[1 : len(raw)-1], nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	}
+	num := strings.ReplaceAll(raw, "_", "")
+	if _, err := strconv.ParseFloat(num, 64); err != nil {
+		return "", fmt.Errorf("invalid value %s (quote strings and durations)", raw)
+	}
+	return num, nil
+}
+
+// splitArray splits array contents on commas outside strings.
+func splitArray(s string) []string {
+	var (
+		items []string
+		quote byte
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// maxUserAgentLen bounds the user agent kept per connection.
+const maxUserAgentLen = 256
+
+// ConnInfo describes how a client is connected. Admins see it in full;
+// presence shows the redacted form.
+type ConnInfo struct {
+	RemoteIP    string    `json:"remote_ip"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	Transport   string    `json:"transport"`
+	Compression bool      `json:"compression,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LatencyMS   float64   `json:"latency_ms,omitempty"`
+}
+
+// newConnInfo records the request's address and user agent.
+func newConnInfo(r *http.Request, ip string, transport string) ConnInfo {
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLen {
+		ua = ua[:maxUserAgentLen]
+	}
+	return ConnInfo{RemoteIP: ip, UserAgent: ua, Transport: transport, ConnectedAt: time.Now().UTC()}
+}
+
+// redacted keeps enough to tell connections apart without exposing them:
+// the network (/24 or /48) instead of the address, and only the first
+// product token of the user agent.
+func (i ConnInfo) redacted() *ConnInfo {
+	r := i
+	r.RemoteIP = ""
+	if addr, err := netip.ParseAddr(i.RemoteIP); err == nil {
+		bits := 48
+		if addr.Unmap().Is4() {
+			addr, bits = addr.Unmap(), 24
+		}
+		if p, err := addr.Prefix(bits); err == nil {
+			r.RemoteIP = p.String()
+		}
+	}
+	r.UserAgent, _, _ = strings.Cut(i.UserAgent, " ")
+	return &r
+}
+
+// offersDeflate reports whether a WebSocket upgrade request offers
+// permessage-deflate, which the upgrader accepts whenever it is offered.
+func offersDeflate(r *http.Request) bool {
+	for _, h := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(h, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
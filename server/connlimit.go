@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// connLimiter caps concurrent long-lived connections, per source address
+// and server-wide, so one client cannot exhaust file descriptors. IPv6
+// clients are counted per /64, since each usually holds a whole one.
+// Zero disables a limit.
+type connLimiter struct {
+	perIP int
+	total int
+	proxy proxyTrust
+
+	mu    sync.Mutex
+	open  int
+	byKey map[string]int
+}
+
+func newConnLimiter(perIP, total int, proxy proxyTrust) *connLimiter {
+	return &connLimiter{perIP: perIP, total: total, proxy: proxy, byKey: make(map[string]int)}
+}
+
+// connKey is the address a connection counts against.
+func connKey(ip string) string {
+	if p := net.ParseIP(ip); p != nil && p.To4() == nil {
+		return p.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return ip
+}
+
+// acquire counts a new connection from key, or returns the error code
+// naming the limit it would exceed.
+func (l *connLimiter) acquire(key string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.total > 0 && l.open >= l.total {
+		return codeServerFull
+	}
+	if l.perIP > 0 && l.byKey[key] >= l.perIP {
+		return codeTooManyConnections
+	}
+	l.open++
+	l.byKey[key]++
+	return ""
+}
+
+func (l *connLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.open--
+	if l.byKey[key]--; l.byKey[key] <= 0 {
+		delete(l.byKey, key)
+	}
+}
+
+// limit wraps a handler that holds its connection open, such as /ws or
+// /sse, refusing it with 429 while either limit is reached.
+func (l *connLimiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := connKey(l.proxy.clientIP(r))
+		if code := l.acquire(key); code != "" {
+			reason := "too many connections from your address"
+			if code == codeServerFull {
+				reason = "the server is at its connection limit"
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(5))
+			writeError(w, http.StatusTooManyRequests, code, reason)
+			return
+		}
+		defer l.release(key)
+		next(w, r)
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Supported values for Message.ContentType.
+const (
+	contentPlain    = "text/plain"
+	contentMarkdown = "text/markdown"
+	contentImageURL = "image/url"
+)
+
+// maxMarkdownSize caps text/markdown bodies, which are costlier to render.
+const maxMarkdownSize = 4 * 1024
+
+var (
+	scriptBlockPattern = regexp.MustCompile(`(?is)<\s*(script|style|iframe|object|embed)\b.*?(<\s*/\s*(script|style|iframe|object|embed)\s*>|$)`)
+	htmlTagPattern     = regexp.MustCompile(`(?s)<\s*/?\s*[a-zA-Z][^>]*>`)
+	inlineLinkPattern  = regexp.MustCompile(`\]\(((?:[^()]|\([^()]*\))*)\)`)
+	linkDefPattern     = regexp.MustCompile(`(?m)^([ \t]{0,3}\[[^\]\n]+\]:[ \t]*\n?[ \t]*)(\S+)`)
+	markdownEscape     = regexp.MustCompile(`\\([[:punct:]])`)
+)
+
+// sanitizeMarkdown strips raw HTML and turns every link target that is
+// not http(s), mailto or relative into "#", so clients can render the
+// result without trusting the sender. Inline links, images and reference
+// definitions are all checked.
+func sanitizeMarkdown(s string) string {
+	s = scriptBlockPattern.ReplaceAllString(s, "")
+	s = htmlTagPattern.ReplaceAllString(s, "")
+	s = inlineLinkPattern.ReplaceAllStringFunc(s, func(link string) string {
+		dest := strings.Fields(inlineLinkPattern.FindStringSubmatch(link)[1])
+		if len(dest) == 0 || safeLinkTarget(dest[0]) {
+			return link
+		}
+		return "](#)"
+	})
+	return linkDefPattern.ReplaceAllStringFunc(s, func(def string) string {
+		m := linkDefPattern.FindStringSubmatch(def)
+		if safeLinkTarget(m[2]) {
+			return def
+		}
+		return m[1] + "#"
+	})
+}
+
+// safeLinkTarget reports whether a link destination is http(s), mailto or
+// relative. It first undoes the escapes a Markdown renderer would undo,
+// backslashes and HTML entities such as &colon; or &#106;, and drops the
+// whitespace and control characters a browser ignores in a URL.
+func safeLinkTarget(dest string) bool {
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	dest = html.UnescapeString(markdownEscape.ReplaceAllString(dest, "$1"))
+	dest = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, dest)
+	i := strings.IndexAny(dest, ":/?#")
+	if i < 0 || dest[i] != ':' {
+		return true
+	}
+	switch strings.ToLower(dest[:i]) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// validateContent normalises m.ContentType and checks the body against it.
+func validateContent(m *Message) *parseError {
+	switch m.ContentType {
+	case "":
+		m.ContentType = contentPlain
+	case contentPlain:
+	case contentMarkdown:
+		if len(m.Msg) > maxMarkdownSize {
+			return &parseError{codeTooLarge, "markdown body exceeds size limit"}
+		}
+		m.Msg = sanitizeMarkdown(m.Msg)
+	case contentImageURL:
+		u, err := url.Parse(m.Msg)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &parseError{codeInvalidContent, "image/url body must be an http(s) URL"}
+		}
+	case contentCiphertext:
+		if !validCiphertext(m.Msg) {
+			return &parseError{codeInvalidContent, "ciphertext body must be base64"}
+		}
+	default:
+		return &parseError{codeUnsupportedContentType, "unsupported content type " + `"` + m.ContentType + `"`}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateContent(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		msg         string
+		wantType    string
+		wantMsg     string
+		wantCode    string
+	}{
+		{name: "default", msg: "hi", wantType: contentPlain, wantMsg: "hi"},
+		{name: "plain", contentType: contentPlain, msg: "<b>hi</b>", wantType: contentPlain, wantMsg: "<b>hi</b>"},
+		{name: "markdown", contentType: contentMarkdown, msg: "**hi**", wantType: contentMarkdown, wantMsg: "**hi**"},
+		{name: "markdown script", contentType: contentMarkdown, msg: "a<script>alert(1)</script>b", wantType: contentMarkdown, wantMsg: "ab"},
+		{name: "markdown html", contentType: contentMarkdown, msg: `<img src=x onerror=alert(1)>hi`, wantType: contentMarkdown, wantMsg: "hi"},
+		{name: "markdown javascript link", contentType: contentMarkdown, msg: "[x](javascript:alert(1))", wantType: contentMarkdown, wantMsg: "[x](#)"},
+		{name: "markdown named entity colon", contentType: contentMarkdown, msg: "[x](javascript&colon;alert(1))", wantType: contentMarkdown, wantMsg: "[x](#)"},
+		{name: "markdown numeric entity", contentType: contentMarkdown, msg: "[x](&#106;avascript:alert(1))", wantType: contentMarkdown, wantMsg: "[x](#)"},
+		{name: "markdown hex entity", contentType: contentMarkdown, msg: "[x](javascript&#x3A;alert(1))", wantType: contentMarkdown, wantMsg: "[x](#)"},
+		{name: "markdown escaped colon", contentType: contentMarkdown, msg: `[x](javascript\:alert(1))`, wantType: contentMarkdown, wantMsg: "[x](#)"},
+		{name: "markdown encoded tab in scheme", contentType: contentMarkdown, msg: "[x](java&#9;script:alert(1))", wantType: contentMarkdown, wantMsg: "[x](#)"},
+		{name: "markdown link with title", contentType: contentMarkdown, msg: `[x](JavaScript:alert(1) "hi")`, wantType: contentMarkdown, wantMsg: "[x](#)"},
+		{name: "markdown image data", contentType: contentMarkdown, msg: "![x](data:text/html;base64,PHNjcmlwdD4=)", wantType: contentMarkdown, wantMsg: "![x](#)"},
+		{name: "markdown other scheme", contentType: contentMarkdown, msg: "[x](file:///etc/passwd)", wantType: contentMarkdown, wantMsg: "[x](#)"},
+		{name: "markdown reference definition", contentType: contentMarkdown, msg: "[x][1]\n\n[1]: javascript:alert(1)", wantType: contentMarkdown, wantMsg: "[x][1]\n\n[1]: #"},
+		{name: "markdown reference definition entity", contentType: contentMarkdown, msg: "[x][1]\n[1]: <&#106;avascript:alert(1)> \"t\"", wantType: contentMarkdown, wantMsg: "[x][1]\n[1]: # \"t\""},
+		{name: "markdown https link", contentType: contentMarkdown, msg: "[x](https://example.com/a_(b))", wantType: contentMarkdown, wantMsg: "[x](https://example.com/a_(b))"},
+		{name: "markdown mailto link", contentType: contentMarkdown, msg: "[x](mailto:amy@example.com)", wantType: contentMarkdown, wantMsg: "[x](mailto:amy@example.com)"},
+		{name: "markdown relative link", contentType: contentMarkdown, msg: "[x](/rooms?a=b:c)", wantType: contentMarkdown, wantMsg: "[x](/rooms?a=b:c)"},
+		{name: "markdown https reference definition", contentType: contentMarkdown, msg: "[1]: https://example.com", wantType: contentMarkdown, wantMsg: "[1]: https://example.com"},
+		{name: "markdown at the cap", contentType: contentMarkdown, msg: strings.Repeat("a", maxMarkdownSize), wantType: contentMarkdown, wantMsg: strings.Repeat("a", maxMarkdownSize)},
+		{name: "markdown over the cap", contentType: contentMarkdown, msg: strings.Repeat("a", maxMarkdownSize+1), wantCode: "too_large"},
+		{name: "image", contentType: contentImageURL, msg: "https://example.com/cat.png", wantType: contentImageURL, wantMsg: "https://example.com/cat.png"},
+		{name: "image not a url", contentType: contentImageURL, msg: "cat.png", wantCode: "invalid_content"},
+		{name: "image javascript", contentType: contentImageURL, msg: "javascript:alert(1)", wantCode: "invalid_content"},
+		{name: "unknown", contentType: "text/html", msg: "<b>hi</b>", wantCode: "unsupported_content_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Message{Type: "chat", ContentType: tt.contentType, Msg: tt.msg}
+			err := validateContent(m)
+			if tt.wantCode != "" {
+				if err == nil || err.code != tt.wantCode {
+					t.Fatalf("validateContent = %v, want code %q", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateContent: %v", err)
+			}
+			if m.ContentType != tt.wantType || m.Msg != tt.wantMsg {
+				t.Errorf("got %q %q, want %q %q", m.ContentType, m.Msg, tt.wantType, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestContentTypeDeliveredAndPersisted(t *testing.T) {
+	s, ts := startServer(t, nil)
+	amy := dial(t, ts, "1234", "amy", nil)
+	amy.expect("system")
+	bob := dial(t, ts, "1234", "bob", nil)
+	bob.expect("system")
+
+	tests := []struct {
+		contentType string
+		msg         string
+		wantErr     string
+	}{
+		{contentType: contentPlain, msg: "plain"},
+		{contentType: contentMarkdown, msg: "*markdown*"},
+		{contentType: contentImageURL, msg: "https://example.com/cat.png"},
+		{contentType: "application/x-unknown", msg: "unknown", wantErr: "unsupported_content_type"},
+	}
+	for _, tt := range tests {
+		amy.send(map[string]any{"type": "chat", "contentType": tt.contentType, "msg": tt.msg})
+		if tt.wantErr != "" {
+			if code := amy.expect("error")["code"]; code != tt.wantErr {
+				t.Errorf("%s: error code = %v, want %q", tt.contentType, code, tt.wantErr)
+			}
+			continue
+		}
+		if got := bob.expectMsg("chat", tt.msg)["contentType"]; got != tt.contentType {
+			t.Errorf("%s: delivered contentType = %v", tt.contentType, got)
+		}
+	}
+
+	// History is written behind the room; wait for the three accepted
+	// messages to land.
+	var stored []StoredMessage
+	deadline := time.Now().Add(testWait)
+	for len(stored) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("stored %d messages, want 3", len(stored))
+		}
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		if stored, err = s.manager.store.Recent(context.Background(), "1234", 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, m := range stored {
+		var frame Message
+		if err := json.Unmarshal(m.Frame, &frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame.ContentType != tests[i].contentType {
+			t.Errorf("stored message %d contentType = %q, want %q", i, frame.ContentType, tests[i].contentType)
+		}
+	}
+}
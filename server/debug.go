@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// backlog is how full a buffered channel or queue is.
+type backlog struct {
+	Len int `json:"len"`
+	Cap int `json:"cap"`
+}
+
+// HubDebug is one hub's state as dumped by /debug/hubs.
+type HubDebug struct {
+	Pin      string `json:"pin"`
+	Clients  int    `json:"clients"`
+	Draining bool   `json:"draining,omitempty"`
+	// Channels are the backlogs of the channels run reads from.
+	Channels map[string]backlog `json:"channels"`
+	// SendQueued is the total of frames waiting in members' send queues,
+	// and SendMax the longest single queue.
+	SendQueued int `json:"send_queued"`
+	SendMax    int `json:"send_max"`
+}
+
+func chanBacklog[T any](ch chan T) backlog {
+	return backlog{Len: len(ch), Cap: cap(ch)}
+}
+
+// debug describes the hub from its channels and last published member
+// list, without going through run, so it works even if run is stuck.
+func (h *Hub) debug() HubDebug {
+	d := HubDebug{
+		Pin:      h.pin,
+		Draining: h.draining.Load(),
+		Channels: map[string]backlog{
+			"broadcast":  chanBacklog(h.broadcast),
+			"register":   chanBacklog(h.register),
+			"unregister": chanBacklog(h.unregister),
+			"remote":     chanBacklog(h.remote),
+			"kick":       chanBacklog(h.kick),
+			"threads":    chanBacklog(h.threads),
+			"configs":    chanBacklog(h.configs),
+		},
+	}
+	if snap := h.presence.Load(); snap != nil {
+		for _, m := range snap.admin {
+			if m.client == nil {
+				continue
+			}
+			d.Clients++
+			n := m.client.send.len()
+			d.SendQueued += n
+			d.SendMax = max(d.SendMax, n)
+		}
+	}
+	return d
+}
+
+// handleDebugHubs serves GET /debug/hubs: process-wide goroutine and
+// memory figures, the history write backlog, and every hub's backlogs.
+func handleDebugHubs(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	hubs := []HubDebug{}
+	for _, h := range manager.hubList() {
+		hubs = append(hubs, h.debug())
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"goroutines": runtime.NumGoroutine(),
+		"heap_bytes": mem.HeapAlloc,
+		"num_gc":     mem.NumGC,
+		"persist":    chanBacklog(manager.persist.queue),
+		"count":      len(hubs),
+		"hubs":       hubs,
+	})
+}
+
+// registerDebug mounts /debug/hubs and the net/http/pprof handlers behind
+// the admin token.
+func registerDebug(mux *http.ServeMux, manager *HubManager, token string) {
+	mux.HandleFunc("GET /debug/hubs", requireAdmin(token, func(w http.ResponseWriter, r *http.Request) {
+		handleDebugHubs(manager, w, r)
+	}))
+	mux.HandleFunc("/debug/pprof/", requireAdmin(token, pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", requireAdmin(token, pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", requireAdmin(token, pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", requireAdmin(token, pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", requireAdmin(token, pprof.Trace))
+}
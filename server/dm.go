@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// newClientID returns a short random id that identifies a connection
+// within the server, used as the target of direct messages.
+func newClientID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// findClient returns the member with the given connection id. Only run
+// may call it.
+func (h *Hub) findClient(id string) *Client {
+	for c := range h.clients {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// directMessage routes a dm to its single target and echoes it back to the
+// sender, or tells the sender the target is gone. A signed-in sender may
+// also address the user id of a signed-in member who has left: the dm is
+// stored and pushed to them. Only run may call it.
+func (h *Hub) directMessage(msg *Message) {
+	target := h.findClient(msg.To)
+	_, away := h.away[msg.To]
+	if target == nil && (!away || msg.from.userID == "" || h.connected(msg.To)) {
+		msg.from.trySend(errorFrame(codeDMUndeliverable, "recipient "+`"`+msg.To+`"`+" is not in this room"))
+		return
+	}
+	msg.ID = newMessageID()
+	msg.Room = h.pin
+	msg.From = msg.from.id
+	msg.TS = time.Now().UTC().Format(time.RFC3339Nano)
+	clientMsgID := msg.ClientMsgID
+	msg.ClientMsgID = ""
+	frame := h.frame(msg)
+	h.ack(msg.from, clientMsgID, msg)
+	targetID := msg.To
+	if target != nil {
+		targetID = target.userID
+	}
+	// Dms between signed-in users are kept for GET /api/account/dms. The
+	// store orders by seq, which dms lack, so the time stands in for it.
+	if msg.from.userID != "" && targetID != "" && !h.ephemeral {
+		now := time.Now()
+		h.manager.persist.saveDM(msg.trace, StoredMessage{Room: dmKey(msg.from.userID, targetID), ID: msg.ID, Seq: uint64(now.UnixNano()), At: now, Frame: frame})
+	}
+	if target == nil {
+		h.manager.push.notify(targetID, pushNotice{Type: "dm", Room: h.pin, User: msg.User, Msg: pushPreview(msg), TS: msg.TS})
+		h.sendTo(msg.from, frame)
+		return
+	}
+
+	// An ignored sender's dm is dropped silently so the ignore isn't revealed.
+	if !target.ignores(msg.User) {
+		h.sendTo(target, frame)
+	}
+	if target != msg.from {
+		h.sendTo(msg.from, frame)
+	}
+}
+
+// sendTo queues a frame for one client, dropping the client if it can't
+// keep up. Only run may call it.
+func (h *Hub) sendTo(c *Client, frame []byte) {
+	if !c.send.push(frame) {
+		h.dropSlow(c)
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStoreDMs(t *testing.T) {
+	now := time.Now()
+	dm := func(i int) StoredMessage {
+		return StoredMessage{Room: dmKey("user:amy", "user:bob"), ID: fmt.Sprintf("dm-%d", i), Seq: uint64(i), At: now.Add(time.Duration(i-10) * time.Hour), Frame: []byte(`{"type":"dm"}`)}
+	}
+	tests := []struct {
+		name    string
+		before  string
+		limit   int
+		want    []string
+		wantErr error
+	}{
+		{name: "newest", limit: 3, want: []string{"dm-8", "dm-9", "dm-10"}},
+		{name: "before", before: "dm-5", limit: 3, want: []string{"dm-2", "dm-3", "dm-4"}},
+		{name: "before the start", before: "dm-2", limit: 3, want: []string{"dm-1"}},
+		{name: "unknown cursor", before: "dm-99", limit: 3, wantErr: errMessageNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachStore(t, func(t *testing.T, s Store) {
+				ctx := context.Background()
+				for i := 1; i <= 10; i++ {
+					if err := s.AppendDM(ctx, dm(i)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				got, err := s.DMs(ctx, dmKey("user:bob", "user:amy"), tt.before, tt.limit)
+				if err != tt.wantErr {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("got %d dms, want %v", len(got), tt.want)
+				}
+				for i, m := range got {
+					if m.ID != tt.want[i] {
+						t.Errorf("dm %d = %s, want %s", i, m.ID, tt.want[i])
+					}
+				}
+				if msgs, _ := s.Recent(ctx, dmKey("user:amy", "user:bob"), 100); len(msgs) != 0 {
+					t.Errorf("room history under the dm key holds %d messages, want none", len(msgs))
+				}
+			})
+		})
+	}
+}
+
+func TestStorePruneDMs(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		now := time.Now()
+		for i := 1; i <= 4; i++ {
+			m := StoredMessage{Room: dmKey("user:amy", "user:bob"), ID: fmt.Sprintf("dm-%d", i), Seq: uint64(i), At: now.Add(time.Duration(i-4) * time.Hour), Frame: []byte(`{}`)}
+			if err := s.AppendDM(ctx, m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n, err := s.Prune(ctx, now.Add(-90*time.Minute))
+		if err != nil || n != 2 {
+			t.Fatalf("Prune = %d, %v; want 2", n, err)
+		}
+		if left, _ := s.DMs(ctx, dmKey("user:amy", "user:bob"), "", 10); len(left) != 2 {
+			t.Errorf("%d dms left, want 2", len(left))
+		}
+	})
+}
+
+func TestDMsUnreachableFromRooms(t *testing.T) {
+	const admin = "admin-token"
+	s, ts := startServer(t, func(cfg *Config) {
+		cfg.AdminToken = admin
+		cfg.JWTSecret = strings.Repeat("k", 32)
+		cfg.Accounts = true
+	})
+	token := func(sub string) string {
+		req, _ := http.NewRequest("POST", ts.URL+"/api/token", strings.NewReader(`{"sub":"`+sub+`"}`))
+		req.Header.Set("Authorization", "Bearer "+admin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct{ Token string }
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Token == "" {
+			t.Fatalf("issuing token for %s: %v (status %d)", sub, err, resp.StatusCode)
+		}
+		return body.Token
+	}
+	amyToken := token("user:amy")
+
+	amy := dial(t, ts, "1234", "amy", url.Values{"token": {amyToken}})
+	amy.expect("system")
+	bob := dial(t, ts, "1234", "bob", url.Values{"token": {token("user:bob")}})
+	bobID := bob.expect("system")["from"]
+	amy.send(map[string]any{"type": "dm", "to": bobID, "msg": "just between us"})
+	bob.expect("dm")
+
+	deadline := time.Now().Add(testWait)
+	for {
+		msgs, _ := s.store.DMs(context.Background(), dmKey("user:amy", "user:bob"), "", 10)
+		if len(msgs) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dm was not stored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Run("account api", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", ts.URL+"/api/account/dms?with=user:bob", nil)
+		req.Header.Set("Authorization", "Bearer "+amyToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct{ Count int }
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Count != 1 {
+			t.Fatalf("GET /api/account/dms count = %d, %v; want 1", body.Count, err)
+		}
+	})
+
+	for _, pin := range []string{dmKey("user:amy", "user:bob"), "dm/", "a/b"} {
+		t.Run("join "+pin, func(t *testing.T) {
+			u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?" + url.Values{"pin": {pin}, "name": {"mallory"}}.Encode()
+			conn, resp, err := websocket.DefaultDialer.Dial(u, nil)
+			if err == nil {
+				conn.Close()
+				t.Fatalf("joined room %q", pin)
+			}
+			if resp == nil || resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("join %q: %v, want 400", pin, err)
+			}
+		})
+	}
+
+	t.Run("room history", func(t *testing.T) {
+		msgs, err := s.store.Recent(context.Background(), dmKey("user:amy", "user:bob"), 100)
+		if err != nil || len(msgs) != 0 {
+			t.Fatalf("room history under the dm key = %d messages, %v; want none", len(msgs), err)
+		}
+	})
+}
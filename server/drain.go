@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// roomDrainDelay is how long clients get to act on a migrate hint before
+// the room closes their connections.
+const roomDrainDelay = 5 * time.Second
+
+// drain marks the hub as draining, tells every client where to reconnect,
+// and closes the room after roomDrainDelay. It reports false if the room
+// was already draining.
+func (h *Hub) drain(target *url.URL) bool {
+	if !h.draining.CompareAndSwap(false, true) {
+		return false
+	}
+	to := *target
+	q := to.Query()
+	q.Set("pin", h.pin)
+	to.RawQuery = q.Encode()
+
+	h.publish(&Message{Type: "migrate", To: to.String()})
+	time.AfterFunc(roomDrainDelay, func() { h.stop(errRoomMigrated) })
+	return true
+}
+
+// handleRoomDrain serves POST /rooms/{pin}/drain with {"to":"wss://host/ws"}.
+func handleRoomDrain(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	pin := r.PathValue("pin")
+	var body struct {
+		To string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body")
+		return
+	}
+	target, err := url.Parse(body.To)
+	if err != nil || (target.Scheme != "ws" && target.Scheme != "wss") || target.Host == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "to must be a ws:// or wss:// URL")
+		return
+	}
+
+	hub := manager.lookup(pin)
+	if hub == nil {
+		writeError(w, http.StatusNotFound, codeRoomNotFound, "room not found")
+		return
+	}
+	if !hub.drain(target) {
+		writeError(w, http.StatusConflict, codeDraining, "room is already draining")
+		return
+	}
+	hub.log.Info("draining room", "target", target.Host)
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "draining", "pin": pin})
+}
+
+// requestDrain closes drainRequested, asking whoever runs the server to
+// drain it; later calls do nothing.
+func (m *HubManager) requestDrain() {
+	m.drainOnce.Do(func() { close(m.drainRequested) })
+}
+
+// drain takes the server out of rotation for a rolling deploy. It refuses
+// new connections at once, so /readyz fails, waits delay for load
+// balancers to notice, then closes every room with code 1012 so clients
+// reconnect to another instance, and waits for the rooms to close or ctx
+// to expire.
+func (m *HubManager) drain(ctx context.Context, delay time.Duration) error {
+	m.maintenance.Store(true)
+	slog.Info("draining server", "delay", delay)
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return m.closeRooms(ctx, errServerDraining)
+}
+
+// handleServerDrain serves POST /admin/drain, which drains the server and
+// then stops it.
+func handleServerDrain(manager *HubManager, delay time.Duration, w http.ResponseWriter) {
+	manager.requestDrain()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "draining", "delay": delay.String()})
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestHandleRoomDrain(t *testing.T) {
+	const token = "secret"
+	_, ts := startServer(t, func(cfg *Config) { cfg.AdminToken = token })
+	member := dial(t, ts, "1234", "", nil)
+	member.expect("system")
+
+	tests := []struct {
+		name     string
+		pin      string
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{name: "bad body", pin: "1234", body: "{", wantCode: http.StatusBadRequest},
+		{name: "bad target", pin: "1234", body: `{"to":"https://other/ws"}`, wantCode: http.StatusBadRequest},
+		{name: "no room", pin: "9999", body: `{"to":"wss://other/ws"}`, wantCode: http.StatusNotFound},
+		{name: "drains", pin: "1234", body: `{"to":"wss://other/ws"}`, wantCode: http.StatusAccepted},
+		{name: "already draining", pin: "1234", body: `{"to":"wss://third/ws"}`, wantCode: http.StatusConflict, wantErr: "draining"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := call(t, ts, token, "POST", "/rooms/"+tt.pin+"/drain", tt.body)
+			if status != tt.wantCode {
+				t.Fatalf("status = %d, want %d", status, tt.wantCode)
+			}
+			var reply map[string]string
+			if tt.wantErr != "" && (json.Unmarshal([]byte(body), &reply) != nil || reply["error"] != tt.wantErr) {
+				t.Errorf("body = %s, want error %q", body, tt.wantErr)
+			}
+		})
+	}
+
+	if to := member.expect("migrate")["to"]; to != "wss://other/ws?pin=1234" {
+		t.Errorf("migrate to = %v, want the first target", to)
+	}
+
+	// The draining room turns joiners away; others still take them.
+	joins := []struct {
+		pin      string
+		wantCode int
+		wantErr  string
+	}{
+		{pin: "1234", wantCode: http.StatusServiceUnavailable, wantErr: "draining"},
+		{pin: "5678", wantCode: http.StatusSwitchingProtocols},
+	}
+	for _, tt := range joins {
+		status, body := dialStatus(t, ts, url.Values{"pin": {tt.pin}})
+		if status != tt.wantCode {
+			t.Errorf("joining %s: status = %d, want %d", tt.pin, status, tt.wantCode)
+			continue
+		}
+		var reply map[string]string
+		if tt.wantErr != "" && (json.Unmarshal([]byte(body), &reply) != nil || reply["error"] != tt.wantErr) {
+			t.Errorf("joining %s: body = %s, want error %q", tt.pin, body, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import "encoding/base64"
+
+// contentCiphertext marks a chat body as end-to-end encrypted: Msg is
+// base64 ciphertext the server stores and relays but never interprets.
+const contentCiphertext = "application/x-gochat-ciphertext"
+
+// maxPublicKeyLen caps a decoded public key; room for any common curve or
+// a 2048-bit RSA key.
+const maxPublicKeyLen = 512
+
+// validateKey checks a key message, clearing the fields only the server
+// may set. An empty key withdraws the sender's published key.
+func validateKey(m *Message) *parseError {
+	*m = Message{Type: m.Type, Key: m.Key}
+	if m.Key == "" {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(m.Key)
+	if err != nil || len(b) > maxPublicKeyLen {
+		return &parseError{codeInvalidMessage, "key must be base64 of at most 512 bytes"}
+	}
+	return nil
+}
+
+// validCiphertext reports whether s is plausible ciphertext.
+func validCiphertext(s string) bool {
+	_, err := base64.StdEncoding.DecodeString(s)
+	return err == nil
+}
+
+// opaque reports whether the server must leave m's body alone: no
+// commands, filtering or previews.
+func (m *Message) opaque() bool {
+	return m.ContentType == contentCiphertext
+}
+
+// publishKey records the sender's public key and schedules a rekey so the
+// room can start encrypting to it. Only run may call it.
+func (h *Hub) publishKey(msg *Message) {
+	if msg.from.publicKey == msg.Key {
+		return
+	}
+	msg.from.publicKey = msg.Key
+	h.rekeyDue = true
+}
+
+// memberKeys returns the published public keys by client id. Only run may
+// call it.
+func (h *Hub) memberKeys() map[string]string {
+	keys := make(map[string]string)
+	for c := range h.clients {
+		if c.publicKey != "" {
+			keys[c.id] = c.publicKey
+		}
+	}
+	return keys
+}
+
+// rekey starts a new key epoch and sends every member the current key set,
+// so a member can distribute a fresh room key that excludes anyone who
+// left. Rooms where nobody has published a key stay quiet. Only run may
+// call it.
+func (h *Hub) rekey() {
+	keys := h.memberKeys()
+	if len(keys) == 0 && !h.keyed {
+		return
+	}
+	h.keyed = len(keys) > 0
+	h.epoch++
+	h.fanOut(h.frame(&Message{Type: "rekey", Epoch: h.epoch, Keys: keys}))
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+)
+
+// amend handles edit and delete. The message must still be in history;
+// only its sender may edit it, and its sender or a moderator may delete
+// it. Only run may call it.
+func (h *Hub) amend(msg *Message) {
+	c := msg.from
+	i := -1
+	for j, e := range h.history {
+		if e.id == msg.ID {
+			i = j
+		}
+	}
+	if i < 0 {
+		c.trySend(errorFrame(codeNotFound, "no message with id "+`"`+msg.ID+`"`))
+		return
+	}
+	entry := h.history[i]
+	var orig Message
+	if err := json.Unmarshal(entry.frame, &orig); err != nil {
+		return
+	}
+	mine := entry.sentBy(c, orig.User)
+
+	switch msg.Type {
+	case "edit":
+		if !mine {
+			c.trySend(errorFrame(codeForbidden, "you can only edit your own messages"))
+			return
+		}
+		if orig.Type != "chat" {
+			c.trySend(errorFrame(codeInvalidMessage, "only chat messages can be edited"))
+			return
+		}
+		orig.Msg, orig.ContentType = msg.Msg, msg.ContentType
+		orig.Edited = time.Now().UTC().Format(time.RFC3339Nano)
+		before := orig.Mentions
+		orig.Mentions = h.mentionedMembers(&orig)
+		// A new first link needs a new preview.
+		relink := orig.Preview == nil || firstLink(orig.Msg) != orig.Preview.URL
+		if relink {
+			orig.Preview = nil
+		}
+		frame := h.frame(&orig)
+		h.history[i].frame = frame
+		h.replaceFrame(msg.ID, frame)
+		if h.features[featureHistory] {
+			h.manager.persist.update(StoredMessage{Room: h.pin, ID: msg.ID, Frame: frame})
+		}
+		h.fanOutFrom(entry.channel, "", nil, h.frame(&Message{
+			Type:        "edit",
+			ID:          msg.ID,
+			User:        msg.User,
+			Msg:         orig.Msg,
+			ContentType: orig.ContentType,
+			Edited:      orig.Edited,
+			Channel:     entry.channel,
+			Mentions:    orig.Mentions,
+		}))
+		// Only members the edit newly mentions are told; the rest were
+		// told when the message was sent.
+		var added []string
+		for _, name := range orig.Mentions {
+			if !slices.Contains(before, name) {
+				added = append(added, name)
+			}
+		}
+		h.notifyMentions(&orig, added, entry.channel)
+		if relink {
+			h.manager.unfurl.enqueue(h, &orig, entry.channel)
+		}
+
+	case "delete":
+		if !mine && !c.can(permDelete) {
+			c.trySend(errorFrame(codeForbidden, "you can only delete your own messages"))
+			return
+		}
+		h.history = append(h.history[:i], h.history[i+1:]...)
+		h.forgetMessage(msg.ID)
+		h.manager.persist.remove(h.pin, msg.ID)
+		h.fanOutFrom(entry.channel, "", nil, h.frame(&Message{Type: "delete", ID: msg.ID, User: msg.User, Channel: entry.channel}))
+		// Members deleting their own messages are not moderating.
+		if !mine {
+			h.audit(c, AuditEntry{Action: auditDelete, Target: orig.User, Detail: msg.ID})
+		}
+	}
+}
+
+// sentBy reports whether c sent the entry. Entries loaded from the store or
+// relayed from another instance only record the sender's name.
+func (e historyEntry) sentBy(c *Client, user string) bool {
+	if e.sender != "" {
+		return e.sender == c.identity()
+	}
+	return strings.EqualFold(user, c.name)
+}
+
+// replaceFrame swaps an edited message's frame into pins and mailboxes.
+// Only run may call it.
+func (h *Hub) replaceFrame(id string, frame []byte) {
+	for i := range h.pins {
+		if h.pins[i].id == id {
+			h.pins[i].frame = frame
+			h.savePins()
+		}
+	}
+	for _, mb := range h.mailboxes {
+		for i := range mb.frames {
+			if mb.frames[i].id == id {
+				mb.frames[i].frame = frame
+			}
+		}
+	}
+}
+
+// forgetMessage drops every trace of a deleted message besides history.
+// Only run may call it.
+func (h *Hub) forgetMessage(id string) {
+	delete(h.reactions, id)
+	for i, p := range h.pins {
+		if p.id == id {
+			h.pins = append(h.pins[:i], h.pins[i+1:]...)
+			h.pinsChanged()
+			break
+		}
+	}
+	for _, mb := range h.mailboxes {
+		for i, e := range mb.frames {
+			if e.id == id {
+				mb.frames = append(mb.frames[:i], mb.frames[i+1:]...)
+				break
+			}
+		}
+	}
+}
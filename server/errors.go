@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Error codes. Every error the server reports to a client, as a frame or
+// as the body of a refused request, carries one of these in "code". They
+// are stable, so clients can switch on them; the detail next to them is
+// for people and may change.
+const (
+	// The frame itself was malformed or broke a protocol limit.
+	codeInvalidJSON            = "invalid_json"
+	codeUnknownType            = "unknown_type"
+	codeTooDeep                = "too_deep"
+	codeTooLarge               = "too_large"
+	codeInvalidUTF8            = "invalid_utf8"
+	codeInvalidMessage         = "invalid_message"
+	codeInvalidProto           = "invalid_proto"
+	codeInvalidContent         = "invalid_content"
+	codeUnsupportedContentType = "unsupported_content_type"
+	codeContentRejected        = "content_rejected"
+
+	// The sender may not do this.
+	codeAuthRequired   = "auth_required"
+	codeAuthFailed     = "auth_failed"
+	codeInvalidToken   = "invalid_token"
+	codeForbidden      = "forbidden"
+	codeReadOnly       = "read_only"
+	codeUnauthorized   = "unauthorized"
+	codeInvalidSession = "invalid_session"
+
+	// The sender is going too fast. Some carry "retry_after".
+	codeRateLimited     = "rate_limited"
+	codeFlooding        = "flooding"
+	codeRoomRateLimited = "room_rate_limited"
+	codeSlowMode        = "slow_mode"
+	codeMuted           = "muted"
+
+	// The server removed the client.
+	codeKicked = "kicked"
+	codeBanned = "banned"
+	codeIdle   = "idle"
+
+	// The join was refused.
+	codeInvalidRequest     = "invalid_request"
+	codeInvalidName        = "invalid_name"
+	codeNameTaken          = "name_taken"
+	codeRoomFull           = "room_full"
+	codeRoomBusy           = "room_busy"
+	codeRoomClosed         = "room_closed"
+	codeRoomNotFound       = "room_not_found"
+	codeMaintenance        = "maintenance"
+	codeDraining           = "draining"
+	codeTooManyConnections = "too_many_connections"
+	codeServerFull         = "server_full"
+
+	// A request, in the room or to the HTTP API, could not be carried out.
+	codeNotFound             = "not_found"
+	codeNotSubscribed        = "not_subscribed"
+	codePeerUnavailable      = "peer_unavailable"
+	codeDMUndeliverable      = "dm_undeliverable"
+	codeUnknownCommand       = "unknown_command"
+	codeFeatureDisabled      = "feature_disabled"
+	codeEphemeralRoom        = "ephemeral_room"
+	codeInvalidSettings      = "invalid_settings"
+	codeInvalidFeatures      = "invalid_features"
+	codeUnknownFeature       = "unknown_feature"
+	codeInvalidUser          = "invalid_user"
+	codeTooManyReactions     = "too_many_reactions"
+	codeTooManyPins          = "too_many_pins"
+	codeTooManyIgnored       = "too_many_ignored"
+	codeTooManyChannels      = "too_many_channels"
+	codeUsernameTaken        = "username_taken"
+	codeTooManySubscriptions = "too_many_subscriptions"
+	codeInternal             = "internal_error"
+	codeUnavailable          = "unavailable"
+)
+
+// errorReply is the error object: {"type":"error","code":...,"detail":...}.
+// Frames repeat detail as msg, and refused requests repeat code and
+// detail as error and reason, for clients written before detail existed.
+type errorReply struct {
+	Type       string `json:"type"`
+	Code       string `json:"code"`
+	Detail     string `json:"detail"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+	Msg        string `json:"msg,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// errorFrame builds the error frame sent to a client.
+func errorFrame(code, detail string) []byte {
+	b, _ := json.Marshal(errorReply{Type: "error", Code: code, Detail: detail, Msg: detail})
+	return b
+}
+
+// cooldownFrame is an errorFrame that also says how long the client must
+// wait before trying again, in whole seconds rounded up.
+func cooldownFrame(code, detail string, wait time.Duration) []byte {
+	b, _ := json.Marshal(errorReply{Type: "error", Code: code, Detail: detail, Msg: detail, RetryAfter: retryAfter(wait)})
+	return b
+}
+
+// retryAfter rounds wait up to whole seconds.
+func retryAfter(wait time.Duration) int {
+	return int((wait + time.Second - 1) / time.Second)
+}
+
+// writeError refuses a connection or request with status and the error
+// object, so clients that read the body see what a frame would say.
+func writeError(w http.ResponseWriter, status int, code, detail string) {
+	writeJSON(w, status, errorReply{Type: "error", Code: code, Detail: detail, Error: code, Reason: detail})
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportTimeout bounds how long a transcript download may take.
+const exportTimeout = 5 * time.Minute
+
+// exportFormats maps each export format to its media type.
+var exportFormats = map[string]string{
+	"json": "application/json",
+	"txt":  "text/plain; charset=utf-8",
+	"csv":  "text/csv; charset=utf-8",
+}
+
+// moderates reports whether r may moderate room pin: it carries the admin
+// token, or the session of the room's current owner.
+func (m *HubManager) moderates(adminToken, pin string, r *http.Request) bool {
+	if isAdmin(adminToken, r) {
+		return true
+	}
+	c := m.sessions.get(r.Header.Get(sessionHeader))
+	if c == nil || c.hub.pin != pin {
+		return false
+	}
+	snap := c.hub.presence.Load()
+	if snap == nil {
+		return false
+	}
+	for _, mem := range snap.admin {
+		if mem.ID == c.id {
+			return mem.Owner
+		}
+	}
+	return false
+}
+
+// handleExport serves GET /api/rooms/{pin}/export?format=json|txt|csv,
+// streaming the room's stored transcript oldest first.
+func handleExport(manager *HubManager, adminToken string, w http.ResponseWriter, r *http.Request) {
+	pin := r.PathValue("pin")
+	if !manager.moderates(adminToken, pin, r) {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+		return
+	}
+	if manager.keepsNoHistory(pin, w) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	ctype, ok := exportFormats[format]
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "format must be json, txt or csv")
+		return
+	}
+
+	// Transcripts can outlast the server's write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportTimeout))
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", `attachment; filename="room-`+pin+"."+format+`"`)
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	var write func(StoredMessage, *Message) error
+	switch format {
+	case "json":
+		sep := "[\n"
+		write = func(s StoredMessage, _ *Message) error {
+			out.WriteString(sep)
+			sep = ",\n"
+			_, err := out.Write(s.Frame)
+			return err
+		}
+		defer func() {
+			if sep == "[\n" {
+				out.WriteString("[")
+			}
+			out.WriteString("\n]\n")
+		}()
+	case "txt":
+		write = func(_ StoredMessage, m *Message) error {
+			_, err := out.WriteString("[" + m.TS + "] " + transcriptLine(m) + "\n")
+			return err
+		}
+	case "csv":
+		cw := csv.NewWriter(out)
+		_ = cw.Write([]string{"id", "seq", "ts", "type", "user", "content_type", "msg"})
+		write = func(s StoredMessage, m *Message) error {
+			body := m.Msg
+			if m.Type == "file" {
+				body = m.URL
+			}
+			return cw.Write([]string{s.ID, strconv.FormatUint(s.Seq, 10), m.TS, m.Type, csvSafe(m.User), m.ContentType, csvSafe(body)})
+		}
+		defer cw.Flush()
+	}
+
+	err := manager.store.Scan(r.Context(), pin, func(s StoredMessage) error {
+		var m Message
+		if err := json.Unmarshal(s.Frame, &m); err != nil {
+			return nil // skip frames this version cannot read
+		}
+		return write(s, &m)
+	})
+	if err != nil {
+		slog.Warn("export interrupted", "room", pin, "err", err)
+	}
+}
+
+// transcriptLine renders one message for a plain-text transcript.
+func transcriptLine(m *Message) string {
+	switch {
+	case m.opaque():
+		return m.User + ": [encrypted]"
+	case m.Type == "file":
+		return m.User + " shared " + m.FileName + " (" + m.URL + ")"
+	case m.Emote:
+		return "* " + m.User + " " + m.Msg
+	}
+	return m.User + ": " + m.Msg
+}
+
+// csvSafe defuses cells a spreadsheet would otherwise run as a formula.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"runtime"
+	"sync"
+)
+
+// parallelFanOutMin is the room size from which a frame is queued for
+// members on several goroutines at once.
+const parallelFanOutMin = 512
+
+// maxFanOutWorkers bounds the goroutines one fan-out uses.
+const maxFanOutWorkers = 8
+
+// fanOut queues a frame for every client, dropping any that can't keep up.
+func (h *Hub) fanOut(message []byte) {
+	h.fanOutFrom("", "", nil, message)
+}
+
+// fanOutFrom is fanOut for a frame in channel (room-wide if empty)
+// attributed to sender, skipping skip (if set), clients not subscribed to
+// the channel and clients that have personally ignored that sender.
+//
+// Queuing never blocks: each client's writePump drains its own queue, and
+// a client whose queue overflows past what SEND_OVERFLOW allows is dropped
+// instead of holding up the room.
+// Large rooms split the queuing across workers; run waits for them, so
+// they may read run-owned client state, and drops the slow clients itself.
+func (h *Hub) fanOutFrom(channel, sender string, skip *Client, message []byte) {
+	workers := min(maxFanOutWorkers, runtime.GOMAXPROCS(0))
+	if len(h.clients) < parallelFanOutMin || workers < 2 {
+		for client := range h.clients {
+			if !offer(client, channel, sender, skip, message) {
+				h.dropSlow(client)
+			}
+		}
+		return
+	}
+
+	for client := range h.clients {
+		h.roster = append(h.roster, client)
+	}
+	chunk := (len(h.roster) + workers - 1) / workers
+	slow := make([][]*Client, workers)
+	var wg sync.WaitGroup
+	for w := range workers {
+		lo, hi := w*chunk, min((w+1)*chunk, len(h.roster))
+		if lo >= hi {
+			break
+		}
+		wg.Add(1)
+		go func(w int, part []*Client) {
+			defer wg.Done()
+			for _, c := range part {
+				if !offer(c, channel, sender, skip, message) {
+					slow[w] = append(slow[w], c)
+				}
+			}
+		}(w, h.roster[lo:hi])
+	}
+	wg.Wait()
+	clear(h.roster)
+	h.roster = h.roster[:0]
+	for _, part := range slow {
+		for _, c := range part {
+			h.dropSlow(c)
+		}
+	}
+}
+
+// offer queues message for c unless c is skip, is not in channel or
+// ignores sender. It reports false only if c's queue overflowed and the
+// client must go.
+func offer(c *Client, channel, sender string, skip *Client, message []byte) bool {
+	if c == skip || !c.subscribed(channel) || c.ignores(sender) {
+		return true
+	}
+	return c.send.push(message)
+}
+
+// dropSlow removes a client whose send queue overflowed. Only run may
+// call it.
+func (h *Hub) dropSlow(c *Client) {
+	c.log.Warn("send queue full, dropping slow client")
+	h.drop(c)
+}
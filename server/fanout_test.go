@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+)
+
+// fanOutRoom returns a hub that is never run, holding n bare clients whose
+// queues overflow by the given policy.
+func fanOutRoom(tb testing.TB, n int, overflow overflowPolicy) (*Hub, []*Client) {
+	s, _ := startServer(tb, nil)
+	h := newHub("1234", s.manager)
+	clients := make([]*Client, n)
+	for i := range clients {
+		send := newSendQueue(s.manager.wire.sendQueueSize, overflow)
+		clients[i] = &Client{name: fmt.Sprint("member", i), send: send, done: make(chan struct{}), log: slog.Default()}
+		h.clients[clients[i]] = true
+	}
+	return h, clients
+}
+
+func TestFanOut(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+	}{
+		{name: "small room", n: 10},
+		{name: "just under parallel", n: parallelFanOutMin - 1},
+		{name: "parallel", n: parallelFanOutMin + 88},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, clients := fanOutRoom(t, tt.n, overflowDisconnect)
+			// One member has stopped reading and one ignores the sender.
+			slow, ignoring := clients[0], clients[1]
+			for range slow.send.size {
+				slow.send.push([]byte("{}"))
+			}
+			ignoring.ignored = map[string]bool{"amy": true}
+
+			h.fanOutFrom("", "amy", nil, []byte(`{"type":"chat"}`))
+			if h.clients[slow] {
+				t.Error("the slow member was not dropped")
+			}
+			if got := ignoring.send.len(); got != 0 {
+				t.Errorf("the ignoring member got %d frames, want none", got)
+			}
+			for _, c := range clients[2:] {
+				if got := c.send.len(); got != 1 {
+					t.Fatalf("%s got %d frames, want 1", c.name, got)
+				}
+			}
+			if len(h.clients) != tt.n-1 {
+				t.Errorf("room has %d members, want %d", len(h.clients), tt.n-1)
+			}
+		})
+	}
+}
+
+// BenchmarkFanOut queues one frame for every member of rooms either side
+// of parallelFanOutMin, while each member's queue is drained on its own
+// goroutine as its writePump would. Full queues drop their oldest frames,
+// so a member that falls behind costs time but is never dropped.
+func BenchmarkFanOut(b *testing.B) {
+	for _, n := range []int{64, parallelFanOutMin - 1, parallelFanOutMin, 2048, 8192} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			h, clients := fanOutRoom(b, n, overflowDropOldest)
+			stop := make(chan struct{})
+			defer close(stop)
+			for _, c := range clients {
+				go func() {
+					for {
+						select {
+						case <-c.send.ready:
+							c.send.drain(func([]byte) error { return nil })
+						case <-stop:
+							return
+						}
+					}
+				}()
+			}
+
+			frame := []byte(`{"type":"chat","msg":"hello"}`)
+			for b.Loop() {
+				h.fanOut(frame)
+			}
+			if len(h.clients) != n {
+				b.Fatalf("%d of %d members dropped", n-len(h.clients), n)
+			}
+			b.ReportMetric(float64(b.N*n)/b.Elapsed().Seconds(), "deliveries/s")
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import "sort"
+
+// Per-room feature flags. All are on by default.
+const (
+	featureHistory   = "history"
+	featureReactions = "reactions"
+	featureUploads   = "uploads"
+	featurePresence  = "presence"
+)
+
+var knownFeatures = []string{featureHistory, featureReactions, featureUploads, featurePresence}
+
+func defaultFeatures() map[string]bool {
+	f := make(map[string]bool, len(knownFeatures))
+	for _, name := range knownFeatures {
+		f[name] = true
+	}
+	return f
+}
+
+// applyFeatures merges a set_features request into the room's flags,
+// rejecting the whole update if it names an unknown flag.
+func (h *Hub) applyFeatures(update map[string]bool) *parseError {
+	if len(update) == 0 {
+		return &parseError{codeInvalidFeatures, "no features given"}
+	}
+	for name := range update {
+		if _, ok := h.features[name]; !ok {
+			return &parseError{codeUnknownFeature, "unknown feature " + `"` + name + `"`}
+		}
+	}
+	if h.ephemeral && update[featureHistory] {
+		return &parseError{codeEphemeralRoom, "an ephemeral room cannot keep history"}
+	}
+	for name, on := range update {
+		h.features[name] = on
+	}
+	return nil
+}
+
+// featureSnapshot copies the flags so they can be serialised outside run.
+func (h *Hub) featureSnapshot() map[string]bool {
+	f := make(map[string]bool, len(h.features))
+	for name, on := range h.features {
+		f[name] = on
+	}
+	return f
+}
+
+// featureNames lists the enabled flags in a stable order, for logging.
+func featureNames(f map[string]bool) []string {
+	var names []string
+	for name, on := range f {
+		if on {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// featureRoom is a room whose owner amy has set one feature, with bob, a
+// plain member, already in it.
+type featureRoom struct {
+	ts       *httptest.Server
+	amy, bob *testConn
+	bobToken string
+}
+
+// sync has amy send a marker and returns every frame she got up to its
+// echo: all the room sent her before it.
+func (r *featureRoom) sync() []map[string]any {
+	r.amy.send(map[string]any{"type": "chat", "msg": "sync"})
+	return r.amy.until("chat", "sync")
+}
+
+// uploadAs posts a small text file to the room as the session token's
+// client.
+func uploadAs(t *testing.T, ts *httptest.Server, pin, session string) int {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "notes.txt")
+	fw.Write([]byte("just some notes\n"))
+	mw.Close()
+	req, _ := http.NewRequest("POST", ts.URL+"/upload?pin="+pin, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set(sessionHeader, session)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestDisabledFeatureIsInert exercises each feature with it on and off:
+// on, the effect reaches the room; off, it does not.
+func TestDisabledFeatureIsInert(t *testing.T) {
+	tests := []struct {
+		feature string
+		// exercise uses the feature and reports whether it took effect.
+		exercise func(t *testing.T, r *featureRoom, on bool) bool
+	}{
+		{feature: featureHistory, exercise: func(t *testing.T, r *featureRoom, _ bool) bool {
+			r.amy.send(map[string]any{"type": "chat", "msg": "remember me"})
+			r.bob.expectMsg("chat", "remember me")
+			// History is replayed to spectators ahead of the welcome.
+			carol := dial(t, r.ts, "1234", "carol", url.Values{"spectate": {"1"}})
+			replayed := false
+			for _, f := range ofType(carol.until("system", ""), "chat") {
+				replayed = replayed || f["msg"] == "remember me"
+			}
+			return replayed
+		}},
+		{feature: featureReactions, exercise: func(t *testing.T, r *featureRoom, on bool) bool {
+			r.amy.send(map[string]any{"type": "chat", "msg": "react to me"})
+			id := r.bob.expectMsg("chat", "react to me")["id"]
+			r.bob.send(map[string]any{"type": "reaction", "msg_id": id, "emoji": "👍"})
+			if on {
+				r.bob.expect("reaction")
+			} else if code := r.bob.expect("error")["code"]; code != "feature_disabled" {
+				t.Errorf("reacting: error code = %v, want feature_disabled", code)
+			}
+			return len(ofType(r.sync(), "reaction")) > 0
+		}},
+		{feature: featurePresence, exercise: func(t *testing.T, r *featureRoom, _ bool) bool {
+			carol := dial(t, r.ts, "1234", "carol", nil)
+			carol.expect("system")
+			frames := r.sync()
+			return len(ofType(frames, "joined")) > 0 || len(ofType(frames, "presence")) > 0
+		}},
+		{feature: featureUploads, exercise: func(t *testing.T, r *featureRoom, on bool) bool {
+			if status := uploadAs(t, r.ts, "1234", r.bobToken); status != http.StatusCreated {
+				t.Fatalf("upload status = %d", status)
+			}
+			if !on {
+				if code := r.bob.expect("error")["code"]; code != "feature_disabled" {
+					t.Errorf("uploading: error code = %v, want feature_disabled", code)
+				}
+			}
+			return len(ofType(r.sync(), "file")) > 0
+		}},
+	}
+	for _, tt := range tests {
+		for _, on := range []bool{true, false} {
+			name := tt.feature + " off"
+			if on {
+				name = tt.feature + " on"
+			}
+			t.Run(name, func(t *testing.T) {
+				_, ts := startServer(t, func(cfg *Config) {
+					cfg.UploadDir = t.TempDir()
+					cfg.UploadTypes = []string{"text/plain"}
+				})
+				amy := dial(t, ts, "1234", "amy", nil)
+				amy.expect("system")
+				amy.send(map[string]any{"type": "set_features", "features": map[string]bool{tt.feature: on}})
+				if got := amy.expect("features")["features"].(map[string]any)[tt.feature]; got != on {
+					t.Fatalf("features[%s] = %v, want %v", tt.feature, got, on)
+				}
+				bob := dial(t, ts, "1234", "bob", nil)
+				welcome := bob.expect("system")
+				if got := welcome["features"].(map[string]any)[tt.feature]; got != on {
+					t.Errorf("welcome features[%s] = %v, want %v", tt.feature, got, on)
+				}
+				r := &featureRoom{ts: ts, amy: amy, bob: bob, bobToken: welcome["session"].(string)}
+				r.sync() // drop whatever bob's arrival sent amy
+				if got := tt.exercise(t, r, on); got != on {
+					t.Errorf("%s took effect = %v with the feature on = %v", tt.feature, got, on)
+				}
+			})
+		}
+	}
+}
+
+func TestSetFeaturesOwnerOnly(t *testing.T) {
+	_, ts := startServer(t, nil)
+	amy := dial(t, ts, "1234", "amy", nil)
+	amy.expect("system")
+	bob := dial(t, ts, "1234", "bob", nil)
+	bob.expect("system")
+
+	bob.send(map[string]any{"type": "set_features", "features": map[string]bool{featureHistory: false}})
+	if code := bob.expect("error")["code"]; code != "forbidden" {
+		t.Errorf("member changing features: error code = %v, want forbidden", code)
+	}
+}
+
+func TestApplyFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   map[string]bool
+		wantCode string
+	}{
+		{name: "known flag", update: map[string]bool{featureUploads: false}},
+		{name: "several flags", update: map[string]bool{featureHistory: false, featurePresence: false}},
+		{name: "nothing", update: map[string]bool{}, wantCode: "invalid_features"},
+		{name: "unknown flag", update: map[string]bool{"teleport": true}, wantCode: "unknown_feature"},
+		{name: "unknown among known", update: map[string]bool{featureUploads: false, "teleport": true}, wantCode: "unknown_feature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Hub{features: defaultFeatures()}
+			pe := h.applyFeatures(tt.update)
+			if tt.wantCode != "" {
+				if pe == nil || pe.code != tt.wantCode {
+					t.Fatalf("applyFeatures = %v, want code %q", pe, tt.wantCode)
+				}
+				if !h.features[featureUploads] {
+					t.Error("a rejected update changed the flags")
+				}
+				return
+			}
+			if pe != nil {
+				t.Fatalf("applyFeatures: %v", pe)
+			}
+			for name, on := range tt.update {
+				if h.features[name] != on {
+					t.Errorf("features[%s] = %v, want %v", name, h.features[name], on)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// FilterAction is a MessageFilter's verdict.
+type FilterAction int
+
+const (
+	// FilterPass delivers the message, including any changes the filter
+	// made to it.
+	FilterPass FilterAction = iota
+	// FilterReject bounces the message to its sender with the reason.
+	FilterReject
+	// FilterDrop discards the message without telling anyone.
+	FilterDrop
+)
+
+// MessageFilter screens members' chat, dms and edits before the room sees
+// them. Filters run in order on the room's run loop and may rewrite the
+// message in place; the first that does not pass stops the chain.
+// Encrypted messages are never filtered, as there is nothing to read.
+type MessageFilter interface {
+	Name() string
+	Filter(m *Message) (FilterAction, string)
+}
+
+// screen runs msg through the server's filters, reporting whether it may
+// go on. Only run may call it.
+func (h *Hub) screen(msg *Message) bool {
+	if msg.opaque() {
+		return true
+	}
+	for _, f := range h.manager.filters {
+		action, reason := f.Filter(msg)
+		switch action {
+		case FilterPass:
+			continue
+		case FilterReject:
+			h.log.Info("message rejected", "filter", f.Name(), "user", msg.User)
+			if msg.from != nil {
+				msg.from.trySend(errorFrame(codeContentRejected, reason))
+			}
+		case FilterDrop:
+			h.log.Info("message dropped", "filter", f.Name(), "user", msg.User)
+		}
+		return false
+	}
+	return true
+}
+
+// wordFilter is the built-in wordlist filter. It matches whole words,
+// ignoring case, and either masks them with asterisks or rejects the
+// message.
+type wordFilter struct {
+	pattern *regexp.Regexp
+	reject  bool
+}
+
+func newWordFilter(words []string, reject bool) *wordFilter {
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	return &wordFilter{
+		pattern: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`),
+		reject:  reject,
+	}
+}
+
+func (f *wordFilter) Name() string { return "wordlist" }
+
+func (f *wordFilter) Filter(m *Message) (FilterAction, string) {
+	if !f.pattern.MatchString(m.Msg) {
+		return FilterPass, ""
+	}
+	if f.reject {
+		return FilterReject, "your message contains words not allowed here"
+	}
+	m.Msg = f.pattern.ReplaceAllStringFunc(m.Msg, func(w string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(w))
+	})
+	return FilterPass, ""
+}
+
+// readWordList reads one word or phrase per line, skipping blank lines and
+// # comments.
+func readWordList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var words []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words, sc.Err()
+}
@@ -0,0 +1,175 @@
+//go:build grpc
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Building with -tags grpc links the gochat.v1.GoChat service so GRPC_PORT
+// works. Every request and response is an Envelope, so the service needs
+// no generated code: envelopeCodec transcodes with the same tables as the
+// gochat.v1.proto subprotocol.
+func init() {
+	grpcHook = func(manager *HubManager, adminToken string, tlsConfig *tls.Config) RPCServer {
+		opts := []grpc.ServerOption{
+			grpc.ForceServerCodec(envelopeCodec{}),
+			grpc.MaxRecvMsgSize(int(manager.wire.frameReadLimit())),
+		}
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		s := grpc.NewServer(opts...)
+		s.RegisterService(&gochatService, &grpcService{manager: manager, adminToken: adminToken})
+		return s
+	}
+}
+
+// envelope is a JSON frame on its way to or from the wire as an Envelope.
+type envelope struct {
+	json []byte
+}
+
+type envelopeCodec struct{}
+
+func (envelopeCodec) Name() string { return "proto" }
+
+func (envelopeCodec) Marshal(v any) ([]byte, error) {
+	return jsonToProto(v.(*envelope).json)
+}
+
+func (envelopeCodec) Unmarshal(data []byte, v any) error {
+	b, err := protoToJSON(data)
+	if err != nil {
+		return err
+	}
+	v.(*envelope).json = b
+	return nil
+}
+
+type grpcService struct {
+	manager    *HubManager
+	adminToken string
+}
+
+// grpcCodes maps the HTTP statuses of the shared checks to gRPC codes.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+}
+
+// grpcError converts an error from the rpc layer to a gRPC status.
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code, msg := rpcStatus(err)
+	c, ok := grpcCodes[code]
+	if !ok {
+		c = codes.Internal
+	}
+	return status.Error(c, msg)
+}
+
+// firstMD returns the first value of key in the incoming metadata.
+func firstMD(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (s *grpcService) joinRoom(stream grpc.ServerStream) error {
+	var req envelope
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	var j rpcJoin
+	if err := json.Unmarshal(req.json, &j); err != nil {
+		return status.Error(codes.InvalidArgument, "malformed join request")
+	}
+	ctx := stream.Context()
+	remote := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+	err := s.manager.rpcJoinRoom(ctx, &j, remote, firstMD(ctx, "user-agent"), func(frame []byte) error {
+		return stream.SendMsg(&envelope{json: frame})
+	})
+	if err == ctx.Err() {
+		return nil
+	}
+	return grpcError(err)
+}
+
+func (s *grpcService) sendMessage(ctx context.Context, dec func(any) error) (any, error) {
+	var req envelope
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	if err := s.manager.rpcSendMessage(req.json); err != nil {
+		return nil, grpcError(err)
+	}
+	return &envelope{json: []byte(`{"type":"accepted"}`)}, nil
+}
+
+func (s *grpcService) listRooms(ctx context.Context, dec func(any) error) (any, error) {
+	var req envelope
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	b, err := s.manager.rpcListRooms(s.adminToken, firstMD(ctx, "authorization"))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &envelope{json: b}, nil
+}
+
+// gochatService is the hand-written descriptor of service GoChat in
+// proto/gochat.proto.
+var gochatService = grpc.ServiceDesc{
+	ServiceName: "gochat.v1.GoChat",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SendMessage",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				return srv.(*grpcService).sendMessage(ctx, dec)
+			},
+		},
+		{
+			MethodName: "ListRooms",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				return srv.(*grpcService).listRooms(ctx, dec)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "JoinRoom",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(*grpcService).joinRoom(stream)
+			},
+		},
+	},
+	Metadata: "proto/gochat.proto",
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ping is an application-level heartbeat from a client. TS is whatever the
+// client put there, echoed back untouched so it can time the round trip.
+type ping struct {
+	Type string          `json:"type"`
+	TS   json.RawMessage `json:"ts"`
+}
+
+// pong answers a ping with the server's time and the round trip the
+// server last measured for the connection.
+type pong struct {
+	Type      string          `json:"type"`
+	TS        string          `json:"ts"`
+	Echo      json.RawMessage `json:"echo,omitempty"`
+	LatencyMS float64         `json:"latency_ms,omitempty"`
+}
+
+// maxPingEcho bounds the ts a ping may ask to have echoed.
+const maxPingEcho = 64
+
+// parsePing reports whether a frame is a ping, decoding it apart from
+// Message so any ts the client chooses is accepted.
+func parsePing(message []byte) (*ping, bool) {
+	var p ping
+	if json.Unmarshal(message, &p) != nil || p.Type != "ping" {
+		return nil, false
+	}
+	if len(p.TS) > maxPingEcho {
+		p.TS = nil
+	}
+	return &p, true
+}
+
+// heartbeat answers a ping.
+func (c *Client) heartbeat(p *ping) {
+	b, _ := json.Marshal(pong{
+		Type:      "pong",
+		TS:        time.Now().UTC().Format(time.RFC3339Nano),
+		Echo:      p.TS,
+		LatencyMS: latencyMS(c.latency()),
+	})
+	c.trySend(b)
+}
+
+// writePing sends a WebSocket ping carrying the time it left, so the pong
+// handler can measure the round trip. Only writePump may call it.
+func (c *Client) writePing() error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.wire.writeWait))
+	return c.conn.WriteMessage(websocket.PingMessage, strconv.AppendInt(nil, time.Now().UnixNano(), 10))
+}
+
+// recordPong is the pong handler: any pong keeps the connection alive, and
+// one answering writePing updates the measured round trip.
+func (c *Client) recordPong(payload string) error {
+	c.conn.SetReadDeadline(time.Now().Add(c.wire.pongWait))
+	if sent, err := strconv.ParseInt(payload, 10, 64); err == nil {
+		if rtt := time.Since(time.Unix(0, sent)); rtt >= 0 && rtt <= c.wire.pongWait {
+			c.rtt.Store(int64(rtt))
+		}
+	}
+	return nil
+}
+
+// latency is the connection's last measured round trip, or zero before
+// the first pong and for transports without pings.
+func (c *Client) latency() time.Duration {
+	return time.Duration(c.rtt.Load())
+}
+
+// latencyMS converts a round trip to milliseconds with 0.1ms precision.
+func latencyMS(d time.Duration) float64 {
+	return float64(d.Round(100*time.Microsecond)) / float64(time.Millisecond)
+}
+
+// withLatency copies members with their connections' current latency, so
+// lists read between presence changes are not stale.
+func withLatency(members []Member) []Member {
+	out := make([]Member, len(members))
+	for i, m := range members {
+		out[i] = m
+		if m.Conn != nil && m.client != nil {
+			conn := *m.Conn
+			conn.LatencyMS = latencyMS(m.client.latency())
+			out[i].Conn = &conn
+		}
+	}
+	return out
+}
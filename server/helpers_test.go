@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testWait bounds how long a test waits for a frame that should come.
+const testWait = 5 * time.Second
+
+// startServer runs a server with the default config, changed by configure
+// if set, and shuts it down when the test ends. Unless configure says
+// otherwise, rooms close as soon as they empty, dropped members cannot
+// resume, and members are not throttled.
+func startServer(t testing.TB, configure func(*Config)) (*Server, *httptest.Server) {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.StaticDir, cfg.UploadDir = "", ""
+	cfg.RoomIdleTTL, cfg.ResumeWindow = 0, 0
+	cfg.ClientLimits = ClientLimits{}
+	if configure != nil {
+		configure(cfg)
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), testWait)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+	return s, ts
+}
+
+// testConn is one WebSocket client of a test server.
+type testConn struct {
+	t    testing.TB
+	conn *websocket.Conn
+}
+
+// dial joins room pin as name, or as a guest if name is empty, with extra
+// query parameters if given.
+func dial(t testing.TB, ts *httptest.Server, pin, name string, extra url.Values) *testConn {
+	t.Helper()
+	q := url.Values{"pin": {pin}}
+	if name != "" {
+		q.Set("name", name)
+	}
+	for k, v := range extra {
+		q[k] = v
+	}
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?" + q.Encode()
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", u, err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return &testConn{t: t, conn: conn}
+}
+
+// send writes v as one JSON frame.
+func (c *testConn) send(v any) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(v); err != nil {
+		c.t.Fatalf("sending %v: %v", v, err)
+	}
+}
+
+// next reads the next frame, failing the test after wait.
+func (c *testConn) next(wait time.Duration) (map[string]any, error) {
+	c.conn.SetReadDeadline(time.Now().Add(wait))
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		c.t.Fatalf("decoding %s: %v", data, err)
+	}
+	return m, nil
+}
+
+// expect skips frames until one of type typ arrives and returns it.
+func (c *testConn) expect(typ string) map[string]any {
+	c.t.Helper()
+	deadline := time.Now().Add(testWait)
+	for {
+		m, err := c.next(time.Until(deadline))
+		if err != nil {
+			c.t.Fatalf("waiting for %q: %v", typ, err)
+		}
+		if m["type"] == typ {
+			return m
+		}
+	}
+}
+
+// expectMsg skips frames until one of type typ with text msg arrives and
+// returns it.
+func (c *testConn) expectMsg(typ, msg string) map[string]any {
+	c.t.Helper()
+	for {
+		if f := c.expect(typ); f["msg"] == msg {
+			return f
+		}
+	}
+}
+
+// until collects frames up to and including the first of type typ with
+// text msg, or of type typ at all if msg is empty.
+func (c *testConn) until(typ, msg string) []map[string]any {
+	c.t.Helper()
+	var got []map[string]any
+	deadline := time.Now().Add(testWait)
+	for {
+		m, err := c.next(time.Until(deadline))
+		if err != nil {
+			c.t.Fatalf("waiting for %q %q: %v", typ, msg, err)
+		}
+		got = append(got, m)
+		if m["type"] == typ && (msg == "" || m["msg"] == msg) {
+			return got
+		}
+	}
+}
+
+// frames collects every frame that arrives within wait. A read that times
+// out breaks the connection, so nothing can be read after it.
+func (c *testConn) frames(wait time.Duration) []map[string]any {
+	var got []map[string]any
+	deadline := time.Now().Add(wait)
+	for {
+		m, err := c.next(time.Until(deadline))
+		if err != nil {
+			return got
+		}
+		got = append(got, m)
+	}
+}
+
+// closeError waits for the server to close the connection and returns the
+// close frame it sent.
+func (c *testConn) closeError() *websocket.CloseError {
+	c.t.Helper()
+	deadline := time.Now().Add(testWait)
+	for {
+		_, err := c.next(time.Until(deadline))
+		if err == nil {
+			continue
+		}
+		ce, ok := err.(*websocket.CloseError)
+		if !ok {
+			c.t.Fatalf("connection ended without a close frame: %v", err)
+		}
+		return ce
+	}
+}
+
+// ofType keeps the frames of type typ.
+func ofType(frames []map[string]any, typ string) []map[string]any {
+	var out []map[string]any
+	for _, f := range frames {
+		if f["type"] == typ {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// call sends an HTTP request to the test server with token, if set, as a
+// bearer token, and returns the status and body.
+func call(t testing.TB, ts *httptest.Server, token, method, path, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+// dialStatus tries to join with query q and returns the HTTP status of
+// the refusal and its body, or 101 if the upgrade succeeded.
+func dialStatus(t testing.TB, ts *httptest.Server, q url.Values) (int, string) {
+	t.Helper()
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?" + q.Encode()
+	conn, resp, err := websocket.DefaultDialer.Dial(u, nil)
+	if err == nil {
+		conn.Close()
+		return http.StatusSwitchingProtocols, ""
+	}
+	if resp == nil {
+		t.Fatalf("dialing %s: %v", u, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
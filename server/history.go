@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Page sizes for GET /api/rooms/{pin}/messages.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// historyPageTimeout bounds the store reads behind one page.
+const historyPageTimeout = 10 * time.Second
+
+// handleHistory serves GET /api/rooms/{pin}/messages?before=<id>&limit=50,
+// a page of the room's stored chat older than before (or the newest
+// without it), oldest first. ?channel= keeps one channel's chat. The
+// caller needs the admin token or the X-GoChat-Session header of a member.
+// The response's "before" is the cursor for the next page, absent once
+// the start of the room is reached. ?after_seq= pages forwards instead;
+// see messagesAfter.
+func handleHistory(manager *HubManager, adminToken string, w http.ResponseWriter, r *http.Request) {
+	pin := r.PathValue("pin")
+	if !isAdmin(adminToken, r) {
+		client := manager.sessions.get(r.Header.Get(sessionHeader))
+		if client == nil || client.hub.pin != pin {
+			writeError(w, http.StatusUnauthorized, codeInvalidSession, "not a member of this room")
+			return
+		}
+	}
+	if manager.keepsNoHistory(pin, w) {
+		return
+	}
+	q := r.URL.Query()
+	limit := defaultPageSize
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "limit must be 1 to "+strconv.Itoa(maxPageSize))
+			return
+		}
+		limit = n
+	}
+	channel := q.Get("channel")
+	if channel != "" {
+		var ok bool
+		if channel, ok = normalizeChannel(channel); !ok {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid channel")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), historyPageTimeout)
+	defer cancel()
+	if q.Has("after_seq") {
+		after, err := strconv.ParseUint(q.Get("after_seq"), 10, 64)
+		if err != nil || q.Has("before") {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "after_seq must be a seq number, without before")
+			return
+		}
+		messagesAfter(ctx, manager, pin, channel, after, limit, w)
+		return
+	}
+	// Pages are read until enough of the channel's chat is found, so a
+	// quiet channel does not come back as a run of short pages.
+	page := []json.RawMessage{}
+	cursor, more := q.Get("before"), true
+	for more && len(page) < limit {
+		var (
+			msgs []StoredMessage
+			err  error
+		)
+		if cursor == "" {
+			msgs, err = manager.store.Recent(ctx, pin, limit)
+		} else {
+			msgs, err = manager.store.Before(ctx, pin, cursor, limit)
+		}
+		if errors.Is(err, errMessageNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "no stored message with that id")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, codeUnavailable, "history unavailable")
+			return
+		}
+		more = len(msgs) == limit
+		if len(msgs) == 0 {
+			cursor = ""
+		}
+		// msgs is oldest first; take from its newest end.
+		for i := len(msgs) - 1; i >= 0 && len(page) < limit; i-- {
+			cursor = msgs[i].ID
+			if i == 0 && !more {
+				cursor = ""
+			}
+			if channel == "" || frameChannel(msgs[i].Frame) == channel {
+				page = append(page, msgs[i].Frame)
+			}
+		}
+	}
+	for i, j := 0, len(page)-1; i < j; i, j = i+1, j-1 {
+		page[i], page[j] = page[j], page[i]
+	}
+
+	resp := map[string]any{"pin": pin, "count": len(page), "messages": page}
+	if cursor != "" {
+		resp["before"] = cursor
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// messagesAfter writes a page of the room's stored chat with a seq above
+// after, oldest first. A client that sees a jump in seq asks for the chat
+// after the last seq it has to fill the gap. The response's "after_seq"
+// is the cursor for the next page, absent once the newest stored message
+// is reached.
+func messagesAfter(ctx context.Context, manager *HubManager, pin, channel string, after uint64, limit int, w http.ResponseWriter) {
+	page := []json.RawMessage{}
+	more := true
+	for more && len(page) < limit {
+		msgs, err := manager.store.After(ctx, pin, after, limit)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, codeUnavailable, "history unavailable")
+			return
+		}
+		more = len(msgs) == limit
+		for _, m := range msgs {
+			if len(page) == limit {
+				more = true
+				break
+			}
+			after = m.Seq
+			if channel == "" || frameChannel(m.Frame) == channel {
+				page = append(page, m.Frame)
+			}
+		}
+	}
+
+	resp := map[string]any{"pin": pin, "count": len(page), "messages": page}
+	if more {
+		resp["after_seq"] = after
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
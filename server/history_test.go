@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestSpectatorViewIsContiguous(t *testing.T) {
+	const total = 250
+	tests := []struct {
+		name  string
+		after int // messages sent before the spectator joins
+	}{
+		{name: "at the start", after: 0},
+		{name: "mid-stream", after: 40},
+		{name: "past history", after: historySize + 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := startServer(t, nil)
+			amy := dial(t, ts, "1234", "amy", nil)
+			amy.expect("system")
+
+			// amy keeps talking while the spectator joins and catches up.
+			reached := make(chan struct{})
+			sent := make(chan error, 1)
+			go func() {
+				for i := range total {
+					if i == tt.after {
+						close(reached)
+					}
+					if err := amy.conn.WriteJSON(map[string]any{"type": "chat", "msg": fmt.Sprint(i)}); err != nil {
+						sent <- err
+						return
+					}
+				}
+				sent <- nil
+			}()
+			<-reached
+			spectator := dial(t, ts, "1234", "", url.Values{"spectate": {"1"}})
+			if err := <-sent; err != nil {
+				t.Fatalf("sending: %v", err)
+			}
+
+			var seqs []uint64
+			for {
+				f := spectator.expect("chat")
+				seqs = append(seqs, uint64(f["seq"].(float64)))
+				if f["msg"] == fmt.Sprint(total-1) {
+					break
+				}
+			}
+			for i := 1; i < len(seqs); i++ {
+				if seqs[i] != seqs[i-1]+1 {
+					t.Fatalf("spectator saw seq %d after %d", seqs[i], seqs[i-1])
+				}
+			}
+			// Whatever was sent before the join is replayed up to the
+			// history limit, and everything after it arrives live.
+			if want := min(total, historySize); len(seqs) < want {
+				t.Errorf("spectator saw %d messages, want at least %d", len(seqs), want)
+			}
+		})
+	}
+}
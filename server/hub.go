@@ -0,0 +1,857 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hub is a single room. Its run loop is the only goroutine that mutates
+// clients or closes a client's done channel.
+//
+// Lifecycle invariants:
+//   - A client's send channel is never closed. run signals removal by
+//     closing client.done exactly once (see drop); writePump exits on it.
+//   - Anyone sending to unregister/broadcast must also select on h.done,
+//     which run closes after it has dropped every client.
+//   - register is a bounded queue fed only through enqueue, so joins never
+//     wait on the run loop and nothing lands in the queue after teardown.
+type Hub struct {
+	clients    map[*Client]bool
+	broadcast  chan *Message
+	register   chan *Client
+	unregister chan *Client
+	remote     chan []byte
+	kick       chan kickRequest
+	threads    chan threadRequest
+	configs    chan settingsRequest
+	sweep      chan struct{}
+	done       chan struct{}
+	pin        string
+	manager    *HubManager
+	log        *slog.Logger
+
+	// stop cancels run with a cause that is sent to clients as the close
+	// reason; set by the manager when the hub is started.
+	stop context.CancelCauseFunc
+
+	// regMu guards closed against enqueue racing with teardown.
+	regMu  sync.Mutex
+	closed bool
+
+	// draining refuses new joins while clients are redirected elsewhere.
+	draining atomic.Bool
+
+	// seq numbers chat messages; history holds the most recent frames in
+	// seq order. Both are owned by run.
+	seq     uint64
+	history []historyEntry
+
+	// roster is scratch space for parallel fan-out. Owned by run.
+	roster []*Client
+
+	// features are the room's toggles, settable by the owner. Owned by run.
+	features map[string]bool
+
+	// settings and the room-wide limiter derived from them. Owned by run.
+	settings RoomSettings
+	limiter  *tokenBucket
+
+	// reactions holds who reacted with what, by message id, for messages
+	// still in history. Owned by run.
+	reactions map[string]reactionSet
+
+	// roles holds the roles of signed-in members who are not plain
+	// members, by identity, so they survive a reconnect. Owned by run.
+	roles map[string]role
+
+	// mutes holds when each spam-muted member may chat again, by
+	// identity. Owned by run.
+	mutes map[string]time.Time
+
+	// parked holds members whose connection dropped, by resume token,
+	// until parkTimer fires for them. Owned by run.
+	parked    map[string]*parkedClient
+	parkTimer *time.Timer
+
+	// reads holds each member's read marker, by identity. Owned by run.
+	reads map[string]readMarker
+
+	// pins are the room's pinned messages, at most maxPins. Owned by run.
+	pins []historyEntry
+
+	// presence is the published member list; presenceDirty asks run to
+	// republish it after membership or the presence flag changes.
+	presence      atomic.Pointer[presenceSnapshot]
+	presenceDirty bool
+
+	// epoch counts key rotations and rekeyDue asks run for another after
+	// membership or a member's public key changes. keyed records whether
+	// the last rotation carried any keys. Owned by run.
+	epoch    uint64
+	rekeyDue bool
+	keyed    bool
+
+	// capacity caps the room's members and occupants counts them; both are
+	// written only by run. created is set once the first join is processed.
+	capacity  atomic.Int32
+	occupants atomic.Int32
+	created   bool
+
+	// preset rooms were created through the API: their settings are fixed
+	// and the first member cannot change them. title is their display
+	// name. Persistent rooms outlive their members; any other preset room
+	// closes if nobody joins by claimBy. Public rooms are listed in the
+	// lobby. Ephemeral rooms store and replay nothing. retention, if set,
+	// bounds how long the room keeps messages, and a room with expiresAt
+	// closes then for good. All are set before run starts.
+	preset     bool
+	title      string
+	persistent bool
+	public     bool
+	ephemeral  bool
+	claimBy    time.Time
+	retention  time.Duration
+	expiresAt  time.Time
+
+	// topic is the room's one-line description, shown in the lobby.
+	topic atomic.Pointer[string]
+
+	// webhook, if set, receives the room's chat. Set before run starts.
+	webhook *webhook
+
+	// bots run in the room, and botNames records which. Set before run
+	// starts.
+	bots     []Bot
+	botNames map[string]bool
+
+	// historyLimit is how many messages history keeps, at most the
+	// manager's historyLimit.
+	historyLimit int
+
+	// idleSince is when the room last became empty, in Unix nanoseconds;
+	// zero while anyone is in it. Written only by run.
+	idleSince atomic.Int64
+
+	// mailboxes collect chat for recently disconnected users, by identity.
+	// Owned by run.
+	mailboxes map[string]*mailbox
+	// away holds the names of signed-in members who disconnected, by
+	// user id, so Web Push can tell them of mentions and dms.
+	away map[string]string
+
+	// authRequired refuses anonymous joins; set by the creator. Owned by run.
+	authRequired bool
+
+	// bans are names and addresses refused entry.
+	bans banList
+
+	// password, if set by the room's creator, is required to join. Owned
+	// by run; locked mirrors whether it is set, for the lobby.
+	password *roomPassword
+	locked   atomic.Bool
+}
+
+// historySize is how many recent chat frames a room keeps for replay.
+const historySize = 100
+
+// remoteQueueSize bounds frames from other instances waiting on a room.
+const remoteQueueSize = 256
+
+// registerQueueSize bounds how many joins may be waiting on a busy room.
+const registerQueueSize = 128
+
+var (
+	errRoomBusy   = errors.New("room is busy, try again shortly")
+	errHubStopped = errors.New("hub stopped")
+	// errRoomNotFound is returned for a PIN with no live room.
+	errRoomNotFound = errors.New("room not found")
+)
+
+func newHub(pin string, manager *HubManager) *Hub {
+	h := &Hub{
+		manager:    manager,
+		clients:    make(map[*Client]bool),
+		broadcast:  make(chan *Message),
+		register:   make(chan *Client, registerQueueSize),
+		unregister: make(chan *Client),
+		remote:     make(chan []byte, remoteQueueSize),
+		kick:       make(chan kickRequest),
+		threads:    make(chan threadRequest),
+		configs:    make(chan settingsRequest),
+		sweep:      make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		pin:        pin,
+		log:        slog.With("room", pin),
+		features:   defaultFeatures(),
+
+		historyLimit: manager.historyLimit(),
+	}
+	h.applySettings(manager.defaults)
+	h.addBots(manager.bots)
+	h.capacity.Store(int32(manager.capacity))
+	return h
+}
+
+func (h *Hub) run(ctx context.Context) {
+	defer func() {
+		h.regMu.Lock()
+		h.closed = true
+		h.regMu.Unlock()
+		for len(h.register) > 0 {
+			(<-h.register).reject(closeTryAgain, codeRoomClosed, "the room closed, reconnect to reopen it")
+		}
+		cause := context.Cause(ctx)
+		for client := range h.clients {
+			if cause != nil && cause != context.Canceled {
+				client.closeCode, client.closeReason = closeCodeFor(cause), cause.Error()
+			}
+			h.drop(client)
+		}
+		if h.parkTimer != nil {
+			h.parkTimer.Stop()
+		}
+	}()
+
+	if !h.ephemeral {
+		h.loadHistory(ctx)
+		h.loadPins(ctx)
+	}
+
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+	var expiry <-chan time.Time
+	if !h.expiresAt.IsZero() {
+		t := time.NewTimer(time.Until(h.expiresAt))
+		defer t.Stop()
+		expiry = t.C
+	}
+	var idleCheck <-chan time.Time
+	if h.manager.idle.Timeout > 0 {
+		t := time.NewTicker(idleCheckInterval)
+		defer t.Stop()
+		idleCheck = t.C
+	}
+
+	for {
+		for h.presenceDirty {
+			h.presenceDirty = false
+			h.presenceChanged()
+		}
+		if h.rekeyDue {
+			h.rekeyDue = false
+			h.rekey()
+		}
+		if h.created && len(h.clients) == 0 && len(h.parked) == 0 && h.vacated(time.Now()) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-prune.C:
+			if !h.created && !h.claimBy.IsZero() && now.After(h.claimBy) {
+				h.log.Info("room never claimed, closing")
+				return
+			}
+			h.pruneHistory(now)
+			h.expireMailboxes(now)
+			h.expireMutes(now)
+		case now := <-idleCheck:
+			h.checkIdle(now)
+		case client := <-h.register:
+			h.admit(client)
+		case client := <-h.unregister:
+			if _, ok := h.clients[client]; ok {
+				h.drop(client)
+				client.log.Info("left room", "reason", client.leaveReason)
+				h.leftRoom(client)
+				if client.leaveReason == leaveDisconnected {
+					h.openMailbox(client, time.Now())
+					if h.park(client, time.Now()) {
+						break
+					}
+				}
+				if len(h.clients) > 0 && h.features[featurePresence] {
+					h.fanOutFrom("", client.name, nil, h.frame(&Message{Type: "left", User: client.name, Reason: client.leaveReason}))
+				}
+				h.notifyBots(func(b Bot, room BotRoom) { b.OnLeave(room, client.name) })
+			}
+		case msg := <-h.broadcast:
+			h.handle(msg)
+		case req := <-h.kick:
+			h.kicked(req)
+		case now := <-h.parkExpiry():
+			h.expireParked(now)
+		case req := <-h.threads:
+			req.reply <- h.threadFrames(req)
+		case req := <-h.configs:
+			req.reply <- h.changeSettings(req.client, req.settings)
+		case <-expiry:
+			h.expire()
+			return
+		case <-h.sweep:
+			if h.expired(time.Now()) {
+				h.log.Info("room expired", "idle", h.manager.roomTTL)
+				return
+			}
+		case frame := <-h.remote:
+			// A chat frame already stamped and persisted by another instance.
+			var from struct {
+				ID   string `json:"id"`
+				Seq  uint64 `json:"seq"`
+				User string `json:"user"`
+			}
+			_ = json.Unmarshal(frame, &from)
+			// Keep numbering after the highest seq seen, so this
+			// instance's next chat does not go backwards.
+			h.seq = max(h.seq, from.Seq)
+			channel := frameChannel(frame)
+			now := time.Now()
+			if h.features[featureHistory] {
+				h.remember(historyEntry{id: from.ID, seq: from.Seq, channel: channel, parent: frameParent(frame), at: now, frame: frame})
+			}
+			h.deliverOffline(from.ID, channel, frame, now)
+			h.fanOutFrom(channel, from.User, nil, frame)
+		}
+	}
+}
+
+// handle processes one message published to the room. Only run may call it.
+func (h *Hub) handle(msg *Message) {
+	// Names can change with /nick, so the sender is stamped here rather
+	// than when the frame is read. ignore and unignore name their target
+	// in user instead.
+	if msg.from != nil && msg.Type != "ignore" && msg.Type != "unignore" {
+		msg.User = msg.from.name
+		if msg.Type == "chat" || msg.Type == "dm" || msg.Type == "file" {
+			msg.Avatar = msg.from.avatarURL()
+		}
+	}
+	if msg.from != nil && (msg.Type == "chat" || msg.Type == "dm" || msg.Type == "file") {
+		msg.from.touch(time.Now())
+	}
+	if (msg.Type == "chat" || msg.Type == "dm" || msg.Type == "edit") && !h.screen(msg) {
+		return
+	}
+	if (msg.Type == "chat" || msg.Type == "dm") && h.silenced(msg) {
+		return
+	}
+	switch msg.Type {
+	case "set_features":
+		h.setFeatures(msg)
+	case "settings":
+		h.updateSettings(msg)
+	case "pin", "unpin":
+		h.updatePins(msg)
+	case "ignore", "unignore":
+		h.updateIgnore(msg)
+	case "typing":
+		h.relayTyping(msg)
+	case "dm":
+		h.directMessage(msg)
+	case "offer", "answer", "ice-candidate":
+		h.relaySignal(msg)
+	case "key":
+		h.publishKey(msg)
+	case "file":
+		h.shareFile(msg)
+	case "reaction":
+		h.react(msg)
+	case "read":
+		h.markRead(msg)
+	case "subscribe", "unsubscribe":
+		h.updateChannels(msg)
+	case "link_preview":
+		h.attachPreview(msg)
+	case "edit", "delete":
+		h.amend(msg)
+	case "chat":
+		if !h.runCommand(msg) {
+			h.broadcastChat(msg)
+		}
+	default:
+		// Server-originated notices such as migrate.
+		h.fanOut(h.frame(msg))
+	}
+}
+
+// broadcastChat rate-checks, stamps, records and fans out a chat message.
+func (h *Hub) broadcastChat(msg *Message) {
+	ctx, sp := startSpan(msg.trace, "gochat.broadcast", spanAttr{"gochat.room", h.pin})
+	defer sp.end()
+	if h.limiter != nil && !h.limiter.allow(time.Now()) {
+		if msg.from != nil {
+			msg.from.trySend(errorFrame(codeRoomRateLimited, "room is over its message rate, slow down"))
+		}
+		return
+	}
+	if msg.ParentID != "" && !h.threadReply(msg) {
+		return
+	}
+	if msg.from != nil && !msg.from.subscribed(msg.Channel) {
+		msg.from.trySend(errorFrame(codeNotSubscribed, "subscribe to #"+msg.Channel+" before posting to it"))
+		return
+	}
+	if !h.slowModeAllows(msg.from) {
+		return
+	}
+	h.seq++
+	msg.Seq = h.seq
+	msg.ID = newMessageID()
+	msg.Room = h.pin
+	msg.TS = time.Now().UTC().Format(time.RFC3339Nano)
+	sp.set(spanAttr{"gochat.seq", msg.Seq}, spanAttr{"gochat.msg_id", msg.ID}, spanAttr{"gochat.channel", msg.Channel})
+
+	// The client's id goes back in the ack only, not to the room.
+	clientMsgID := msg.ClientMsgID
+	msg.ClientMsgID = ""
+	msg.Mentions = h.mentionedMembers(msg)
+	message, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	h.ack(msg.from, clientMsgID, msg)
+	now := time.Now()
+	if h.features[featureHistory] {
+		var sender string
+		if msg.from != nil {
+			sender = msg.from.identity()
+		}
+		h.remember(historyEntry{id: msg.ID, seq: msg.Seq, sender: sender, channel: msg.Channel, parent: msg.ParentID, at: now, frame: message})
+		h.manager.persist.save(ctx, StoredMessage{Room: h.pin, ID: msg.ID, Seq: msg.Seq, At: now, Frame: message})
+	}
+	h.deliverOffline(msg.ID, msg.Channel, message, now)
+	_, fan := startSpan(ctx, "gochat.fanout", spanAttr{"gochat.recipients", len(h.clients)})
+	h.fanOutFrom(msg.Channel, msg.User, nil, message)
+	fan.end()
+	h.notifyMentions(msg, msg.Mentions, msg.Channel)
+	h.pushMentions(msg)
+	h.manager.unfurl.enqueue(h, msg, msg.Channel)
+	if h.webhook != nil && h.webhook.matches(msg) {
+		h.webhook.enqueue(message)
+	}
+	if h.manager.backplane != nil {
+		h.manager.backplane.Publish(h.pin, message)
+	}
+	h.botsSee(msg)
+}
+
+// ack tells the sender its message was accepted, before the message
+// itself reaches the room. Only run may call it.
+func (h *Hub) ack(c *Client, clientMsgID string, msg *Message) {
+	if c == nil || clientMsgID == "" {
+		return
+	}
+	c.trySend(h.frame(&Message{Type: "ack", ClientMsgID: clientMsgID, ServerID: msg.ID, Seq: msg.Seq}))
+}
+
+// admit adds a queued client to the room, or turns it away. Only run may
+// call it.
+func (h *Hub) admit(client *Client) bool {
+	creating := !h.created
+	h.created = true
+	if h.draining.Load() || client.left.Load() {
+		close(client.done)
+		return false
+	}
+	cursor, resumed := h.unpark(client)
+	if h.bans.banned(client.name, client.fingerprint) {
+		client.reject(closeBanned, codeBanned, "you are banned from this room")
+		return false
+	}
+	if h.authRequired && client.userID == "" {
+		client.reject(closeAuthFailed, codeAuthRequired, "this room requires a signed-in user")
+		return false
+	}
+	if !resumed && !h.checkPassword(client, creating && !h.preset, time.Now()) {
+		client.reject(closeAuthFailed, codeAuthFailed, "wrong or missing room password")
+		return false
+	}
+	if len(h.clients) >= int(h.capacity.Load()) {
+		client.reject(closeTryAgain, codeRoomFull, "the room is full")
+		return false
+	}
+	if h.nameTaken(client.name) {
+		client.reject(closeNameTaken, codeNameTaken, "the name "+`"`+client.name+`"`+" is already in use in this room")
+		return false
+	}
+
+	// Everything up to h.seq goes out via replay; every later
+	// broadcast lands in send, so the view has no gap or overlap.
+	// A returning user first gets whatever they missed that history no
+	// longer holds.
+	// A resumed member gets only what came after its cursor.
+	var frames [][]byte
+	if h.features[featureHistory] {
+		h.pruneHistory(time.Now())
+		if resumed {
+			frames = h.framesSince(client, cursor)
+		} else {
+			frames = h.historyFrames(client)
+		}
+	}
+	if missed := h.claimMailbox(client, frames, time.Now()); len(missed) > 0 {
+		frames = append(missed, frames...)
+	}
+	client.replay <- frames
+
+	// The room's creator owns it. A resumed member never left as far as
+	// the room knows, so it keeps its role and is not announced.
+	if !resumed {
+		client.role = h.joinRole(client, len(h.clients) == 0)
+		if h.features[featurePresence] {
+			h.fanOutFrom("", client.name, nil, h.frame(&Message{Type: "joined", User: client.name}))
+		}
+	}
+	if creating && !h.preset && client.capacity > 0 {
+		h.capacity.Store(int32(client.capacity))
+	}
+	if creating && !h.preset && client.requireAuth {
+		h.authRequired = true
+	}
+	h.idleSince.Store(0)
+	client.touch(time.Now())
+	h.clients[client] = true
+	delete(h.away, client.userID)
+	h.occupants.Store(int32(len(h.clients)))
+	h.presenceDirty = true
+	h.rekeyDue = true
+	client.resumeToken = newResumeToken()
+	client.log.Info("joined room", "resumed", resumed)
+
+	settings := h.settings
+	client.trySend(h.frame(&Message{
+		Type:      "system",
+		User:      client.name,
+		From:      client.id,
+		Role:      string(client.role),
+		Channels:  client.channelList(),
+		Session:   client.sessionToken(),
+		Resume:    client.resumeToken,
+		Resumed:   resumed,
+		Msg:       "👋 Welcome to room " + h.pin + ", " + client.name,
+		RoomName:  h.title,
+		Features:  h.featureSnapshot(),
+		Settings:  &settings,
+		Pinned:    h.pinnedFrames(),
+		Reactions: h.reactionCounts(),
+		Reads:     h.readMarkers(),
+		Keys:      h.memberKeys(),
+		Epoch:     h.epoch,
+	}))
+	if motd := h.manager.motd.current(); motd != "" {
+		client.trySend(motdFrame(motd))
+	}
+	if !resumed {
+		h.notifyBots(func(b Bot, room BotRoom) { b.OnJoin(room, client.name) })
+	}
+	return true
+}
+
+// setFeatures applies an owner's set_features request and announces the
+// new flags to the room.
+func (h *Hub) setFeatures(msg *Message) {
+	if !msg.from.can(permSettings) {
+		msg.from.trySend(errorFrame(codeForbidden, "only the room owner can change features"))
+		return
+	}
+	if pe := h.applyFeatures(msg.Features); pe != nil {
+		msg.from.trySend(errorFrame(pe.code, pe.detail))
+		return
+	}
+	if !h.features[featureHistory] {
+		h.history = nil
+	}
+	if !h.features[featureReactions] {
+		h.reactions = nil
+	}
+	h.presenceDirty = true
+	h.log.Info("features changed", "features", featureNames(h.features))
+	h.audit(msg.from, AuditEntry{Action: auditFeatures, Detail: auditJSON(msg.Features)})
+	h.fanOut(h.frame(&Message{Type: "features", Features: h.featureSnapshot()}))
+}
+
+// frame serialises a server-originated message.
+func (h *Hub) frame(m *Message) []byte {
+	b, _ := json.Marshal(m)
+	return b
+}
+
+// enqueue queues c for registration without waiting on the run loop.
+func (h *Hub) enqueue(c *Client) error {
+	h.regMu.Lock()
+	defer h.regMu.Unlock()
+	if h.closed {
+		return errHubStopped
+	}
+	select {
+	case h.register <- c:
+		return nil
+	default:
+		return errRoomBusy
+	}
+}
+
+// publish hands a message to the run loop for fan-out, giving up if the
+// hub has already shut down.
+func (h *Hub) publish(m *Message) bool {
+	select {
+	case h.broadcast <- m:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// drop removes a client and signals its pumps. Only run may call it.
+func (h *Hub) drop(c *Client) {
+	delete(h.clients, c)
+	h.occupants.Store(int32(len(h.clients)))
+	close(c.done)
+	h.presenceDirty = true
+	h.rekeyDue = true
+}
+
+type HubManager struct {
+	hubs map[string]*Hub
+	mu   sync.Mutex
+
+	// maintenance rejects new upgrades while existing connections drain.
+	maintenance atomic.Bool
+
+	// drainRequested is closed once a server drain is asked for.
+	drainRequested chan struct{}
+	drainOnce      sync.Once
+
+	// policy restricts what anonymous clients may do; nil allows everything.
+	policy *authPolicy
+
+	// tokens verifies JWTs offered at upgrade; nil when JWT_SECRET is unset.
+	tokens *tokenIssuer
+
+	// bans is the server-wide banlist, checked at upgrade.
+	bans *globalBans
+
+	// motd is the optional externally-fetched join banner.
+	motd *motdSource
+
+	// push sends Web Push notifications; nil when VAPID_PRIVATE_KEY is
+	// unset.
+	push *pusher
+
+	// unfurl fetches link previews; nil unless LINK_PREVIEWS is set.
+	unfurl *unfurler
+
+	// retention bounds how long room history is kept; zero keeps it until
+	// it is pushed out by historySize.
+	retention time.Duration
+	// roomLimit bounds how many stored messages each room keeps; zero
+	// keeps them all.
+	roomLimit int
+
+	// defaults are the settings every new room starts with.
+	defaults RoomSettings
+
+	// store persists history; writes go through persist.
+	store   Store
+	persist *persister
+
+	// backplane relays chat to other instances; nil when running alone.
+	// cluster holds the presence the other instances announce over it.
+	backplane Broker
+	cluster   presenceRegistry
+
+	// clientLimits bound each connection's send rate, and wire its
+	// timeouts and frame sizes.
+	clientLimits ClientLimits
+	wire         wireLimits
+
+	// proxy says which forwarding headers to believe about a request.
+	proxy proxyTrust
+
+	// sessions lets HTTP requests act for a connected client.
+	sessions sessions
+
+	// conns tracks running pumps and SSE streams, so shutdown can wait for
+	// the final frames to be written and no connection outlives it.
+	conns sync.WaitGroup
+
+	// capacity is the default room size and maxCapacity the largest a
+	// creator may ask for.
+	capacity    int
+	maxCapacity int
+
+	// mailboxWindow is how long missed chat is kept for a disconnected
+	// user; zero disables mailboxes.
+	mailboxWindow time.Duration
+
+	// roomTTL keeps an empty room alive this long; zero closes it at once.
+	roomTTL time.Duration
+
+	// requireCreated refuses joins to PINs not created through the API.
+	requireCreated bool
+
+	// passwordTTL is how long a room password lasts; zero never expires.
+	passwordTTL time.Duration
+
+	// bots are started in every room.
+	bots []string
+
+	// filters screen every room's chat, in order.
+	filters []MessageFilter
+
+	// spam configures automatic muting.
+	spam SpamLimits
+
+	// idle disconnects members who stop chatting.
+	idle IdleLimits
+
+	// resumeWindow is how long a dropped connection may be resumed; zero
+	// disables resuming.
+	resumeWindow time.Duration
+}
+
+func newHubManager(cfg *Config, store Store) *HubManager {
+	var tokens *tokenIssuer
+	if cfg.JWTSecret != "" {
+		tokens = &tokenIssuer{secret: []byte(cfg.JWTSecret), ttl: cfg.JWTTTL}
+	}
+	m := &HubManager{
+		tokens:    tokens,
+		bans:      newGlobalBans(nil),
+		hubs:      make(map[string]*Hub),
+		policy:    newAuthPolicy(cfg.AnonActions),
+		retention: cfg.MessageRetention,
+		roomLimit: cfg.MessageRoomLimit,
+		defaults:  cfg.RoomDefaults,
+		store:     store,
+		persist:   newPersister(store),
+
+		drainRequested: make(chan struct{}),
+
+		passwordTTL:    cfg.RoomPasswordTTL,
+		requireCreated: cfg.RequireRoomCreate,
+		clientLimits:   cfg.ClientLimits,
+		wire:           newWireLimits(cfg),
+		proxy:          newProxyTrust(cfg),
+		roomTTL:        cfg.RoomIdleTTL,
+		mailboxWindow:  cfg.MailboxWindow,
+		capacity:       cfg.RoomCapacity,
+		maxCapacity:    cfg.RoomMaxCapacity,
+		bots:           cfg.Bots,
+		spam:           cfg.SpamLimits,
+		idle:           cfg.IdleLimits,
+		resumeWindow:   cfg.ResumeWindow,
+	}
+	if len(cfg.FilterWords) > 0 {
+		m.filters = append(m.filters, newWordFilter(cfg.FilterWords, cfg.FilterAction == "reject"))
+	}
+	return m
+}
+
+func (m *HubManager) getHub(pin string) *Hub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hub, exists := m.hubs[pin]
+	if exists {
+		select {
+		case <-hub.done:
+			exists = false // stopped but not yet removed
+		default:
+		}
+	}
+	if !exists {
+		hub = newHub(pin, m)
+		m.start(hub)
+	}
+	return hub
+}
+
+// start registers h under its PIN and runs it, removing it again when it
+// stops. done is closed only once the hub is gone from m.hubs, so anyone
+// who saw it close can rely on the PIN being free. The caller must hold
+// m.mu.
+func (m *HubManager) start(h *Hub) {
+	m.hubs[h.pin] = h
+	ctx, cancel := context.WithCancelCause(context.Background())
+	h.stop = cancel
+	if h.webhook != nil {
+		go h.webhook.run(h.done)
+	}
+	go func() {
+		h.run(ctx)
+		m.mu.Lock()
+		if m.hubs[h.pin] == h {
+			delete(m.hubs, h.pin)
+		}
+		m.mu.Unlock()
+		if m.backplane != nil {
+			m.backplane.PublishPresence(h.pin, instancePresence{})
+		}
+		cancel(nil)
+		close(h.done)
+	}()
+}
+
+// Close stops the room, disconnecting its clients with cause as the close
+// reason, and waits until it has shut down or ctx expires.
+func (h *Hub) Close(ctx context.Context, cause error) error {
+	h.draining.Store(true)
+	h.stop(cause)
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeRoom closes the live room for pin, if any.
+func (m *HubManager) closeRoom(ctx context.Context, pin string, cause error) error {
+	h := m.lookup(pin)
+	if h == nil {
+		return errRoomNotFound
+	}
+	return h.Close(ctx, cause)
+}
+
+// deliverRemote hands a frame from another instance to the local room for
+// pin, if anyone here is in it. It never blocks the backplane.
+func (m *HubManager) deliverRemote(pin string, frame []byte) {
+	hub := m.lookup(pin)
+	if hub == nil {
+		return
+	}
+	select {
+	case hub.remote <- frame:
+	default:
+		hub.log.Warn("remote queue full, dropping frame")
+	}
+}
+
+// lookup returns the live hub for pin without creating one.
+func (m *HubManager) lookup(pin string) *Hub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hubs[pin]
+}
+
+// join queues c for the room for pin, retrying if it raced with the
+// previous hub for that PIN shutting down. It returns errRoomBusy when the
+// room's join queue is full.
+func (m *HubManager) join(pin string, c *Client) error {
+	for {
+		hub := m.getHub(pin)
+		c.hub = hub
+		if err := hub.enqueue(c); err != errHubStopped {
+			return err
+		}
+		<-hub.done
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// chatter joins n members to pin who keep sending chat until their
+// connection drops, and returns a wait for every connection to end.
+func chatter(t *testing.T, ts *httptest.Server, pin string, n int) (conns []*testConn, wait func() bool) {
+	var wg sync.WaitGroup
+	for range n {
+		c := dial(t, ts, pin, "", nil)
+		c.expect("system")
+		conns = append(conns, c)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				if c.conn.WriteJSON(map[string]any{"type": "chat", "msg": fmt.Sprint("message ", j)}) != nil {
+					return
+				}
+				// Unpaced, the members outrun their own send buffers and are
+				// dropped as slow before the teardown starts.
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				if _, _, err := c.conn.NextReader(); err != nil {
+					_ = c.conn.Close()
+					return
+				}
+			}
+		}()
+	}
+	return conns, func() bool {
+		done := make(chan struct{})
+		go func() { wg.Wait(); close(done) }()
+		select {
+		case <-done:
+			return true
+		case <-time.After(testWait):
+			return false
+		}
+	}
+}
+
+// TestBusyRoomTeardown empties rooms while their members are still
+// sending, to catch sends on closed channels and races under -race.
+func TestBusyRoomTeardown(t *testing.T) {
+	s, ts := startServer(t, nil)
+	for round := range 5 {
+		pin := fmt.Sprint(1000 + round)
+		conns, wait := chatter(t, ts, pin, 4)
+		time.Sleep(20 * time.Millisecond)
+		for _, c := range conns {
+			_ = c.conn.Close()
+		}
+		if !wait() {
+			t.Fatalf("round %d: connections still open after teardown", round)
+		}
+		deadline := time.Now().Add(testWait)
+		for s.manager.lookup(pin) != nil {
+			if time.Now().After(deadline) {
+				t.Fatalf("round %d: room still open", round)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// joinBurst joins n members to pin at once and returns how many were
+// welcomed, closing each connection unless keep is set.
+func joinBurst(tb testing.TB, ts *httptest.Server, pin string, n int, keep bool) (int, []error) {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		welcome int
+		errs    []error
+	)
+	start := make(chan struct{})
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q := url.Values{"pin": {pin}, "name": {fmt.Sprintf("member%d", i)}}
+			<-start
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?"+q.Encode(), nil)
+			if err == nil {
+				if keep {
+					tb.Cleanup(func() { conn.Close() })
+				} else {
+					defer conn.Close()
+				}
+				c := &testConn{t: tb, conn: conn}
+				var f map[string]any
+				if f, err = c.next(testWait); err == nil && f["type"] != "system" {
+					err = fmt.Errorf("first frame %v, want the welcome", f["type"])
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			welcome++
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return welcome, errs
+}
+
+func TestJoinBurst(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+	}{
+		{name: "one", n: 1},
+		{name: "some", n: 25},
+		{name: "many", n: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := startServer(t, nil)
+			welcome, errs := joinBurst(t, ts, "1234", tt.n, true)
+			if welcome != tt.n {
+				t.Fatalf("%d of %d joins welcomed; errors: %v", welcome, tt.n, errs)
+			}
+		})
+	}
+}
+
+func TestEnqueueBackpressure(t *testing.T) {
+	s, _ := startServer(t, nil)
+	tests := []struct {
+		name    string
+		waiting int
+		closed  bool
+		want    error
+	}{
+		{name: "empty queue", waiting: 0, want: nil},
+		{name: "one slot left", waiting: registerQueueSize - 1, want: nil},
+		{name: "full queue", waiting: registerQueueSize, want: errRoomBusy},
+		{name: "stopped hub", waiting: 0, closed: true, want: errHubStopped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The hub is never run, so whatever is queued stays queued.
+			h := newHub("1234", s.manager)
+			for range tt.waiting {
+				if err := h.enqueue(&Client{}); err != nil {
+					t.Fatalf("filling the queue: %v", err)
+				}
+			}
+			h.closed = tt.closed
+			if err := h.enqueue(&Client{}); err != tt.want {
+				t.Errorf("enqueue = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkJoinBurst measures how long a room takes to welcome a burst of
+// simultaneous joins through the register queue.
+func BenchmarkJoinBurst(b *testing.B) {
+	for _, n := range []int{10, 100} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			_, ts := startServer(b, nil)
+			for i := 0; b.Loop(); i++ {
+				if welcome, errs := joinBurst(b, ts, fmt.Sprint(1000+i), n, false); welcome != n {
+					b.Fatalf("%d of %d joins welcomed; errors: %v", welcome, n, errs)
+				}
+			}
+			b.ReportMetric(float64(b.N*n)/b.Elapsed().Seconds(), "joins/s")
+		})
+	}
+}
+
+// BenchmarkEnqueueFull measures turning a join away from a full queue,
+// the cost a burst pays once a room falls behind.
+func BenchmarkEnqueueFull(b *testing.B) {
+	s, _ := startServer(b, nil)
+	h := newHub("1234", s.manager)
+	for range registerQueueSize {
+		h.enqueue(&Client{})
+	}
+	c := &Client{}
+	for b.Loop() {
+		if h.enqueue(c) != errRoomBusy {
+			b.Fatal("enqueue took a join past the bound")
+		}
+	}
+}
+
+func TestLeaveIsClean(t *testing.T) {
+	tests := []struct {
+		name       string
+		hardClose  bool
+		wantReason string // in amy's "left" event
+	}{
+		{name: "leave message", wantReason: leaveClient},
+		{name: "hard close", hardClose: true, wantReason: leaveDisconnected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := startServer(t, nil)
+			amy := dial(t, ts, "1234", "amy", nil)
+			amy.expect("system")
+			bob := dial(t, ts, "1234", "bob", nil)
+			bob.expect("system")
+
+			if tt.hardClose {
+				bob.conn.Close()
+			} else {
+				bob.send(map[string]any{"type": "leave"})
+				var ce *websocket.CloseError
+				for {
+					_, err := bob.next(testWait)
+					if err != nil {
+						if !errors.As(err, &ce) {
+							t.Fatalf("bob's read ended with %v, want a close frame", err)
+						}
+						break
+					}
+				}
+				if ce.Code != websocket.CloseNormalClosure || ce.Text != leaveClient {
+					t.Errorf("bob's close = %d %q, want %d %q", ce.Code, ce.Text, websocket.CloseNormalClosure, leaveClient)
+				}
+			}
+
+			if reason := amy.expect("left")["reason"]; reason != tt.wantReason {
+				t.Errorf("amy saw bob leave with %v, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
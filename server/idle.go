@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// maxSweepInterval bounds how late an idle room may outlive its TTL.
+const maxSweepInterval = 30 * time.Second
+
+// vacated is called by run whenever the room has nobody in it. It reports
+// whether run should stop now. A persistent room is kept indefinitely;
+// with an idle TTL any other room is kept, with its history, bans and
+// settings, until the sweeper reaps it.
+func (h *Hub) vacated(now time.Time) bool {
+	if h.persistent {
+		return false
+	}
+	if h.manager.roomTTL <= 0 {
+		return true
+	}
+	if h.idleSince.Load() == 0 {
+		h.idleSince.Store(now.UnixNano())
+	}
+	return false
+}
+
+// expired reports whether run should stop for a sweep at now: the room is
+// still empty, nobody is waiting to join and the TTL has passed. Only run
+// may call it.
+func (h *Hub) expired(now time.Time) bool {
+	since := h.idleSince.Load()
+	return len(h.clients) == 0 && len(h.register) == 0 && since != 0 &&
+		now.Sub(time.Unix(0, since)) >= h.manager.roomTTL
+}
+
+// sweepIdle periodically asks rooms that have been empty longer than the
+// idle TTL to shut down. Each room makes the final decision itself, so a
+// join racing the sweep keeps the room alive.
+func (m *HubManager) sweepIdle(ctx context.Context) {
+	if m.roomTTL <= 0 {
+		return
+	}
+	interval := min(m.roomTTL, maxSweepInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, h := range m.hubList() {
+				since := h.idleSince.Load()
+				if since == 0 || now.Sub(time.Unix(0, since)) < m.roomTTL {
+					continue
+				}
+				select {
+				case h.sweep <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
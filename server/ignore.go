@@ -0,0 +1,36 @@
+package server
+
+import "strings"
+
+// maxIgnored bounds each client's personal ignore list.
+const maxIgnored = 100
+
+// updateIgnore handles ignore/unignore, which only affect what the
+// requesting client receives. Only run may call it.
+func (h *Hub) updateIgnore(msg *Message) {
+	c := msg.from
+	name := strings.ToLower(strings.TrimSpace(msg.User))
+	if name == "" {
+		c.trySend(errorFrame(codeInvalidUser, "user required"))
+		return
+	}
+	switch msg.Type {
+	case "ignore":
+		if c.ignored == nil {
+			c.ignored = make(map[string]bool)
+		}
+		if len(c.ignored) >= maxIgnored && !c.ignored[name] {
+			c.trySend(errorFrame(codeTooManyIgnored, "ignore list is full"))
+			return
+		}
+		c.ignored[name] = true
+	case "unignore":
+		delete(c.ignored, name)
+	}
+	c.trySend(h.frame(&Message{Type: msg.Type + "d", User: msg.User}))
+}
+
+// ignores reports whether c has muted sender for itself.
+func (c *Client) ignores(sender string) bool {
+	return sender != "" && c.ignored[strings.ToLower(sender)]
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIgnoreHidesChat(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []map[string]any // what amy sends before bob talks
+		wantSeen bool
+	}{
+		{name: "not ignored", wantSeen: true},
+		{name: "ignored", requests: []map[string]any{{"type": "ignore", "user": "bob"}}, wantSeen: false},
+		{name: "ignored in other case", requests: []map[string]any{{"type": "ignore", "user": "BOB"}}, wantSeen: false},
+		{name: "unignored", requests: []map[string]any{{"type": "ignore", "user": "bob"}, {"type": "unignore", "user": "bob"}}, wantSeen: true},
+		{name: "someone else ignored", requests: []map[string]any{{"type": "ignore", "user": "dave"}}, wantSeen: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := startServer(t, nil)
+			amy := dial(t, ts, "1234", "amy", nil)
+			amy.expect("system")
+			bob := dial(t, ts, "1234", "bob", nil)
+			bob.expect("system")
+			carol := dial(t, ts, "1234", "carol", nil)
+			carol.expect("system")
+			for _, req := range tt.requests {
+				amy.send(req)
+				amy.expect(req["type"].(string) + "d")
+			}
+
+			bob.send(map[string]any{"type": "typing"})
+			bob.send(map[string]any{"type": "chat", "msg": "hello from bob"})
+			carol.expectMsg("chat", "hello from bob")
+			carol.send(map[string]any{"type": "chat", "msg": "sync"})
+
+			var seen []string
+			for _, f := range amy.until("chat", "sync") {
+				if f["user"] == "bob" && (f["type"] == "chat" || f["type"] == "typing") {
+					seen = append(seen, f["type"].(string))
+				}
+			}
+			if tt.wantSeen && len(seen) != 2 {
+				t.Errorf("amy saw %v from bob, want his typing and chat", seen)
+			}
+			if !tt.wantSeen && len(seen) != 0 {
+				t.Errorf("amy saw %v from bob, want nothing", seen)
+			}
+		})
+	}
+}
+
+func TestIgnoreHidesPresence(t *testing.T) {
+	tests := []struct {
+		name   string
+		ignore string
+		want   []string
+	}{
+		{name: "not ignored", want: []string{"joined", "left"}},
+		{name: "ignored", ignore: "carol", want: nil},
+		{name: "ignored in other case", ignore: "CAROL", want: nil},
+		{name: "someone else ignored", ignore: "dave", want: []string{"joined", "left"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := startServer(t, nil)
+			amy := dial(t, ts, "1234", "amy", nil)
+			amy.expect("system")
+			if tt.ignore != "" {
+				amy.send(map[string]any{"type": "ignore", "user": tt.ignore})
+				amy.expect("ignored")
+			}
+
+			carol := dial(t, ts, "1234", "carol", nil)
+			carol.expect("system")
+			carol.send(map[string]any{"type": "leave"})
+
+			var got []string
+			for _, f := range amy.frames(500 * time.Millisecond) {
+				if typ := f["type"]; (typ == "joined" || typ == "left") && f["user"] == "carol" {
+					got = append(got, typ.(string))
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("amy saw %v about carol, want %v", got, tt.want)
+			}
+		})
+	}
+}
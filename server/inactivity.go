@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// idleCheckInterval is how often a room looks for members who have gone
+// quiet, and so how late a warning or disconnect may come.
+const idleCheckInterval = 10 * time.Second
+
+// IdleLimits disconnect members who stop chatting, however healthy their
+// connection, so rooms are not left full of forgotten tabs.
+type IdleLimits struct {
+	// Timeout is how long a member may go without sending chat, a dm or
+	// a file; zero disables the check.
+	Timeout time.Duration
+	// Warning is how long before the disconnect the member is told.
+	Warning time.Duration
+}
+
+// touch records that c just sent something. Only run may call it.
+func (c *Client) touch(now time.Time) {
+	c.active, c.idleWarned = now, false
+}
+
+// checkIdle warns members who have been quiet for Timeout less Warning
+// and disconnects those quiet for Timeout. Spectators cannot chat, so
+// they are left alone. Only run may call it.
+func (h *Hub) checkIdle(now time.Time) {
+	l := h.manager.idle
+	for c := range h.clients {
+		if c.spectator {
+			continue
+		}
+		quiet := now.Sub(c.active)
+		switch {
+		case quiet >= l.Timeout:
+			h.expel(c, closeIdle, codeIdle, fmt.Sprintf("disconnected after %v without a message", l.Timeout))
+		case quiet >= l.Timeout-l.Warning && !c.idleWarned:
+			c.idleWarned = true
+			left := (l.Timeout - quiet).Round(time.Second)
+			h.sendTo(c, h.frame(&Message{
+				Type:         "idle_warning",
+				Room:         h.pin,
+				Msg:          fmt.Sprintf("you will be disconnected in %v unless you send a message", left),
+				DisconnectIn: retryAfter(left),
+			}))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// TestCheckIdle steps a never-run hub's clock through one member's warning,
+// a message that resets it, a second warning and the disconnect.
+func TestCheckIdle(t *testing.T) {
+	s, _ := startServer(t, func(cfg *Config) {
+		cfg.IdleLimits = IdleLimits{Timeout: 5 * time.Minute, Warning: time.Minute}
+	})
+	h := newHub("1234", s.manager)
+	t0 := time.Unix(1700000000, 0)
+	member := func(name string) *Client {
+		c := &Client{name: name, send: newSendQueue(s.manager.wire.sendQueueSize, overflowDisconnect), done: make(chan struct{}), log: slog.Default(), active: t0}
+		h.clients[c] = true
+		return c
+	}
+	amy, watcher := member("amy"), member("watcher")
+	watcher.spectator = true
+
+	// frames returns the types of the frames queued for c since last asked,
+	// and the last one decoded.
+	frames := func(c *Client) ([]string, map[string]any) {
+		var types []string
+		var last map[string]any
+		_ = c.send.drain(func(f []byte) error {
+			last = nil
+			if err := json.Unmarshal(f, &last); err != nil {
+				t.Fatal(err)
+			}
+			types = append(types, last["type"].(string))
+			return nil
+		})
+		return types, last
+	}
+
+	steps := []struct {
+		at    time.Duration
+		touch bool
+		want  string // the one frame amy should get; "" for none
+	}{
+		{at: 4*time.Minute - time.Second},
+		{at: 4 * time.Minute, want: "idle_warning"},
+		{at: 4*time.Minute + 30*time.Second},
+		{at: 4*time.Minute + 40*time.Second, touch: true},
+		{at: 8*time.Minute + 39*time.Second},
+		{at: 8*time.Minute + 40*time.Second, want: "idle_warning"},
+		{at: 9*time.Minute + 39*time.Second},
+		{at: 9*time.Minute + 40*time.Second, want: "error"},
+	}
+	for _, step := range steps {
+		now := t0.Add(step.at)
+		if step.touch {
+			amy.touch(now)
+		}
+		h.checkIdle(now)
+		types, last := frames(amy)
+		if step.want == "" {
+			if len(types) != 0 {
+				t.Fatalf("at %v amy got %v, want nothing", step.at, types)
+			}
+			continue
+		}
+		if len(types) != 1 || types[0] != step.want {
+			t.Fatalf("at %v amy got %v, want one %s", step.at, types, step.want)
+		}
+		switch step.want {
+		case "idle_warning":
+			if got := last["disconnect_in"]; got != float64(60) {
+				t.Errorf("at %v disconnect_in = %v, want 60", step.at, got)
+			}
+		case "error":
+			if got := last["code"]; got != codeIdle {
+				t.Errorf("at %v error code = %v, want %s", step.at, got, codeIdle)
+			}
+		}
+	}
+
+	if h.clients[amy] || amy.closeCode != closeIdle {
+		t.Errorf("amy still in the room = %v, close code %d; want gone with %d", h.clients[amy], amy.closeCode, closeIdle)
+	}
+	select {
+	case <-amy.done:
+	default:
+		t.Error("amy's connection was not told to close")
+	}
+	if !h.clients[watcher] {
+		t.Error("the spectator was disconnected")
+	}
+	types, _ := frames(watcher)
+	for _, typ := range types {
+		if typ != "left" {
+			t.Errorf("the spectator got %v, want only amy leaving", types)
+			break
+		}
+	}
+}
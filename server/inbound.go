@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// inboundMaxBytes bounds a POST /api/rooms/{pin}/messages body.
+const inboundMaxBytes = 16 * 1024
+
+// defaultInboundName is the sender shown for messages posted with the
+// admin token and no user.
+const defaultInboundName = "api"
+
+// handlePostMessage serves POST /api/rooms/{pin}/messages, letting external
+// systems post chat to a live room without a connection. The caller
+// authenticates with "Authorization: Bearer" and either the admin token,
+// which may pick any sender name, or a JWT, whose name is used.
+func handlePostMessage(manager *HubManager, adminToken string, w http.ResponseWriter, r *http.Request) {
+	var (
+		admin  = isAdmin(adminToken, r)
+		claims *Claims
+	)
+	if !admin && manager.tokens != nil {
+		claims, _ = manager.tokens.verify(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), time.Now())
+	}
+	if !admin && claims == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	hub := manager.lookup(r.PathValue("pin"))
+	if hub == nil {
+		writeError(w, http.StatusNotFound, codeRoomNotFound, "room not found")
+		return
+	}
+
+	var msg Message
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, inboundMaxBytes)).Decode(&msg); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body")
+		return
+	}
+	user := msg.User
+	msg = Message{Type: "chat", Msg: msg.Msg, ContentType: msg.ContentType, Channel: msg.Channel, ParentID: msg.ParentID}
+	if pe := validateChat(&msg); pe != nil {
+		writeError(w, http.StatusBadRequest, pe.code, pe.detail)
+		return
+	}
+	switch {
+	case claims != nil && claims.Name != "":
+		user = claims.Name
+	case claims != nil:
+		user = claims.Subject
+	case user == "":
+		user = defaultInboundName
+	}
+	name, err := validateName(user)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	msg.User, msg.Bot = name, true
+
+	if !hub.publish(&msg) {
+		writeError(w, http.StatusGone, codeRoomClosed, "room closed")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
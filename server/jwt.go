@@ -0,0 +1,160 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// minJWTSecretLen is the shortest JWT_SECRET accepted at boot.
+const minJWTSecretLen = 32
+
+// tokenSubprotocol is the WebSocket subprotocol a browser offers alongside
+// "bearer.<jwt>", since it cannot set an Authorization header on upgrade.
+const (
+	tokenSubprotocol  = "gochat"
+	bearerProtoPrefix = "bearer."
+)
+
+var (
+	errTokenMalformed = errors.New("malformed token")
+	errTokenSignature = errors.New("bad token signature")
+	errTokenExpired   = errors.New("token expired")
+)
+
+// Claims are the JWT claims the server issues and accepts.
+type Claims struct {
+	Subject   string `json:"sub"`
+	Name      string `json:"name,omitempty"`
+	Avatar    string `json:"avatar,omitempty"`
+	Issuer    string `json:"iss"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// tokenIssuer signs and verifies HS256 JWTs.
+type tokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+const jwtIssuer = "gochat"
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func (t *tokenIssuer) sign(sub, name, avatar string, now time.Time) (string, Claims, error) {
+	c := Claims{Subject: sub, Name: name, Avatar: avatar, Issuer: jwtIssuer, IssuedAt: now.Unix(), ExpiresAt: now.Add(t.ttl).Unix()}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", c, err
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + t.mac(unsigned), c, nil
+}
+
+func (t *tokenIssuer) mac(unsigned string) string {
+	h := hmac.New(sha256.New, t.secret)
+	h.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// verify checks the signature, algorithm, issuer and expiry of token.
+func (t *tokenIssuer) verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errTokenMalformed
+	}
+	if !hmac.Equal([]byte(t.mac(parts[0]+"."+parts[1])), []byte(parts[2])) {
+		return nil, errTokenSignature
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(h, &header) != nil || header.Alg != "HS256" {
+		return nil, errTokenMalformed
+	}
+	p, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+	var c Claims
+	if err := json.Unmarshal(p, &c); err != nil || c.Subject == "" || c.Issuer != jwtIssuer {
+		return nil, errTokenMalformed
+	}
+	if now.Unix() >= c.ExpiresAt {
+		return nil, errTokenExpired
+	}
+	return &c, nil
+}
+
+// requestToken returns the JWT offered on an upgrade request, from the
+// token query parameter or a "bearer.<jwt>" subprotocol.
+func requestToken(r *http.Request) string {
+	if t := r.URL.Query().Get("token"); t != "" {
+		return t
+	}
+	for _, p := range websocketProtocols(r) {
+		if t, ok := strings.CutPrefix(p, bearerProtoPrefix); ok {
+			return t
+		}
+	}
+	return ""
+}
+
+func websocketProtocols(r *http.Request) []string {
+	var out []string
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
+
+// handleIssueToken serves POST /api/token with
+// {"sub":"...","name":"...","avatar":"..."}.
+// It is called by a trusted backend holding the admin token, which mints
+// tokens for users it has already authenticated.
+func handleIssueToken(issuer *tokenIssuer, w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Sub    string `json:"sub"`
+		Name   string `json:"name"`
+		Avatar string `json:"avatar"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body")
+		return
+	}
+	if body.Sub == "" || len(body.Sub) > 128 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "sub must be 1 to 128 characters")
+		return
+	}
+	if body.Name != "" {
+		name, err := validateName(body.Name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		body.Name = name
+	}
+	if err := validateAvatar(body.Avatar); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	token, claims, err := issuer.sign(body.Sub, body.Name, body.Avatar, time.Now())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not sign token")
+		return
+	}
+	slog.Info("issued token", "sub", body.Sub)
+	writeJSON(w, http.StatusOK, map[string]any{"token": token, "expires_at": claims.ExpiresAt})
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyToken(t *testing.T) {
+	issuer := &tokenIssuer{secret: []byte(strings.Repeat("s", minJWTSecretLen)), ttl: time.Hour}
+	other := &tokenIssuer{secret: []byte(strings.Repeat("o", minJWTSecretLen)), ttl: time.Hour}
+	now := time.Unix(1_700_000_000, 0)
+
+	valid, _, err := issuer.sign("user:amy", "amy", "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// forge signs any header and claims with signer's secret, or leaves
+	// the signature empty when signer is nil.
+	forge := func(signer *tokenIssuer, header string, c Claims) string {
+		payload, _ := json.Marshal(c)
+		unsigned := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		if signer == nil {
+			return unsigned + "."
+		}
+		return unsigned + "." + signer.mac(unsigned)
+	}
+	claims := Claims{Subject: "user:amy", Issuer: jwtIssuer, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}
+	parts := strings.Split(valid, ".")
+	tampered, _ := json.Marshal(Claims{Subject: "user:admin", Issuer: jwtIssuer, ExpiresAt: now.Add(time.Hour).Unix()})
+
+	tests := []struct {
+		name  string
+		token string
+		at    time.Time
+		want  error
+	}{
+		{name: "valid", token: valid, at: now},
+		{name: "other secret", token: forge(other, `{"alg":"HS256","typ":"JWT"}`, claims), at: now, want: errTokenSignature},
+		{name: "tampered claims", token: parts[0] + "." + base64.RawURLEncoding.EncodeToString(tampered) + "." + parts[2], at: now, want: errTokenSignature},
+		{name: "no signature", token: parts[0] + "." + parts[1] + ".", at: now, want: errTokenSignature},
+		{name: "alg none", token: forge(nil, `{"alg":"none","typ":"JWT"}`, claims), at: now, want: errTokenSignature},
+		{name: "alg none with a valid mac", token: forge(issuer, `{"alg":"none","typ":"JWT"}`, claims), at: now, want: errTokenMalformed},
+		{name: "wrong alg", token: forge(issuer, `{"alg":"HS512","typ":"JWT"}`, claims), at: now, want: errTokenMalformed},
+		{name: "wrong issuer", token: forge(issuer, `{"alg":"HS256","typ":"JWT"}`, Claims{Subject: "user:amy", Issuer: "other", ExpiresAt: claims.ExpiresAt}), at: now, want: errTokenMalformed},
+		{name: "no subject", token: forge(issuer, `{"alg":"HS256","typ":"JWT"}`, Claims{Issuer: jwtIssuer, ExpiresAt: claims.ExpiresAt}), at: now, want: errTokenMalformed},
+		{name: "two parts", token: parts[0] + "." + parts[1], at: now, want: errTokenMalformed},
+		{name: "just before expiry", token: valid, at: now.Add(time.Hour - time.Second)},
+		{name: "at expiry", token: valid, at: now.Add(time.Hour), want: errTokenExpired},
+		{name: "expired", token: valid, at: now.Add(2 * time.Hour), want: errTokenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := issuer.verify(tt.token, tt.at)
+			if err != tt.want {
+				t.Fatalf("verify error = %v, want %v", err, tt.want)
+			}
+			if err == nil && c.Subject != "user:amy" {
+				t.Errorf("subject = %q, want user:amy", c.Subject)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	previewWorkers   = 4
+	previewQueueSize = 256
+	previewTimeout   = 5 * time.Second
+	// previewMaxBytes bounds how much of a page is read; OpenGraph tags
+	// live in the head.
+	previewMaxBytes     = 512 * 1024
+	previewMaxRedirects = 3
+	// Fetched previews, and failures, are cached by URL for
+	// previewCacheTTL, up to previewCacheSize of them.
+	previewCacheSize = 1024
+	previewCacheTTL  = time.Hour
+	maxPreviewField  = 300
+	maxPreviewURL    = 2048
+)
+
+var (
+	previewLinkPattern = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+	metaTagPattern     = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrPattern        = regexp.MustCompile(`(?is)([a-z:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	titlePattern       = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// errPrivateAddress refuses a request, such as a preview fetch or a push,
+// aimed at the server's own network.
+var errPrivateAddress = errors.New("address is not public")
+
+// blockedPrefixes are non-public ranges netip has no predicate for.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// LinkPreview is the OpenGraph summary of the first link in a chat
+// message.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// firstLink returns the first http(s) URL in text, without trailing
+// punctuation, or "".
+func firstLink(text string) string {
+	link := strings.TrimRight(previewLinkPattern.FindString(text), ".,;:!?)]}")
+	if len(link) > maxPreviewURL {
+		return ""
+	}
+	return link
+}
+
+// unfurlJob asks for a preview of link, the first in message id.
+type unfurlJob struct {
+	hub     *Hub
+	id      string
+	channel string
+	link    string
+}
+
+type cachedPreview struct {
+	preview *LinkPreview // nil if the fetch failed
+	at      time.Time
+}
+
+// unfurler fetches link previews on a few worker goroutines and hands
+// them to the room that asked. Fetches only reach public addresses on
+// ports 80 and 443, so a link cannot make the server probe its own
+// network.
+type unfurler struct {
+	client *http.Client
+	queue  chan unfurlJob
+
+	mu    sync.Mutex
+	cache map[string]cachedPreview
+}
+
+func newUnfurler() *unfurler {
+	return &unfurler{
+		client: publicClient(previewTimeout, previewMaxRedirects),
+		queue:  make(chan unfurlJob, previewQueueSize),
+		cache:  make(map[string]cachedPreview),
+	}
+}
+
+// publicClient returns a client for requests whose URL a user chose. It
+// dials only public addresses on ports 80 and 443 and follows at most
+// maxRedirects redirects, each to an http or https URL.
+func publicClient(timeout time.Duration, maxRedirects int) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: publicOnly}
+	return &http.Client{
+		Timeout: timeout,
+		// No proxy: it would dial on the request's behalf, past
+		// publicOnly.
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          16,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("redirect to a non-http URL")
+			}
+			return nil
+		},
+	}
+}
+
+// publicOnly is the dialer's Control hook. It runs after DNS resolution,
+// on the address actually dialled, so a name that resolves to a private
+// address is refused too.
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if port != "80" && port != "443" {
+		return fmt.Errorf("port %s is not allowed", port)
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(ip.Unmap()) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+func publicAddr(ip netip.Addr) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// enqueue queues a preview of msg's first link, if it has one, without
+// blocking. Only run may call it.
+func (u *unfurler) enqueue(h *Hub, msg *Message, channel string) {
+	if u == nil || msg.opaque() || msg.ContentType == contentImageURL {
+		return
+	}
+	link := firstLink(msg.Msg)
+	if link == "" {
+		return
+	}
+	select {
+	case u.queue <- unfurlJob{hub: h, id: msg.ID, channel: channel, link: link}:
+	default:
+		h.log.Warn("link preview queue full, skipping", "url", link)
+	}
+}
+
+// run starts the workers, which stop when ctx is done.
+func (u *unfurler) run(ctx context.Context) {
+	for range previewWorkers {
+		go func() {
+			for {
+				select {
+				case job := <-u.queue:
+					if p := u.preview(ctx, job.link); p != nil {
+						job.hub.publish(&Message{Type: "link_preview", ID: job.id, Channel: job.channel, Preview: p})
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// preview returns link's preview from the cache or the network, or nil if
+// the page has none or cannot be fetched.
+func (u *unfurler) preview(ctx context.Context, link string) *LinkPreview {
+	now := time.Now()
+	u.mu.Lock()
+	c, ok := u.cache[link]
+	u.mu.Unlock()
+	if ok && now.Sub(c.at) < previewCacheTTL {
+		return c.preview
+	}
+	p, err := u.fetch(ctx, link)
+	if err != nil {
+		slog.Debug("link preview failed", "url", link, "err", err)
+	}
+	u.mu.Lock()
+	if len(u.cache) >= previewCacheSize {
+		for k, c := range u.cache {
+			if now.Sub(c.at) >= previewCacheTTL {
+				delete(u.cache, k)
+			}
+		}
+		// Still full of fresh entries: start afresh.
+		if len(u.cache) >= previewCacheSize {
+			u.cache = make(map[string]cachedPreview)
+		}
+	}
+	u.cache[link] = cachedPreview{preview: p, at: now}
+	u.mu.Unlock()
+	return p
+}
+
+// fetch reads link's OpenGraph tags, falling back to its <title> and
+// description meta tag.
+func (u *unfurler) fetch(ctx context.Context, link string) (*LinkPreview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "GoChat-LinkPreview")
+	req.Header.Set("Accept", "text/html")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "text/html" {
+		return nil, fmt.Errorf("not a page: %s", mt)
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, previewMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	p := parsePreview(string(page), resp.Request.URL)
+	if p.Title == "" && p.Description == "" {
+		return nil, nil
+	}
+	p.URL = link
+	return p, nil
+}
+
+// parsePreview extracts a preview from page, resolving its image against
+// base, the URL the page was served from.
+func parsePreview(page string, base *url.URL) *LinkPreview {
+	meta := make(map[string]string)
+	for _, tag := range metaTagPattern.FindAllString(page, -1) {
+		var key, content string
+		for _, a := range attrPattern.FindAllStringSubmatch(tag, -1) {
+			value := a[2] + a[3]
+			switch strings.ToLower(a[1]) {
+			case "property", "name":
+				key = strings.ToLower(value)
+			case "content":
+				content = value
+			}
+		}
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = previewText(content)
+		}
+	}
+	p := &LinkPreview{
+		Title:       meta["og:title"],
+		Description: meta["og:description"],
+		SiteName:    meta["og:site_name"],
+	}
+	if p.Title == "" {
+		if m := titlePattern.FindStringSubmatch(page); m != nil {
+			p.Title = previewText(m[1])
+		}
+	}
+	if p.Description == "" {
+		p.Description = meta["description"]
+	}
+	if img, err := base.Parse(html.UnescapeString(meta["og:image"])); err == nil && meta["og:image"] != "" &&
+		(img.Scheme == "https" || img.Scheme == "http") && len(img.String()) <= maxPreviewURL {
+		p.Image = img.String()
+	}
+	return p
+}
+
+// previewText unescapes s, folds its whitespace and bounds its length.
+func previewText(s string) string {
+	s = strings.Join(strings.Fields(stripControl(html.UnescapeString(s))), " ")
+	if utf8.RuneCountInString(s) > maxPreviewField {
+		s = string([]rune(s)[:maxPreviewField-1]) + "…"
+	}
+	return s
+}
+
+// attachPreview stamps a fetched preview into its message, in history,
+// pins, mailboxes and the store, and tells the message's channel. A
+// preview for a link the message no longer has, after an edit, is
+// dropped. Only run may call it.
+func (h *Hub) attachPreview(msg *Message) {
+	found := false
+	for i, e := range h.history {
+		if e.id != msg.ID {
+			continue
+		}
+		var orig Message
+		if err := json.Unmarshal(e.frame, &orig); err != nil || firstLink(orig.Msg) != msg.Preview.URL {
+			return
+		}
+		orig.Preview = msg.Preview
+		frame := h.frame(&orig)
+		h.history[i].frame = frame
+		h.replaceFrame(msg.ID, frame)
+		if h.features[featureHistory] {
+			h.manager.persist.update(StoredMessage{Room: h.pin, ID: msg.ID, Frame: frame})
+		}
+		found = true
+		break
+	}
+	// Without history there is nothing to check against; with it, a
+	// message that is gone was deleted.
+	if !found && h.features[featureHistory] {
+		return
+	}
+	h.fanOutFrom(msg.Channel, "", nil, h.frame(&Message{Type: "link_preview", ID: msg.ID, Room: h.pin, Channel: msg.Channel, Preview: msg.Preview}))
+}
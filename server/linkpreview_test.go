@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPublicOnly(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr error // nil with ok false: any error will do
+		ok      bool
+	}{
+		{addr: "93.184.216.34:443", ok: true},
+		{addr: "93.184.216.34:80", ok: true},
+		{addr: "[2606:4700::1111]:443", ok: true},
+		{addr: "93.184.216.34:8080"},
+		{addr: "93.184.216.34:22"},
+		{addr: "127.0.0.1:80", wantErr: errPrivateAddress},
+		{addr: "127.8.9.10:443", wantErr: errPrivateAddress},
+		{addr: "[::1]:443", wantErr: errPrivateAddress},
+		{addr: "0.0.0.0:80", wantErr: errPrivateAddress},
+		{addr: "[::]:80", wantErr: errPrivateAddress},
+		{addr: "10.0.0.1:80", wantErr: errPrivateAddress},
+		{addr: "172.16.5.4:443", wantErr: errPrivateAddress},
+		{addr: "192.168.1.1:80", wantErr: errPrivateAddress},
+		{addr: "100.64.0.1:80", wantErr: errPrivateAddress},
+		{addr: "[fd00::1]:443", wantErr: errPrivateAddress},
+		{addr: "169.254.169.254:80", wantErr: errPrivateAddress},
+		{addr: "[fe80::1]:80", wantErr: errPrivateAddress},
+		{addr: "[::ffff:127.0.0.1]:80", wantErr: errPrivateAddress},
+		{addr: "[::ffff:10.0.0.1]:443", wantErr: errPrivateAddress},
+		{addr: "[::ffff:169.254.169.254]:80", wantErr: errPrivateAddress},
+		{addr: "[64:ff9b::7f00:1]:80", wantErr: errPrivateAddress},
+		{addr: "224.0.0.1:80", wantErr: errPrivateAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			err := publicOnly("tcp", tt.addr, nil)
+			switch {
+			case tt.ok && err != nil:
+				t.Errorf("publicOnly = %v, want allowed", err)
+			case !tt.ok && err == nil:
+				t.Error("publicOnly allowed it")
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("publicOnly = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestPreviewRedirectToPrivate lets the first hop reach a local page
+// standing in for a public site, then checks that its redirects to the
+// server's own network are refused when dialled.
+func TestPreviewRedirectToPrivate(t *testing.T) {
+	var landed atomic.Bool
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if to := r.URL.Query().Get("to"); to != "" {
+			http.Redirect(w, r, to, http.StatusFound)
+			return
+		}
+		landed.Store(true)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<title>inside</title>"))
+	}))
+	defer page.Close()
+
+	u := newUnfurler()
+	transport := u.client.Transport.(*http.Transport).Clone()
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "public.example:80" {
+			return (&net.Dialer{}).DialContext(ctx, network, page.Listener.Addr().String())
+		}
+		return dial(ctx, network, addr)
+	}
+	u.client.Transport = transport
+
+	tests := []struct {
+		name string
+		to   string
+	}{
+		{name: "loopback", to: "http://127.0.0.1/"},
+		{name: "localhost", to: "http://localhost/"},
+		{name: "private", to: "http://10.0.0.1/"},
+		{name: "metadata service", to: "http://169.254.169.254/latest/meta-data/"},
+		{name: "ipv4-mapped", to: "http://[::ffff:127.0.0.1]/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := u.fetch(context.Background(), "http://public.example/?to="+tt.to)
+			if !errors.Is(err, errPrivateAddress) {
+				t.Errorf("fetch = %v, %v; want %v", p, err, errPrivateAddress)
+			}
+		})
+	}
+
+	// The stand-in itself is reachable, so the refusals above came from
+	// the redirect targets.
+	if p, err := u.fetch(context.Background(), "http://public.example/"); err != nil || p == nil || p.Title != "inside" {
+		t.Fatalf("fetch = %v, %v; want the page", p, err)
+	}
+	if !landed.Load() {
+		t.Error("the stand-in page was never served")
+	}
+}
@@ -0,0 +1,15 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// parseLogLevel accepts debug, info, warn or error.
+func parseLogLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown level %q", s)
+	}
+	return l, nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"strings"
+	"time"
+)
+
+// maxMailboxSize bounds how many missed messages are kept per user.
+const maxMailboxSize = 500
+
+// mailbox collects the chat a disconnected user misses.
+type mailbox struct {
+	since  time.Time
+	frames []historyEntry
+}
+
+// identity is how a user is recognised across connections: the verified
+// user id when there is one, otherwise the display name.
+func (c *Client) identity() string {
+	if c.userID != "" {
+		return "id:" + c.userID
+	}
+	return "name:" + strings.ToLower(c.name)
+}
+
+// openMailbox starts collecting for a client that dropped off. Only run
+// may call it.
+func (h *Hub) openMailbox(c *Client, now time.Time) {
+	if h.manager.mailboxWindow <= 0 || c.spectator || h.ephemeral {
+		return
+	}
+	if h.mailboxes == nil {
+		h.mailboxes = make(map[string]*mailbox)
+	}
+	h.mailboxes[c.identity()] = &mailbox{since: now}
+}
+
+// deliverOffline adds a chat frame to every open mailbox. Only run may
+// call it.
+func (h *Hub) deliverOffline(id, channel string, frame []byte, at time.Time) {
+	for _, mb := range h.mailboxes {
+		if len(mb.frames) < maxMailboxSize {
+			mb.frames = append(mb.frames, historyEntry{id: id, channel: channel, at: at, frame: frame})
+		}
+	}
+}
+
+// claimMailbox returns, and forgets, the frames c missed that are not
+// already in replay. Only run may call it.
+func (h *Hub) claimMailbox(c *Client, replay [][]byte, now time.Time) [][]byte {
+	key := c.identity()
+	mb := h.mailboxes[key]
+	if mb == nil {
+		return nil
+	}
+	delete(h.mailboxes, key)
+	if now.Sub(mb.since) > h.manager.mailboxWindow {
+		return nil
+	}
+	// History is always a suffix of the room's chat, so anything it holds
+	// is at the end of the mailbox.
+	inReplay := make(map[string]bool, len(h.history))
+	if len(replay) > 0 {
+		for _, e := range h.history {
+			inReplay[e.id] = true
+		}
+	}
+	var missed [][]byte
+	for _, e := range mb.frames {
+		if !inReplay[e.id] && c.subscribed(e.channel) {
+			missed = append(missed, e.frame)
+		}
+	}
+	if len(missed) > 0 {
+		c.log.Info("delivering missed messages", "count", len(missed))
+	}
+	return missed
+}
+
+// expireMailboxes drops mailboxes whose owners didn't return in time. Only
+// run may call it.
+func (h *Hub) expireMailboxes(now time.Time) {
+	for key, mb := range h.mailboxes {
+		if now.Sub(mb.since) > h.manager.mailboxWindow {
+			delete(h.mailboxes, key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxMentions bounds how many members one message can mention.
+const maxMentions = 20
+
+// mentionedMembers returns the names of the room's members, connected or
+// parked, that msg mentions, sorted. The sender and encrypted messages
+// mention nobody. Only run may call it.
+func (h *Hub) mentionedMembers(msg *Message) []string {
+	if msg.opaque() || !strings.Contains(msg.Msg, "@") {
+		return nil
+	}
+	var names []string
+	add := func(name string) {
+		if len(names) < maxMentions && !strings.EqualFold(name, msg.User) && mentions(msg.Msg, name) {
+			names = append(names, name)
+		}
+	}
+	for c := range h.clients {
+		add(c.name)
+	}
+	for _, p := range h.parked {
+		add(p.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// notifyMentions sends each connected member named in names a mention
+// event pointing at msg, unless they ignore its sender or are not in its
+// channel. Only run may call it.
+func (h *Hub) notifyMentions(msg *Message, names []string, channel string) {
+	if len(names) == 0 {
+		return
+	}
+	frame := h.frame(&Message{Type: "mention", ID: msg.ID, Room: h.pin, User: msg.User, Channel: channel, TS: msg.TS})
+	for c := range h.clients {
+		named := slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, c.name) })
+		if named && c.subscribed(channel) && !c.ignores(msg.User) {
+			h.sendTo(c, frame)
+		}
+	}
+}
+
+// mentions reports whether text mentions name as "@name", ignoring case.
+// The mention must stand alone: "@amy" mentions neither "amyb" nor, in
+// "bob@amy", anyone. A trailing '.' ends a sentence rather than the name.
+func mentions(text, name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] != '@' {
+			continue
+		}
+		if prev, _ := utf8.DecodeLastRuneInString(text[:i]); i > 0 && nameRune(prev) {
+			continue
+		}
+		rest := text[i+1:]
+		if len(rest) < len(name) || !strings.EqualFold(rest[:len(name)], name) {
+			continue
+		}
+		next, size := utf8.DecodeRuneInString(rest[len(name):])
+		if next == '.' {
+			next, _ = utf8.DecodeRuneInString(rest[len(name)+size:])
+		}
+		if next == utf8.RuneError || !nameRune(next) {
+			return true
+		}
+	}
+	return false
+}
+
+// nameRune reports whether r can continue a name in a mention. Spaces and
+// '.' can appear in names too, but not at the end of one.
+func nameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
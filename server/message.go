@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxJSONDepth bounds object/array nesting so pathological payloads are
+// rejected before they reach the decoder.
+const maxJSONDepth = 16
+
+// Limits on chat envelope fields.
+const (
+	maxUserLen = 32
+	maxBodyLen = 4000
+	// maxClientMsgIDLen bounds a sender-chosen client_msg_id.
+	maxClientMsgIDLen = 64
+)
+
+// Message is the envelope every client frame must decode into. For chat
+// messages the server validates it, then stamps ID, Room and TS before the
+// envelope is re-serialised for broadcast.
+type Message struct {
+	Type string `json:"type"`
+	// ID is the server-assigned message id, or the target id for pin,
+	// unpin, edit and delete.
+	ID   string `json:"id,omitempty"`
+	Room string `json:"room,omitempty"`
+	// User is the sender and Msg the body.
+	User string `json:"user,omitempty"`
+	Msg  string `json:"msg,omitempty"`
+	// Avatar is the sender's image URL, absolute or relative to the
+	// server, on chat, dm and file.
+	Avatar string `json:"avatar,omitempty"`
+	// TS is the server receive time, RFC 3339 in UTC.
+	TS string `json:"ts,omitempty"`
+	// Edited is when the message was last edited, in the same format.
+	Edited string `json:"edited,omitempty"`
+
+	// ContentType tells clients how to render Msg (default text/plain), or
+	// is the type of a shared file.
+	ContentType string `json:"contentType,omitempty"`
+
+	// To is the target of a directed message: a client id for dm and
+	// signaling, a URL for migrate.
+	To string `json:"to,omitempty"`
+	// From is the sender's client id on dm, signaling and welcome messages.
+	From string `json:"from,omitempty"`
+	// URL, FileName and Size describe an uploaded file. For an image,
+	// ThumbURL is a small copy to show inline, or URL itself when the image
+	// is small already, and Width and Height are its size in pixels.
+	URL      string `json:"url,omitempty"`
+	ThumbURL string `json:"thumb_url,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	FileName string `json:"filename,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+
+	// MsgID and Emoji name a reaction's target and symbol; Remove takes
+	// the sender's reaction back. Counts is the target's resulting tally.
+	MsgID  string         `json:"msg_id,omitempty"`
+	Emoji  string         `json:"emoji,omitempty"`
+	Remove bool           `json:"remove,omitempty"`
+	Counts map[string]int `json:"counts,omitempty"`
+
+	// Reactions tallies every reacted message by id (welcome).
+	Reactions map[string]map[string]int `json:"reactions,omitempty"`
+
+	// Count is how many members have seen MsgID (read). Reads maps member
+	// names to the last message id each has seen (welcome).
+	Count int               `json:"count,omitempty"`
+	Reads map[string]string `json:"reads,omitempty"`
+
+	// Emote marks a /me action and Announcement one posted with /announce.
+	Emote        bool `json:"emote,omitempty"`
+	Announcement bool `json:"announcement,omitempty"`
+	// Bot marks chat posted by an integration rather than a member.
+	Bot bool `json:"bot,omitempty"`
+	// DisconnectIn is how many seconds an idle_warning's member has left
+	// to send something.
+	DisconnectIn int `json:"disconnect_in,omitempty"`
+	// Name is a member's new display name (renamed).
+	Name string `json:"name,omitempty"`
+	// Channel is the channel within the room a chat, file or typing event
+	// belongs to, or that subscribe and unsubscribe name. Channels lists a
+	// client's subscriptions (channels, welcome).
+	Channel  string   `json:"channel,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+
+	// ParentID is the thread a chat message replies to.
+	ParentID string `json:"parent_id,omitempty"`
+
+	// Mentions lists the members a chat message mentions as @name, as
+	// the server found them (chat, edit).
+	Mentions []string `json:"mentions,omitempty"`
+
+	// Preview summarises the first link in a chat message, once fetched
+	// (chat, link_preview).
+	Preview *LinkPreview `json:"preview,omitempty"`
+
+	// Role is a member's role in the room (role, welcome).
+	Role string `json:"role,omitempty"`
+
+	// SDP is the session description of a WebRTC offer or answer, and
+	// Candidate the ICE candidate object of an ice-candidate.
+	SDP       string          `json:"sdp,omitempty"`
+	Candidate json.RawMessage `json:"candidate,omitempty"`
+
+	// Key is a member's base64 public key (key). Keys maps client ids to
+	// published keys and Epoch numbers the room's key rotations (rekey,
+	// welcome).
+	Key   string            `json:"key,omitempty"`
+	Keys  map[string]string `json:"keys,omitempty"`
+	Epoch uint64            `json:"epoch,omitempty"`
+
+	// RoomName is the room's display name, if it has one (welcome).
+	RoomName string `json:"room_name,omitempty"`
+
+	// Session is the client's own session token, in its welcome only.
+	Session string `json:"session,omitempty"`
+	// Resume is the token for resuming this connection after a drop, and
+	// Resumed reports that the connection resumed an earlier one (welcome).
+	Resume  string `json:"resume,omitempty"`
+	Resumed bool   `json:"resumed,omitempty"`
+
+	// Seq is the room-assigned sequence number of a chat message.
+	Seq uint64 `json:"seq,omitempty"`
+
+	// ClientMsgID is the sender's own id for a chat or dm, echoed back in
+	// the ack along with the ServerID the room assigned.
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+	ServerID    string `json:"server_id,omitempty"`
+
+	// Meta is the sender's join-time metadata, stamped by the server.
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// Reason explains a departure (left).
+	Reason string `json:"reason,omitempty"`
+
+	// Features carries room feature flags (set_features, features, welcome).
+	Features map[string]bool `json:"features,omitempty"`
+
+	// Settings carries room settings (settings, welcome).
+	Settings *RoomSettings `json:"settings,omitempty"`
+
+	// Members is the room's presence list (presence).
+	Members []Member `json:"members,omitempty"`
+
+	// IDs lists pinned message ids (pinned).
+	IDs []string `json:"ids,omitempty"`
+
+	// Pinned carries the pinned messages themselves (welcome).
+	Pinned []json.RawMessage `json:"pinned,omitempty"`
+
+	// from is the sending client; nil for server-originated messages.
+	from *Client
+	// trace carries the span of the frame's handling into run; nil if
+	// the message did not come from a client.
+	trace context.Context
+}
+
+// knownTypes lists the client message types the server accepts.
+var knownTypes = map[string]bool{
+	"chat":          true,
+	"set_features":  true,
+	"settings":      true,
+	"pin":           true,
+	"unpin":         true,
+	"ignore":        true,
+	"unignore":      true,
+	"leave":         true,
+	"typing":        true,
+	"dm":            true,
+	"reaction":      true,
+	"edit":          true,
+	"delete":        true,
+	"offer":         true,
+	"answer":        true,
+	"ice-candidate": true,
+	"key":           true,
+	"read":          true,
+	"subscribe":     true,
+	"unsubscribe":   true,
+}
+
+// parseError carries the protocol error code for a rejected frame.
+type parseError struct {
+	code   string
+	detail string
+}
+
+func (e *parseError) Error() string { return e.code + ": " + e.detail }
+
+// parseMessage decodes a raw client frame, distinguishing malformed JSON
+// from well-formed JSON with a type the server does not understand.
+func parseMessage(data []byte) (*Message, error) {
+	if jsonDepth(data) > maxJSONDepth {
+		return nil, &parseError{codeTooDeep, "message nesting exceeds limit"}
+	}
+	if !json.Valid(data) {
+		return nil, &parseError{codeInvalidJSON, "message is not valid JSON"}
+	}
+
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, &parseError{codeInvalidJSON, "field " + typeErr.Field + " has the wrong type"}
+		}
+		return nil, &parseError{codeInvalidJSON, "message must be a JSON object"}
+	}
+	if !knownTypes[m.Type] {
+		return nil, &parseError{codeUnknownType, "unknown message type " + `"` + m.Type + `"`}
+	}
+	switch m.Type {
+	case "chat":
+		if pe := validateChat(&m); pe != nil {
+			return nil, pe
+		}
+	case "dm":
+		to := m.To
+		if pe := validateChat(&m); pe != nil {
+			return nil, pe
+		}
+		m.Channel, m.ParentID = "", ""
+		if to == "" {
+			return nil, &parseError{codeInvalidMessage, "dm requires to"}
+		}
+		m.To = to
+	case "edit":
+		id := m.ID
+		if pe := validateChat(&m); pe != nil {
+			return nil, pe
+		}
+		if id == "" {
+			return nil, &parseError{codeInvalidMessage, "edit requires id"}
+		}
+		m.ID, m.Channel, m.ParentID = id, "", ""
+	case "delete":
+		if m.ID == "" {
+			return nil, &parseError{codeInvalidMessage, "delete requires id"}
+		}
+	case "offer", "answer", "ice-candidate":
+		if pe := validateSignal(&m); pe != nil {
+			return nil, pe
+		}
+	case "typing", "subscribe", "unsubscribe":
+		ch, ok := normalizeChannel(m.Channel)
+		if !ok {
+			return nil, &parseError{codeInvalidMessage, "invalid channel name"}
+		}
+		m = Message{Type: m.Type, Channel: ch}
+	case "read":
+		m = Message{Type: m.Type, MsgID: m.MsgID}
+		if m.MsgID == "" {
+			return nil, &parseError{codeInvalidMessage, "read requires msg_id"}
+		}
+	case "key":
+		if pe := validateKey(&m); pe != nil {
+			return nil, pe
+		}
+	case "reaction":
+		if m.MsgID == "" {
+			return nil, &parseError{codeInvalidMessage, "reaction requires msg_id"}
+		}
+		if !validEmoji(m.Emoji) {
+			return nil, &parseError{codeInvalidMessage, "emoji must be a single emoji"}
+		}
+	}
+	return &m, nil
+}
+
+// validateChat checks the client-controlled fields of a chat envelope and
+// clears the ones only the server may set.
+func validateChat(m *Message) *parseError {
+	m.ID, m.Room, m.TS, m.Seq, m.To, m.From, m.ServerID, m.Session = "", "", "", 0, "", "", "", ""
+	m.Emote, m.Announcement, m.Bot, m.Name, m.Role = false, false, false, "", ""
+	m.Key, m.Keys, m.Epoch = "", nil, 0
+	m.Avatar, m.Mentions, m.Preview = "", nil, nil
+	var ok bool
+	if m.Channel, ok = normalizeChannel(m.Channel); !ok {
+		return &parseError{codeInvalidMessage, "invalid channel name"}
+	}
+	if len(m.ClientMsgID) > maxClientMsgIDLen {
+		return &parseError{codeInvalidMessage, "client_msg_id is too long"}
+	}
+	if len(m.ParentID) > maxParentIDLen {
+		return &parseError{codeInvalidMessage, "parent_id is too long"}
+	}
+	if utf8.RuneCountInString(m.User) > maxUserLen {
+		return &parseError{codeInvalidMessage, "user name is too long"}
+	}
+	m.Msg = stripControl(m.Msg)
+	if strings.TrimSpace(m.Msg) == "" {
+		return &parseError{codeInvalidMessage, "msg must not be empty"}
+	}
+	if utf8.RuneCountInString(m.Msg) > maxBodyLen {
+		return &parseError{codeInvalidMessage, "msg is too long"}
+	}
+	return validateContent(m)
+}
+
+// jsonDepth returns the maximum nesting of objects/arrays in data, ignoring
+// brackets inside strings. It does not validate the document.
+func jsonDepth(data []byte) int {
+	depth, deepest := 0, 0
+	inString, escaped := false, false
+	for _, b := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+			continue
+		}
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > deepest {
+				deepest = depth
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return deepest
+}
+
+// newMessageID returns a random RFC 4122 version 4 UUID.
+func newMessageID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
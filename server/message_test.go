@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseMessage(t *testing.T) {
+	nested := func(depth int) string {
+		return `{"type":"chat","msg":"hi","x":` + strings.Repeat("[", depth-1) + strings.Repeat("]", depth-1) + `}`
+	}
+	tests := []struct {
+		name     string
+		data     string
+		wantCode string
+		wantType string
+	}{
+		{name: "chat", data: `{"type":"chat","msg":"hello"}`, wantType: "chat"},
+		{name: "empty", data: ``, wantCode: codeInvalidJSON},
+		{name: "truncated object", data: `{"type":"chat","msg":"hel`, wantCode: codeInvalidJSON},
+		{name: "truncated after key", data: `{"type":`, wantCode: codeInvalidJSON},
+		{name: "missing brace", data: `{"type":"chat","msg":"hello"`, wantCode: codeInvalidJSON},
+		{name: "trailing garbage", data: `{"type":"chat","msg":"hi"}}`, wantCode: codeInvalidJSON},
+		{name: "not an object", data: `["chat"]`, wantCode: codeInvalidJSON},
+		{name: "wrong field type", data: `{"type":"chat","msg":42}`, wantCode: codeInvalidJSON},
+		{name: "unknown type", data: `{"type":"teleport"}`, wantCode: codeUnknownType},
+		{name: "no type", data: `{"msg":"hi"}`, wantCode: codeUnknownType},
+		{name: "nesting at the limit", data: nested(maxJSONDepth), wantType: "chat"},
+		{name: "nesting past the limit", data: nested(maxJSONDepth + 1), wantCode: codeTooDeep},
+		{name: "pathological nesting", data: strings.Repeat("[", 100000), wantCode: codeTooDeep},
+		{name: "brackets inside strings", data: `{"type":"chat","msg":"` + strings.Repeat("[", 100) + `"}`, wantType: "chat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parseMessage([]byte(tt.data))
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("parseMessage: %v", err)
+				}
+				if m.Type != tt.wantType {
+					t.Errorf("type = %q, want %q", m.Type, tt.wantType)
+				}
+				return
+			}
+			var pe *parseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("err = %v, want a parseError", err)
+			}
+			if pe.code != tt.wantCode {
+				t.Errorf("code = %q, want %q (%s)", pe.code, tt.wantCode, pe.detail)
+			}
+		})
+	}
+}
+
+func TestMalformedFrameErrors(t *testing.T) {
+	_, ts := startServer(t, nil)
+	c := dial(t, ts, "1234", "", nil)
+	c.expect("system")
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "truncated", data: `{"type":"chat","msg":"he`, want: codeInvalidJSON},
+		{name: "deep", data: strings.Repeat(`{"a":`, 64) + `1` + strings.Repeat(`}`, 64), want: codeTooDeep},
+		{name: "unknown type", data: `{"type":"teleport"}`, want: codeUnknownType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.conn.WriteMessage(websocket.TextMessage, []byte(tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.expect("error")["code"]; got != tt.want {
+				t.Errorf("code = %v, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// The connection survives every bad frame.
+	c.send(map[string]any{"type": "chat", "msg": "still here"})
+	c.expect("chat")
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Client metadata limits.
+const (
+	maxMetaFields   = 4
+	maxMetaKeyLen   = 16
+	maxMetaValueLen = 32
+)
+
+var (
+	metaKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	colorPattern   = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+)
+
+// parseClientMeta validates the metadata object a client supplies at join
+// time (the "meta" query parameter). The result is stamped onto every
+// message the client sends.
+func parseClientMeta(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var meta map[string]string
+	if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+		return nil, errors.New("meta must be a JSON object of string values")
+	}
+	if len(meta) > maxMetaFields {
+		return nil, fmt.Errorf("meta may have at most %d fields", maxMetaFields)
+	}
+	for k, v := range meta {
+		if len(k) > maxMetaKeyLen || !metaKeyPattern.MatchString(k) {
+			return nil, fmt.Errorf("invalid meta key %q", k)
+		}
+		if len(v) > maxMetaValueLen {
+			return nil, fmt.Errorf("meta value for %q exceeds %d bytes", k, maxMetaValueLen)
+		}
+		if k == "color" && !colorPattern.MatchString(v) {
+			return nil, fmt.Errorf("invalid color %q", v)
+		}
+	}
+	return meta, nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseClientMeta(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "none", raw: "", want: nil},
+		{name: "color and badge", raw: `{"color":"#ff8800","badge":"VIP"}`, want: map[string]string{"color": "#ff8800", "badge": "VIP"}},
+		{name: "short color", raw: `{"color":"#f80"}`, want: map[string]string{"color": "#f80"}},
+		{name: "bad color", raw: `{"color":"red"}`, wantErr: true},
+		{name: "color with script", raw: `{"color":"#fff;background:url(x)"}`, wantErr: true},
+		{name: "too many fields", raw: `{"a":"1","b":"2","c":"3","d":"4","e":"5"}`, wantErr: true},
+		{name: "bad key", raw: `{"Badge":"VIP"}`, wantErr: true},
+		{name: "long key", raw: `{"` + strings.Repeat("k", maxMetaKeyLen+1) + `":"v"}`, wantErr: true},
+		{name: "long value", raw: `{"badge":"` + strings.Repeat("v", maxMetaValueLen+1) + `"}`, wantErr: true},
+		{name: "non-string value", raw: `{"badge":1}`, wantErr: true},
+		{name: "not an object", raw: `["VIP"]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseClientMeta(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("meta = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("meta[%s] = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestMetaStampedOnBroadcasts(t *testing.T) {
+	_, ts := startServer(t, nil)
+	amy := dial(t, ts, "1234", "amy", url.Values{"meta": {`{"color":"#ff8800","badge":"VIP"}`}})
+	amy.expect("system")
+	bob := dial(t, ts, "1234", "bob", nil)
+	bob.expect("system")
+
+	// A per-message meta is overridden by the join-time one.
+	amy.send(map[string]any{"type": "chat", "msg": "hi", "meta": map[string]string{"badge": "Admin"}})
+	meta, _ := bob.expectMsg("chat", "hi")["meta"].(map[string]any)
+	if meta["color"] != "#ff8800" || meta["badge"] != "VIP" {
+		t.Errorf("chat meta = %v, want the join-time meta", meta)
+	}
+
+	bob.send(map[string]any{"type": "chat", "msg": "hello", "meta": map[string]string{"badge": "Admin"}})
+	if meta := amy.expectMsg("chat", "hello")["meta"]; meta != nil {
+		t.Errorf("chat from a member without meta carries %v", meta)
+	}
+
+	q := url.Values{"pin": {"1234"}, "meta": {`{"color":"red"}`}}
+	if status, _ := dialStatus(t, ts, q); status != http.StatusBadRequest {
+		t.Errorf("join with invalid meta = %d, want 400", status)
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// proxyTrust says whether clientIP believes X-Forwarded-For and
+// requestScheme believes X-Forwarded-Proto, which is only safe behind
+// proxies that set them, and how many of those proxies append to
+// X-Forwarded-For.
+type proxyTrust struct {
+	headers bool
+	hops    int
+}
+
+func newProxyTrust(cfg *Config) proxyTrust {
+	return proxyTrust{headers: cfg.TrustProxyHeaders, hops: cfg.TrustedProxyHops}
+}
+
+// clientIP returns the address a request came from. Behind trusted
+// proxies that is the X-Forwarded-For entry the outermost one appended,
+// hops from the right: anything further left came from the client and
+// may be forged.
+func (p proxyTrust) clientIP(r *http.Request) string {
+	if p.headers {
+		if ip := forwardedFor(r.Header.Values("X-Forwarded-For"), p.hops); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedFor returns the address hops entries from the right of the
+// X-Forwarded-For values, or the leftmost if there are fewer, or "" if
+// that entry is not an address.
+func forwardedFor(values []string, hops int) string {
+	var entries []string
+	for _, v := range values {
+		entries = append(entries, strings.Split(v, ",")...)
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+	entry := entries[max(0, len(entries)-hops)]
+	if ip := net.ParseIP(strings.TrimSpace(entry)); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// ipFingerprint identifies a client address in ban lists without keeping
+// the address itself. An IPv6 address stands for its whole /64, as in
+// connKey, since its holder can move within that at will.
+func ipFingerprint(ip string) string {
+	sum := sha256.Sum256([]byte("gochat-ban:" + connKey(ip)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// banList is a room's bans, by lowercased name and by IP fingerprint. It
+// is read by serveWs before upgrading, so it has its own lock.
+type banList struct {
+	mu    sync.Mutex
+	names map[string]string // name -> fingerprint at the time of the ban
+	fps   map[string]bool
+}
+
+func (b *banList) add(name, fp string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.names == nil {
+		b.names, b.fps = make(map[string]string), make(map[string]bool)
+	}
+	b.names[strings.ToLower(name)] = fp
+	if fp != "" {
+		b.fps[fp] = true
+	}
+}
+
+// remove lifts the ban on name and the address it was banned from.
+func (b *banList) remove(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fp, ok := b.names[strings.ToLower(name)]
+	if !ok {
+		return false
+	}
+	delete(b.names, strings.ToLower(name))
+	delete(b.fps, fp)
+	return true
+}
+
+func (b *banList) banned(name, fp string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, byName := b.names[strings.ToLower(name)]
+	return byName || (fp != "" && b.fps[fp])
+}
+
+// expel removes a member on a moderator's or admin's behalf, closing its
+// connection with closeCode and reason. Only run may call it.
+func (h *Hub) expel(c *Client, closeCode int, code, reason string) {
+	c.closeCode, c.closeReason = closeCode, reason
+	c.trySend(errorFrame(code, reason))
+	h.drop(c)
+	c.log.Info("client expelled", "code", code, "reason", reason)
+	if h.features[featurePresence] {
+		h.fanOutFrom("", c.name, nil, h.frame(&Message{Type: "left", User: c.name, Reason: code}))
+	}
+}
+
+// findByName returns the member using name, ignoring case. Only run may
+// call it.
+func (h *Hub) findByName(name string) *Client {
+	for c := range h.clients {
+		if strings.EqualFold(c.name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(&command{name: "kick", args: "<name>[: reason]", help: "disconnect a member", perm: permKick, run: (*Hub).kickCommand})
+	registerCommand(&command{name: "ban", args: "<name>[: reason]", help: "disconnect a member and refuse their name and address", perm: permBan, run: (*Hub).banCommand})
+	registerCommand(&command{name: "unban", args: "<name>[: reason]", help: "lift a ban", perm: permBan, run: (*Hub).unbanCommand})
+}
+
+// moderationTarget normalises the name a moderation command acts on,
+// replying with an error and returning "" if it is missing or the sender.
+func moderationTarget(msg *Message, cmd, args string) string {
+	name := strings.Join(strings.Fields(args), " ")
+	if name == "" {
+		usageError(msg.from, cmd)
+		return ""
+	}
+	if strings.EqualFold(name, msg.from.name) {
+		msg.from.trySend(errorFrame(codeInvalidMessage, "you cannot "+cmd+" yourself"))
+		return ""
+	}
+	return name
+}
+
+func (h *Hub) kickCommand(msg *Message, args string) {
+	args, reason := splitReason(args)
+	name := moderationTarget(msg, "kick", args)
+	if name == "" {
+		return
+	}
+	target := h.findByName(name)
+	if target == nil {
+		msg.from.trySend(errorFrame(codeNotFound, "no member named "+`"`+name+`"`))
+		return
+	}
+	if !msg.from.outranks(target) {
+		msg.from.trySend(errorFrame(codeForbidden, "you cannot kick "+target.name))
+		return
+	}
+	h.expel(target, closeKicked, codeKicked, "kicked by "+msg.from.name)
+	h.audit(msg.from, AuditEntry{Action: auditKick, Target: target.name, TargetID: target.userID, Reason: reason})
+}
+
+func (h *Hub) banCommand(msg *Message, args string) {
+	args, reason := splitReason(args)
+	name := moderationTarget(msg, "ban", args)
+	if name == "" {
+		return
+	}
+	target := h.findByName(name)
+	if target != nil && !msg.from.outranks(target) {
+		msg.from.trySend(errorFrame(codeForbidden, "you cannot ban "+target.name))
+		return
+	}
+	fp, targetID := "", ""
+	if target != nil {
+		fp, targetID = target.fingerprint, target.userID
+	}
+	h.bans.add(name, fp)
+	if target != nil {
+		h.expel(target, closeBanned, codeBanned, "banned by "+msg.from.name)
+	}
+	h.audit(msg.from, AuditEntry{Action: auditBan, Target: name, TargetID: targetID, Reason: reason})
+	msg.from.trySend(h.frame(&Message{Type: "banned", User: name}))
+}
+
+func (h *Hub) unbanCommand(msg *Message, args string) {
+	args, reason := splitReason(args)
+	name := moderationTarget(msg, "unban", args)
+	if name == "" {
+		return
+	}
+	if !h.bans.remove(name) {
+		msg.from.trySend(errorFrame(codeNotFound, `"`+name+`"`+" is not banned"))
+		return
+	}
+	h.audit(msg.from, AuditEntry{Action: auditUnban, Target: name, Reason: reason})
+	msg.from.trySend(h.frame(&Message{Type: "unbanned", User: name}))
+}
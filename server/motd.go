@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	motdFetchTimeout = 5 * time.Second
+	motdMaxBytes     = 4 * 1024
+)
+
+// motdSource periodically fetches a banner from an external URL and caches
+// the latest good value. Failed fetches keep the previous value.
+type motdSource struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	mu  sync.RWMutex
+	msg string
+}
+
+func newMOTDSource(url string, interval time.Duration) *motdSource {
+	return &motdSource{
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: motdFetchTimeout},
+	}
+}
+
+// current returns the cached banner, or "" if none has been fetched.
+func (s *motdSource) current() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.msg
+}
+
+// run refreshes the banner until ctx is cancelled.
+func (s *motdSource) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if msg, err := s.fetch(ctx); err != nil {
+			slog.Debug("motd fetch failed", "url", s.url, "err", err)
+		} else {
+			s.mu.Lock()
+			s.msg = msg
+			s.mu.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *motdSource) fetch(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var body struct {
+		Msg string `json:"msg"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, motdMaxBytes)).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.Msg, nil
+}
+
+// motdFrame builds the banner sent to a joining client.
+func motdFrame(msg string) []byte {
+	b, _ := json.Marshal(map[string]string{"type": "motd", "msg": msg})
+	return b
+}
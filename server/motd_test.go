@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMOTDDeliveredOnJoin(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{name: "banner", status: http.StatusOK, body: `{"msg":"maintenance at noon"}`, want: "maintenance at noon"},
+		{name: "empty banner", status: http.StatusOK, body: `{"msg":""}`},
+		{name: "server error", status: http.StatusInternalServerError, body: `{"msg":"oops"}`},
+		{name: "not json", status: http.StatusOK, body: "maintenance at noon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetched atomic.Int32
+			motd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+				fetched.Add(1)
+			}))
+			defer motd.Close()
+			s, ts := startServer(t, func(cfg *Config) {
+				cfg.MOTDURL = motd.URL
+				cfg.MOTDInterval = time.Minute
+			})
+
+			// The first fetch starts with the server; join once it has
+			// been cached.
+			deadline := time.Now().Add(testWait)
+			for fetched.Load() == 0 || (tt.want != "" && s.manager.motd.current() == "") {
+				if time.Now().After(deadline) {
+					t.Fatal("MOTD was never fetched")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			amy := dial(t, ts, "1234", "amy", nil)
+			amy.expect("system")
+			var got []string
+			for _, f := range ofType(amy.frames(300*time.Millisecond), "motd") {
+				got = append(got, f["msg"].(string))
+			}
+			switch {
+			case tt.want == "" && len(got) != 0:
+				t.Errorf("got MOTD %q, want none", got)
+			case tt.want != "" && (len(got) != 1 || got[0] != tt.want):
+				t.Errorf("got MOTD %q, want [%q]", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxNameLen bounds display names; they are also the sender of every
+// message, so they share the envelope's limit.
+const maxNameLen = maxUserLen
+
+var namePattern = regexp.MustCompile(`^[\p{L}\p{N}_.\- ]+$`)
+
+// validateName normalises a display name declared at join. An empty name
+// gets a random guest name.
+func validateName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		var b [2]byte
+		_, _ = rand.Read(b[:])
+		return "guest-" + hex.EncodeToString(b[:]), nil
+	}
+	if utf8.RuneCountInString(name) > maxNameLen {
+		return "", errors.New("name must be at most 32 characters")
+	}
+	if !namePattern.MatchString(name) {
+		return "", errors.New("name may only contain letters, digits, spaces and _ . -")
+	}
+	return name, nil
+}
+
+// nameTaken reports whether another member of the room already uses name,
+// ignoring case. Only run may call it.
+func (h *Hub) nameTaken(name string) bool {
+	for c := range h.clients {
+		if strings.EqualFold(c.name, name) {
+			return true
+		}
+	}
+	return h.parkedName(name)
+}
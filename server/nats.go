@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	natsDialTimeout   = 5 * time.Second
+	natsWriteTimeout  = 10 * time.Second
+	natsSubjectPrefix = "gochat.room."
+	natsMaxPayload    = 1 << 20
+)
+
+// natsBackplane is a Broker over NATS core pub/sub. Each room maps to the
+// subject gochat.room.<pin>, with the PIN escaped to a single subject
+// token, and every instance subscribes to gochat.room.*.
+type natsBackplane struct {
+	relay
+	url string
+}
+
+func newNATSBackplane(url string, deliver func(room string, frame []byte), presence func(room, instance string, p instancePresence)) *natsBackplane {
+	return &natsBackplane{relay: newRelay("nats", deliver, presence), url: url}
+}
+
+// Run keeps a connection to NATS alive until ctx ends, reconnecting with
+// backoff. Frames published while disconnected wait in the queue.
+func (b *natsBackplane) Run(ctx context.Context) {
+	b.retry(ctx, "nats", b.serve)
+}
+
+// serve subscribes on a fresh connection, then publishes from the queue
+// while reading deliveries until either side fails.
+func (b *natsBackplane) serve(ctx context.Context) error {
+	conn, err := dialNATS(b.url)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	if err := conn.write("SUB " + natsSubjectPrefix + "* 1\r\n"); err != nil {
+		return err
+	}
+	slog.Info("nats backplane subscribed", "instance", b.instance)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case f := <-b.out:
+				if err := conn.publish(natsSubjectPrefix+natsToken(f.room), b.envelope(f)); err != nil {
+					// Put it back for the next connection; the read side
+					// fails on the same broken socket.
+					b.Publish(f.room, f.frame)
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		subject, payload, err := conn.next()
+		if err != nil {
+			return err
+		}
+		room, err := url.PathUnescape(strings.TrimPrefix(subject, natsSubjectPrefix))
+		if err != nil {
+			continue
+		}
+		b.receive(room, payload)
+	}
+}
+
+// natsToken escapes a PIN into one subject token: NATS splits subjects on
+// "." and reserves "*", ">" and whitespace.
+func natsToken(pin string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pin); i++ {
+		c := pin[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// natsConn is a minimal client for the NATS text protocol: enough to
+// CONNECT, SUB, PUB and answer keepalive PINGs without pulling in a driver.
+type natsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	mu   sync.Mutex // serialises writes
+}
+
+// dialNATS connects to a nats://[user:pass@|token@]host[:port] URL and
+// completes the handshake.
+func dialNATS(rawURL string) (*natsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" {
+		return nil, fmt.Errorf("unsupported nats scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	conn, err := net.DialTimeout("tcp", addr, natsDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &natsConn{conn: conn, r: bufio.NewReader(conn)}
+
+	_ = conn.SetReadDeadline(time.Now().Add(natsDialTimeout))
+	line, err := c.line()
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		c.Close()
+		return nil, fmt.Errorf("nats: expected INFO, got %q: %v", line, err)
+	}
+
+	opts := map[string]any{"verbose": false, "pedantic": false, "name": "gochat", "lang": "go", "version": "1", "protocol": 1}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			opts["user"], opts["pass"] = u.User.Username(), pass
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	connect, _ := json.Marshal(opts)
+	if err := c.write("CONNECT " + string(connect) + "\r\nPING\r\n"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	// The server answers PING with PONG once CONNECT is accepted, or with
+	// -ERR if it is not.
+	for {
+		line, err := c.line()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			c.Close()
+			return nil, errors.New("nats: " + strings.TrimSpace(line[4:]))
+		}
+		if line == "PONG" {
+			break
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *natsConn) Close() error { return c.conn.Close() }
+
+func (c *natsConn) write(s string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+	_, err := io.WriteString(c.conn, s)
+	return err
+}
+
+func (c *natsConn) publish(subject string, payload []byte) error {
+	return c.write("PUB " + subject + " " + strconv.Itoa(len(payload)) + "\r\n" + string(payload) + "\r\n")
+}
+
+func (c *natsConn) line() (string, error) {
+	line, err := c.r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// next returns the next message delivered to a subscription, answering
+// PINGs and skipping other control lines on the way.
+func (c *natsConn) next() (string, []byte, error) {
+	for {
+		line, err := c.line()
+		if err != nil {
+			return "", nil, err
+		}
+		switch {
+		case line == "PING":
+			if err := c.write("PONG\r\n"); err != nil {
+				return "", nil, err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return "", nil, errors.New("nats: " + strings.TrimSpace(line[4:]))
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			f := strings.Fields(line)
+			if len(f) < 4 {
+				return "", nil, fmt.Errorf("nats: malformed %q", line)
+			}
+			n, err := strconv.Atoi(f[len(f)-1])
+			if err != nil || n < 0 || n > natsMaxPayload {
+				return "", nil, fmt.Errorf("nats: malformed %q", line)
+			}
+			buf := make([]byte, n+2)
+			if _, err := io.ReadFull(c.r, buf); err != nil {
+				return "", nil, err
+			}
+			return f[1], buf[:n], nil
+		}
+		// +OK, PONG and INFO updates need no action.
+	}
+}
@@ -0,0 +1,415 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// stateCookie ties a callback to the login that began it, for stateTTL.
+const (
+	stateCookie = "gochat_oauth"
+	stateTTL    = 10 * time.Minute
+)
+
+// oauthTimeout bounds each call to the provider.
+const oauthTimeout = 10 * time.Second
+
+// Built-in providers. Google is found through its OIDC discovery document;
+// GitHub is plain OAuth2, so the identity comes from its user API.
+const (
+	googleIssuer  = "https://accounts.google.com"
+	githubUserURL = "https://api.github.com/user"
+)
+
+var githubEndpoints = &oauthEndpoints{
+	Auth:  "https://github.com/login/oauth/authorize",
+	Token: "https://github.com/login/oauth/access_token",
+}
+
+var (
+	errOAuthState    = errors.New("sign-in expired or was started elsewhere, try again")
+	errOAuthIDToken  = errors.New("provider returned an invalid ID token")
+	errOAuthIdentity = errors.New("provider returned no user id")
+)
+
+// validateOAuth checks the sign-in settings, which all hang off
+// OAUTH_PROVIDER.
+func (c *Config) validateOAuth(env *envReader) {
+	if c.OAuthProvider == "" {
+		return
+	}
+	switch c.OAuthProvider {
+	case "google", "github":
+		if c.OAuthIssuer != "" {
+			env.fail("OAUTH_ISSUER is only for OAUTH_PROVIDER=oidc")
+		}
+	case "oidc":
+		if u, err := url.Parse(c.OAuthIssuer); err != nil || u.Scheme != "https" || u.Host == "" {
+			env.fail("OAUTH_ISSUER must be an https URL, got %q", c.OAuthIssuer)
+		}
+	default:
+		env.fail("OAUTH_PROVIDER must be google, github or oidc, got %q", c.OAuthProvider)
+	}
+	if c.JWTSecret == "" {
+		env.fail("OAUTH_PROVIDER requires JWT_SECRET, which signs the session cookie")
+	}
+	if c.OAuthClientID == "" || c.OAuthClientSecret == "" {
+		env.fail("OAUTH_PROVIDER requires OAUTH_CLIENT_ID and OAUTH_CLIENT_SECRET")
+	}
+	if u, err := url.Parse(c.OAuthRedirectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		env.fail("OAUTH_REDIRECT_URL must be the absolute URL of /auth/callback, got %q", c.OAuthRedirectURL)
+	}
+}
+
+// oauthEndpoints are where a provider signs users in. Issuer is empty for
+// plain OAuth2.
+type oauthEndpoints struct {
+	Issuer string `json:"issuer"`
+	Auth   string `json:"authorization_endpoint"`
+	Token  string `json:"token_endpoint"`
+}
+
+// oauthLogin runs the authorization code flow against one provider and
+// turns the identity it returns into a session cookie.
+type oauthLogin struct {
+	provider     string
+	clientID     string
+	clientSecret string
+	issuer       string // OIDC issuer; empty for GitHub
+	redirectURL  string
+	signin       *signIn
+	http         *http.Client
+
+	// endpoints is discovered on first use and kept once found.
+	mu        sync.Mutex
+	endpoints *oauthEndpoints
+}
+
+func newOAuthLogin(cfg *Config, signin *signIn) *oauthLogin {
+	o := &oauthLogin{
+		provider:     cfg.OAuthProvider,
+		clientID:     cfg.OAuthClientID,
+		clientSecret: cfg.OAuthClientSecret,
+		issuer:       cfg.OAuthIssuer,
+		redirectURL:  cfg.OAuthRedirectURL,
+		signin:       signin,
+		http:         &http.Client{Timeout: oauthTimeout},
+	}
+	switch o.provider {
+	case "google":
+		o.issuer = googleIssuer
+	case "github":
+		o.endpoints = githubEndpoints
+	}
+	return o
+}
+
+// discover returns the provider's endpoints, fetching the OIDC discovery
+// document the first time. A failed fetch is retried on the next login.
+func (o *oauthLogin) discover(ctx context.Context) (*oauthEndpoints, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.endpoints != nil {
+		return o.endpoints, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(o.issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var ep oauthEndpoints
+	if err := o.fetchJSON(req, &ep); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(ep.Issuer, "/") != strings.TrimSuffix(o.issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery: issuer is %q, want %q", ep.Issuer, o.issuer)
+	}
+	if ep.Auth == "" || ep.Token == "" {
+		return nil, errors.New("oidc discovery: endpoints missing")
+	}
+	o.endpoints = &ep
+	return o.endpoints, nil
+}
+
+// fetchJSON sends req and decodes a 2xx JSON reply into v.
+func (o *oauthLogin) fetchJSON(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := o.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// loginState is kept in the state cookie between login and callback.
+type loginState struct {
+	State  string `json:"s"`
+	Nonce  string `json:"n"`
+	Return string `json:"r"`
+}
+
+// randomToken returns 16 random bytes, base64url-encoded.
+func randomToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// localPath returns p if it is a path on this server, or "/", so the
+// callback never redirects off-site. Browsers read a backslash as "/"
+// and drop tabs and newlines, so "/\evil.example" and a "/" followed by
+// a tab and "/evil.example" are refused like "//evil.example".
+func localPath(p string) string {
+	if strings.ContainsFunc(p, unicode.IsControl) {
+		return "/"
+	}
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return "/"
+	}
+	return p
+}
+
+// handleLogin serves GET /auth/login?return=/path, sending the browser to
+// the provider. After signing in it comes back to return, on this server.
+func (o *oauthLogin) handleLogin(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), oauthTimeout)
+	defer cancel()
+	ep, err := o.discover(ctx)
+	if err != nil {
+		slog.Error("sign-in provider unavailable", "provider", o.provider, "err", err)
+		writeError(w, http.StatusBadGateway, codeUnavailable, "sign-in provider unavailable")
+		return
+	}
+	st := loginState{State: randomToken(), Nonce: randomToken(), Return: localPath(r.URL.Query().Get("return"))}
+	raw, _ := json.Marshal(st)
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(raw),
+		Path:     "/auth/",
+		MaxAge:   int(stateTTL / time.Second),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.clientID},
+		"redirect_uri":  {o.redirectURL},
+		"state":         {st.State},
+	}
+	if ep.Issuer != "" {
+		q.Set("scope", "openid profile email")
+		q.Set("nonce", st.Nonce)
+	} else {
+		q.Set("scope", "read:user")
+	}
+	http.Redirect(w, r, ep.Auth+"?"+q.Encode(), http.StatusFound)
+}
+
+// handleCallback serves GET /auth/callback, where the provider returns the
+// browser with a code. The code is exchanged for the user's identity,
+// which is stored in the session cookie.
+func (o *oauthLogin) handleCallback(w http.ResponseWriter, r *http.Request) {
+	st, err := o.takeState(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		writeError(w, http.StatusUnauthorized, codeAuthFailed, "sign-in refused: "+e)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*oauthTimeout)
+	defer cancel()
+	id, err := o.identify(ctx, q.Get("code"), st.Nonce)
+	if err != nil {
+		slog.Warn("sign-in failed", "provider", o.provider, "err", err)
+		writeError(w, http.StatusBadGateway, codeUnavailable, "sign-in failed")
+		return
+	}
+	if _, _, err := o.signin.begin(w, r, id); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not sign session")
+		return
+	}
+	slog.Info("signed in", "provider", o.provider, "sub", id.Subject)
+	http.Redirect(w, r, st.Return, http.StatusFound)
+}
+
+// takeState reads and clears the state cookie, checking it against the
+// callback's state parameter.
+func (o *oauthLogin) takeState(w http.ResponseWriter, r *http.Request) (loginState, error) {
+	var st loginState
+	c, err := r.Cookie(stateCookie)
+	if err != nil {
+		return st, errOAuthState
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/auth/", MaxAge: -1, HttpOnly: true, Secure: secureRequest(r)})
+	raw, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil || json.Unmarshal(raw, &st) != nil || st.State == "" {
+		return st, errOAuthState
+	}
+	if subtle.ConstantTimeCompare([]byte(st.State), []byte(r.URL.Query().Get("state"))) != 1 {
+		return st, errOAuthState
+	}
+	st.Return = localPath(st.Return)
+	return st, nil
+}
+
+// identify exchanges code for tokens and returns the user's stable id,
+// prefixed with the provider, display name and picture, as the claims of
+// a session to sign.
+func (o *oauthLogin) identify(ctx context.Context, code, nonce string) (Claims, error) {
+	ep, err := o.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.redirectURL},
+		"client_id":     {o.clientID},
+		"client_secret": {o.clientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.Token, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+	}
+	if err := o.fetchJSON(req, &tok); err != nil {
+		return Claims{}, fmt.Errorf("token exchange: %w", err)
+	}
+	if tok.Error != "" {
+		return Claims{}, fmt.Errorf("token exchange: %s", tok.Error)
+	}
+	if ep.Issuer != "" {
+		return o.idTokenIdentity(ep.Issuer, tok.IDToken, nonce)
+	}
+	return o.githubIdentity(ctx, tok.AccessToken)
+}
+
+// idTokenIdentity reads the user from an OIDC ID token. The token came
+// straight from the token endpoint over TLS, which OIDC Core 3.1.3.7
+// accepts in place of checking its signature; issuer, audience, expiry
+// and nonce are still checked.
+func (o *oauthLogin) idTokenIdentity(issuer, idToken, nonce string) (Claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, errOAuthIDToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, errOAuthIDToken
+	}
+	var c struct {
+		Issuer    string          `json:"iss"`
+		Audience  json.RawMessage `json:"aud"`
+		ExpiresAt int64           `json:"exp"`
+		Nonce     string          `json:"nonce"`
+		Subject   string          `json:"sub"`
+		Name      string          `json:"name"`
+		Username  string          `json:"preferred_username"`
+		GivenName string          `json:"given_name"`
+		Picture   string          `json:"picture"`
+	}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Claims{}, errOAuthIDToken
+	}
+	if c.Issuer != issuer || !audienceHas(c.Audience, o.clientID) || time.Now().Unix() >= c.ExpiresAt || c.Nonce != nonce {
+		return Claims{}, errOAuthIDToken
+	}
+	if c.Subject == "" {
+		return Claims{}, errOAuthIdentity
+	}
+	return Claims{Subject: o.provider + ":" + c.Subject, Name: displayName(c.Name, c.Username, c.GivenName), Avatar: providerAvatar(c.Picture)}, nil
+}
+
+// audienceHas reports whether an aud claim, a string or an array of
+// them, names clientID.
+func audienceHas(aud json.RawMessage, clientID string) bool {
+	var one string
+	if json.Unmarshal(aud, &one) == nil {
+		return one == clientID
+	}
+	var many []string
+	if json.Unmarshal(aud, &many) == nil {
+		for _, a := range many {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// githubIdentity reads the user from GitHub's user API.
+func (o *oauthLogin) githubIdentity(ctx context.Context, accessToken string) (Claims, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, githubUserURL, nil)
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	var u struct {
+		ID     int64  `json:"id"`
+		Login  string `json:"login"`
+		Name   string `json:"name"`
+		Avatar string `json:"avatar_url"`
+	}
+	if err := o.fetchJSON(req, &u); err != nil {
+		return Claims{}, fmt.Errorf("github user: %w", err)
+	}
+	if u.ID == 0 {
+		return Claims{}, errOAuthIdentity
+	}
+	return Claims{Subject: "github:" + strconv.FormatInt(u.ID, 10), Name: displayName(u.Name, u.Login), Avatar: providerAvatar(u.Avatar)}, nil
+}
+
+// providerAvatar returns a provider's picture URL if it is usable as an
+// avatar, or "" for the identicon.
+func providerAvatar(picture string) string {
+	if validateAvatar(picture) != nil {
+		return ""
+	}
+	return picture
+}
+
+// displayName returns the first candidate that is a valid chat name, or
+// "" to let the member choose one.
+func displayName(candidates ...string) string {
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		if name, err := validateName(c); err == nil {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "/", want: "/"},
+		{in: "/rooms/1234?x=1#top", want: "/rooms/1234?x=1#top"},
+		{in: "", want: "/"},
+		{in: "rooms", want: "/"},
+		{in: "//evil.example", want: "/"},
+		{in: "//evil.example/path", want: "/"},
+		{in: `/\evil.example`, want: "/"},
+		{in: "/\t/evil.example", want: "/"},
+		{in: "/\n/evil.example", want: "/"},
+		{in: "/\r\n/evil.example", want: "/"},
+		{in: "https://evil.example", want: "/"},
+		{in: "javascript:alert(1)", want: "/"},
+		{in: " /evil", want: "/"},
+	}
+	for _, tt := range tests {
+		if got := localPath(tt.in); got != tt.want {
+			t.Errorf("localPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOAuthCallback(t *testing.T) {
+	const clientID = "gochat-test"
+	var idToken string
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"access_token": "at", "id_token": idToken})
+	}))
+	defer provider.Close()
+
+	o := newOAuthLogin(&Config{OAuthProvider: "oidc", OAuthClientID: clientID, OAuthIssuer: provider.URL},
+		newSignIn(&Config{JWTSecret: strings.Repeat("s", minJWTSecretLen), SessionTTL: time.Hour}, nil))
+	o.endpoints = &oauthEndpoints{Issuer: provider.URL, Auth: provider.URL + "/auth", Token: provider.URL + "/token"}
+
+	// token builds an unsigned ID token; the callback trusts the token
+	// endpoint's TLS in place of a signature.
+	token := func(claims map[string]any) string {
+		payload, _ := json.Marshal(claims)
+		return "e30." + base64.RawURLEncoding.EncodeToString(payload) + "."
+	}
+	claims := func(edit func(map[string]any)) map[string]any {
+		c := map[string]any{"iss": provider.URL, "aud": clientID, "exp": time.Now().Add(time.Hour).Unix(), "nonce": "n1", "sub": "42", "name": "amy"}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+	stateCookieValue := func(st loginState) string {
+		raw, _ := json.Marshal(st)
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		name       string
+		cookie     string // "" sends no state cookie
+		state      string
+		claims     map[string]any
+		wantStatus int
+		wantTo     string
+	}{
+		{name: "signed in", cookie: stateCookieValue(loginState{State: "s1", Nonce: "n1", Return: "/rooms"}), state: "s1", claims: claims(nil), wantStatus: http.StatusFound, wantTo: "/rooms"},
+		{name: "audience list", cookie: stateCookieValue(loginState{State: "s1", Nonce: "n1", Return: "/"}), state: "s1", claims: claims(func(c map[string]any) { c["aud"] = []string{"other", clientID} }), wantStatus: http.StatusFound, wantTo: "/"},
+		{name: "forged return", cookie: stateCookieValue(loginState{State: "s1", Nonce: "n1", Return: "//evil.example"}), state: "s1", claims: claims(nil), wantStatus: http.StatusFound, wantTo: "/"},
+		{name: "no state cookie", state: "s1", claims: claims(nil), wantStatus: http.StatusBadRequest},
+		{name: "state mismatch", cookie: stateCookieValue(loginState{State: "s1", Nonce: "n1"}), state: "s2", claims: claims(nil), wantStatus: http.StatusBadRequest},
+		{name: "no state", cookie: stateCookieValue(loginState{State: "s1", Nonce: "n1"}), claims: claims(nil), wantStatus: http.StatusBadRequest},
+		{name: "garbled cookie", cookie: "not base64!", state: "s1", claims: claims(nil), wantStatus: http.StatusBadRequest},
+		{name: "nonce mismatch", cookie: stateCookieValue(loginState{State: "s1", Nonce: "n1"}), state: "s1", claims: claims(func(c map[string]any) { c["nonce"] = "n2" }), wantStatus: http.StatusBadGateway},
+		{name: "no nonce", cookie: stateCookieValue(loginState{State: "s1", Nonce: "n1"}), state: "s1", claims: claims(func(c map[string]any) { delete(c, "nonce") }), wantStatus: http.StatusBadGateway},
+		{name: "wrong audience", cookie: stateCookieValue(loginState{State: "s1", Nonce: "n1"}), state: "s1", claims: claims(func(c map[string]any) { c["aud"] = "someone-else" }), wantStatus: http.StatusBadGateway},
+		{name: "audience list without us", cookie: stateCookieValue(loginState{State: "s1", Nonce: "n1"}), state: "s1", claims: claims(func(c map[string]any) { c["aud"] = []string{"a", "b"} }), wantStatus: http.StatusBadGateway},
+		{name: "wrong issuer", cookie: stateCookieValue(loginState{State: "s1", Nonce: "n1"}), state: "s1", claims: claims(func(c map[string]any) { c["iss"] = "https://evil.example" }), wantStatus: http.StatusBadGateway},
+		{name: "expired", cookie: stateCookieValue(loginState{State: "s1", Nonce: "n1"}), state: "s1", claims: claims(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() }), wantStatus: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idToken = token(tt.claims)
+			r := httptest.NewRequest("GET", "/auth/callback?code=c1&state="+tt.state, nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: stateCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			o.handleCallback(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var session bool
+			for _, c := range w.Result().Cookies() {
+				if c.Name == sessionCookie && c.Value != "" {
+					session = true
+				}
+			}
+			if session != (tt.wantStatus == http.StatusFound) {
+				t.Errorf("session cookie set = %v, want %v", session, !session)
+			}
+			if tt.wantTo != "" {
+				if got := w.Header().Get("Location"); got != tt.wantTo {
+					t.Errorf("Location = %q, want %q", got, tt.wantTo)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// defaultAllowedOrigins keeps local development and Render deployments
+// working when ALLOWED_ORIGINS is unset.
+const defaultAllowedOrigins = "localhost:*,127.0.0.1:*,[::1]:*,https://*.onrender.com"
+
+// originRule matches browser origins. An empty scheme matches http and
+// https; a host starting with "*." matches any subdomain (but not the bare
+// domain); port "*" matches any port and an empty port the scheme default.
+type originRule struct {
+	scheme string
+	host   string
+	port   string
+}
+
+// parseOriginRule parses "[scheme://]host[:port]", e.g.
+// "https://*.example.com" or "localhost:*".
+func parseOriginRule(s string) (originRule, error) {
+	var r originRule
+	rest := strings.ToLower(strings.TrimSuffix(s, "/"))
+	if scheme, after, ok := strings.Cut(rest, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return r, fmt.Errorf("origin %q: scheme must be http or https", s)
+		}
+		r.scheme, rest = scheme, after
+	}
+	r.host = rest
+	if h, p, err := net.SplitHostPort(rest); err == nil {
+		r.host, r.port = h, p
+	} else {
+		r.host = strings.Trim(rest, "[]")
+	}
+	if r.host == "" || strings.ContainsAny(r.host, "/?#@") || strings.Contains(strings.TrimPrefix(r.host, "*."), "*") {
+		return r, fmt.Errorf("origin %q: want [scheme://]host[:port] with an optional leading *.", s)
+	}
+	return r, nil
+}
+
+func (r originRule) matches(u *url.URL) bool {
+	if r.scheme != "" && r.scheme != u.Scheme {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if suffix, ok := strings.CutPrefix(r.host, "*."); ok {
+		if !strings.HasSuffix(host, "."+suffix) {
+			return false
+		}
+	} else if host != r.host {
+		return false
+	}
+	switch r.port {
+	case "*":
+		return true
+	case "":
+		return u.Port() == "" || u.Port() == defaultPort(u.Scheme)
+	default:
+		return u.Port() == r.port || (u.Port() == "" && r.port == defaultPort(u.Scheme))
+	}
+}
+
+// parseOriginList parses a comma-separated ALLOWED_ORIGINS value.
+func parseOriginList(list []string) ([]originRule, error) {
+	rules := make([]originRule, 0, len(list))
+	for _, s := range list {
+		r, err := parseOriginRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
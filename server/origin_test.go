@@ -0,0 +1,142 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseOriginRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    originRule
+		wantErr bool
+	}{
+		{rule: "localhost:*", want: originRule{host: "localhost", port: "*"}},
+		{rule: "https://*.Example.com/", want: originRule{scheme: "https", host: "*.example.com"}},
+		{rule: "http://example.com:8080", want: originRule{scheme: "http", host: "example.com", port: "8080"}},
+		{rule: "[::1]:*", want: originRule{host: "::1", port: "*"}},
+		{rule: "ftp://example.com", wantErr: true},
+		{rule: "", wantErr: true},
+		{rule: "example.com/app", wantErr: true},
+		{rule: "user@example.com", wantErr: true},
+		{rule: "ex*mple.com", wantErr: true},
+		{rule: "*.*.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			got, err := parseOriginRule(tt.rule)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseOriginRule(%q) = %+v, want an error", tt.rule, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("parseOriginRule(%q) = %+v, %v, want %+v", tt.rule, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestAllowOrigin checks the same-origin rule, which holds whatever the
+// allowlist says.
+func TestAllowOrigin(t *testing.T) {
+	tests := []struct {
+		name     string
+		origin   string
+		host     string
+		tls      bool
+		noOrigin bool // ALLOW_NO_ORIGIN
+		proxy    bool // TRUST_PROXY_HEADERS
+		proto    string
+		want     bool
+	}{
+		{name: "no origin", host: "example.com", noOrigin: true, want: true},
+		{name: "no origin refused", host: "example.com", want: false},
+		{name: "same origin over http", origin: "http://example.com", host: "example.com", want: true},
+		{name: "same origin over https", origin: "https://example.com", host: "example.com", tls: true, want: true},
+		{name: "explicit default port", origin: "https://example.com", host: "example.com:443", tls: true, want: true},
+		{name: "host case", origin: "http://EXAMPLE.com", host: "example.COM", want: true},
+		{name: "http origin on https listener", origin: "http://example.com", host: "example.com", tls: true, want: false},
+		{name: "https origin on http listener", origin: "https://example.com", host: "example.com", want: false},
+		{name: "http origin with https port", origin: "http://example.com:443", host: "example.com", tls: true, want: false},
+		{name: "other port", origin: "http://example.com:8080", host: "example.com", want: false},
+		{name: "other host", origin: "http://evil.example", host: "example.com", want: false},
+		{name: "forwarded https", origin: "https://example.com", host: "example.com", proxy: true, proto: "https", want: true},
+		{name: "forwarded https, http origin", origin: "http://example.com", host: "example.com", proxy: true, proto: "https", want: false},
+		{name: "forwarded proto list", origin: "https://example.com", host: "example.com", proxy: true, proto: "HTTPS, http", want: true},
+		{name: "forwarded proto untrusted", origin: "https://example.com", host: "example.com", proto: "https", want: false},
+		{name: "not http", origin: "ftp://example.com", host: "example.com", want: false},
+		{name: "with path", origin: "http://example.com/app", host: "example.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &originPolicy{allowNoOrigin: tt.noOrigin, proxy: proxyTrust{headers: tt.proxy, hops: 1}}
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if got := o.allow(r); got != tt.want {
+				t.Errorf("allow(%q on %q) = %v, want %v", tt.origin, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAllowedOriginSpoofing runs lookalike origins against the default
+// allowlist and a wildcard rule; none may pass for the name they imitate.
+func TestAllowedOriginSpoofing(t *testing.T) {
+	rules, err := parseOriginList(append(strings.Split(defaultAllowedOrigins, ","), "https://*.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "http://localhost", want: true},
+		{origin: "http://localhost:3000", want: true},
+		{origin: "https://LOCALHOST:8443", want: true},
+		{origin: "http://127.0.0.1:5173", want: true},
+		{origin: "http://[::1]:8080", want: true},
+		{origin: "https://app.onrender.com", want: true},
+		{origin: "https://chat.example.com", want: true},
+
+		{origin: "http://evil-localhost.com", want: false},
+		{origin: "http://localhost.attacker.net", want: false},
+		{origin: "http://localhost.attacker.net:3000", want: false},
+		{origin: "http://notlocalhost", want: false},
+		{origin: "http://127.0.0.1.attacker.net", want: false},
+		{origin: "http://localhost@attacker.net", want: false},
+		{origin: "http://attacker.net/localhost", want: false},
+		{origin: "http://app.onrender.com", want: false},
+		{origin: "https://onrender.com", want: false},
+		{origin: "https://evilonrender.com", want: false},
+		{origin: "https://app.onrender.com.attacker.net", want: false},
+		{origin: "https://example.com", want: false},
+		{origin: "https://chat.example.com:8443", want: false},
+		{origin: "https://chat.example.com.attacker.net", want: false},
+		{origin: "https://evil-example.com", want: false},
+		{origin: "null", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			o := &originPolicy{allowed: rules}
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.Host = "chat.internal"
+			r.Header.Set("Origin", tt.origin)
+			if got := o.allow(r); got != tt.want {
+				t.Errorf("allow(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+//go:build otel
+
+package server
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Building with -tags otel links the OpenTelemetry SDK so OTLP_ENDPOINT
+// works. Sampling follows the standard OTEL_TRACES_SAMPLER variables.
+func init() {
+	otelHook = func(ctx context.Context, endpoint string) (spanTracer, func(context.Context) error, error) {
+		// A bare host:port is a collector on plain HTTP, at the usual
+		// /v1/traces path; a URL is used as it is.
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure()}
+		if strings.Contains(endpoint, "://") {
+			opts = []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint)}
+		}
+		exporter, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		provider := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("gochat"))),
+		)
+		otel.SetTracerProvider(provider)
+		return otelTracer{provider.Tracer("github.com/EJ-Edwards/GoChat")}, provider.Shutdown, nil
+	}
+}
+
+type otelTracer struct{ t trace.Tracer }
+
+func (o otelTracer) start(ctx context.Context, name string, attrs ...spanAttr) (context.Context, span) {
+	ctx, s := o.t.Start(ctx, name, trace.WithAttributes(otelAttrs(attrs)...))
+	return ctx, otelSpan{s}
+}
+
+type otelSpan struct{ s trace.Span }
+
+func (o otelSpan) set(attrs ...spanAttr) { o.s.SetAttributes(otelAttrs(attrs)...) }
+
+func (o otelSpan) fail(err error) {
+	o.s.RecordError(err)
+	o.s.SetStatus(codes.Error, err.Error())
+}
+
+func (o otelSpan) end() { o.s.End() }
+
+func otelAttrs(attrs []spanAttr) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		switch v := a.value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(a.key, v))
+		case bool:
+			kvs = append(kvs, attribute.Bool(a.key, v))
+		case int:
+			kvs = append(kvs, attribute.Int(a.key, v))
+		case uint64:
+			kvs = append(kvs, attribute.Int64(a.key, int64(v)))
+		}
+	}
+	return kvs
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"time"
+	"unicode/utf8"
+)
+
+// Room passwords are checked on the run loop for every join, so the KDF
+// cost is kept low; the hash never leaves memory.
+const (
+	passwordIter   = 4096
+	maxPasswordLen = 128
+)
+
+// roomPassword is the salted hash of a room's join password.
+type roomPassword struct {
+	salt    []byte
+	hash    []byte
+	expires time.Time // zero never expires
+}
+
+func validatePassword(pw string) error {
+	if utf8.RuneCountInString(pw) > maxPasswordLen {
+		return errors.New("password must be at most 128 characters")
+	}
+	return nil
+}
+
+func newRoomPassword(pw string, ttl time.Duration) *roomPassword {
+	p := &roomPassword{salt: make([]byte, 16)}
+	_, _ = rand.Read(p.salt)
+	p.hash = p.derive(pw)
+	if ttl > 0 {
+		p.expires = time.Now().Add(ttl)
+	}
+	return p
+}
+
+func (p *roomPassword) derive(pw string) []byte {
+	key, _ := pbkdf2.Key(sha256.New, pw, p.salt, passwordIter, sha256.Size)
+	return key
+}
+
+func (p *roomPassword) matches(pw string) bool {
+	return hmac.Equal(p.derive(pw), p.hash)
+}
+
+// checkPassword decides whether client may join a room that may be
+// password protected. The creator's password, if any, protects the room.
+// Only run may call it.
+func (h *Hub) checkPassword(client *Client, creating bool, now time.Time) bool {
+	if h.password != nil && !h.password.expires.IsZero() && now.After(h.password.expires) {
+		h.log.Info("room password expired")
+		h.password = nil
+		h.locked.Store(false)
+	}
+	if h.password == nil {
+		if creating && client.password != "" {
+			h.password = newRoomPassword(client.password, h.manager.passwordTTL)
+			h.locked.Store(true)
+		}
+		return true
+	}
+	return h.password.matches(client.password)
+}
@@ -0,0 +1,55 @@
+package server
+
+// Actions gated per message by the auth policy.
+const (
+	actionMsg   = "msg"
+	actionReact = "react"
+	actionCall  = "call"
+)
+
+var knownActions = map[string]bool{actionMsg: true, actionReact: true, actionCall: true}
+
+// actionForType maps a client message type to the action it performs.
+var actionForType = map[string]string{
+	"chat":     actionMsg,
+	"typing":   actionMsg,
+	"dm":       actionMsg,
+	"reaction": actionReact,
+	"edit":     actionMsg,
+	"delete":   actionMsg,
+	"key":      actionMsg,
+
+	"offer":         actionCall,
+	"answer":        actionCall,
+	"ice-candidate": actionCall,
+}
+
+// authPolicy maps auth state to permitted actions. Authenticated clients
+// may do anything; anonymous (token-less) clients only what is listed.
+type authPolicy struct {
+	anonymous map[string]bool
+}
+
+// newAuthPolicy builds the policy from the configured anonymous actions.
+// An empty list means no restriction and returns nil; "none" alone makes
+// anonymous clients read-only.
+func newAuthPolicy(anonymous []string) *authPolicy {
+	if len(anonymous) == 0 {
+		return nil
+	}
+	p := &authPolicy{anonymous: make(map[string]bool)}
+	for _, a := range anonymous {
+		if a != "none" {
+			p.anonymous[a] = true
+		}
+	}
+	return p
+}
+
+// allowed reports whether c may perform action under the manager's policy.
+func (m *HubManager) allowed(c *Client, action string) bool {
+	if m.policy == nil || c.userID != "" || action == "" {
+		return true
+	}
+	return m.policy.anonymous[action]
+}
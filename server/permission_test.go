@@ -0,0 +1,52 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthPolicyAllowed(t *testing.T) {
+	anon, signedIn := &Client{}, &Client{userID: "user:amy"}
+	tests := []struct {
+		name   string
+		anon   []string
+		client *Client
+		action string
+		want   bool
+	}{
+		{name: "no policy", client: anon, action: actionMsg, want: true},
+		{name: "listed action", anon: []string{"react"}, client: anon, action: actionReact, want: true},
+		{name: "unlisted action", anon: []string{"react"}, client: anon, action: actionMsg, want: false},
+		{name: "read-only", anon: []string{"none"}, client: anon, action: actionReact, want: false},
+		{name: "signed in", anon: []string{"none"}, client: signedIn, action: actionMsg, want: true},
+		{name: "ungated type", anon: []string{"none"}, client: anon, action: "", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &HubManager{policy: newAuthPolicy(tt.anon)}
+			if got := m.allowed(tt.client, tt.action); got != tt.want {
+				t.Errorf("allowed(%s) = %v, want %v", tt.action, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnonymousPolicy(t *testing.T) {
+	_, ts := startServer(t, func(cfg *Config) { cfg.AnonActions = []string{actionReact} })
+	guest := dial(t, ts, "1234", "", nil)
+	guest.expect("system")
+
+	guest.send(map[string]any{"type": "chat", "msg": "me too"})
+	if code := guest.expect("error")["code"]; code != "auth_required" {
+		t.Errorf("anonymous chat error = %v, want auth_required", code)
+	}
+	for {
+		f, err := guest.next(200 * time.Millisecond)
+		if err != nil {
+			break
+		}
+		if f["type"] == "chat" {
+			t.Error("anonymous chat reached the room")
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// maxPins bounds how many messages a room may pin at once.
+const maxPins = 3
+
+// pinnedIDs lists the ids of the room's pinned messages in pin order.
+func (h *Hub) pinnedIDs() []string {
+	ids := make([]string, len(h.pins))
+	for i, p := range h.pins {
+		ids[i] = p.id
+	}
+	return ids
+}
+
+// pinnedFrames returns the pinned messages themselves for the welcome payload.
+func (h *Hub) pinnedFrames() []json.RawMessage {
+	frames := make([]json.RawMessage, len(h.pins))
+	for i, p := range h.pins {
+		frames[i] = p.frame
+	}
+	return frames
+}
+
+// updatePins handles pin and unpin requests from owners and moderators.
+func (h *Hub) updatePins(msg *Message) {
+	if !msg.from.can(permPin) {
+		msg.from.trySend(errorFrame(codeForbidden, "only owners and moderators can pin messages"))
+		return
+	}
+
+	idx := -1
+	for i, p := range h.pins {
+		if p.id == msg.ID {
+			idx = i
+		}
+	}
+
+	switch msg.Type {
+	case "pin":
+		if idx >= 0 {
+			return
+		}
+		if len(h.pins) >= maxPins {
+			msg.from.trySend(errorFrame(codeTooManyPins, "a room can pin at most 3 messages"))
+			return
+		}
+		entry, ok := h.findHistory(msg.ID)
+		if !ok {
+			msg.from.trySend(errorFrame(codeNotFound, "no message with id "+`"`+msg.ID+`"`))
+			return
+		}
+		h.pins = append(h.pins, entry)
+	case "unpin":
+		if idx < 0 {
+			msg.from.trySend(errorFrame(codeNotFound, "message "+`"`+msg.ID+`"`+" is not pinned"))
+			return
+		}
+		h.pins = append(h.pins[:idx], h.pins[idx+1:]...)
+	}
+
+	h.pinsChanged()
+}
+
+// pinsChanged tells the room the current pins, with the messages
+// themselves for members who never saw them. Only run may call it.
+func (h *Hub) pinsChanged() {
+	h.log.Info("pins changed", "pins", h.pinnedIDs())
+	h.savePins()
+	h.fanOut(h.frame(&Message{Type: "pinned", IDs: h.pinnedIDs(), Pinned: h.pinnedFrames()}))
+}
+
+func init() {
+	registerCommand(&command{name: "announce", args: "<text>", help: "post a message and pin it", perm: permPin, run: (*Hub).announceCommand})
+}
+
+// announceCommand posts an announcement and pins it, unpinning the oldest
+// pin if the room already has maxPins.
+func (h *Hub) announceCommand(msg *Message, args string) {
+	if args == "" {
+		usageError(msg.from, "announce")
+		return
+	}
+	msg.Msg, msg.Announcement = args, true
+	seq := h.seq
+	h.broadcastChat(msg)
+	if h.seq == seq {
+		return // refused, and the sender told why
+	}
+	if len(h.pins) >= maxPins {
+		h.pins = h.pins[1:]
+	}
+	h.pins = append(h.pins, historyEntry{id: msg.ID, channel: msg.Channel, at: time.Now(), frame: h.frame(msg)})
+	h.pinsChanged()
+}
+
+// savePins queues the room's pins for the store so they outlast the room,
+// unless the room is ephemeral. Only run may call it.
+func (h *Hub) savePins() {
+	if h.ephemeral {
+		return
+	}
+	pins := make([]StoredMessage, len(h.pins))
+	for i, p := range h.pins {
+		pins[i] = StoredMessage{Room: h.pin, ID: p.id, At: p.at, Frame: p.frame}
+	}
+	h.manager.persist.savePins(h.pin, pins)
+}
+
+// loadPins restores the room's pins from the store. Only run may call it,
+// before serving any client.
+func (h *Hub) loadPins(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, historyLoadTimeout)
+	defer cancel()
+	pins, err := h.manager.store.Pins(ctx, h.pin)
+	if err != nil {
+		h.log.Error("loading pins failed", "err", err)
+		return
+	}
+	for _, m := range pins {
+		h.pins = append(h.pins, historyEntry{id: m.ID, channel: frameChannel(m.Frame), at: m.At, frame: m.Frame})
+	}
+}
+
+// findHistory looks up a retained message by id.
+func (h *Hub) findHistory(id string) (historyEntry, bool) {
+	for _, e := range h.history {
+		if e.id == id {
+			return e, true
+		}
+	}
+	return historyEntry{}, false
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPinRequests(t *testing.T) {
+	_, ts := startServer(t, nil)
+	amy := dial(t, ts, "1234", "amy", nil) // the owner
+	amy.expect("system")
+	bob := dial(t, ts, "1234", "bob", nil)
+	bob.expect("system")
+	ids := make([]string, maxPins+1)
+	for i := range ids {
+		amy.send(map[string]any{"type": "chat", "msg": fmt.Sprint("message ", i)})
+		ids[i] = amy.expectMsg("chat", fmt.Sprint("message ", i))["id"].(string)
+	}
+	conns := map[string]*testConn{"amy": amy, "bob": bob}
+
+	// Each step builds on the pins the ones before it left.
+	steps := []struct {
+		name    string
+		who     string
+		typ     string
+		id      string
+		wantIDs []string
+		wantErr string
+	}{
+		{name: "member cannot pin", who: "bob", typ: "pin", id: ids[0], wantErr: "forbidden"},
+		{name: "owner pins", who: "amy", typ: "pin", id: ids[0], wantIDs: ids[:1]},
+		{name: "unknown message", who: "amy", typ: "pin", id: "nope", wantErr: "not_found"},
+		{name: "second pin", who: "amy", typ: "pin", id: ids[1], wantIDs: ids[:2]},
+		{name: "up to the limit", who: "amy", typ: "pin", id: ids[2], wantIDs: ids[:3]},
+		{name: "past the limit", who: "amy", typ: "pin", id: ids[3], wantErr: "too_many_pins"},
+		{name: "member cannot unpin", who: "bob", typ: "unpin", id: ids[0], wantErr: "forbidden"},
+		{name: "owner unpins", who: "amy", typ: "unpin", id: ids[1], wantIDs: []string{ids[0], ids[2]}},
+		{name: "not pinned", who: "amy", typ: "unpin", id: ids[1], wantErr: "not_found"},
+		{name: "room for one more", who: "amy", typ: "pin", id: ids[3], wantIDs: []string{ids[0], ids[2], ids[3]}},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			c := conns[tt.who]
+			c.send(map[string]any{"type": tt.typ, "id": tt.id})
+			var reply map[string]any
+			for reply == nil {
+				f, err := c.next(testWait)
+				if err != nil {
+					t.Fatalf("waiting for a reply: %v", err)
+				}
+				if f["type"] == "pinned" || f["type"] == "error" {
+					reply = f
+				}
+			}
+			if tt.wantErr != "" {
+				if reply["code"] != tt.wantErr {
+					t.Fatalf("reply = %v, want error %q", reply, tt.wantErr)
+				}
+				return
+			}
+			if reply["type"] != "pinned" {
+				t.Fatalf("reply = %v, want pinned", reply)
+			}
+			got := fmt.Sprint(reply["ids"])
+			if want := fmt.Sprint(tt.wantIDs); got != want {
+				t.Errorf("pinned ids = %s, want %s", got, want)
+			}
+			// Everyone else hears about it too.
+			for who, other := range conns {
+				if who != tt.who {
+					if got := fmt.Sprint(other.expect("pinned")["ids"]); got != fmt.Sprint(tt.wantIDs) {
+						t.Errorf("%s saw pinned ids %s, want %v", who, got, tt.wantIDs)
+					}
+				}
+			}
+		})
+	}
+
+	// A member joining later is shown the pinned messages themselves.
+	carol := dial(t, ts, "1234", "carol", nil)
+	pinned, _ := carol.expect("system")["pinned"].([]any)
+	var got []any
+	for _, p := range pinned {
+		got = append(got, p.(map[string]any)["id"])
+	}
+	if want := fmt.Sprint([]string{ids[0], ids[2], ids[3]}); fmt.Sprint(got) != want {
+		t.Errorf("welcome pinned ids = %v, want %s", got, want)
+	}
+}
+
+func TestPinsOutlastRoom(t *testing.T) {
+	s, ts := startServer(t, nil)
+
+	owner := dial(t, ts, "1234", "", nil)
+	owner.expect("system")
+	owner.send(map[string]any{"type": "chat", "msg": "read the rules"})
+	id := owner.expect("chat")["id"].(string)
+	owner.send(map[string]any{"type": "pin", "id": id})
+	owner.expect("pinned")
+	owner.conn.Close()
+
+	deadline := time.Now().Add(testWait)
+	for {
+		pins, _ := s.manager.store.Pins(context.Background(), "1234")
+		if len(pins) == 1 && s.manager.lookup("1234") == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("room still open or pins not stored: %d pins", len(pins))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	member := dial(t, ts, "1234", "", nil)
+	pinned, _ := member.expect("system")["pinned"].([]any)
+	if len(pinned) != 1 || pinned[0].(map[string]any)["id"] != id {
+		t.Fatalf("welcome pinned = %v, want the message %s", pinned, id)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// frameReadLimit is the largest frame a socket reads at all. Frames over
+// maxMessageSize but under this are answered with a too_large error;
+// anything larger closes the connection with 1009 before it is buffered.
+func (l wireLimits) frameReadLimit() int64 {
+	return 4 * l.maxMessageSize
+}
+
+// checkFrame applies the limits every transport shares to a raw client
+// frame, before it is parsed.
+func (l wireLimits) checkFrame(data []byte) *parseError {
+	if int64(len(data)) > l.maxMessageSize {
+		return &parseError{codeTooLarge, "frame exceeds " + strconv.FormatInt(l.maxMessageSize, 10) + " bytes"}
+	}
+	if l.rejectInvalidUTF8 && !utf8.Valid(data) {
+		return &parseError{codeInvalidUTF8, "frame is not valid UTF-8"}
+	}
+	return nil
+}
+
+// stripControl removes control characters other than newline and tab, so
+// a message cannot move the cursor, ring bells or hide text in clients
+// that render it raw.
+func stripControl(s string) string {
+	clean := func(r rune) bool { return r != '\n' && r != '\t' && unicode.IsControl(r) }
+	if strings.IndexFunc(s, clean) < 0 {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if clean(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
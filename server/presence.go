@@ -0,0 +1,99 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Member is one entry of a room's presence list.
+type Member struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	UserID    string            `json:"user_id,omitempty"`
+	Avatar    string            `json:"avatar"`
+	Owner     bool              `json:"owner,omitempty"`
+	Role      string            `json:"role"`
+	Spectator bool              `json:"spectator,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	Conn      *ConnInfo         `json:"conn,omitempty"`
+
+	// client lets readers refresh the connection's latency.
+	client *Client
+}
+
+// presenceSnapshot is the published view of who is in a room, readable
+// from any goroutine. members carry redacted connection info and admin
+// the full info.
+type presenceSnapshot struct {
+	enabled bool
+	members []Member
+	admin   []Member
+}
+
+// memberList builds the sorted member list, with full or redacted
+// connection info. Only run may call it.
+func (h *Hub) memberList(full bool) []Member {
+	members := make([]Member, 0, len(h.clients))
+	for c := range h.clients {
+		conn := c.info.redacted()
+		if full {
+			info := c.info
+			conn = &info
+		}
+		conn.LatencyMS = latencyMS(c.latency())
+		members = append(members, Member{ID: c.id, Name: c.name, UserID: c.userID, Avatar: c.avatarURL(), Owner: c.role == roleOwner, Role: string(c.role), Spectator: c.spectator, Meta: c.meta, Conn: conn, client: c})
+	}
+	members = append(members, h.parkedMembers(full)...)
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// presenceChanged republishes the snapshot and, if presence is on, tells
+// the room. Only run may call it.
+func (h *Hub) presenceChanged() {
+	members := h.memberList(false)
+	h.presence.Store(&presenceSnapshot{enabled: h.features[featurePresence], members: members, admin: h.memberList(true)})
+	h.manager.announcePresence(h)
+	if h.features[featurePresence] {
+		h.fanOut(h.frame(&Message{Type: "presence", Members: members}))
+	}
+}
+
+// members returns the last published member list and whether presence is
+// enabled for the room.
+func (h *Hub) members() ([]Member, bool) {
+	snap := h.presence.Load()
+	if snap == nil {
+		return nil, true // nobody admitted yet
+	}
+	return snap.members, snap.enabled
+}
+
+// handleRoomMembers serves GET /rooms/{pin}/members, listing the room's
+// members on every instance when running clustered.
+func handleRoomMembers(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	pin := r.PathValue("pin")
+	hub := manager.lookup(pin)
+	remote, count, instances, hidden := manager.cluster.lookup(pin, time.Now())
+	if hub == nil && instances == 0 {
+		writeError(w, http.StatusNotFound, codeRoomNotFound, "room not found")
+		return
+	}
+	var members []Member
+	if hub != nil {
+		var enabled bool
+		members, enabled = hub.members()
+		hidden = hidden || !enabled
+		count += len(members)
+		if len(members) > 0 {
+			instances++
+		}
+	}
+	if hidden {
+		writeError(w, http.StatusForbidden, codeFeatureDisabled, "presence is disabled in this room")
+		return
+	}
+	members = clusterMembers(withLatency(members), remote)
+	writeJSON(w, http.StatusOK, map[string]any{"pin": pin, "count": count, "instances": instances, "members": members})
+}
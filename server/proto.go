@@ -0,0 +1,290 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// WebSocket subprotocols selecting the frame encoding. JSON is the
+// default; gochat.v1.proto carries each frame as a protobuf Envelope (see
+// proto/gochat.proto) in a binary message.
+const (
+	subprotoJSON  = "gochat.v1.json"
+	subprotoProto = "gochat.v1.proto"
+)
+
+// Protobuf wire types used by Envelope.
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireBytes  = 2
+	wireI32    = 5
+)
+
+// protoField is how one JSON key maps onto an Envelope field.
+type protoField struct {
+	num  uint64
+	kind byte // 's'tring, 'u'int64, 'i'nt64 or 'b'ool
+}
+
+// extraField carries every JSON key not listed in protoFields.
+const extraField = 15
+
+var protoFields = map[string]protoField{
+	"type":          {1, 's'},
+	"id":            {2, 's'},
+	"room":          {3, 's'},
+	"user":          {4, 's'},
+	"msg":           {5, 's'},
+	"ts":            {6, 's'},
+	"contentType":   {7, 's'},
+	"seq":           {8, 'u'},
+	"to":            {9, 's'},
+	"from":          {10, 's'},
+	"client_msg_id": {11, 's'},
+	"server_id":     {12, 's'},
+	"code":          {13, 's'},
+	"reason":        {14, 's'},
+	"msg_id":        {16, 's'},
+	"emoji":         {17, 's'},
+	"remove":        {18, 'b'},
+	"edited":        {19, 's'},
+	"emote":         {20, 'b'},
+	"name":          {21, 's'},
+	"url":           {22, 's'},
+	"filename":      {23, 's'},
+	"size":          {24, 'i'},
+	"session":       {25, 's'},
+	"room_name":     {26, 's'},
+	"sdp":           {27, 's'},
+	"key":           {28, 's'},
+	"epoch":         {29, 'u'},
+	"count":         {30, 'i'},
+	"bot":           {31, 'b'},
+	"role":          {32, 's'},
+	"channel":       {33, 's'},
+	"parent_id":     {34, 's'},
+	"announcement":  {35, 'b'},
+	"resume":        {36, 's'},
+	"resumed":       {37, 'b'},
+	"detail":        {38, 's'},
+}
+
+// protoKeys is protoFields inverted.
+var protoKeys = func() map[uint64]string {
+	m := make(map[uint64]string, len(protoFields))
+	for k, f := range protoFields {
+		m[f.num] = k
+	}
+	return m
+}()
+
+var errBadProto = errors.New("malformed protobuf frame")
+
+// jsonToProto transcodes a JSON frame into an Envelope. Values whose JSON
+// type does not match their field go to extra unchanged.
+func jsonToProto(frame []byte) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(frame, &obj); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]byte, 0, len(frame))
+	extra := make(map[string]json.RawMessage)
+	for _, k := range keys {
+		raw := obj[k]
+		f, ok := protoFields[k]
+		if !ok {
+			extra[k] = raw
+			continue
+		}
+		switch f.kind {
+		case 's':
+			var s string
+			if json.Unmarshal(raw, &s) != nil {
+				extra[k] = raw
+				continue
+			}
+			out = appendTag(out, f.num, wireBytes)
+			out = appendVarint(out, uint64(len(s)))
+			out = append(out, s...)
+		case 'u':
+			var n uint64
+			if json.Unmarshal(raw, &n) != nil {
+				extra[k] = raw
+				continue
+			}
+			out = appendVarint(appendTag(out, f.num, wireVarint), n)
+		case 'i':
+			var n int64
+			if json.Unmarshal(raw, &n) != nil {
+				extra[k] = raw
+				continue
+			}
+			out = appendVarint(appendTag(out, f.num, wireVarint), uint64(n))
+		case 'b':
+			var b bool
+			if json.Unmarshal(raw, &b) != nil {
+				extra[k] = raw
+				continue
+			}
+			v := uint64(0)
+			if b {
+				v = 1
+			}
+			out = appendVarint(appendTag(out, f.num, wireVarint), v)
+		}
+	}
+	if len(extra) > 0 {
+		b, err := json.Marshal(extra)
+		if err != nil {
+			return nil, err
+		}
+		out = appendTag(out, extraField, wireBytes)
+		out = appendVarint(out, uint64(len(b)))
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// protoToJSON transcodes an Envelope into the equivalent JSON frame.
+// Unknown field numbers are skipped.
+func protoToJSON(data []byte) ([]byte, error) {
+	obj := make(map[string]any)
+	var extra map[string]json.RawMessage
+	for len(data) > 0 {
+		tag, n := readVarint(data)
+		if n <= 0 {
+			return nil, errBadProto
+		}
+		data = data[n:]
+		num, wt := tag>>3, tag&7
+		var (
+			v   uint64
+			buf []byte
+		)
+		switch wt {
+		case wireVarint:
+			if v, n = readVarint(data); n <= 0 {
+				return nil, errBadProto
+			}
+		case wireBytes:
+			l, m := readVarint(data)
+			if m <= 0 || l > uint64(len(data)-m) {
+				return nil, errBadProto
+			}
+			buf, n = data[m:m+int(l)], m+int(l)
+		case wireI64:
+			n = 8
+		case wireI32:
+			n = 4
+		default:
+			return nil, errBadProto
+		}
+		if n > len(data) {
+			return nil, errBadProto
+		}
+		data = data[n:]
+
+		if num == extraField && wt == wireBytes {
+			if err := json.Unmarshal(buf, &extra); err != nil {
+				return nil, errBadProto
+			}
+			continue
+		}
+		key, ok := protoKeys[num]
+		if !ok {
+			continue
+		}
+		switch f := protoFields[key]; {
+		case f.kind == 's' && wt == wireBytes:
+			obj[key] = string(buf)
+		case f.kind == 'u' && wt == wireVarint:
+			obj[key] = v
+		case f.kind == 'i' && wt == wireVarint:
+			obj[key] = int64(v)
+		case f.kind == 'b' && wt == wireVarint:
+			obj[key] = v != 0
+		default:
+			return nil, errBadProto
+		}
+	}
+	for k, raw := range extra {
+		if _, ok := obj[k]; !ok {
+			obj[k] = raw
+		}
+	}
+	return json.Marshal(obj)
+}
+
+// protoCacheSize is how many recent transcodings are kept.
+const protoCacheSize = 64
+
+// protoCache remembers recent transcodings, keyed by the frame's backing
+// array: a room queues the same slice for every member, so a frame is
+// transcoded once however many binary clients receive it. Each entry holds
+// its source slice, so that address cannot be reused while cached.
+type protoCache struct {
+	mu      sync.Mutex
+	next    int
+	entries [protoCacheSize]struct{ src, out []byte }
+}
+
+var frameCache protoCache
+
+// encode returns frame as an Envelope.
+func (pc *protoCache) encode(frame []byte) ([]byte, error) {
+	if len(frame) == 0 {
+		return jsonToProto(frame)
+	}
+	pc.mu.Lock()
+	for _, e := range pc.entries {
+		if len(e.src) == len(frame) && &e.src[0] == &frame[0] {
+			pc.mu.Unlock()
+			return e.out, nil
+		}
+	}
+	pc.mu.Unlock()
+
+	out, err := jsonToProto(frame)
+	if err != nil {
+		return nil, err
+	}
+	pc.mu.Lock()
+	pc.entries[pc.next].src, pc.entries[pc.next].out = frame, out
+	pc.next = (pc.next + 1) % protoCacheSize
+	pc.mu.Unlock()
+	return out, nil
+}
+
+func appendTag(b []byte, num uint64, wt byte) []byte {
+	return appendVarint(b, num<<3|uint64(wt))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// readVarint decodes a varint, returning the bytes used or 0 if data is
+// truncated or the value overflows.
+func readVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		v |= uint64(data[i]&0x7f) << (7 * i)
+		if data[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,479 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Web Push (RFC 8030) delivers notifications to browsers whose tab is
+// closed. Requests are authorised with VAPID (RFC 8292) and payloads
+// encrypted to the subscription's keys (RFC 8291).
+const (
+	pushWorkers   = 4
+	pushQueueSize = 256
+	pushTimeout   = 10 * time.Second
+	// pushTTL is how long the push service holds a notification for a
+	// browser that is offline.
+	pushTTL = 24 * time.Hour
+	// vapidTokenTTL is the lifetime of each VAPID token; RFC 8292 caps
+	// it at a day.
+	vapidTokenTTL = 12 * time.Hour
+	// pushRecordSize is the aes128gcm record size. Payloads fit in one
+	// record.
+	pushRecordSize = 4096
+	// maxPushSubscriptions bounds the browsers one user may subscribe.
+	maxPushSubscriptions = 10
+	maxPushEndpoint      = 1024
+	// maxPushPreview bounds the message text a notification carries.
+	maxPushPreview = 120
+	// maxAwayMembers bounds how many members who left a room it keeps
+	// notifying.
+	maxAwayMembers = 1000
+)
+
+var errTooManyPushSubscriptions = errors.New("too many push subscriptions")
+
+// PushSubscription is a browser's push endpoint, as PushSubscription.toJSON
+// returns it, and the signed-in user it notifies.
+type PushSubscription struct {
+	UserID   string    `json:"-"`
+	Endpoint string    `json:"endpoint"`
+	Keys     PushKeys  `json:"keys"`
+	Created  time.Time `json:"-"`
+}
+
+// PushKeys are a subscription's encryption keys, base64url encoded: the
+// browser's P-256 public key and its 16-byte auth secret.
+type PushKeys struct {
+	P256DH string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+func (s *PushSubscription) validate() error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" || len(s.Endpoint) > maxPushEndpoint {
+		return fmt.Errorf("endpoint must be an https URL of at most %d characters", maxPushEndpoint)
+	}
+	key, err := decodePushKey(s.Keys.P256DH)
+	if err == nil {
+		_, err = ecdh.P256().NewPublicKey(key)
+	}
+	if err != nil {
+		return errors.New("keys.p256dh must be a base64url P-256 public key")
+	}
+	if auth, err := decodePushKey(s.Keys.Auth); err != nil || len(auth) != 16 {
+		return errors.New("keys.auth must be 16 base64url bytes")
+	}
+	return nil
+}
+
+// decodePushKey decodes base64url with or without padding, as browsers
+// and libraries differ.
+func decodePushKey(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// parseVAPIDKey parses VAPID_PRIVATE_KEY, a base64url P-256 private
+// scalar.
+func parseVAPIDKey(s string) (*ecdsa.PrivateKey, error) {
+	b, err := decodePushKey(s)
+	if err != nil {
+		return nil, err
+	}
+	return ecdsa.ParseRawPrivateKey(elliptic.P256(), b)
+}
+
+// GenerateVAPIDKeys returns a new VAPID key pair, base64url encoded: the
+// public key browsers subscribe with and the private key for
+// VAPID_PRIVATE_KEY.
+func GenerateVAPIDKeys() (publicKey, privateKey string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	priv, err := key.Bytes()
+	if err != nil {
+		return "", "", err
+	}
+	pub, err := key.PublicKey.Bytes()
+	if err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(pub), base64.RawURLEncoding.EncodeToString(priv), nil
+}
+
+// pushNotice is the payload a service worker receives: a mention or a dm
+// for a member who has left the room.
+type pushNotice struct {
+	Type string `json:"type"`
+	Room string `json:"room"`
+	User string `json:"user"`
+	Msg  string `json:"msg,omitempty"`
+	TS   string `json:"ts"`
+}
+
+// pushJob is one notice for every browser of one user.
+type pushJob struct {
+	userID string
+	notice []byte
+}
+
+// pusher sends Web Push notifications from its own goroutine. Like a
+// webhook, a push service that falls too far behind loses notifications
+// rather than slowing rooms.
+type pusher struct {
+	key *ecdsa.PrivateKey
+	// publicKey is the applicationServerKey browsers subscribe with.
+	publicKey string
+	subject   string
+	store     Store
+	// client reaches only public addresses, as subscribers choose the
+	// endpoints, and follows no redirects, which push services never
+	// send.
+	client *http.Client
+	queue  chan pushJob
+}
+
+func newPusher(cfg *Config, store Store) (*pusher, error) {
+	key, err := parseVAPIDKey(cfg.VAPIDPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("VAPID_PRIVATE_KEY: %w", err)
+	}
+	pub, err := key.PublicKey.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &pusher{
+		key:       key,
+		publicKey: base64.RawURLEncoding.EncodeToString(pub),
+		subject:   cfg.VAPIDSubject,
+		store:     store,
+		client:    publicClient(pushTimeout, 0),
+		queue:     make(chan pushJob, pushQueueSize),
+	}, nil
+}
+
+// notify queues a notice for userID without blocking.
+func (p *pusher) notify(userID string, n pushNotice) {
+	if p == nil {
+		return
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return
+	}
+	select {
+	case p.queue <- pushJob{userID: userID, notice: body}:
+	default:
+		slog.Warn("push queue full, dropping notification", "user_id", userID)
+	}
+}
+
+// run starts pushWorkers workers, which send queued notices until ctx is
+// done. A slow push service holds up at most one of them.
+func (p *pusher) run(ctx context.Context) {
+	for range pushWorkers {
+		go func() {
+			for {
+				select {
+				case job := <-p.queue:
+					p.deliver(ctx, job)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// deliver sends one notice to each of the user's subscriptions, forgetting
+// those the push service says are gone.
+func (p *pusher) deliver(ctx context.Context, job pushJob) {
+	lookup, cancel := context.WithTimeout(ctx, accountTimeout)
+	subs, err := p.store.PushSubscriptions(lookup, job.userID)
+	cancel()
+	if err != nil {
+		slog.Warn("loading push subscriptions failed", "user_id", job.userID, "err", err)
+		return
+	}
+	for _, sub := range subs {
+		gone, err := p.send(ctx, sub, job.notice)
+		if gone {
+			slog.Info("push subscription expired", "user_id", job.userID, "err", err)
+			del, cancel := context.WithTimeout(ctx, accountTimeout)
+			_ = p.store.DeletePushSubscription(del, job.userID, sub.Endpoint)
+			cancel()
+		} else if err != nil {
+			slog.Warn("push delivery failed", "user_id", job.userID, "err", err)
+		}
+	}
+}
+
+// send makes one push request and reports whether the subscription no
+// longer exists.
+func (p *pusher) send(ctx context.Context, sub PushSubscription, notice []byte) (bool, error) {
+	body, err := encryptPush(sub, notice)
+	if err != nil {
+		return false, err
+	}
+	auth, err := p.vapidAuth(sub.Endpoint, time.Now())
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprint(int(pushTTL.Seconds())))
+	req.Header.Set("Urgency", "high")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
+
+// vapidAuth returns the Authorization header for a push to endpoint: an
+// ES256 token for the push service's origin and the public key.
+func (p *pusher) vapidAuth(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidTokenTTL).Unix(),
+		"sub": p.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, p.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS wants the raw 64-byte r || s, not ASN.1.
+	var sig [64]byte
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return "vapid t=" + unsigned + "." + base64.RawURLEncoding.EncodeToString(sig[:]) + ", k=" + p.publicKey, nil
+}
+
+// encryptPush encrypts plaintext to sub's keys as a single aes128gcm
+// record, following RFC 8291 section 3.
+func encryptPush(sub PushSubscription, plaintext []byte) ([]byte, error) {
+	uaKey, err := decodePushKey(sub.Keys.P256DH)
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := decodePushKey(sub.Keys.Auth)
+	if err != nil {
+		return nil, err
+	}
+	ua, err := ecdh.P256().NewPublicKey(uaKey)
+	if err != nil {
+		return nil, err
+	}
+	as, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := as.ECDH(ua)
+	if err != nil {
+		return nil, err
+	}
+	asKey := as.PublicKey().Bytes()
+
+	ikm, err := hkdf.Key(sha256.New, shared, authSecret, "WebPush: info\x00"+string(uaKey)+string(asKey), 32)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	_, _ = rand.Read(salt)
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(plaintext)+1+gcm.Overhead() > pushRecordSize {
+		return nil, errors.New("push payload too large")
+	}
+
+	// The header is the salt, record size and sender key; the record is
+	// the plaintext with the last-record delimiter 0x02.
+	out := make([]byte, 0, len(salt)+4+1+len(asKey)+len(plaintext)+1+gcm.Overhead())
+	out = append(out, salt...)
+	out = binary.BigEndian.AppendUint32(out, pushRecordSize)
+	out = append(out, byte(len(asKey)))
+	out = append(out, asKey...)
+	record := append(append([]byte(nil), plaintext...), 2)
+	return gcm.Seal(out, nonce, record, nil), nil
+}
+
+// handleKey serves GET /api/push/key, the applicationServerKey to
+// subscribe with.
+func (p *pusher) handleKey(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"public_key": p.publicKey})
+}
+
+// handleSubscribe serves POST /api/push/subscriptions with a browser's
+// PushSubscription, notifying the signed-in caller there from now on.
+func (p *pusher) handleSubscribe(signin *signIn, w http.ResponseWriter, r *http.Request) {
+	claims := signin.caller(r)
+	if claims == nil {
+		writeError(w, http.StatusUnauthorized, codeAuthRequired, "not signed in")
+		return
+	}
+	var sub PushSubscription
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&sub); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body")
+		return
+	}
+	if err := sub.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	sub.UserID, sub.Created = claims.Subject, time.Now().UTC()
+	ctx, cancel := context.WithTimeout(r.Context(), accountTimeout)
+	defer cancel()
+	switch err := p.store.SavePushSubscription(ctx, sub, maxPushSubscriptions); {
+	case errors.Is(err, errTooManyPushSubscriptions):
+		writeError(w, http.StatusConflict, codeTooManySubscriptions, err.Error())
+		return
+	case err != nil:
+		slog.Error("saving push subscription failed", "user_id", sub.UserID, "err", err)
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "push unavailable")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"endpoint": sub.Endpoint})
+}
+
+// handleUnsubscribe serves DELETE /api/push/subscriptions with
+// {"endpoint":"..."}.
+func (p *pusher) handleUnsubscribe(signin *signIn, w http.ResponseWriter, r *http.Request) {
+	claims := signin.caller(r)
+	if claims == nil {
+		writeError(w, http.StatusUnauthorized, codeAuthRequired, "not signed in")
+		return
+	}
+	var body struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil || body.Endpoint == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "endpoint is required")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), accountTimeout)
+	defer cancel()
+	if err := p.store.DeletePushSubscription(ctx, claims.Subject, body.Endpoint); err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "push unavailable")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// leftRoom records a signed-in member who disconnected, so mentions and
+// dms can still reach them, or forgets one who left for good. Only run
+// may call it.
+func (h *Hub) leftRoom(c *Client) {
+	if c.userID == "" || h.ephemeral || h.manager.push == nil {
+		return
+	}
+	if c.leaveReason != leaveDisconnected {
+		delete(h.away, c.userID)
+		return
+	}
+	if h.away == nil {
+		h.away = make(map[string]string)
+	}
+	if _, ok := h.away[c.userID]; ok || len(h.away) < maxAwayMembers {
+		h.away[c.userID] = c.name
+	}
+}
+
+// connected reports whether any of userID's connections is in the room.
+// Only run may call it.
+func (h *Hub) connected(userID string) bool {
+	for c := range h.clients {
+		if c.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// pushMentions notifies the away members a chat message mentions. Only
+// run may call it.
+func (h *Hub) pushMentions(msg *Message) {
+	if len(h.away) == 0 || msg.opaque() {
+		return
+	}
+	for userID, name := range h.away {
+		if mentions(msg.Msg, name) && !h.connected(userID) {
+			h.manager.push.notify(userID, pushNotice{Type: "mention", Room: h.pin, User: msg.User, Msg: pushPreview(msg), TS: msg.TS})
+		}
+	}
+}
+
+// pushPreview returns msg's text shortened for a notification, or "" if
+// it is encrypted.
+func pushPreview(msg *Message) string {
+	text := msg.Msg
+	if msg.opaque() {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= maxPushPreview {
+		return text
+	}
+	return string([]rune(text)[:maxPushPreview-1]) + "…"
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPushStaysOffPrivateNetworks(t *testing.T) {
+	_, priv, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newPusher(&Config{VAPIDPrivateKey: priv, VAPIDSubject: "mailto:ops@example.com"}, newMemoryStore(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	browser, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := PushKeys{
+		P256DH: base64.RawURLEncoding.EncodeToString(browser.PublicKey().Bytes()),
+		Auth:   base64.RawURLEncoding.EncodeToString(make([]byte, 16)),
+	}
+
+	var hits atomic.Int32
+	local := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer local.Close()
+
+	tests := []struct {
+		name     string
+		endpoint string
+		wantErr  error // nil: any error will do
+	}{
+		{name: "local push service", endpoint: local.URL + "/push"},
+		{name: "loopback", endpoint: "https://127.0.0.1/push", wantErr: errPrivateAddress},
+		{name: "ipv6 loopback", endpoint: "https://[::1]/push", wantErr: errPrivateAddress},
+		{name: "private", endpoint: "https://10.0.0.1/push", wantErr: errPrivateAddress},
+		{name: "metadata service", endpoint: "https://169.254.169.254/latest", wantErr: errPrivateAddress},
+		{name: "ipv4-mapped loopback", endpoint: "https://[::ffff:127.0.0.1]/push", wantErr: errPrivateAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := PushSubscription{UserID: "user:amy", Endpoint: tt.endpoint, Keys: keys}
+			if err := sub.validate(); err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			gone, err := p.send(context.Background(), sub, []byte(`{"type":"dm"}`))
+			if err == nil || gone {
+				t.Fatalf("send = %v, %v; want refused", gone, err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("send error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("local push service got %d requests, want none", n)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"math"
+	"time"
+)
+
+// tokenBucket is a classic token-bucket limiter. It is not safe for
+// concurrent use; each bucket belongs to a single goroutine.
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket. A burst below 1 defaults to the
+// rate rounded up.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := float64(burst)
+	if b < 1 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b}
+}
+
+// allow takes a token if one is available at now.
+func (b *tokenBucket) allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// ClientLimits bound how fast a single connection may send frames.
+type ClientLimits struct {
+	// Rate is frames per second per connection; zero disables the limit.
+	Rate  float64
+	Burst int
+	// Strikes is how many frames in a row may be dropped for exceeding
+	// Rate before the connection is closed.
+	Strikes int
+}
+
+// closeRateLimited is sent as the close reason to a flooding client.
+const closeRateLimited = "rate limit exceeded"
+
+// clientLimiter applies ClientLimits to one connection. Owned by readPump.
+type clientLimiter struct {
+	bucket  *tokenBucket
+	strikes int
+	max     int
+}
+
+func newClientLimiter(l ClientLimits) *clientLimiter {
+	if l.Rate <= 0 {
+		return nil
+	}
+	return &clientLimiter{bucket: newTokenBucket(l.Rate, l.Burst), max: l.Strikes}
+}
+
+// allow reports whether a frame read at now may be processed, and whether
+// the client has now dropped enough frames in a row to be disconnected.
+func (l *clientLimiter) allow(now time.Time) (ok, flooding bool) {
+	if l == nil || l.bucket.allow(now) {
+		if l != nil {
+			l.strikes = 0
+		}
+		return true, false
+	}
+	l.strikes++
+	return false, l.max > 0 && l.strikes >= l.max
+}
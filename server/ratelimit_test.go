@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		at    []time.Duration // when each take is tried, from start
+		want  []bool
+	}{
+		{name: "burst then empty", rate: 1, burst: 3, at: []time.Duration{0, 0, 0, 0}, want: []bool{true, true, true, false}},
+		{name: "refills at rate", rate: 2, burst: 1, at: []time.Duration{0, 0, 500 * time.Millisecond, 600 * time.Millisecond}, want: []bool{true, false, true, false}},
+		{name: "refill capped at burst", rate: 10, burst: 2, at: []time.Duration{0, time.Hour, time.Hour, time.Hour}, want: []bool{true, true, true, false}},
+		{name: "burst defaults to rate", rate: 2.5, at: []time.Duration{0, 0, 0, 0}, want: []bool{true, true, true, false}},
+		{name: "slow rate still allows one", rate: 0.1, at: []time.Duration{0, time.Second}, want: []bool{true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(tt.rate, tt.burst)
+			for i, at := range tt.at {
+				if got := b.allow(start.Add(at)); got != tt.want[i] {
+					t.Errorf("take %d at %v = %v, want %v", i, at, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestRoomRateCap has several members, each well under the per-client
+// limit, together send more than the room's burst.
+func TestRoomRateCap(t *testing.T) {
+	const burst, each = 6, 4
+	tests := []struct {
+		name    string
+		configs bool // the cap comes from ROOM_MSG_RATE rather than the owner
+	}{
+		{name: "server default", configs: true},
+		{name: "set by the owner"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := startServer(t, func(cfg *Config) {
+				if tt.configs {
+					cfg.RoomDefaults = RoomSettings{Rate: 0.01, Burst: burst}
+				}
+			})
+			names := []string{"amy", "bob", "carol"}
+			conns := make([]*testConn, len(names))
+			for i := range names {
+				conns[i] = dial(t, ts, "1234", names[i], nil)
+				conns[i].expect("system")
+			}
+			if !tt.configs {
+				conns[0].send(map[string]any{"type": "settings", "settings": RoomSettings{Rate: 0.01, Burst: burst}})
+				for _, c := range conns {
+					c.expect("settings")
+				}
+			}
+
+			for round := range each {
+				for i, c := range conns {
+					c.send(map[string]any{"type": "chat", "msg": fmt.Sprint(names[i], " ", round)})
+				}
+			}
+			var accepted, limited int
+			for i, c := range conns {
+				for answered := 0; answered < each; {
+					f, err := c.next(testWait)
+					if err != nil {
+						t.Fatalf("%s waiting for answers: %v", names[i], err)
+					}
+					msg, _ := f["msg"].(string)
+					switch {
+					case f["type"] == "chat" && strings.HasPrefix(msg, names[i]+" "):
+						accepted++
+					case f["type"] == "error" && f["code"] == "room_rate_limited":
+						limited++
+					case f["type"] == "error":
+						t.Fatalf("%s got error %v", names[i], f["code"])
+					default:
+						continue
+					}
+					answered++
+				}
+			}
+			if accepted != burst || limited != len(names)*each-burst {
+				t.Errorf("accepted %d and limited %d, want %d and %d", accepted, limited, burst, len(names)*each-burst)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on reactions.
+const (
+	// maxEmojiRunes allows ZWJ sequences such as family or flag emoji.
+	maxEmojiRunes = 10
+	// maxReactionKinds bounds the distinct emoji on one message.
+	maxReactionKinds = 20
+)
+
+// reactionSet maps each emoji on a message to the identities using it.
+type reactionSet map[string]map[string]bool
+
+// counts tallies the set for clients.
+func (s reactionSet) counts() map[string]int {
+	n := make(map[string]int, len(s))
+	for emoji, users := range s {
+		n[emoji] = len(users)
+	}
+	return n
+}
+
+// validEmoji accepts a short string with no spaces or control characters
+// that contains at least one symbol outside ASCII.
+func validEmoji(s string) bool {
+	if s == "" || utf8.RuneCountInString(s) > maxEmojiRunes || !utf8.ValidString(s) {
+		return false
+	}
+	symbol := false
+	for _, r := range s {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+		if r > unicode.MaxASCII && (unicode.IsSymbol(r) || unicode.Is(unicode.Regional_Indicator, r)) {
+			symbol = true
+		}
+	}
+	return symbol
+}
+
+// react adds or removes the sender's reaction to a retained message and
+// broadcasts the message's new tally. Only run may call it.
+func (h *Hub) react(msg *Message) {
+	c := msg.from
+	if !h.features[featureReactions] {
+		c.trySend(errorFrame(codeFeatureDisabled, "reactions are disabled in this room"))
+		return
+	}
+	if _, ok := h.findHistory(msg.MsgID); !ok {
+		c.trySend(errorFrame(codeNotFound, "no message with id "+`"`+msg.MsgID+`"`))
+		return
+	}
+
+	set := h.reactions[msg.MsgID]
+	who := c.identity()
+	if msg.Remove {
+		if !set[msg.Emoji][who] {
+			return
+		}
+		delete(set[msg.Emoji], who)
+		if len(set[msg.Emoji]) == 0 {
+			delete(set, msg.Emoji)
+		}
+		if len(set) == 0 {
+			delete(h.reactions, msg.MsgID)
+		}
+	} else {
+		if set[msg.Emoji][who] {
+			return
+		}
+		if set == nil {
+			if h.reactions == nil {
+				h.reactions = make(map[string]reactionSet)
+			}
+			set = make(reactionSet)
+			h.reactions[msg.MsgID] = set
+		}
+		if set[msg.Emoji] == nil {
+			if len(set) >= maxReactionKinds {
+				c.trySend(errorFrame(codeTooManyReactions, "this message has too many different reactions"))
+				return
+			}
+			set[msg.Emoji] = make(map[string]bool)
+		}
+		set[msg.Emoji][who] = true
+	}
+	h.forgetReactions()
+
+	h.fanOut(h.frame(&Message{
+		Type:   "reaction",
+		User:   msg.User,
+		MsgID:  msg.MsgID,
+		Emoji:  msg.Emoji,
+		Remove: msg.Remove,
+		Counts: set.counts(),
+	}))
+}
+
+// forgetReactions drops reactions to messages no longer in history. Only
+// run may call it.
+func (h *Hub) forgetReactions() {
+	if len(h.reactions) <= len(h.history) {
+		return
+	}
+	for id := range h.reactions {
+		if _, ok := h.findHistory(id); !ok {
+			delete(h.reactions, id)
+		}
+	}
+}
+
+// reactionCounts tallies every reacted message for the welcome payload.
+func (h *Hub) reactionCounts() map[string]map[string]int {
+	h.forgetReactions()
+	if len(h.reactions) == 0 {
+		return nil
+	}
+	all := make(map[string]map[string]int, len(h.reactions))
+	for id, set := range h.reactions {
+		all[id] = set.counts()
+	}
+	return all
+}
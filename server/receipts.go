@@ -0,0 +1,75 @@
+package server
+
+// readMarker is the last message a member has seen.
+type readMarker struct {
+	name string
+	id   string
+}
+
+// historyIndex returns the position of message id in history, or -1.
+func (h *Hub) historyIndex(id string) int {
+	for i, e := range h.history {
+		if e.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// markRead moves the sender's read marker forward to a retained message
+// and tells the room how many members have now seen it. Markers never move
+// back, so replaying an older receipt is a no-op. Only run may call it.
+func (h *Hub) markRead(msg *Message) {
+	c := msg.from
+	at := h.historyIndex(msg.MsgID)
+	if at < 0 {
+		c.trySend(errorFrame(codeNotFound, "no message with id "+`"`+msg.MsgID+`"`))
+		return
+	}
+	who := c.identity()
+	if prev, ok := h.reads[who]; ok && h.historyIndex(prev.id) >= at {
+		return
+	}
+	if h.reads == nil {
+		h.reads = make(map[string]readMarker)
+	}
+	h.reads[who] = readMarker{name: c.name, id: msg.MsgID}
+
+	h.fanOut(h.frame(&Message{
+		Type:  "read",
+		User:  msg.User,
+		MsgID: msg.MsgID,
+		Count: h.seenBy(at),
+	}))
+}
+
+// seenBy counts the members whose marker is at or past history[at], not
+// counting the message's own sender. Only run may call it.
+func (h *Hub) seenBy(at int) int {
+	sender := h.history[at].sender
+	n := 0
+	for who, m := range h.reads {
+		if who != sender && h.historyIndex(m.id) >= at {
+			n++
+		}
+	}
+	return n
+}
+
+// readMarkers returns each member's last read message id by name, for the
+// welcome payload, dropping markers whose message has left history. Only
+// run may call it.
+func (h *Hub) readMarkers() map[string]string {
+	if len(h.reads) == 0 {
+		return nil
+	}
+	markers := make(map[string]string, len(h.reads))
+	for who, m := range h.reads {
+		if h.historyIndex(m.id) < 0 {
+			delete(h.reads, who)
+			continue
+		}
+		markers[m.name] = m.id
+	}
+	return markers
+}
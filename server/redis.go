@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	redisDialTimeout       = 5 * time.Second
+	redisWriteTimeout      = 10 * time.Second
+	backplaneChannelPrefix = "gochat:room:"
+)
+
+// redisBackplane is a Broker over Redis pub/sub, one channel per room.
+type redisBackplane struct {
+	relay
+	url string
+}
+
+func newRedisBackplane(url string, deliver func(room string, frame []byte), presence func(room, instance string, p instancePresence)) *redisBackplane {
+	return &redisBackplane{relay: newRelay("redis", deliver, presence), url: url}
+}
+
+// Run keeps publisher and subscriber connections alive until ctx ends.
+func (b *redisBackplane) Run(ctx context.Context) {
+	go b.retry(ctx, "publisher", b.publishLoop)
+	b.retry(ctx, "subscriber", b.subscribeLoop)
+}
+
+func (b *redisBackplane) publishLoop(ctx context.Context) error {
+	conn, err := dialRedis(b.url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case f := <-b.out:
+			if _, err := conn.do("PUBLISH", backplaneChannelPrefix+f.room, string(b.envelope(f))); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (b *redisBackplane) subscribeLoop(ctx context.Context) error {
+	conn, err := dialRedis(b.url)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	if err := conn.write("PSUBSCRIBE", backplaneChannelPrefix+"*"); err != nil {
+		return err
+	}
+	slog.Info("redis backplane subscribed", "instance", b.instance)
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		// ["pmessage", pattern, channel, payload]
+		parts, ok := reply.([]any)
+		if !ok || len(parts) != 4 || parts[0] != "pmessage" {
+			continue
+		}
+		channel, _ := parts[2].(string)
+		payload, _ := parts[3].(string)
+		b.receive(strings.TrimPrefix(channel, backplaneChannelPrefix), []byte(payload))
+	}
+}
+
+// redisConn is a minimal RESP2 client: enough for AUTH, SELECT, PUBLISH
+// and PSUBSCRIBE without pulling in a driver.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialRedis connects to a redis://[user:pass@]host[:port][/db] URL,
+// authenticating and selecting the database given in it.
+func dialRedis(rawURL string) (*redisConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported redis scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	conn, err := net.DialTimeout("tcp", addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+
+	if pass, ok := u.User.Password(); ok {
+		args := []string{"AUTH", pass}
+		if name := u.User.Username(); name != "" {
+			args = []string{"AUTH", name, pass}
+		}
+		if _, err := c.do(args...); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" && db != "0" {
+		if _, err := c.do("SELECT", db); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("redis select: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) Close() error { return c.conn.Close() }
+
+// do sends a command and reads its reply.
+func (c *redisConn) do(args ...string) (any, error) {
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// write sends a command as a RESP array of bulk strings.
+func (c *redisConn) write(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(redisWriteTimeout))
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+// read parses one RESP reply. Errors from the server are returned as Go
+// errors; bulk strings as string; arrays as []any; nil bulk as nil.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New("redis: " + line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
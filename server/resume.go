@@ -0,0 +1,172 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// parkedClient is a member whose connection dropped, held for the resume
+// window so a reconnect can pick up where it left off. Meanwhile the
+// member stays in the presence list and its name stays reserved.
+type parkedClient struct {
+	name     string
+	userID   string
+	avatar   string
+	meta     map[string]string
+	role     role
+	channels map[string]bool
+	ignored  map[string]bool
+	member   Member
+	// cursor is the room's seq when the connection dropped.
+	cursor uint64
+	until  time.Time
+}
+
+func newResumeToken() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// park holds a disconnected member for the resume window instead of
+// announcing its departure. It reports false if resuming is off or c
+// cannot resume. Only run may call it, after dropping c.
+func (h *Hub) park(c *Client, now time.Time) bool {
+	if h.manager.resumeWindow <= 0 || c.spectator || c.resumeToken == "" {
+		return false
+	}
+	var member Member
+	if snap := h.presence.Load(); snap != nil {
+		for _, m := range snap.admin {
+			if m.ID == c.id {
+				member = m
+			}
+		}
+	}
+	if h.parked == nil {
+		h.parked = make(map[string]*parkedClient)
+	}
+	h.parked[c.resumeToken] = &parkedClient{
+		name:     c.name,
+		userID:   c.userID,
+		avatar:   c.avatar,
+		meta:     c.meta,
+		role:     c.role,
+		channels: c.channels,
+		ignored:  c.ignored,
+		member:   member,
+		cursor:   h.seq,
+		until:    now.Add(h.manager.resumeWindow),
+	}
+	// The member is still listed, so there is nothing new to announce.
+	h.presenceDirty = false
+	h.scheduleParked()
+	c.log.Info("connection parked for resume", "window", h.manager.resumeWindow)
+	return true
+}
+
+// unpark restores the member a reconnecting client's resume token names,
+// returning the seq to replay from, or false if the token is unknown or
+// has expired. Only run may call it.
+func (h *Hub) unpark(c *Client) (uint64, bool) {
+	p := h.parked[c.resume]
+	if c.resume == "" || p == nil {
+		return 0, false
+	}
+	delete(h.parked, c.resume)
+	h.scheduleParked()
+	c.name, c.userID, c.avatar, c.meta, c.role = p.name, p.userID, p.avatar, p.meta, p.role
+	c.channels, c.ignored = p.channels, p.ignored
+	c.log = c.log.With("resumed_as", p.name)
+	// The client knows best what reached it before the drop.
+	if c.resumeSeq > 0 {
+		return c.resumeSeq, true
+	}
+	return p.cursor, true
+}
+
+// framesSince returns the retained frames after seq in c's channels.
+// Only run may call it.
+func (h *Hub) framesSince(c *Client, seq uint64) [][]byte {
+	var frames [][]byte
+	for _, e := range h.history {
+		if e.seq > seq && c.subscribed(e.channel) {
+			frames = append(frames, e.frame)
+		}
+	}
+	return frames
+}
+
+// parkedMembers lists the presence entries of parked members. Only run
+// may call it.
+func (h *Hub) parkedMembers(full bool) []Member {
+	members := make([]Member, 0, len(h.parked))
+	for _, p := range h.parked {
+		m := p.member
+		if m.Conn != nil && !full {
+			m.Conn = m.Conn.redacted()
+		}
+		m.client = nil
+		members = append(members, m)
+	}
+	return members
+}
+
+// parkedName reports whether a parked member holds name. Only run may
+// call it.
+func (h *Hub) parkedName(name string) bool {
+	for _, p := range h.parked {
+		if strings.EqualFold(p.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// expireParked announces the departure of members whose resume window
+// has passed. Only run may call it.
+func (h *Hub) expireParked(now time.Time) {
+	for token, p := range h.parked {
+		if now.Before(p.until) {
+			continue
+		}
+		delete(h.parked, token)
+		h.presenceDirty = true
+		if h.features[featurePresence] {
+			h.fanOutFrom("", p.name, nil, h.frame(&Message{Type: "left", User: p.name, Reason: leaveDisconnected}))
+		}
+		h.notifyBots(func(b Bot, room BotRoom) { b.OnLeave(room, p.name) })
+	}
+	h.scheduleParked()
+}
+
+// scheduleParked arms parkTimer for the next expiry. Only run may call it.
+func (h *Hub) scheduleParked() {
+	var next time.Time
+	for _, p := range h.parked {
+		if next.IsZero() || p.until.Before(next) {
+			next = p.until
+		}
+	}
+	switch {
+	case next.IsZero():
+		if h.parkTimer != nil {
+			h.parkTimer.Stop()
+		}
+	case h.parkTimer == nil:
+		h.parkTimer = time.NewTimer(time.Until(next))
+	default:
+		h.parkTimer.Reset(time.Until(next))
+	}
+}
+
+// parkExpiry is the channel run waits on for the next resume expiry; nil
+// while nobody is parked.
+func (h *Hub) parkExpiry() <-chan time.Time {
+	if h.parkTimer == nil || len(h.parked) == 0 {
+		return nil
+	}
+	return h.parkTimer.C
+}
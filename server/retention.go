@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// pruneInterval is how often rooms and the store drop history older than
+// the configured retention.
+const pruneInterval = time.Minute
+
+// historyLoadTimeout bounds how long a new room waits on the store.
+const historyLoadTimeout = 5 * time.Second
+
+// loadHistory seeds a new room's history and sequence from the store.
+// Only run may call it, before serving any client.
+func (h *Hub) loadHistory(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, historyLoadTimeout)
+	defer cancel()
+	msgs, err := h.manager.store.Recent(ctx, h.pin, h.historyLimit)
+	if err != nil {
+		h.log.Error("loading history failed", "err", err)
+		return
+	}
+	for _, m := range msgs {
+		h.history = append(h.history, historyEntry{id: m.ID, seq: m.Seq, channel: frameChannel(m.Frame), parent: frameParent(m.Frame), at: m.At, frame: m.Frame})
+		h.seq = max(h.seq, m.Seq)
+	}
+}
+
+// errRoomExpired is the close reason for a room past its expiry time.
+var errRoomExpired = errors.New("room expired")
+
+// pruneStore deletes stored messages past the server's retention period,
+// those of open rooms past their own, and those beyond each room's newest
+// MESSAGE_ROOM_LIMIT, until ctx is cancelled. The store deletes in batches
+// so writes keep flowing.
+func (m *HubManager) pruneStore(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if m.retention > 0 {
+				n, err := m.store.Prune(ctx, now.Add(-m.retention))
+				if err != nil {
+					slog.Error("pruning store failed", "err", err)
+				} else if n > 0 {
+					slog.Info("pruned expired messages", "count", n)
+				}
+			}
+			if m.roomLimit > 0 {
+				n, err := m.store.PruneCount(ctx, m.roomLimit)
+				if err != nil {
+					slog.Error("pruning store failed", "err", err)
+				} else if n > 0 {
+					slog.Info("pruned excess messages", "count", n)
+				}
+			}
+			for _, h := range m.hubList() {
+				if h.retention <= 0 || h.ephemeral {
+					continue
+				}
+				n, err := m.store.PruneRoom(ctx, h.pin, now.Add(-h.retention))
+				if err != nil {
+					h.log.Error("pruning room messages failed", "err", err)
+				} else if n > 0 {
+					h.log.Info("pruned expired messages", "count", n)
+				}
+			}
+		}
+	}
+}
+
+// messageRetention returns how long the room keeps messages: the shorter
+// of its own retention and the server's, or zero to keep them.
+func (h *Hub) messageRetention() time.Duration {
+	switch r := h.manager.retention; {
+	case h.retention <= 0:
+		return r
+	case r <= 0:
+		return h.retention
+	default:
+		return min(r, h.retention)
+	}
+}
+
+// expire closes the room at its expiry time with a final notice, and
+// queues the deletion of its stored messages and pins behind any pending
+// writes so a later room under the same PIN starts empty. Only run may
+// call it, which must then return.
+func (h *Hub) expire() {
+	h.log.Info("room reached its expiry time, closing", "expires_at", h.expiresAt)
+	h.fanOut(h.frame(&Message{Type: "system", Msg: "This room has expired and is now closed."}))
+	if !h.ephemeral {
+		h.manager.persist.purge(h.pin, time.Now())
+		h.manager.persist.savePins(h.pin, nil)
+	}
+	h.stop(errRoomExpired)
+}
+
+// historyEntry is one retained chat frame. sender is the identity of the
+// client that sent it, when known to this instance.
+type historyEntry struct {
+	id      string
+	seq     uint64
+	sender  string
+	channel string
+	parent  string // thread root, for replies
+	at      time.Time
+	frame   []byte
+}
+
+// historyLimit is how many of its newest messages a room replays by
+// default: the room limit, or historySize if that is unset or larger.
+func (m *HubManager) historyLimit() int {
+	if m.roomLimit > 0 && m.roomLimit < historySize {
+		return m.roomLimit
+	}
+	return historySize
+}
+
+// remember appends a chat frame to the room history, keeping at most
+// historyLimit entries. Only run may call it.
+func (h *Hub) remember(e historyEntry) {
+	h.history = append(h.history, e)
+	if len(h.history) > h.historyLimit {
+		h.history = h.history[len(h.history)-h.historyLimit:]
+	}
+}
+
+// pruneHistory drops entries older than the room's retention. History is
+// kept in arrival order, so the expired entries are always a prefix.
+func (h *Hub) pruneHistory(now time.Time) {
+	retention := h.messageRetention()
+	if retention <= 0 {
+		return
+	}
+	cutoff := now.Add(-retention)
+	i := 0
+	for i < len(h.history) && h.history[i].at.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		h.history = append([]historyEntry(nil), h.history[i:]...)
+	}
+}
+
+// historyFrames returns a copy of the retained frames in c's channels, in
+// seq order.
+func (h *Hub) historyFrames(c *Client) [][]byte {
+	frames := make([][]byte, 0, len(h.history))
+	for _, e := range h.history {
+		if c.subscribed(e.channel) {
+			frames = append(frames, e.frame)
+		}
+	}
+	return frames
+}
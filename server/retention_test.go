@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPruneHistory(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		retention time.Duration
+		roomLimit int
+		want      []string // the messages kept, oldest first
+	}{
+		{name: "keep all", want: []string{"m1", "m2", "m3", "m4", "m5"}},
+		{name: "by age", retention: 150 * time.Minute, want: []string{"m4", "m5"}},
+		{name: "all too old", retention: 30 * time.Minute, want: nil},
+		{name: "by count", roomLimit: 3, want: []string{"m3", "m4", "m5"}},
+		{name: "count above the replay window", roomLimit: 500, want: []string{"m1", "m2", "m3", "m4", "m5"}},
+		{name: "by age and count", retention: 250 * time.Minute, roomLimit: 2, want: []string{"m4", "m5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHub("1234", &HubManager{retention: tt.retention, roomLimit: tt.roomLimit})
+			// m1 is four hours old, m5 one.
+			for i := range 5 {
+				at := now.Add(time.Duration(i-4)*time.Hour - time.Hour)
+				h.remember(historyEntry{id: fmt.Sprint(i + 1), at: at, frame: []byte(fmt.Sprintf("m%d", i+1))})
+			}
+			h.pruneHistory(now)
+			var got []string
+			for _, e := range h.history {
+				got = append(got, string(e.frame))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+// role is a member's standing in a room. Whoever opens an empty room owns
+// it; everyone else joins as a member until an owner promotes them.
+type role string
+
+const (
+	roleOwner     role = "owner"
+	roleModerator role = "moderator"
+	roleMember    role = "member"
+)
+
+// permission is an action only some roles may take.
+type permission string
+
+const (
+	permKick     permission = "kick"
+	permBan      permission = "ban"
+	permDelete   permission = "delete"   // other members' messages
+	permPin      permission = "pin"      // pin and unpin
+	permSettings permission = "settings" // settings and features
+	permTopic    permission = "topic"    // topic, description and slow mode
+	permPromote  permission = "promote"  // change other members' roles
+)
+
+// rolePermissions lists what each role may do beyond chatting.
+var rolePermissions = map[role][]permission{
+	roleOwner:     {permKick, permBan, permDelete, permPin, permSettings, permTopic, permPromote},
+	roleModerator: {permKick, permBan, permDelete, permPin, permTopic},
+}
+
+func (r role) can(p permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// rank orders roles so moderators cannot act against owners or each other.
+func (r role) rank() int {
+	switch r {
+	case roleOwner:
+		return 2
+	case roleModerator:
+		return 1
+	}
+	return 0
+}
+
+// can reports whether the client's role grants p.
+func (c *Client) can(p permission) bool { return c.role.can(p) }
+
+// outranks reports whether c may moderate target.
+func (c *Client) outranks(target *Client) bool { return c.role.rank() > target.role.rank() }
+
+// joinRole decides the role a client is admitted with. Spectators are
+// always members and whoever opens an empty room owns it. Otherwise a
+// signed-in user gets back the role the room recorded for them; roles of
+// anonymous members are not remembered, since anyone can take a free name.
+// Only run may call it.
+func (h *Hub) joinRole(c *Client, first bool) role {
+	switch {
+	case c.spectator:
+		return roleMember
+	case first:
+		h.recordRole(c, roleOwner)
+		return roleOwner
+	}
+	if c.userID != "" {
+		if r, ok := h.roles[c.identity()]; ok {
+			return r
+		}
+	}
+	return roleMember
+}
+
+// recordRole remembers a signed-in member's role for when they rejoin.
+// Only run may call it.
+func (h *Hub) recordRole(c *Client, r role) {
+	if c.userID == "" {
+		return
+	}
+	if r == roleMember {
+		delete(h.roles, c.identity())
+		return
+	}
+	if h.roles == nil {
+		h.roles = make(map[string]role)
+	}
+	h.roles[c.identity()] = r
+}
+
+// setRole changes a member's role and tells the room. Only run may call it.
+func (h *Hub) setRole(c *Client, r role) {
+	c.role = r
+	h.recordRole(c, r)
+	h.presenceDirty = true
+	h.fanOut(h.frame(&Message{Type: "role", User: c.name, Role: string(r)}))
+	c.log.Info("role changed", "role", r)
+}
+
+func init() {
+	registerCommand(&command{name: "promote", args: "<name>", help: "make a member a moderator", perm: permPromote, run: (*Hub).promoteCommand})
+	registerCommand(&command{name: "demote", args: "<name>", help: "make a moderator a member again", perm: permPromote, run: (*Hub).demoteCommand})
+}
+
+// roleTarget finds the member a role command acts on, replying with an
+// error and returning nil if there is none or it cannot be changed.
+func (h *Hub) roleTarget(msg *Message, cmd, args string) *Client {
+	name := moderationTarget(msg, cmd, args)
+	if name == "" {
+		return nil
+	}
+	target := h.findByName(name)
+	switch {
+	case target == nil:
+		msg.from.trySend(errorFrame(codeNotFound, "no member named "+`"`+name+`"`))
+		return nil
+	case target.spectator:
+		msg.from.trySend(errorFrame(codeForbidden, "spectators cannot be given a role"))
+		return nil
+	case !msg.from.outranks(target):
+		msg.from.trySend(errorFrame(codeForbidden, "you cannot change the role of "+target.name))
+		return nil
+	}
+	return target
+}
+
+func (h *Hub) promoteCommand(msg *Message, args string) {
+	target := h.roleTarget(msg, "promote", args)
+	if target == nil {
+		return
+	}
+	if target.role == roleModerator {
+		h.reply(msg.from, target.name+" is already a moderator")
+		return
+	}
+	h.setRole(target, roleModerator)
+	h.audit(msg.from, AuditEntry{Action: auditRole, Target: target.name, TargetID: target.userID, Detail: string(roleModerator)})
+}
+
+func (h *Hub) demoteCommand(msg *Message, args string) {
+	target := h.roleTarget(msg, "demote", args)
+	if target == nil {
+		return
+	}
+	if target.role == roleMember {
+		h.reply(msg.from, target.name+" is not a moderator")
+		return
+	}
+	h.setRole(target, roleMember)
+	h.audit(msg.from, AuditEntry{Action: auditRole, Target: target.name, TargetID: target.userID, Detail: string(roleMember)})
+}
+
+// roleLabel is how /who marks members with a role.
+func roleLabel(c *Client) string {
+	if c.role == roleMember || c.role == "" {
+		return ""
+	}
+	return " (" + string(c.role) + ")"
+}
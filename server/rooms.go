@@ -0,0 +1,304 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxRoomNameLen bounds a room's display name, and maxTopicLen its topic.
+const (
+	maxRoomNameLen = 64
+	maxTopicLen    = 256
+)
+
+// roomClaimTTL is how long a non-persistent room created through the API
+// waits for its first member.
+const roomClaimTTL = 10 * time.Minute
+
+// RoomSpec is the body of POST /api/rooms. Zero values take the server
+// defaults.
+type RoomSpec struct {
+	Name       string `json:"name"`
+	Capacity   int    `json:"capacity"`
+	History    int    `json:"history"`
+	Password   string `json:"password"`
+	Persistent bool   `json:"persistent"`
+
+	// Public rooms are listed by GET /api/rooms?public=true, with their
+	// topic.
+	Public bool   `json:"public"`
+	Topic  string `json:"topic"`
+
+	// Ephemeral rooms write nothing to the store and replay nothing to
+	// new or returning members, and their history, export and search
+	// endpoints answer 404.
+	Ephemeral bool `json:"ephemeral"`
+
+	// AuthRequired admits only signed-in members, with a token or a
+	// session from /auth/login.
+	AuthRequired bool `json:"auth_required"`
+
+	// Retention, a duration such as "24h", bounds how long the room's
+	// messages are kept, in memory and in the store. The server-wide
+	// MESSAGE_RETENTION still applies if it is shorter.
+	Retention string `json:"retention"`
+	// ExpiresAt, or ExpiresIn as a duration from now, closes the room for
+	// good at that time and deletes its stored messages.
+	ExpiresAt time.Time `json:"expires_at"`
+	ExpiresIn string    `json:"expires_in"`
+
+	// retention and expiresAt are parsed from the fields above by validate.
+	retention time.Duration
+	expiresAt time.Time
+
+	// Webhook, if set, receives the room's chat.
+	Webhook *WebhookSpec `json:"webhook"`
+
+	// Bots names in-process bots to run in the room, besides the
+	// server-wide BOTS.
+	Bots []string `json:"bots"`
+}
+
+func (s *RoomSpec) validate(maxCapacity int) error {
+	s.Name = strings.Join(strings.Fields(s.Name), " ")
+	if utf8.RuneCountInString(s.Name) > maxRoomNameLen {
+		return fmt.Errorf("name must be at most %d characters", maxRoomNameLen)
+	}
+	s.Topic = strings.Join(strings.Fields(s.Topic), " ")
+	if utf8.RuneCountInString(s.Topic) > maxTopicLen {
+		return fmt.Errorf("topic must be at most %d characters", maxTopicLen)
+	}
+	if s.Capacity < 0 || s.Capacity > maxCapacity {
+		return fmt.Errorf("capacity must be between 1 and %d", maxCapacity)
+	}
+	if s.History < 0 || s.History > historySize {
+		return fmt.Errorf("history must be between 1 and %d", historySize)
+	}
+	if s.Ephemeral && s.History > 0 {
+		return errors.New("an ephemeral room has no history")
+	}
+	if err := s.validateLifetime(time.Now()); err != nil {
+		return err
+	}
+	if err := checkBots(s.Bots); err != nil {
+		return err
+	}
+	if s.Webhook != nil {
+		if err := s.Webhook.validate(); err != nil {
+			return err
+		}
+	}
+	return validatePassword(s.Password)
+}
+
+// minRetention is the shortest message retention a room may ask for; the
+// store is only pruned once per pruneInterval anyway.
+const minRetention = time.Minute
+
+// validateLifetime parses the spec's retention and expiry as of now.
+func (s *RoomSpec) validateLifetime(now time.Time) error {
+	if s.Retention != "" {
+		d, err := time.ParseDuration(s.Retention)
+		if err != nil || d < minRetention {
+			return fmt.Errorf("retention must be a duration of at least %v", minRetention)
+		}
+		s.retention = d
+	}
+	if s.ExpiresIn != "" {
+		if !s.ExpiresAt.IsZero() {
+			return errors.New("give expires_at or expires_in, not both")
+		}
+		d, err := time.ParseDuration(s.ExpiresIn)
+		if err != nil || d <= 0 {
+			return errors.New("expires_in must be a positive duration")
+		}
+		s.ExpiresAt = now.Add(d)
+	}
+	if !s.ExpiresAt.IsZero() && !s.ExpiresAt.After(now) {
+		return errors.New("expires_at must be in the future")
+	}
+	s.expiresAt = s.ExpiresAt
+	return nil
+}
+
+// errNoFreePIN is returned when random PINs keep colliding.
+var errNoFreePIN = errors.New("could not allocate a room PIN")
+
+// newPIN returns a random six-digit PIN.
+func newPIN() string {
+	n, _ := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+// createRoom starts a room under a fresh PIN with the given settings.
+func (m *HubManager) createRoom(spec RoomSpec) (*Hub, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for range 20 {
+		pin := newPIN()
+		if _, taken := m.hubs[pin]; taken {
+			continue
+		}
+		h := newHub(pin, m)
+		h.preset = true
+		h.title = spec.Name
+		h.persistent = spec.Persistent
+		h.public = spec.Public
+		h.ephemeral = spec.Ephemeral
+		h.authRequired = spec.AuthRequired
+		h.retention = spec.retention
+		h.expiresAt = spec.expiresAt
+		if spec.Ephemeral {
+			h.features[featureHistory], h.historyLimit = false, 0
+		}
+		settings := h.settings
+		settings.Topic = spec.Topic
+		h.applySettings(settings)
+		if !spec.Persistent {
+			h.claimBy = time.Now().Add(roomClaimTTL)
+		}
+		if spec.Capacity > 0 {
+			h.capacity.Store(int32(spec.Capacity))
+		}
+		if spec.History > 0 {
+			// The store keeps no more than MESSAGE_ROOM_LIMIT anyway.
+			h.historyLimit = min(spec.History, h.historyLimit)
+		}
+		if spec.Password != "" {
+			h.password = newRoomPassword(spec.Password, m.passwordTTL)
+			h.locked.Store(true)
+		}
+		h.addBots(spec.Bots)
+		if spec.Webhook != nil {
+			h.webhook = newWebhook(spec.Webhook, pin)
+		}
+		m.start(h)
+		return h, nil
+	}
+	return nil, errNoFreePIN
+}
+
+// handleCreateRoom serves POST /api/rooms. Anyone may create a
+// non-persistent room; persistent rooms and webhooks need the admin token.
+func handleCreateRoom(manager *HubManager, adminToken string, w http.ResponseWriter, r *http.Request) {
+	var spec RoomSpec
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body")
+		return
+	}
+	if err := spec.validate(manager.maxCapacity); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if spec.Persistent && !isAdmin(adminToken, r) {
+		writeError(w, http.StatusForbidden, codeForbidden, "persistent rooms need the admin token")
+		return
+	}
+	if spec.Webhook != nil && !isAdmin(adminToken, r) {
+		writeError(w, http.StatusForbidden, codeForbidden, "webhooks need the admin token")
+		return
+	}
+	if spec.AuthRequired && manager.tokens == nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "auth_required needs JWT_SECRET")
+		return
+	}
+	if manager.maintenance.Load() {
+		writeError(w, http.StatusServiceUnavailable, codeMaintenance, "server is in maintenance mode, try again shortly")
+		return
+	}
+	h, err := manager.createRoom(spec)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, err.Error())
+		return
+	}
+	h.log.Info("room created", "name", spec.Name, "persistent", spec.Persistent, "public", spec.Public, "ephemeral", spec.Ephemeral,
+		"retention", spec.retention, "expires_at", spec.expiresAt)
+	resp := map[string]any{
+		"pin":           h.pin,
+		"name":          h.title,
+		"capacity":      h.capacity.Load(),
+		"history":       h.historyLimit,
+		"ephemeral":     h.ephemeral,
+		"auth_required": h.authRequired,
+		"password":      spec.Password != "",
+		"persistent":    h.persistent,
+		"public":        h.public,
+		"topic":         spec.Topic,
+		"webhook":       h.webhook != nil,
+	}
+	if h.retention > 0 {
+		resp["retention"] = h.retention.String()
+	}
+	if !h.expiresAt.IsZero() {
+		resp["expires_at"] = h.expiresAt
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// keepsNoHistory answers 404 for an open ephemeral room, reporting
+// whether it did, so its history endpoints never read the store.
+func (m *HubManager) keepsNoHistory(pin string, w http.ResponseWriter) bool {
+	if h := m.lookup(pin); h != nil && h.ephemeral {
+		writeError(w, http.StatusNotFound, codeEphemeralRoom, "ephemeral room keeps no history")
+		return true
+	}
+	return false
+}
+
+// PublicRoom is a room as listed in the lobby.
+type PublicRoom struct {
+	Pin      string `json:"pin"`
+	Name     string `json:"name,omitempty"`
+	Topic    string `json:"topic,omitempty"`
+	Count    int    `json:"count"`
+	Capacity int    `json:"capacity"`
+	Password bool   `json:"password,omitempty"`
+}
+
+// roomTopic returns h's topic, or "" if it has none.
+func (h *Hub) roomTopic() string {
+	if t := h.topic.Load(); t != nil {
+		return *t
+	}
+	return ""
+}
+
+// handleListRooms serves GET /api/rooms?public=true, the lobby: every
+// public room on this instance that is not draining, busiest first, with
+// members on other instances counted too. Without public=true it is the
+// admin room list and needs the admin token.
+func handleListRooms(manager *HubManager, adminToken string, w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("public") != "true" {
+		requireAdmin(adminToken, func(w http.ResponseWriter, r *http.Request) {
+			handleAdminRooms(manager, w, r)
+		})(w, r)
+		return
+	}
+	now := time.Now()
+	rooms := []PublicRoom{}
+	for _, h := range manager.hubList() {
+		if !h.public || h.draining.Load() {
+			continue
+		}
+		_, remote, _, _ := manager.cluster.lookup(h.pin, now)
+		info := h.info(false)
+		rooms = append(rooms, PublicRoom{
+			Pin:      h.pin,
+			Name:     h.title,
+			Topic:    h.roomTopic(),
+			Count:    info.Count + remote,
+			Capacity: info.Capacity,
+			Password: h.locked.Load(),
+		})
+	}
+	sort.SliceStable(rooms, func(i, j int) bool { return rooms[i].Count > rooms[j].Count })
+	writeJSON(w, http.StatusOK, map[string]any{"count": len(rooms), "rooms": rooms})
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// grpcHook is set when the binary is built with -tags grpc. It returns a
+// server for the gochat.v1.GoChat service in proto/gochat.proto, serving
+// TLS with tlsConfig unless it is nil.
+var grpcHook func(manager *HubManager, adminToken string, tlsConfig *tls.Config) RPCServer
+
+// RPCServer is the part of *grpc.Server needed to run and stop it.
+type RPCServer interface {
+	Serve(net.Listener) error
+	GracefulStop()
+	Stop()
+}
+
+// rpcError is a request the shared HTTP checks refused, with the status
+// they would have answered; the gRPC layer maps it to a status code.
+type rpcError struct {
+	status int
+	msg    string
+}
+
+func (e *rpcError) Error() string { return e.msg }
+
+var errUnknownSession = &rpcError{http.StatusUnauthorized, "unknown or expired session"}
+
+// rpcJoin is a JoinRoom request: the /ws query parameters as Envelope
+// fields, those without a field number travelling in extra.
+type rpcJoin struct {
+	Room     string          `json:"room"`
+	Name     string          `json:"name"`
+	Token    string          `json:"token"`
+	Password string          `json:"password"`
+	Channels string          `json:"channels"`
+	Capacity int             `json:"capacity"`
+	Meta     json.RawMessage `json:"meta"`
+	Auth     string          `json:"auth"`
+	Spectate bool            `json:"spectate"`
+	Resume   string          `json:"resume"`
+	LastSeq  uint64          `json:"last_seq"`
+}
+
+// request rebuilds the join as the /ws upgrade request it stands for, so
+// it goes through exactly the checks every other transport does.
+func (j *rpcJoin) request(ctx context.Context, remoteAddr, userAgent string) *http.Request {
+	q := url.Values{}
+	set := func(k, v string) {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	set("pin", j.Room)
+	set("name", j.Name)
+	set("token", j.Token)
+	set("password", j.Password)
+	set("channels", j.Channels)
+	set("auth", j.Auth)
+	set("resume", j.Resume)
+	if len(j.Meta) > 0 && string(j.Meta) != "null" {
+		set("meta", string(j.Meta))
+	}
+	if j.Capacity != 0 {
+		set("capacity", strconv.Itoa(j.Capacity))
+	}
+	if j.Spectate {
+		set("spectate", "1")
+	}
+	if j.LastSeq != 0 {
+		set("last_seq", strconv.FormatUint(j.LastSeq, 10))
+	}
+	return (&http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: "/ws", RawQuery: q.Encode()},
+		Header:     http.Header{"User-Agent": {userAgent}},
+		RemoteAddr: remoteAddr,
+	}).WithContext(ctx)
+}
+
+// rpcRecorder catches the error newClientFromRequest writes for a refused
+// join.
+type rpcRecorder struct {
+	header http.Header
+	status int
+	body   strings.Builder
+}
+
+func (r *rpcRecorder) Header() http.Header {
+	if r.header == nil {
+		r.header = http.Header{}
+	}
+	return r.header
+}
+
+func (r *rpcRecorder) WriteHeader(status int) { r.status = status }
+
+func (r *rpcRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+// err returns what was written as an rpcError, preferring the reason of a
+// JSON error body.
+func (r *rpcRecorder) err() error {
+	msg := strings.TrimSpace(r.body.String())
+	var body struct{ Error, Reason string }
+	if json.Unmarshal([]byte(msg), &body) == nil && body.Error != "" {
+		msg = body.Error
+		if body.Reason != "" {
+			msg += ": " + body.Reason
+		}
+	}
+	return &rpcError{r.status, msg}
+}
+
+// rpcJoinRoom is the JoinRoom stream: it joins the room and calls send
+// with a session frame, whose session token SendMessage takes, then with
+// every frame the client would get over WebSocket, ending with a close
+// frame. It returns when the client leaves or ctx ends.
+func (m *HubManager) rpcJoinRoom(ctx context.Context, j *rpcJoin, remoteAddr, userAgent string, send func([]byte) error) error {
+	var rec rpcRecorder
+	pin, client := newClientFromRequest(m, &rec, j.request(ctx, remoteAddr, userAgent))
+	if client == nil {
+		return rec.err()
+	}
+	client.info.Transport = "grpc"
+	client.log.Info("grpc connection", "user_agent", userAgent)
+	if err := m.join(pin, client); err != nil {
+		return &rpcError{http.StatusServiceUnavailable, "room_busy: " + err.Error()}
+	}
+	token := m.sessions.add(client)
+	defer m.sessions.remove(client.id)
+	m.conns.Add(1)
+	defer m.conns.Done()
+	defer client.leave(leaveDisconnected)
+
+	session, _ := json.Marshal(map[string]string{"type": "session", "id": client.id, "session": token})
+	if err := send(session); err != nil {
+		return err
+	}
+	select {
+	case frames := <-client.replay:
+		for _, f := range frames {
+			if err := send(f); err != nil {
+				return err
+			}
+		}
+	case <-client.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-client.done:
+			if err := client.send.drain(send); err != nil {
+				return err
+			}
+			var closed map[string]any
+			_ = json.Unmarshal(client.closeEvent(), &closed)
+			closed["type"] = "close"
+			b, _ := json.Marshal(closed)
+			return send(b)
+		case <-client.send.ready:
+			if err := client.send.drain(send); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// rpcSendMessage is SendMessage: one client frame, in the same JSON as
+// over WebSocket, for the JoinRoom stream whose token is in its session
+// field.
+func (m *HubManager) rpcSendMessage(frame []byte) error {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(frame, &fields) != nil {
+		return &rpcError{http.StatusBadRequest, codeInvalidJSON}
+	}
+	var token string
+	_ = json.Unmarshal(fields["session"], &token)
+	client := m.sessions.get(token)
+	if client == nil || client.conn != nil {
+		return errUnknownSession
+	}
+	// The session token must not ride along into the room.
+	delete(fields, "session")
+	frame, _ = json.Marshal(fields)
+
+	client.postMu.Lock()
+	reason := client.handleFrame(frame)
+	client.postMu.Unlock()
+	if reason != "" {
+		// The stream sees done close and ends with a close frame.
+		client.leave(reason)
+	}
+	return nil
+}
+
+// rpcListRooms is ListRooms, the admin room list. It needs the admin token
+// as the request's bearer authorization.
+func (m *HubManager) rpcListRooms(adminToken, authorization string) ([]byte, error) {
+	r := &http.Request{Header: http.Header{"Authorization": {authorization}}}
+	if !isAdmin(adminToken, r) {
+		return nil, &rpcError{http.StatusUnauthorized, "unauthorized"}
+	}
+	rooms := []RoomInfo{}
+	for _, h := range m.hubList() {
+		rooms = append(rooms, h.info(false))
+	}
+	return json.Marshal(map[string]any{"type": "rooms", "count": len(rooms), "rooms": rooms})
+}
+
+// rpcStatus returns the HTTP status behind err, or 500.
+func rpcStatus(err error) (int, string) {
+	var re *rpcError
+	if errors.As(err, &re) {
+		return re.status, re.msg
+	}
+	return http.StatusInternalServerError, err.Error()
+}
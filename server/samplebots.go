@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerBot("echo", func() Bot { return echoBot{} })
+	registerBot("dice", func() Bot { return diceBot{} })
+}
+
+// echoBot repeats anything sent as "!echo <text>" and greets newcomers.
+type echoBot struct{}
+
+func (echoBot) Name() string { return "echo" }
+
+func (echoBot) OnMessage(room BotRoom, m *Message) {
+	if text, ok := strings.CutPrefix(m.Msg, "!echo "); ok && strings.TrimSpace(text) != "" {
+		room.Say(text)
+	}
+}
+
+func (echoBot) OnJoin(room BotRoom, user string) {
+	room.Say("hello, " + user)
+}
+
+func (echoBot) OnLeave(BotRoom, string) {}
+
+// Limits on a single dice roll.
+const (
+	maxDice  = 20
+	maxSides = 1000
+)
+
+var dicePattern = regexp.MustCompile(`^!roll(?:\s+(\d*)d(\d+))?\s*$`)
+
+// diceBot answers "!roll" with a d6 and "!roll NdM" with N M-sided dice.
+type diceBot struct{}
+
+func (diceBot) Name() string { return "dice" }
+
+func (diceBot) OnMessage(room BotRoom, m *Message) {
+	match := dicePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(m.Msg)))
+	if match == nil {
+		return
+	}
+	n, sides := 1, 6
+	if match[2] != "" {
+		sides, _ = strconv.Atoi(match[2])
+		if match[1] != "" {
+			n, _ = strconv.Atoi(match[1])
+		}
+	}
+	if n < 1 || n > maxDice || sides < 2 || sides > maxSides {
+		room.Say(fmt.Sprintf("%s: roll 1 to %d dice of 2 to %d sides", m.User, maxDice, maxSides))
+		return
+	}
+	rolls := make([]string, n)
+	total := 0
+	for i := range rolls {
+		r, _ := rand.Int(rand.Reader, big.NewInt(int64(sides)))
+		v := int(r.Int64()) + 1
+		total += v
+		rolls[i] = strconv.Itoa(v)
+	}
+	text := fmt.Sprintf("%s rolled %dd%d: %d", m.User, n, sides, total)
+	if n > 1 {
+		text += " (" + strings.Join(rolls, " + ") + ")"
+	}
+	room.Say(text)
+}
+
+func (diceBot) OnJoin(BotRoom, string)  {}
+func (diceBot) OnLeave(BotRoom, string) {}
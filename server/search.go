@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Limits on GET /api/rooms/{pin}/search.
+const (
+	maxSearchTerms    = 8
+	maxSearchResults  = 100
+	searchTimeout     = 10 * time.Second
+	defaultSearchSize = 20
+)
+
+// frameText returns the searchable text of a stored chat frame: its msg,
+// or nothing for an encrypted message.
+func frameText(frame []byte) string {
+	var m struct {
+		Msg         string `json:"msg"`
+		ContentType string `json:"contentType"`
+	}
+	_ = json.Unmarshal(frame, &m)
+	if m.ContentType == contentCiphertext {
+		return ""
+	}
+	return m.Msg
+}
+
+// words splits text into lowercased runs of letters and digits.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesTerms reports whether every term starts some word of text, which
+// is how the SQLite full-text index matches too.
+func matchesTerms(text string, terms []string) bool {
+	ws := words(text)
+	for _, t := range terms {
+		found := false
+		for _, w := range ws {
+			if strings.HasPrefix(w, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// handleSearch serves GET /api/rooms/{pin}/search?q=, the room's stored
+// chat containing words starting with every word of q, newest first. It
+// takes the same limit and channel parameters and the same credentials as
+// handleHistory.
+func handleSearch(manager *HubManager, adminToken string, w http.ResponseWriter, r *http.Request) {
+	pin := r.PathValue("pin")
+	if !isAdmin(adminToken, r) {
+		client := manager.sessions.get(r.Header.Get(sessionHeader))
+		if client == nil || client.hub.pin != pin {
+			writeError(w, http.StatusUnauthorized, codeInvalidSession, "not a member of this room")
+			return
+		}
+	}
+	if manager.keepsNoHistory(pin, w) {
+		return
+	}
+	q := r.URL.Query()
+	terms := words(q.Get("q"))
+	if len(terms) == 0 || len(terms) > maxSearchTerms {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "q must have 1 to "+strconv.Itoa(maxSearchTerms)+" words")
+		return
+	}
+	limit := defaultSearchSize
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxSearchResults {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "limit must be 1 to "+strconv.Itoa(maxSearchResults))
+			return
+		}
+		limit = n
+	}
+	channel := q.Get("channel")
+	if channel != "" {
+		var ok bool
+		if channel, ok = normalizeChannel(channel); !ok {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid channel")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), searchTimeout)
+	defer cancel()
+	// Channels are not indexed, so a filtered search reads a few times the
+	// results it needs.
+	fetch := limit
+	if channel != "" {
+		fetch = maxSearchResults * 4
+	}
+	msgs, err := manager.store.Search(ctx, pin, terms, fetch)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "search unavailable")
+		return
+	}
+	results := []json.RawMessage{}
+	for _, m := range msgs {
+		if len(results) == limit {
+			break
+		}
+		if channel == "" || frameChannel(m.Frame) == channel {
+			results = append(results, m.Frame)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"pin": pin, "q": q.Get("q"), "count": len(results), "messages": results})
+}
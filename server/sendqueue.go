@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// overflowPolicy is what a full send queue does with one more frame. Each
+// policy tries the ones before it first.
+type overflowPolicy int
+
+const (
+	// overflowDisconnect drops the client.
+	overflowDisconnect overflowPolicy = iota
+	// overflowCoalesce discards queued typing events and presence lists a
+	// later one supersedes, and drops the client if that frees no space.
+	overflowCoalesce
+	// overflowDropOldest then discards the oldest queued frames, so the
+	// client stays connected but misses them.
+	overflowDropOldest
+)
+
+// Overflow policy names for SEND_OVERFLOW.
+var overflowPolicies = map[string]overflowPolicy{
+	"disconnect":  overflowDisconnect,
+	"coalesce":    overflowCoalesce,
+	"drop-oldest": overflowDropOldest,
+}
+
+func parseOverflowPolicy(name string) (overflowPolicy, error) {
+	p, ok := overflowPolicies[name]
+	if !ok {
+		return 0, fmt.Errorf("must be disconnect, coalesce or drop-oldest, got %q", name)
+	}
+	return p, nil
+}
+
+var (
+	typingPrefix   = []byte(`{"type":"typing"`)
+	presencePrefix = []byte(`{"type":"presence"`)
+)
+
+// sendQueue holds the frames waiting for a client's writer. It is bounded
+// by size and never blocks the sender; ready is signalled whenever frames
+// are waiting.
+type sendQueue struct {
+	mu       sync.Mutex
+	frames   [][]byte
+	ready    chan struct{}
+	size     int
+	overflow overflowPolicy
+}
+
+func newSendQueue(size int, overflow overflowPolicy) *sendQueue {
+	return &sendQueue{ready: make(chan struct{}, 1), size: size, overflow: overflow}
+}
+
+// push queues frame, making room by the overflow policy if the queue is
+// full. It reports false if there was no room and the client should be
+// dropped.
+func (q *sendQueue) push(frame []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.frames) >= q.size && q.overflow >= overflowCoalesce {
+		q.coalesce(frame)
+	}
+	if len(q.frames) >= q.size {
+		if q.overflow < overflowDropOldest {
+			return false
+		}
+		n := len(q.frames) - q.size + 1
+		clear(q.frames[:n])
+		q.frames = q.frames[n:]
+	}
+	q.frames = append(q.frames, frame)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// coalesce drops queued typing events, which are stale by the time a
+// backlog drains, and every presence list but the newest, counting next.
+func (q *sendQueue) coalesce(next []byte) {
+	newest := -1
+	if !bytes.HasPrefix(next, presencePrefix) {
+		for i := len(q.frames) - 1; i >= 0; i-- {
+			if bytes.HasPrefix(q.frames[i], presencePrefix) {
+				newest = i
+				break
+			}
+		}
+	}
+	kept := q.frames[:0]
+	for i, f := range q.frames {
+		if bytes.HasPrefix(f, typingPrefix) || (i != newest && bytes.HasPrefix(f, presencePrefix)) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	clear(q.frames[len(kept):])
+	q.frames = kept
+}
+
+// len is how many frames are waiting.
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames)
+}
+
+// pop returns the oldest queued frame, if any.
+func (q *sendQueue) pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.frames) == 0 {
+		return nil, false
+	}
+	f := q.frames[0]
+	q.frames[0] = nil
+	q.frames = q.frames[1:]
+	return f, true
+}
+
+// drain calls write with each queued frame in order until the queue is
+// empty or write fails.
+func (q *sendQueue) drain(write func([]byte) error) error {
+	for {
+		f, ok := q.pop()
+		if !ok {
+			return nil
+		}
+		if err := write(f); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wireLimits are the timeouts and limits of each client connection, from
+// the config.
+type wireLimits struct {
+	writeWait  time.Duration
+	pongWait   time.Duration
+	pingPeriod time.Duration // < pongWait
+
+	maxMessageSize int64
+	// rejectInvalidUTF8 refuses frames that are not valid UTF-8 instead
+	// of letting the decoder replace bad bytes with U+FFFD.
+	rejectInvalidUTF8 bool
+
+	// sendQueueSize bounds each client's outgoing queue, and sendOverflow
+	// says what happens when it is full.
+	sendQueueSize int
+	sendOverflow  overflowPolicy
+}
+
+func newWireLimits(cfg *Config) wireLimits {
+	return wireLimits{
+		writeWait:         cfg.WriteTimeout,
+		pongWait:          cfg.PongTimeout,
+		pingPeriod:        cfg.PongTimeout * 9 / 10,
+		maxMessageSize:    int64(cfg.MaxMessageSize),
+		rejectInvalidUTF8: cfg.RejectInvalidUTF8,
+		sendQueueSize:     cfg.SendQueueSize,
+		sendOverflow:      cfg.SendOverflow,
+	}
+}
+
+// --- Origin check ---
+
+// originPolicy decides which pages may open a WebSocket.
+type originPolicy struct {
+	// allowNoOrigin admits requests without an Origin header (native and
+	// CLI clients). Browsers always send one on WebSocket upgrades.
+	allowNoOrigin bool
+	// allowed are the cross-origin pages allowed to connect.
+	allowed []originRule
+	proxy   proxyTrust
+}
+
+func (o *originPolicy) allow(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return o.allowNoOrigin
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || u.User != nil || (u.Path != "" && u.Path != "/") {
+		return false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	// Same origin: exact scheme, host and port match with the request.
+	if u.Scheme == o.proxy.requestScheme(r) && originAddr(u) == o.proxy.requestAddr(r) {
+		return true
+	}
+
+	for _, rule := range o.allowed {
+		if rule.matches(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// originAddr returns the lowercased host:port of an origin, filling in the
+// scheme's default port.
+func originAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultPort(u.Scheme)
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// requestScheme returns the scheme the request arrived over: https on a TLS
+// listener, or whatever a trusted proxy says in X-Forwarded-Proto.
+func (p proxyTrust) requestScheme(r *http.Request) string {
+	if p.headers {
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		switch proto = strings.ToLower(strings.TrimSpace(proto)); proto {
+		case "http", "https":
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestAddr returns the lowercased host:port the request was sent to,
+// filling in the request scheme's default port when the Host header omits
+// one.
+func (p proxyTrust) requestAddr(r *http.Request) string {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, port = r.Host, defaultPort(p.requestScheme(r))
+	}
+	return net.JoinHostPort(strings.ToLower(host), port)
+}
+
+func defaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+func newUpgrader(cfg *Config) *websocket.Upgrader {
+	origins := &originPolicy{
+		allowNoOrigin: cfg.AllowNoOrigin,
+		allowed:       cfg.AllowedOrigins,
+		proxy:         newProxyTrust(cfg),
+	}
+	return &websocket.Upgrader{
+		Subprotocols:      []string{subprotoProto, subprotoJSON, tokenSubprotocol},
+		ReadBufferSize:    cfg.ReadBufferSize,
+		WriteBufferSize:   cfg.WriteBufferSize,
+		EnableCompression: true,
+		CheckOrigin: func(r *http.Request) bool {
+			ok := origins.allow(r)
+			slog.Debug("websocket origin check", "origin", r.Header.Get("Origin"), "host", r.Host, "allow", ok)
+			return ok
+		},
+	}
+}
+
+// Server is a GoChat chat service: rooms, their WebSocket, SSE and HTTP
+// APIs, and the background work behind them. Mount Handler in an HTTP
+// server and call Shutdown when done. A process may run several Servers;
+// only the tracer set up by OTLP_ENDPOINT is shared between them.
+type Server struct {
+	cfg      *Config
+	manager  *HubManager
+	store    Store
+	handler  http.Handler
+	upgrader *websocket.Upgrader
+
+	// stopBg cancels the background workers; persisted is closed once the
+	// persister has flushed.
+	stopBg    context.CancelFunc
+	persisted chan struct{}
+
+	stopTracing func(context.Context) error
+}
+
+// New opens the store named by cfg and starts the background workers:
+// history writes and pruning, idle-room sweeps, the backplane, Web Push,
+// link previews and the MOTD. cfg should come from LoadConfig or
+// DefaultConfig; New does not validate it again.
+func New(cfg *Config) (*Server, error) {
+	s := &Server{
+		cfg:         cfg,
+		upgrader:    newUpgrader(cfg),
+		persisted:   make(chan struct{}),
+		stopTracing: func(context.Context) error { return nil },
+	}
+	if cfg.OTLPEndpoint != "" {
+		if otelHook == nil {
+			return nil, fmt.Errorf("OTLP_ENDPOINT needs a binary built with -tags otel")
+		}
+		t, stop, err := otelHook(context.Background(), cfg.OTLPEndpoint)
+		if err != nil {
+			return nil, fmt.Errorf("starting tracing: %w", err)
+		}
+		tracer, s.stopTracing = t, stop
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("opening store: %w", err)
+	}
+	s.store = store
+
+	manager := newHubManager(cfg, store)
+	s.manager = manager
+	if cfg.VAPIDPrivateKey != "" {
+		if manager.push, err = newPusher(cfg, store); err != nil {
+			_ = store.Close()
+			return nil, err
+		}
+	}
+	loadCtx, cancelLoad := context.WithTimeout(context.Background(), banStoreTimeout)
+	err = manager.loadBans(loadCtx)
+	cancelLoad()
+	if err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("loading bans: %w", err)
+	}
+	if s.handler, err = s.routes(); err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+
+	// bg scopes the background workers; it is cancelled after the rooms
+	// have closed so the persister can flush before the store closes.
+	bg, stopBg := context.WithCancel(context.Background())
+	s.stopBg = stopBg
+	go func() {
+		manager.persist.run(bg)
+		close(s.persisted)
+	}()
+	go manager.pruneStore(bg)
+	go manager.sweepIdle(bg)
+	switch {
+	case cfg.RedisURL != "":
+		manager.backplane = newRedisBackplane(cfg.RedisURL, manager.deliverRemote, manager.remotePresence)
+	case cfg.NATSURL != "":
+		manager.backplane = newNATSBackplane(cfg.NATSURL, manager.deliverRemote, manager.remotePresence)
+	}
+	if manager.backplane != nil {
+		go manager.backplane.Run(bg)
+		go manager.heartbeatPresence(bg)
+	}
+	if manager.push != nil {
+		go manager.push.run(bg)
+	}
+	if cfg.LinkPreviews {
+		manager.unfurl = newUnfurler()
+		go manager.unfurl.run(bg)
+	}
+	if cfg.MOTDURL != "" {
+		manager.motd = newMOTDSource(cfg.MOTDURL, cfg.MOTDInterval)
+		go manager.motd.run(bg)
+	}
+	return s, nil
+}
+
+// Handler serves every GoChat route, from /ws to the admin API, and the
+// web client from cfg.StaticDir when it is set. To mount it under a
+// prefix of another mux, strip the prefix:
+//
+//	mux.Handle("/chat/", http.StripPrefix("/chat", srv.Handler()))
+func (s *Server) Handler() http.Handler { return s.handler }
+
+// routes builds the handler for every route.
+func (s *Server) routes() (http.Handler, error) {
+	cfg, manager := s.cfg, s.manager
+
+	mux := http.NewServeMux()
+
+	// --- Serve static files ---
+	if dir := cfg.StaticDir; dir != "" {
+		mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(dir))))
+
+		// --- Serve root & fallback routes ---
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			file := filepath.Join(dir, filepath.FromSlash(r.URL.Path))
+			if _, err := os.Stat(file); os.IsNotExist(err) || strings.HasSuffix(r.URL.Path, "/") {
+				http.ServeFile(w, r, filepath.Join(dir, "index.html"))
+				return
+			}
+			http.ServeFile(w, r, file)
+		})
+	}
+
+	// --- WebSocket route ---
+	conns := newConnLimiter(cfg.MaxConnsPerIP, cfg.MaxConns, manager.proxy)
+	mux.HandleFunc("/ws", conns.limit(func(w http.ResponseWriter, r *http.Request) {
+		serveWs(manager, s.upgrader, w, r)
+	}))
+
+	// --- SSE fallback ---
+	mux.HandleFunc("GET /sse", conns.limit(func(w http.ResponseWriter, r *http.Request) {
+		serveSSE(manager, w, r)
+	}))
+	mux.HandleFunc("POST /sse/send", func(w http.ResponseWriter, r *http.Request) {
+		serveSSESend(manager, w, r)
+	})
+
+	// --- Uploads ---
+	if cfg.UploadDir != "" {
+		blobs, err := newDiskBlobs(cfg.UploadDir)
+		if err != nil {
+			return nil, fmt.Errorf("opening upload dir: %w", err)
+		}
+		up := &uploader{blobs: blobs, maxBytes: cfg.UploadMaxBytes, types: make(map[string]bool), thumbSize: cfg.UploadThumbSize}
+		for _, t := range cfg.UploadTypes {
+			up.types[t] = true
+		}
+		mux.HandleFunc("POST /upload", func(w http.ResponseWriter, r *http.Request) {
+			up.serveUpload(manager, w, r)
+		})
+		mux.HandleFunc("GET /uploads/{name}", blobs.serve)
+	}
+
+	// Generated avatars for members who have not chosen one.
+	mux.HandleFunc("GET /avatars/{file}", handleIdenticon)
+
+	// --- Rooms ---
+	mux.HandleFunc("POST /api/rooms", func(w http.ResponseWriter, r *http.Request) {
+		handleCreateRoom(manager, cfg.AdminToken, w, r)
+	})
+	mux.HandleFunc("GET /api/rooms", func(w http.ResponseWriter, r *http.Request) {
+		handleListRooms(manager, cfg.AdminToken, w, r)
+	})
+	mux.HandleFunc("GET /api/rooms/{pin}/export", func(w http.ResponseWriter, r *http.Request) {
+		handleExport(manager, cfg.AdminToken, w, r)
+	})
+	mux.HandleFunc("GET /api/rooms/{pin}/messages", func(w http.ResponseWriter, r *http.Request) {
+		handleHistory(manager, cfg.AdminToken, w, r)
+	})
+	mux.HandleFunc("GET /api/rooms/{pin}/search", func(w http.ResponseWriter, r *http.Request) {
+		handleSearch(manager, cfg.AdminToken, w, r)
+	})
+	mux.HandleFunc("POST /api/rooms/{pin}/messages", func(w http.ResponseWriter, r *http.Request) {
+		handlePostMessage(manager, cfg.AdminToken, w, r)
+	})
+	mux.HandleFunc("PUT /api/rooms/{pin}/settings", func(w http.ResponseWriter, r *http.Request) {
+		handleRoomSettings(manager, cfg.AdminToken, w, r)
+	})
+	mux.HandleFunc("GET /api/rooms/{pin}/audit", func(w http.ResponseWriter, r *http.Request) {
+		handleRoomAudit(manager, cfg.AdminToken, w, r)
+	})
+	mux.HandleFunc("GET /api/rooms/{pin}/threads/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handleThread(manager, cfg.AdminToken, w, r)
+	})
+
+	// --- Presence ---
+	mux.HandleFunc("GET /rooms/{pin}/members", func(w http.ResponseWriter, r *http.Request) {
+		handleRoomMembers(manager, w, r)
+	})
+
+	// --- Health check ---
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("OK"))
+	})
+
+	// --- Readiness (false while in maintenance) ---
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if manager.maintenance.Load() {
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("OK"))
+	})
+
+	// --- Auth ---
+	if manager.tokens != nil {
+		mux.HandleFunc("POST /api/token", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+			handleIssueToken(manager.tokens, w, r)
+		}))
+		signin := newSignIn(cfg, s.store)
+		mux.HandleFunc("GET /auth/me", signin.handleMe)
+		mux.HandleFunc("POST /auth/logout", signin.handleLogout)
+		if cfg.OAuthProvider != "" {
+			login := newOAuthLogin(cfg, signin)
+			mux.HandleFunc("GET /auth/login", login.handleLogin)
+			mux.HandleFunc("GET /auth/callback", login.handleCallback)
+		}
+		if cfg.Accounts {
+			accts := newAccounts(signin, s.store, manager.proxy)
+			mux.HandleFunc("POST /api/accounts", accts.handleRegister)
+			mux.HandleFunc("POST /api/login", accts.handleLogin)
+			mux.HandleFunc("GET /api/account", accts.handleGet)
+			mux.HandleFunc("PUT /api/account", accts.handleUpdate)
+			mux.HandleFunc("GET /api/account/dms", accts.handleDMs)
+		}
+		if push := manager.push; push != nil {
+			mux.HandleFunc("GET /api/push/key", push.handleKey)
+			mux.HandleFunc("POST /api/push/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+				push.handleSubscribe(signin, w, r)
+			})
+			mux.HandleFunc("DELETE /api/push/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+				push.handleUnsubscribe(signin, w, r)
+			})
+		}
+	}
+
+	// --- Admin ---
+	mux.HandleFunc("/admin/maintenance", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleMaintenance(manager, w, r)
+	}))
+	mux.HandleFunc("POST /admin/drain", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleServerDrain(manager, cfg.DrainDelay, w)
+	}))
+	mux.HandleFunc("POST /rooms/{pin}/drain", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleRoomDrain(manager, w, r)
+	}))
+	mux.HandleFunc("GET /api/admin/rooms", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminRooms(manager, w, r)
+	}))
+	mux.HandleFunc("GET /api/admin/rooms/{pin}", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminRoom(manager, w, r)
+	}))
+	mux.HandleFunc("DELETE /api/admin/rooms/{pin}", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminCloseRoom(manager, w, r)
+	}))
+	mux.HandleFunc("DELETE /api/admin/rooms/{pin}/clients/{id}", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminKick(manager, w, r)
+	}))
+	mux.HandleFunc("GET /api/admin/bans", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminBans(manager, w, r)
+	}))
+	mux.HandleFunc("POST /api/admin/bans", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminBan(manager, w, r)
+	}))
+	mux.HandleFunc("DELETE /api/admin/bans/{kind}/{value}", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminUnban(manager, w, r)
+	}))
+	mux.HandleFunc("GET /api/admin/audit", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminAudit(manager, w, r)
+	}))
+	mux.HandleFunc("POST /api/admin/announce", requireAdmin(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		handleAdminAnnounce(manager, w, r)
+	}))
+	if cfg.DebugEndpoints {
+		registerDebug(mux, manager, cfg.AdminToken)
+	}
+
+	return mux, nil
+}
+
+// Shutdown refuses new connections, tells every room the server is going
+// away and closes them, then flushes pending history writes, closes the
+// store and stops tracing. It gives up waiting when ctx expires.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.manager.shutdown(ctx)
+	s.stopBg()
+	select {
+	case <-s.persisted:
+	case <-ctx.Done():
+		slog.Warn("store queue not flushed before timeout")
+	}
+	if cerr := s.store.Close(); err == nil {
+		err = cerr
+	}
+	if terr := s.stopTracing(ctx); terr != nil {
+		slog.Warn("flushing traces failed", "err", terr)
+	}
+	return err
+}
+
+// Drain takes the server out of rotation for a rolling deploy. It refuses
+// new connections at once, so /readyz fails, waits delay for load
+// balancers to notice, then closes every room with code 1012 so clients
+// reconnect elsewhere. Call Shutdown afterwards.
+func (s *Server) Drain(ctx context.Context, delay time.Duration) error {
+	return s.manager.drain(ctx, delay)
+}
+
+// DrainRequested is closed once POST /admin/drain asks for a drain. The
+// Server does not drain by itself; whoever runs it calls Drain.
+func (s *Server) DrainRequested() <-chan struct{} { return s.manager.drainRequested }
+
+// RequestDrain closes DrainRequested, as POST /admin/drain does.
+func (s *Server) RequestDrain() { s.manager.requestDrain() }
+
+// GRPC returns a server for the gochat.v1.GoChat service, serving TLS
+// with tlsConfig unless it is nil, or nil if the binary was built without
+// -tags grpc.
+func (s *Server) GRPC(tlsConfig *tls.Config) RPCServer {
+	if grpcHook == nil {
+		return nil
+	}
+	return grpcHook(s.manager, s.cfg.AdminToken, tlsConfig)
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name  string
+		proxy bool
+		hops  int
+		xff   []string
+		want  string
+	}{
+		{name: "direct", want: "192.0.2.1"},
+		{name: "header ignored without a proxy", xff: []string{"203.0.113.9"}, want: "192.0.2.1"},
+		{name: "one proxy", proxy: true, hops: 1, xff: []string{"203.0.113.9"}, want: "203.0.113.9"},
+		{name: "spoofed leftmost entry", proxy: true, hops: 1, xff: []string{"10.0.0.1, 203.0.113.9"}, want: "203.0.113.9"},
+		{name: "spoofed header line", proxy: true, hops: 1, xff: []string{"10.0.0.1", "203.0.113.9"}, want: "203.0.113.9"},
+		{name: "two proxies", proxy: true, hops: 2, xff: []string{"10.0.0.1, 203.0.113.9, 198.51.100.7"}, want: "203.0.113.9"},
+		{name: "fewer entries than hops", proxy: true, hops: 2, xff: []string{"203.0.113.9"}, want: "203.0.113.9"},
+		{name: "ipv6", proxy: true, hops: 1, xff: []string{"2001:db8::1"}, want: "2001:db8::1"},
+		{name: "not an address", proxy: true, hops: 1, xff: []string{"203.0.113.9, unknown"}, want: "192.0.2.1"},
+		{name: "no header", proxy: true, hops: 1, want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.RemoteAddr = "192.0.2.1:5000"
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := (proxyTrust{headers: tt.proxy, hops: tt.hops}).clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
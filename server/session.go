@@ -0,0 +1,57 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// sessionHeader carries a client's session token on HTTP requests made on
+// behalf of its connection, such as SSE sends and uploads.
+const sessionHeader = "X-GoChat-Session"
+
+// sessions maps connection ids to live clients so HTTP requests can act
+// as them. A token is "<id>.<key>"; only the client itself learns the key,
+// from its welcome message or SSE session event.
+type sessions struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+// add registers c and returns its session token.
+func (s *sessions) add(c *Client) string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	c.sessionKey = hex.EncodeToString(b[:])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients == nil {
+		s.clients = make(map[string]*Client)
+	}
+	s.clients[c.id] = c
+	return c.sessionToken()
+}
+
+func (s *sessions) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, id)
+}
+
+// get returns the client for a session token, or nil.
+func (s *sessions) get(token string) *Client {
+	id, key, _ := strings.Cut(token, ".")
+	s.mu.Lock()
+	c := s.clients[id]
+	s.mu.Unlock()
+	if c == nil || subtle.ConstantTimeCompare([]byte(c.sessionKey), []byte(key)) != 1 {
+		return nil
+	}
+	return c
+}
+
+func (c *Client) sessionToken() string {
+	return c.id + "." + c.sessionKey
+}
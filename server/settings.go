@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// RoomSettings are per-room knobs changed at runtime with
+// {"type":"settings","settings":{...}} or PUT /api/rooms/{pin}/settings.
+// Each change replaces them all, so clients send back the current
+// settings with their edits.
+type RoomSettings struct {
+	// Rate caps the room's total chat throughput in messages per second,
+	// across all senders. Zero means unlimited.
+	Rate float64 `json:"rate"`
+	// Burst is how many messages may exceed Rate momentarily.
+	Burst int `json:"burst"`
+
+	// Topic is a one-line summary, also shown in the lobby, and
+	// Description a longer text about the room.
+	Topic       string `json:"topic,omitempty"`
+	Description string `json:"description,omitempty"`
+	// SlowMode is how many seconds each member must wait between chat
+	// messages; zero turns slow mode off.
+	SlowMode int `json:"slow_mode,omitempty"`
+}
+
+// Bounds on what may be configured.
+const (
+	maxRoomRate       = 1000
+	maxDescriptionLen = 2000
+	maxSlowMode       = 6 * 60 * 60
+)
+
+func (s *RoomSettings) validate() *parseError {
+	if s.Rate < 0 || s.Rate > maxRoomRate {
+		return &parseError{codeInvalidSettings, "rate must be between 0 and 1000"}
+	}
+	if s.Burst < 0 || s.Burst > maxRoomRate {
+		return &parseError{codeInvalidSettings, "burst must be between 0 and 1000"}
+	}
+	s.Topic = strings.Join(strings.Fields(s.Topic), " ")
+	if utf8.RuneCountInString(s.Topic) > maxTopicLen {
+		return &parseError{codeInvalidSettings, fmt.Sprintf("topic must be at most %d characters", maxTopicLen)}
+	}
+	s.Description = strings.TrimSpace(s.Description)
+	if utf8.RuneCountInString(s.Description) > maxDescriptionLen {
+		return &parseError{codeInvalidSettings, fmt.Sprintf("description must be at most %d characters", maxDescriptionLen)}
+	}
+	if s.SlowMode < 0 || s.SlowMode > maxSlowMode {
+		return &parseError{codeInvalidSettings, fmt.Sprintf("slow_mode must be between 0 and %d seconds", maxSlowMode)}
+	}
+	return nil
+}
+
+// applySettings installs new settings and rebuilds anything derived from
+// them. Only run may call it, or the creator before run starts.
+func (h *Hub) applySettings(s RoomSettings) {
+	h.settings = s
+	h.limiter = nil
+	if s.Rate > 0 {
+		h.limiter = newTokenBucket(s.Rate, s.Burst)
+	}
+	h.topic.Store(&s.Topic)
+}
+
+// changeSettings installs settings on behalf of c, or the admin if c is
+// nil, and announces them to the room. Moderators may change the topic,
+// description and slow mode; the rate limit needs permSettings. Only run
+// may call it.
+func (h *Hub) changeSettings(c *Client, s RoomSettings) *parseError {
+	if c != nil && !c.can(permTopic) {
+		return &parseError{codeForbidden, "only owners and moderators can change settings"}
+	}
+	if pe := s.validate(); pe != nil {
+		return pe
+	}
+	if c != nil && !c.can(permSettings) && (s.Rate != h.settings.Rate || s.Burst != h.settings.Burst) {
+		return &parseError{codeForbidden, "only the room owner can change the rate limit"}
+	}
+	h.applySettings(s)
+	h.log.Info("settings changed", "settings", h.settings)
+	h.audit(c, AuditEntry{Action: auditSettings, Detail: auditJSON(s)})
+	h.fanOut(h.frame(&Message{Type: "settings", Settings: &s}))
+	return nil
+}
+
+// updateSettings handles a settings message.
+func (h *Hub) updateSettings(msg *Message) {
+	if msg.Settings == nil {
+		msg.from.trySend(errorFrame(codeInvalidSettings, "settings object required"))
+		return
+	}
+	if pe := h.changeSettings(msg.from, *msg.Settings); pe != nil {
+		msg.from.trySend(errorFrame(pe.code, pe.detail))
+	}
+}
+
+// slowModeAllows reports whether c may chat now under the room's slow mode,
+// recording the message if so and otherwise telling c how long is left.
+// Moderators and server messages are exempt. Only run may call it.
+func (h *Hub) slowModeAllows(c *Client) bool {
+	if h.settings.SlowMode == 0 || c == nil || c.can(permTopic) {
+		return true
+	}
+	now := time.Now()
+	if wait := c.lastChat.Add(time.Duration(h.settings.SlowMode) * time.Second).Sub(now); wait > 0 {
+		c.trySend(cooldownFrame(codeSlowMode, fmt.Sprintf("slow mode is on: one message every %ds", h.settings.SlowMode), wait))
+		return false
+	}
+	c.lastChat = now
+	return true
+}
+
+// settingsRequest asks run to change the room's settings from the REST
+// API; client is nil for the admin.
+type settingsRequest struct {
+	settings RoomSettings
+	client   *Client
+	reply    chan *parseError
+}
+
+// configure hands a settingsRequest to run, reporting errHubStopped if the
+// room closed first.
+func (h *Hub) configure(req settingsRequest) (*parseError, error) {
+	select {
+	case h.configs <- req:
+		return <-req.reply, nil
+	case <-h.done:
+		return nil, errHubStopped
+	}
+}
+
+// handleRoomSettings serves PUT /api/rooms/{pin}/settings with a settings
+// object, for the admin token or the X-GoChat-Session header of one of the
+// room's moderators.
+func handleRoomSettings(manager *HubManager, adminToken string, w http.ResponseWriter, r *http.Request) {
+	pin := r.PathValue("pin")
+	var client *Client
+	if !isAdmin(adminToken, r) {
+		client = manager.sessions.get(r.Header.Get(sessionHeader))
+		if client == nil || client.hub.pin != pin {
+			writeError(w, http.StatusUnauthorized, codeInvalidSession, "not a member of this room")
+			return
+		}
+	}
+	hub := manager.lookup(pin)
+	if hub == nil {
+		writeError(w, http.StatusNotFound, codeRoomNotFound, "room not found")
+		return
+	}
+	var s RoomSettings
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8192)).Decode(&s); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON body")
+		return
+	}
+	pe, err := hub.configure(settingsRequest{settings: s, client: client, reply: make(chan *parseError, 1)})
+	switch {
+	case err != nil:
+		writeError(w, http.StatusNotFound, codeRoomNotFound, "room not found")
+	case pe != nil && pe.code == codeForbidden:
+		writeError(w, http.StatusForbidden, pe.code, pe.detail)
+	case pe != nil:
+		writeError(w, http.StatusBadRequest, pe.code, pe.detail)
+	default:
+		writeJSON(w, http.StatusOK, s)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// Causes passed to a hub's stop, reported to its clients as the close
+// frame reason.
+var (
+	errServerShutdown = errors.New("server restarting")
+	errServerDraining = errors.New("server draining, reconnect")
+	errRoomMigrated   = errors.New("room migrated")
+)
+
+// shutdown refuses new connections, tells every room the server is going
+// away, closes them with a going-away close frame and waits for those
+// frames to be written or ctx to expire.
+func (m *HubManager) shutdown(ctx context.Context) error {
+	m.maintenance.Store(true)
+	return m.closeRooms(ctx, errServerShutdown)
+}
+
+// closeRooms sends every room a system notice, stops it with cause and
+// waits until the rooms have closed and their final frames are written.
+func (m *HubManager) closeRooms(ctx context.Context, cause error) error {
+	hubs := m.hubList()
+	for _, h := range hubs {
+		h.draining.Store(true)
+		// publish returns once run has taken the notice, so it is fanned
+		// out before run sees the cancellation.
+		h.publish(&Message{Type: "system", Msg: cause.Error()})
+		h.stop(cause)
+	}
+	for _, h := range hubs {
+		select {
+		case <-h.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	flushed := make(chan struct{})
+	go func() {
+		m.conns.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	slog.Info("closed rooms", "rooms", len(hubs))
+	return nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestShutdownWithOpenConnection checks that an open connection is closed
+// with 1001 as soon as shutdown begins and does not hold it up.
+func TestShutdownWithOpenConnection(t *testing.T) {
+	const grace = time.Second
+	cfg := DefaultConfig()
+	cfg.StaticDir, cfg.UploadDir = "", ""
+	cfg.RoomIdleTTL, cfg.ResumeWindow = 0, 0
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Wired as main wires its server.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	ts := httptest.NewUnstartedServer(s.Handler())
+	ts.Config.BaseContext = func(net.Listener) context.Context { return baseCtx }
+	ts.Config.RegisterOnShutdown(cancelBase)
+	ts.Start()
+	defer ts.Close()
+
+	amy := dial(t, ts, "1234", "amy", nil)
+	amy.expect("system")
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := ts.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	defer s.Shutdown(ctx)
+
+	var ce *websocket.CloseError
+	for {
+		_, err := amy.next(grace)
+		if err == nil {
+			continue
+		}
+		if !errors.As(err, &ce) {
+			t.Fatalf("read ended with %v, want a close frame", err)
+		}
+		break
+	}
+	if ce.Code != websocket.CloseGoingAway {
+		t.Errorf("close code = %d, want %d", ce.Code, websocket.CloseGoingAway)
+	}
+	for {
+		if s.manager.lookup("1234") == nil {
+			break
+		}
+		if time.Since(start) > grace {
+			t.Fatal("member still registered after shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
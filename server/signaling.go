@@ -0,0 +1,54 @@
+package server
+
+import "bytes"
+
+// Limits on WebRTC signaling payloads.
+const (
+	maxSDPLen       = 6000
+	maxCandidateLen = 1024
+)
+
+// validateSignal checks an offer, answer or ice-candidate and clears the
+// fields only the server may set. These are relayed between two members so
+// they can set up a peer-to-peer call; the media never touches the server.
+func validateSignal(m *Message) *parseError {
+	to, sdp, candidate := m.To, m.SDP, m.Candidate
+	*m = Message{Type: m.Type, To: to, SDP: sdp, Candidate: candidate}
+	if m.To == "" {
+		return &parseError{codeInvalidMessage, m.Type + " requires to"}
+	}
+	if m.Type == "ice-candidate" {
+		if len(m.Candidate) > maxCandidateLen || !bytes.HasPrefix(bytes.TrimSpace(m.Candidate), []byte("{")) {
+			return &parseError{codeInvalidMessage, "candidate must be an object of at most 1024 bytes"}
+		}
+		m.SDP = ""
+		return nil
+	}
+	if m.SDP == "" || len(m.SDP) > maxSDPLen {
+		return &parseError{codeInvalidMessage, "sdp is required and at most 6000 bytes"}
+	}
+	m.Candidate = nil
+	return nil
+}
+
+// relaySignal forwards a signaling message to its one target, stamped with
+// the sender's connection id so the answer can find its way back. A target
+// that ignores the sender never sees it. Only run may call it.
+func (h *Hub) relaySignal(msg *Message) {
+	target := h.findClient(msg.To)
+	if target == nil || target == msg.from {
+		msg.from.trySend(errorFrame(codePeerUnavailable, "peer "+`"`+msg.To+`"`+" is not in this room"))
+		return
+	}
+	if target.ignores(msg.User) {
+		return
+	}
+	h.sendTo(target, h.frame(&Message{
+		Type:      msg.Type,
+		User:      msg.User,
+		From:      msg.from.id,
+		To:        target.id,
+		SDP:       msg.SDP,
+		Candidate: msg.Candidate,
+	}))
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sessionCookie holds a signed-in user's session: a JWT signed like those
+// from /api/token, so the upgrade accepts it in place of a token. It is
+// SameSite=Lax, so cross-site pages cannot join rooms or change accounts
+// with it.
+const sessionCookie = "gochat_session"
+
+// signIn issues and reads the session cookies of users signed in with an
+// account or through an OAuth provider.
+type signIn struct {
+	sessions *tokenIssuer
+	store    Store
+	// loginURL starts OAuth sign-in; empty when no provider is set.
+	loginURL string
+}
+
+func newSignIn(cfg *Config, store Store) *signIn {
+	s := &signIn{sessions: &tokenIssuer{secret: []byte(cfg.JWTSecret), ttl: cfg.SessionTTL}, store: store}
+	if cfg.OAuthProvider != "" {
+		s.loginURL = "/auth/login"
+	}
+	return s
+}
+
+// secureRequest reports whether r reached the server, or the proxy in
+// front of it, over HTTPS, so cookies set in reply should be Secure.
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// begin signs a session for the subject, name and avatar of id and sets
+// it as the session cookie. The token is returned too, for clients that
+// cannot use cookies.
+func (s *signIn) begin(w http.ResponseWriter, r *http.Request, id Claims) (string, Claims, error) {
+	token, claims, err := s.sessions.sign(id.Subject, id.Name, id.Avatar, time.Now())
+	if err != nil {
+		return "", claims, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Unix(claims.ExpiresAt, 0),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, claims, nil
+}
+
+// cookieClaims returns the identity in r's session cookie, or nil if it
+// has none that verifies. A stale cookie is ignored rather than refused, so
+// its holder can still join as a guest.
+func cookieClaims(tokens *tokenIssuer, r *http.Request) *Claims {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || tokens == nil {
+		return nil
+	}
+	claims, err := tokens.verify(c.Value, time.Now())
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// caller returns who made r: the user in its bearer token, else in its
+// session cookie, or nil.
+func (s *signIn) caller(r *http.Request) *Claims {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		claims, err := s.sessions.verify(token, time.Now())
+		if err != nil {
+			return nil
+		}
+		return claims
+	}
+	return cookieClaims(s.sessions, r)
+}
+
+// handleLogout serves POST /auth/logout, clearing the session cookie.
+func (s *signIn) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: secureRequest(r), SameSite: http.SameSiteLaxMode})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMe serves GET /auth/me: the signed-in user, with the account's
+// profile for account holders. A 401 carries login_url when OAuth sign-in
+// is on.
+func (s *signIn) handleMe(w http.ResponseWriter, r *http.Request) {
+	claims := s.caller(r)
+	if claims == nil {
+		const detail = "not signed in"
+		writeJSON(w, http.StatusUnauthorized, struct {
+			errorReply
+			LoginURL string `json:"login_url,omitempty"`
+		}{errorReply{Type: "error", Code: codeAuthRequired, Detail: detail, Error: codeAuthRequired, Reason: detail}, s.loginURL})
+		return
+	}
+	me := map[string]any{"sub": claims.Subject, "name": claims.Name, "avatar": claims.Avatar, "expires_at": claims.ExpiresAt}
+	if username, ok := accountUsername(claims.Subject); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), accountTimeout)
+		defer cancel()
+		if a, err := s.store.Account(ctx, username); err == nil {
+			me["account"] = a.profile()
+		}
+	}
+	writeJSON(w, http.StatusOK, me)
+}
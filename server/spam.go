@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SpamLimits configure the heuristics that mute a member automatically,
+// on top of the plain rate limits.
+type SpamLimits struct {
+	// Mute is how long an offender is muted; zero disables detection.
+	Mute time.Duration
+	// Window is how far back the heuristics look.
+	Window time.Duration
+	// Repeats is how many times in a row the same text may be sent within
+	// Window; zero allows any number.
+	Repeats int
+	// Links is how many links may be sent within Window; zero allows any
+	// number.
+	Links int
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// spamTracker is one member's recent chat, as the heuristics see it.
+// Owned by run.
+type spamTracker struct {
+	last    string
+	repeats int
+	lastAt  time.Time
+	links   []time.Time
+}
+
+// check records text sent at now and returns why it is spam, or "".
+func (t *spamTracker) check(text string, now time.Time, l SpamLimits) string {
+	norm := strings.Join(strings.Fields(strings.ToLower(text)), " ")
+	if norm == t.last && now.Sub(t.lastAt) < l.Window {
+		t.repeats++
+	} else {
+		t.last, t.repeats = norm, 1
+	}
+	t.lastAt = now
+	if l.Repeats > 0 && t.repeats > l.Repeats {
+		return "repeating the same message"
+	}
+
+	cutoff := now.Add(-l.Window)
+	kept := t.links[:0]
+	for _, at := range t.links {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	t.links = kept
+	for range linkPattern.FindAllStringIndex(text, l.Links+1) {
+		t.links = append(t.links, now)
+	}
+	if l.Links > 0 && len(t.links) > l.Links {
+		return "posting too many links"
+	}
+	return ""
+}
+
+// silenced reports whether a chat or dm must be dropped because its
+// sender is muted or has just earned a mute. Owners and moderators are
+// never muted automatically. Only run may call it.
+func (h *Hub) silenced(msg *Message) bool {
+	c, limits := msg.from, h.manager.spam
+	if c == nil || limits.Mute <= 0 {
+		return false
+	}
+	now := time.Now()
+	if until, ok := h.mutes[c.identity()]; ok {
+		if now.Before(until) {
+			c.trySend(cooldownFrame(codeMuted, fmt.Sprintf("you are muted for another %v", until.Sub(now).Round(time.Second)), until.Sub(now)))
+			return true
+		}
+		delete(h.mutes, c.identity())
+	}
+	if c.role.rank() > 0 || msg.opaque() {
+		return false
+	}
+	if reason := c.spam.check(msg.Msg, now, limits); reason != "" {
+		h.mute(c, reason, now.Add(limits.Mute))
+		return true
+	}
+	return false
+}
+
+// mute silences c until the given time. The mute is kept by identity, so
+// reconnecting does not lift it. Only run may call it.
+func (h *Hub) mute(c *Client, reason string, until time.Time) {
+	if h.mutes == nil {
+		h.mutes = make(map[string]time.Time)
+	}
+	h.mutes[c.identity()] = until
+	c.spam = spamTracker{}
+	d := time.Until(until).Round(time.Second)
+	h.reply(c, fmt.Sprintf("You have been muted for %v for %s.", d, reason))
+	c.log.Info("muted for spam", "reason", reason, "for", d)
+}
+
+// expireMutes forgets mutes that have run out. Only run may call it.
+func (h *Hub) expireMutes(now time.Time) {
+	for who, until := range h.mutes {
+		if !now.Before(until) {
+			delete(h.mutes, who)
+		}
+	}
+}
@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package server
+
+// Building with -tags sqlite links a pure-Go SQLite driver so STORE=sqlite works.
+import _ "modernc.org/sqlite"
@@ -0,0 +1,18 @@
+//go:build sqlite
+
+package server
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	storeBackends["sqlite"] = func(t *testing.T) Store {
+		s, err := openSQLStore("sqlite", filepath.Join(t.TempDir(), "gochat.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// sseKeepAlive is how often an idle event stream gets a comment line, so
+// proxies don't time it out.
+const sseKeepAlive = 25 * time.Second
+
+// serveSSE serves GET /sse?pin=..., the receive half of the fallback
+// transport. It takes the same query parameters as /ws and streams the
+// same frames as "message" events.
+func serveSSE(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	pin, client := newClientFromRequest(manager, w, r)
+	if client == nil {
+		return
+	}
+	client.info.Transport = "sse"
+	client.log.Info("sse connection", "user_agent", client.info.UserAgent)
+	if err := manager.join(pin, client); err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeRoomBusy, err.Error())
+		return
+	}
+	token := manager.sessions.add(client)
+	defer manager.sessions.remove(client.id)
+	manager.conns.Add(1)
+	defer manager.conns.Done()
+	defer client.leave(leaveDisconnected)
+
+	// The status goes out only now, so a refused join can still answer
+	// with its own. A writer that cannot flush fails the first event.
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	event := func(name string, data []byte) error {
+		// Long-lived: extend the server's WriteTimeout one write at a time.
+		_ = rc.SetWriteDeadline(time.Now().Add(manager.wire.writeWait))
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	session, _ := json.Marshal(map[string]string{"id": client.id, "session": token})
+	if event("session", session) != nil {
+		return
+	}
+
+	select {
+	case frames := <-client.replay:
+		for _, f := range frames {
+			if event("message", f) != nil {
+				return
+			}
+		}
+	case <-client.done:
+	case <-r.Context().Done():
+		return
+	}
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.done:
+			if client.send.drain(func(f []byte) error { return event("message", f) }) != nil {
+				return
+			}
+			_ = event("close", client.closeEvent())
+			return
+		case <-client.send.ready:
+			if client.send.drain(func(f []byte) error { return event("message", f) }) != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = rc.SetWriteDeadline(time.Now().Add(manager.wire.writeWait))
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// closeEvent is the SSE counterpart of closeFrame: the WebSocket close
+// code and reason the client would have received.
+func (c *Client) closeEvent() []byte {
+	code, reason := c.closeStatus()
+	if code == 0 {
+		code, reason = closeLeft, c.leaveReason
+	}
+	b, _ := json.Marshal(map[string]any{"code": code, "reason": reason})
+	return b
+}
+
+// serveSSESend serves POST /sse/send: one client frame, in the same JSON
+// as over WebSocket, for the session named in the X-GoChat-Session header.
+func serveSSESend(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	client := manager.sessions.get(r.Header.Get(sessionHeader))
+	if client == nil || client.conn != nil {
+		writeError(w, http.StatusUnauthorized, codeInvalidSession, "unknown or expired session")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, manager.wire.frameReadLimit()))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, "frame too large")
+		return
+	}
+
+	client.postMu.Lock()
+	reason := client.handleFrame(body)
+	client.postMu.Unlock()
+	if reason != "" {
+		// The stream sees done close and ends with a close event.
+		client.leave(reason)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
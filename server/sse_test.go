@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSSEHeaders(t *testing.T) {
+	s, ts := startServer(t, nil)
+
+	// A hub that never runs keeps whatever is queued, so this room's join
+	// queue stays full.
+	busy := newHub("5678", s.manager)
+	for range registerQueueSize {
+		if err := busy.enqueue(&Client{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.manager.mu.Lock()
+	s.manager.hubs[busy.pin] = busy
+	s.manager.mu.Unlock()
+	t.Cleanup(func() {
+		// Shutdown would wait on it forever.
+		s.manager.mu.Lock()
+		delete(s.manager.hubs, busy.pin)
+		s.manager.mu.Unlock()
+	})
+
+	tests := []struct {
+		name            string
+		pin             string
+		wantStatus      int
+		wantContentType string
+		wantCode        string
+	}{
+		{name: "stream", pin: "1234", wantStatus: http.StatusOK, wantContentType: "text/event-stream"},
+		{name: "no pin", pin: "", wantStatus: http.StatusBadRequest},
+		{name: "busy room", pin: "5678", wantStatus: http.StatusServiceUnavailable, wantContentType: "application/json", wantCode: codeRoomBusy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/sse?pin="+tt.pin+"&name=amy", nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantContentType != "" && !strings.HasPrefix(resp.Header.Get("Content-Type"), tt.wantContentType) {
+				t.Errorf("Content-Type = %q, want %s", resp.Header.Get("Content-Type"), tt.wantContentType)
+			}
+			if tt.wantCode != "" {
+				var reply errorReply
+				if data, _ := io.ReadAll(resp.Body); json.Unmarshal(data, &reply) != nil || reply.Code != tt.wantCode {
+					t.Errorf("body = %s, want code %q", data, tt.wantCode)
+				}
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+			if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+				t.Errorf("Cache-Control = %q, want no-cache", got)
+			}
+			if got := resp.Header.Get("X-Accel-Buffering"); got != "no" {
+				t.Errorf("X-Accel-Buffering = %q, want no", got)
+			}
+			line, err := bufio.NewReader(resp.Body).ReadString('\n')
+			if err != nil || line != "event: session\n" {
+				t.Errorf("first line = %q, %v; want the session event", line, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,589 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// StoredMessage is one persisted chat message. Frame is the exact JSON that
+// was broadcast, so replay needs no re-encoding.
+type StoredMessage struct {
+	Room  string
+	ID    string
+	Seq   uint64
+	At    time.Time
+	Frame []byte
+}
+
+// Store persists room history. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Append saves a message.
+	Append(ctx context.Context, m StoredMessage) error
+	// Recent returns up to limit of the room's newest messages, oldest first.
+	Recent(ctx context.Context, room string, limit int) ([]StoredMessage, error)
+	// Before returns up to limit of the room's messages older than the one
+	// with id, newest of them last, or errMessageNotFound if id is not
+	// stored.
+	Before(ctx context.Context, room, id string, limit int) ([]StoredMessage, error)
+	// After returns up to limit of the room's messages with a seq above
+	// seq, oldest first.
+	After(ctx context.Context, room string, seq uint64, limit int) ([]StoredMessage, error)
+	// Search returns up to limit of the room's messages whose text holds
+	// every one of terms, newest first.
+	Search(ctx context.Context, room string, terms []string, limit int) ([]StoredMessage, error)
+	// Scan calls fn with each of the room's messages, oldest first,
+	// stopping at the first error fn returns.
+	Scan(ctx context.Context, room string, fn func(StoredMessage) error) error
+	// Update replaces the frame of a stored message, if it is still there.
+	Update(ctx context.Context, m StoredMessage) error
+	// Delete removes one message, if it is still there.
+	Delete(ctx context.Context, room, id string) error
+	// Prune deletes messages and dms received before cutoff and returns
+	// how many.
+	Prune(ctx context.Context, cutoff time.Time) (int, error)
+	// PruneCount deletes all but the newest keep messages of each room and
+	// returns how many.
+	PruneCount(ctx context.Context, keep int) (int, error)
+	// PruneRoom deletes the room's messages received before cutoff and
+	// returns how many.
+	PruneRoom(ctx context.Context, room string, cutoff time.Time) (int, error)
+
+	// AppendDM stores a dm under its conversation's key, held in m.Room.
+	// Dms are kept apart from room messages, which never return them.
+	AppendDM(ctx context.Context, m StoredMessage) error
+	// DMs returns up to limit of the conversation's dms before the one
+	// with id before, or the newest if before is empty, oldest first. It
+	// returns errMessageNotFound if before is not stored.
+	DMs(ctx context.Context, conv, before string, limit int) ([]StoredMessage, error)
+
+	// SavePins replaces the room's pinned messages, in pin order; none
+	// clears them.
+	SavePins(ctx context.Context, room string, pins []StoredMessage) error
+	// Pins returns the room's pinned messages in pin order.
+	Pins(ctx context.Context, room string) ([]StoredMessage, error)
+
+	// SaveBan adds a server-wide ban, replacing any of the same kind and
+	// value.
+	SaveBan(ctx context.Context, b GlobalBan) error
+	// DeleteBan lifts a server-wide ban, if there is one.
+	DeleteBan(ctx context.Context, kind, value string) error
+	// Bans returns every server-wide ban.
+	Bans(ctx context.Context) ([]GlobalBan, error)
+
+	// CreateAccount adds an account, or returns errAccountExists if its
+	// username is taken.
+	CreateAccount(ctx context.Context, a Account) error
+	// Account returns the account with username, or errAccountNotFound.
+	Account(ctx context.Context, username string) (Account, error)
+	// UpdateAccount replaces an existing account's name, avatar and
+	// password hash.
+	UpdateAccount(ctx context.Context, a Account) error
+
+	// SavePushSubscription adds a subscription, replacing any with the same
+	// endpoint, or returns errTooManyPushSubscriptions if its user already
+	// has limit others.
+	SavePushSubscription(ctx context.Context, s PushSubscription, limit int) error
+	// PushSubscriptions returns the user's subscriptions.
+	PushSubscriptions(ctx context.Context, userID string) ([]PushSubscription, error)
+	// DeletePushSubscription removes one of the user's subscriptions, if
+	// it is there.
+	DeletePushSubscription(ctx context.Context, userID, endpoint string) error
+
+	// AppendAudit records a moderation action, assigning its ID.
+	AppendAudit(ctx context.Context, e AuditEntry) error
+	// Audit returns up to limit of room's audit entries with an ID below
+	// before, or the newest if before is 0, newest first. An empty room
+	// returns the entries of every room and the server.
+	Audit(ctx context.Context, room string, before int64, limit int) ([]AuditEntry, error)
+	Close() error
+}
+
+// errMessageNotFound is returned by Store.Before for an unknown id.
+var errMessageNotFound = errors.New("message not found")
+
+// openStore builds the backend named by cfg.Store.
+func openStore(cfg *Config) (Store, error) {
+	switch cfg.Store {
+	case "memory":
+		return newMemoryStore(cfg.StoreRoomLimit), nil
+	case "sqlite":
+		return openSQLStore("sqlite", cfg.StoreDSN)
+	default:
+		return nil, fmt.Errorf("unknown store %q", cfg.Store)
+	}
+}
+
+// memoryStore keeps the newest messages of each room in process memory.
+type memoryStore struct {
+	mu       sync.Mutex
+	rooms    map[string][]StoredMessage
+	limit    int
+	dms      map[string][]StoredMessage
+	pins     map[string][]StoredMessage
+	bans     map[banKey]GlobalBan
+	accounts map[string]Account
+	// push holds push subscriptions by endpoint.
+	push map[string]PushSubscription
+	// audit holds the newest audit entries, oldest first, and auditID the
+	// last ID assigned.
+	audit   []AuditEntry
+	auditID int64
+}
+
+func newMemoryStore(limit int) *memoryStore {
+	return &memoryStore{
+		rooms:    make(map[string][]StoredMessage),
+		limit:    limit,
+		dms:      make(map[string][]StoredMessage),
+		pins:     make(map[string][]StoredMessage),
+		bans:     make(map[banKey]GlobalBan),
+		accounts: make(map[string]Account),
+		push:     make(map[string]PushSubscription),
+	}
+}
+
+func (s *memoryStore) Append(_ context.Context, m StoredMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := append(s.rooms[m.Room], m)
+	if len(msgs) > s.limit {
+		msgs = append([]StoredMessage(nil), msgs[len(msgs)-s.limit:]...)
+	}
+	s.rooms[m.Room] = msgs
+	return nil
+}
+
+func (s *memoryStore) Recent(_ context.Context, room string, limit int) ([]StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := s.rooms[room]
+	if len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	return append([]StoredMessage(nil), msgs...), nil
+}
+
+func (s *memoryStore) Before(_ context.Context, room, id string, limit int) ([]StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := s.rooms[room]
+	i := 0
+	for i < len(msgs) && msgs[i].ID != id {
+		i++
+	}
+	if i == len(msgs) {
+		return nil, errMessageNotFound
+	}
+	return append([]StoredMessage(nil), msgs[max(0, i-limit):i]...), nil
+}
+
+func (s *memoryStore) After(_ context.Context, room string, seq uint64, limit int) ([]StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found []StoredMessage
+	for _, m := range s.rooms[room] {
+		if m.Seq > seq && len(found) < limit {
+			found = append(found, m)
+		}
+	}
+	return found, nil
+}
+
+func (s *memoryStore) Search(_ context.Context, room string, terms []string, limit int) ([]StoredMessage, error) {
+	s.mu.Lock()
+	msgs := append([]StoredMessage(nil), s.rooms[room]...)
+	s.mu.Unlock()
+	var found []StoredMessage
+	for i := len(msgs) - 1; i >= 0 && len(found) < limit; i-- {
+		if matchesTerms(frameText(msgs[i].Frame), terms) {
+			found = append(found, msgs[i])
+		}
+	}
+	return found, nil
+}
+
+func (s *memoryStore) Scan(_ context.Context, room string, fn func(StoredMessage) error) error {
+	s.mu.Lock()
+	msgs := append([]StoredMessage(nil), s.rooms[room]...)
+	s.mu.Unlock()
+	for _, m := range msgs {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Update(_ context.Context, m StoredMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.rooms[m.Room] {
+		if s.rooms[m.Room][i].ID == m.ID {
+			s.rooms[m.Room][i].Frame = m.Frame
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, room, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := s.rooms[room]
+	for i := range msgs {
+		if msgs[i].ID == id {
+			s.rooms[room] = append(msgs[:i:i], msgs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Prune(_ context.Context, cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return pruneBefore(s.rooms, cutoff) + pruneBefore(s.dms, cutoff), nil
+}
+
+// pruneBefore deletes the messages in each list of lists received before
+// cutoff and returns how many.
+func pruneBefore(lists map[string][]StoredMessage, cutoff time.Time) int {
+	n := 0
+	for key, msgs := range lists {
+		i := 0
+		for i < len(msgs) && msgs[i].At.Before(cutoff) {
+			i++
+		}
+		n += i
+		if i == len(msgs) {
+			delete(lists, key)
+		} else if i > 0 {
+			lists[key] = append([]StoredMessage(nil), msgs[i:]...)
+		}
+	}
+	return n
+}
+
+func (s *memoryStore) PruneCount(_ context.Context, keep int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for room, msgs := range s.rooms {
+		if len(msgs) > keep {
+			n += len(msgs) - keep
+			s.rooms[room] = append([]StoredMessage(nil), msgs[len(msgs)-keep:]...)
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryStore) AppendDM(_ context.Context, m StoredMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := append(s.dms[m.Room], m)
+	if len(msgs) > s.limit {
+		msgs = append([]StoredMessage(nil), msgs[len(msgs)-s.limit:]...)
+	}
+	s.dms[m.Room] = msgs
+	return nil
+}
+
+func (s *memoryStore) DMs(_ context.Context, conv, before string, limit int) ([]StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := s.dms[conv]
+	end := len(msgs)
+	if before != "" {
+		end = slices.IndexFunc(msgs, func(m StoredMessage) bool { return m.ID == before })
+		if end < 0 {
+			return nil, errMessageNotFound
+		}
+	}
+	return append([]StoredMessage(nil), msgs[max(0, end-limit):end]...), nil
+}
+
+func (s *memoryStore) SavePins(_ context.Context, room string, pins []StoredMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(pins) == 0 {
+		delete(s.pins, room)
+	} else {
+		s.pins[room] = append([]StoredMessage(nil), pins...)
+	}
+	return nil
+}
+
+func (s *memoryStore) Pins(_ context.Context, room string) ([]StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StoredMessage(nil), s.pins[room]...), nil
+}
+
+func (s *memoryStore) PruneRoom(_ context.Context, room string, cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := s.rooms[room]
+	i := 0
+	for i < len(msgs) && msgs[i].At.Before(cutoff) {
+		i++
+	}
+	if i == len(msgs) {
+		delete(s.rooms, room)
+	} else if i > 0 {
+		s.rooms[room] = append([]StoredMessage(nil), msgs[i:]...)
+	}
+	return i, nil
+}
+
+func (s *memoryStore) SaveBan(_ context.Context, b GlobalBan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bans[banKey{b.Kind, b.Value}] = b
+	return nil
+}
+
+func (s *memoryStore) DeleteBan(_ context.Context, kind, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.bans, banKey{kind, value})
+	return nil
+}
+
+func (s *memoryStore) Bans(_ context.Context) ([]GlobalBan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bans := make([]GlobalBan, 0, len(s.bans))
+	for _, b := range s.bans {
+		bans = append(bans, b)
+	}
+	return bans, nil
+}
+
+func (s *memoryStore) CreateAccount(_ context.Context, a Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, taken := s.accounts[a.Username]; taken {
+		return errAccountExists
+	}
+	s.accounts[a.Username] = a
+	return nil
+}
+
+func (s *memoryStore) Account(_ context.Context, username string) (Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[username]
+	if !ok {
+		return a, errAccountNotFound
+	}
+	return a, nil
+}
+
+func (s *memoryStore) UpdateAccount(_ context.Context, a Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[a.Username]; !ok {
+		return errAccountNotFound
+	}
+	s.accounts[a.Username] = a
+	return nil
+}
+
+func (s *memoryStore) SavePushSubscription(_ context.Context, p PushSubscription, limit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, other := range s.push {
+		if other.UserID == p.UserID && other.Endpoint != p.Endpoint {
+			n++
+		}
+	}
+	if n >= limit {
+		return errTooManyPushSubscriptions
+	}
+	s.push[p.Endpoint] = p
+	return nil
+}
+
+func (s *memoryStore) PushSubscriptions(_ context.Context, userID string) ([]PushSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var subs []PushSubscription
+	for _, p := range s.push {
+		if p.UserID == userID {
+			subs = append(subs, p)
+		}
+	}
+	return subs, nil
+}
+
+func (s *memoryStore) DeletePushSubscription(_ context.Context, userID, endpoint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.push[endpoint]; ok && p.UserID == userID {
+		delete(s.push, endpoint)
+	}
+	return nil
+}
+
+func (s *memoryStore) AppendAudit(_ context.Context, e AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auditID++
+	e.ID = s.auditID
+	s.audit = append(s.audit, e)
+	if len(s.audit) > memoryAuditLimit {
+		s.audit = append([]AuditEntry(nil), s.audit[len(s.audit)-memoryAuditLimit:]...)
+	}
+	return nil
+}
+
+func (s *memoryStore) Audit(_ context.Context, room string, before int64, limit int) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []AuditEntry
+	for i := len(s.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		e := s.audit[i]
+		if (before == 0 || e.ID < before) && (room == "" || e.Room == room) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) Close() error { return nil }
+
+// persistQueueSize bounds chat messages waiting to be written.
+const persistQueueSize = 1024
+
+// Kinds of queued store write.
+const (
+	opAppend = iota
+	opUpdate
+	opDelete
+	opPins
+	opPurge
+	opDM
+	opAudit
+)
+
+var storeOpNames = [...]string{opAppend: "append", opUpdate: "update", opDelete: "delete", opPins: "pins", opPurge: "purge", opDM: "dm", opAudit: "audit"}
+
+// storeOp is one queued write; trace, if set, parents its span. Audit
+// writes carry their entry in audit and pin writes the room's pins in
+// pins, both with the room in m.
+type storeOp struct {
+	kind  int
+	m     StoredMessage
+	pins  []StoredMessage
+	trace context.Context
+	audit AuditEntry
+}
+
+// persister writes messages to the store off the hubs' run loops so a slow
+// backend never stalls a room. Writes are applied in the order queued.
+type persister struct {
+	store Store
+	queue chan storeOp
+}
+
+func newPersister(store Store) *persister {
+	return &persister{store: store, queue: make(chan storeOp, persistQueueSize)}
+}
+
+// save queues m for writing, dropping it if the backend has fallen behind.
+// The write is traced under ctx.
+func (p *persister) save(ctx context.Context, m StoredMessage) {
+	p.enqueue(storeOp{kind: opAppend, m: m, trace: ctx})
+}
+
+// update queues a replacement of m's frame.
+func (p *persister) update(m StoredMessage) {
+	p.enqueue(storeOp{kind: opUpdate, m: m})
+}
+
+// remove queues the deletion of a message.
+func (p *persister) remove(room, id string) {
+	p.enqueue(storeOp{kind: opDelete, m: StoredMessage{Room: room, ID: id}})
+}
+
+// purge queues the deletion of the room's messages received before
+// cutoff.
+func (p *persister) purge(room string, cutoff time.Time) {
+	p.enqueue(storeOp{kind: opPurge, m: StoredMessage{Room: room, At: cutoff}})
+}
+
+// saveDM queues a dm for writing under its conversation's key, held in
+// m.Room. The write is traced under ctx.
+func (p *persister) saveDM(ctx context.Context, m StoredMessage) {
+	p.enqueue(storeOp{kind: opDM, m: m, trace: ctx})
+}
+
+// audit queues the recording of a moderation action.
+func (p *persister) audit(e AuditEntry) {
+	p.enqueue(storeOp{kind: opAudit, m: StoredMessage{Room: e.Room}, audit: e})
+}
+
+func (p *persister) enqueue(op storeOp) {
+	select {
+	case p.queue <- op:
+	default:
+		slog.Warn("store queue full, dropping write", "room", op.m.Room, "msg_id", op.m.ID)
+	}
+}
+
+// apply performs one write, logging failures.
+func (p *persister) apply(op storeOp) {
+	ctx, sp := startSpan(op.trace, "gochat.store."+storeOpNames[op.kind],
+		spanAttr{"gochat.room", op.m.Room}, spanAttr{"gochat.msg_id", op.m.ID})
+	defer sp.end()
+	// The trace may come from a connection that has since closed; the
+	// write must not be cancelled with it.
+	ctx = context.WithoutCancel(ctx)
+	var err error
+	switch op.kind {
+	case opAppend:
+		err = p.store.Append(ctx, op.m)
+	case opUpdate:
+		err = p.store.Update(ctx, op.m)
+	case opDelete:
+		err = p.store.Delete(ctx, op.m.Room, op.m.ID)
+	case opPins:
+		err = p.store.SavePins(ctx, op.m.Room, op.pins)
+	case opPurge:
+		_, err = p.store.PruneRoom(ctx, op.m.Room, op.m.At)
+	case opDM:
+		err = p.store.AppendDM(ctx, op.m)
+	case opAudit:
+		err = p.store.AppendAudit(ctx, op.audit)
+	}
+	if err != nil {
+		sp.fail(err)
+		slog.Error("store write failed", "room", op.m.Room, "err", err)
+	}
+}
+
+// savePins queues the replacement of a room's pinned messages.
+func (p *persister) savePins(room string, pins []StoredMessage) {
+	p.enqueue(storeOp{kind: opPins, m: StoredMessage{Room: room}, pins: pins})
+}
+
+// run drains the queue until ctx is cancelled and it is empty.
+func (p *persister) run(ctx context.Context) {
+	for {
+		select {
+		case op := <-p.queue:
+			p.apply(op)
+		case <-ctx.Done():
+			for len(p.queue) > 0 {
+				p.apply(<-p.queue)
+			}
+			return
+		}
+	}
+}
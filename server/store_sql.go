@@ -0,0 +1,538 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sqlStore persists history through database/sql. The driver must be
+// linked into the binary (see sqlite_driver.go).
+type sqlStore struct {
+	db *sql.DB
+}
+
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id    TEXT PRIMARY KEY,
+	room  TEXT NOT NULL,
+	seq   INTEGER NOT NULL,
+	at    INTEGER NOT NULL,
+	frame TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_room_seq ON messages (room, seq);
+CREATE INDEX IF NOT EXISTS messages_at ON messages (at);
+
+CREATE TABLE IF NOT EXISTS pins (
+	room  TEXT NOT NULL,
+	pos   INTEGER NOT NULL,
+	id    TEXT NOT NULL,
+	at    INTEGER NOT NULL,
+	frame TEXT NOT NULL,
+	PRIMARY KEY (room, pos)
+);
+
+CREATE TABLE IF NOT EXISTS bans (
+	kind   TEXT NOT NULL,
+	value  TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	at     INTEGER NOT NULL,
+	PRIMARY KEY (kind, value)
+);
+
+CREATE TABLE IF NOT EXISTS accounts (
+	username TEXT PRIMARY KEY,
+	name     TEXT NOT NULL,
+	avatar   TEXT NOT NULL,
+	hash     BLOB NOT NULL,
+	created  INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+	endpoint TEXT PRIMARY KEY,
+	user_id  TEXT NOT NULL,
+	p256dh   TEXT NOT NULL,
+	auth     TEXT NOT NULL,
+	created  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS push_subscriptions_user ON push_subscriptions (user_id);
+
+CREATE TABLE IF NOT EXISTS dms (
+	id    TEXT PRIMARY KEY,
+	conv  TEXT NOT NULL,
+	seq   INTEGER NOT NULL,
+	at    INTEGER NOT NULL,
+	frame TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS dms_conv_seq ON dms (conv, seq);
+CREATE INDEX IF NOT EXISTS dms_at ON dms (at);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	room      TEXT NOT NULL,
+	action    TEXT NOT NULL,
+	actor     TEXT NOT NULL,
+	actor_id  TEXT NOT NULL,
+	target    TEXT NOT NULL,
+	target_id TEXT NOT NULL,
+	reason    TEXT NOT NULL,
+	detail    TEXT NOT NULL,
+	at        INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_log_room ON audit_log (room, id);
+
+-- Full-text index over each message's text, kept in step by triggers.
+-- Encrypted messages are indexed as empty.
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(text, tokenize = 'unicode61');
+CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+	INSERT INTO messages_fts (rowid, text) VALUES (new.rowid, ` + sqlFrameText + `);
+END;
+CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF frame ON messages BEGIN
+	UPDATE messages_fts SET text = ` + sqlFrameText + ` WHERE rowid = new.rowid;
+END;
+CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+	DELETE FROM messages_fts WHERE rowid = old.rowid;
+END;
+INSERT INTO messages_fts (rowid, text)
+	SELECT new.rowid, ` + sqlFrameText + ` FROM messages AS new
+	WHERE new.rowid NOT IN (SELECT rowid FROM messages_fts);
+`
+
+// sqlFrameText is frameText in SQL, for the row new.
+const sqlFrameText = `CASE WHEN json_extract(new.frame, '$.contentType') = '` + contentCiphertext + `'
+	THEN '' ELSE coalesce(json_extract(new.frame, '$.msg'), '') END`
+
+func openSQLStore(driver, dsn string) (*sqlStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s store (is the driver built in? try -tags %s): %w", driver, driver, err)
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("connect %s store: %w", driver, err)
+	}
+	if _, err := db.Exec(sqlSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate %s store: %w", driver, err)
+	}
+	return &sqlStore{db: db}, nil
+}
+
+func (s *sqlStore) Append(ctx context.Context, m StoredMessage) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO messages (id, room, seq, at, frame) VALUES (?, ?, ?, ?, ?)`,
+		m.ID, m.Room, m.Seq, m.At.UnixNano(), string(m.Frame))
+	return err
+}
+
+func (s *sqlStore) Recent(ctx context.Context, room string, limit int) ([]StoredMessage, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, seq, at, frame FROM messages WHERE room = ? ORDER BY seq DESC LIMIT ?`,
+		room, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanNewestFirst(rows, room)
+}
+
+// scanNewestFirst reads rows of id, seq, at and frame ordered newest first
+// and returns them oldest first.
+func scanNewestFirst(rows *sql.Rows, room string) ([]StoredMessage, error) {
+	var msgs []StoredMessage
+	for rows.Next() {
+		var (
+			m     StoredMessage
+			at    int64
+			frame string
+		)
+		if err := rows.Scan(&m.ID, &m.Seq, &at, &frame); err != nil {
+			return nil, err
+		}
+		m.Room, m.At, m.Frame = room, time.Unix(0, at), []byte(frame)
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Newest first from the query; callers want oldest first.
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+func (s *sqlStore) Before(ctx context.Context, room, id string, limit int) ([]StoredMessage, error) {
+	var seq uint64
+	err := s.db.QueryRowContext(ctx, `SELECT seq FROM messages WHERE id = ? AND room = ?`, id, room).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, seq, at, frame FROM messages WHERE room = ? AND seq < ? ORDER BY seq DESC LIMIT ?`,
+		room, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanNewestFirst(rows, room)
+}
+
+func (s *sqlStore) After(ctx context.Context, room string, seq uint64, limit int) ([]StoredMessage, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, seq, at, frame FROM messages WHERE room = ? AND seq > ? ORDER BY seq LIMIT ?`,
+		room, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs, err := scanNewestFirst(rows, room)
+	slices.Reverse(msgs)
+	return msgs, err
+}
+
+func (s *sqlStore) Search(ctx context.Context, room string, terms []string, limit int) ([]StoredMessage, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT m.id, m.seq, m.at, m.frame FROM messages_fts f JOIN messages m ON m.rowid = f.rowid
+		WHERE messages_fts MATCH ? AND m.room = ? ORDER BY m.seq DESC LIMIT ?`,
+		ftsQuery(terms), room, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs, err := scanNewestFirst(rows, room)
+	slices.Reverse(msgs)
+	return msgs, err
+}
+
+// ftsQuery turns terms into an FTS5 query matching words that start with
+// every one of them. Each is quoted so it is never read as query syntax.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (s *sqlStore) Scan(ctx context.Context, room string, fn func(StoredMessage) error) error {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, seq, at, frame FROM messages WHERE room = ? ORDER BY seq`, room)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			m     StoredMessage
+			at    int64
+			frame string
+		)
+		if err := rows.Scan(&m.ID, &m.Seq, &at, &frame); err != nil {
+			return err
+		}
+		m.Room, m.At, m.Frame = room, time.Unix(0, at), []byte(frame)
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) Update(ctx context.Context, m StoredMessage) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE messages SET frame = ? WHERE id = ? AND room = ?`,
+		string(m.Frame), m.ID, m.Room)
+	return err
+}
+
+func (s *sqlStore) Delete(ctx context.Context, room, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM messages WHERE id = ? AND room = ?`, id, room)
+	return err
+}
+
+// pruneBatch bounds each delete so pruning never holds long write locks.
+const pruneBatch = 500
+
+func (s *sqlStore) Prune(ctx context.Context, cutoff time.Time) (int, error) {
+	n, err := s.deleteInBatches(ctx,
+		`DELETE FROM messages WHERE id IN (SELECT id FROM messages WHERE at < ? LIMIT ?)`,
+		cutoff.UnixNano())
+	if err != nil {
+		return n, err
+	}
+	dms, err := s.deleteInBatches(ctx,
+		`DELETE FROM dms WHERE id IN (SELECT id FROM dms WHERE at < ? LIMIT ?)`,
+		cutoff.UnixNano())
+	return n + dms, err
+}
+
+func (s *sqlStore) PruneRoom(ctx context.Context, room string, cutoff time.Time) (int, error) {
+	return s.deleteInBatches(ctx,
+		`DELETE FROM messages WHERE id IN (SELECT id FROM messages WHERE room = ? AND at < ? LIMIT ?)`,
+		room, cutoff.UnixNano())
+}
+
+func (s *sqlStore) PruneCount(ctx context.Context, keep int) (int, error) {
+	return s.deleteInBatches(ctx,
+		`DELETE FROM messages WHERE id IN (
+			SELECT id FROM (
+				SELECT id, row_number() OVER (PARTITION BY room ORDER BY seq DESC) AS n FROM messages
+			) WHERE n > ? LIMIT ?)`,
+		keep)
+}
+
+// deleteInBatches runs query, whose last parameter is the batch size,
+// until it deletes fewer than pruneBatch rows, and returns how many it
+// deleted.
+func (s *sqlStore) deleteInBatches(ctx context.Context, query string, args ...any) (int, error) {
+	args = append(args, pruneBatch)
+	total := 0
+	for {
+		res, err := s.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += int(n)
+		if n < pruneBatch {
+			return total, nil
+		}
+	}
+}
+
+func (s *sqlStore) AppendDM(ctx context.Context, m StoredMessage) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO dms (id, conv, seq, at, frame) VALUES (?, ?, ?, ?, ?)`,
+		m.ID, m.Room, m.Seq, m.At.UnixNano(), string(m.Frame))
+	return err
+}
+
+func (s *sqlStore) DMs(ctx context.Context, conv, before string, limit int) ([]StoredMessage, error) {
+	seq := uint64(math.MaxInt64)
+	if before != "" {
+		err := s.db.QueryRowContext(ctx, `SELECT seq FROM dms WHERE id = ? AND conv = ?`, before, conv).Scan(&seq)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errMessageNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, seq, at, frame FROM dms WHERE conv = ? AND seq < ? ORDER BY seq DESC LIMIT ?`,
+		conv, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanNewestFirst(rows, conv)
+}
+
+func (s *sqlStore) SavePins(ctx context.Context, room string, pins []StoredMessage) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM pins WHERE room = ?`, room); err != nil {
+		return err
+	}
+	for i, m := range pins {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO pins (room, pos, id, at, frame) VALUES (?, ?, ?, ?, ?)`,
+			room, i, m.ID, m.At.UnixNano(), string(m.Frame))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Pins(ctx context.Context, room string) ([]StoredMessage, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, at, frame FROM pins WHERE room = ? ORDER BY pos`, room)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pins []StoredMessage
+	for rows.Next() {
+		var (
+			m     StoredMessage
+			at    int64
+			frame string
+		)
+		if err := rows.Scan(&m.ID, &at, &frame); err != nil {
+			return nil, err
+		}
+		m.Room, m.At, m.Frame = room, time.Unix(0, at), []byte(frame)
+		pins = append(pins, m)
+	}
+	return pins, rows.Err()
+}
+
+func (s *sqlStore) SaveBan(ctx context.Context, b GlobalBan) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO bans (kind, value, reason, at) VALUES (?, ?, ?, ?)`,
+		b.Kind, b.Value, b.Reason, b.At.UnixNano())
+	return err
+}
+
+func (s *sqlStore) DeleteBan(ctx context.Context, kind, value string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM bans WHERE kind = ? AND value = ?`, kind, value)
+	return err
+}
+
+func (s *sqlStore) Bans(ctx context.Context) ([]GlobalBan, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT kind, value, reason, at FROM bans`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []GlobalBan
+	for rows.Next() {
+		var (
+			b  GlobalBan
+			at int64
+		)
+		if err := rows.Scan(&b.Kind, &b.Value, &b.Reason, &at); err != nil {
+			return nil, err
+		}
+		b.At = time.Unix(0, at).UTC()
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
+
+func (s *sqlStore) CreateAccount(ctx context.Context, a Account) error {
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO accounts (username, name, avatar, hash, created) VALUES (?, ?, ?, ?, ?) ON CONFLICT (username) DO NOTHING`,
+		a.Username, a.Name, a.Avatar, a.PasswordHash, a.Created.UnixNano())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errAccountExists
+	}
+	return nil
+}
+
+func (s *sqlStore) Account(ctx context.Context, username string) (Account, error) {
+	a := Account{Username: username}
+	var created int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT name, avatar, hash, created FROM accounts WHERE username = ?`, username).
+		Scan(&a.Name, &a.Avatar, &a.PasswordHash, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, errAccountNotFound
+	}
+	a.Created = time.Unix(0, created)
+	return a, err
+}
+
+func (s *sqlStore) UpdateAccount(ctx context.Context, a Account) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE accounts SET name = ?, avatar = ?, hash = ? WHERE username = ?`,
+		a.Name, a.Avatar, a.PasswordHash, a.Username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errAccountNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) SavePushSubscription(ctx context.Context, p PushSubscription, limit int) error {
+	var n int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM push_subscriptions WHERE user_id = ? AND endpoint != ?`, p.UserID, p.Endpoint).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n >= limit {
+		return errTooManyPushSubscriptions
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO push_subscriptions (endpoint, user_id, p256dh, auth, created) VALUES (?, ?, ?, ?, ?)`,
+		p.Endpoint, p.UserID, p.Keys.P256DH, p.Keys.Auth, p.Created.UnixNano())
+	return err
+}
+
+func (s *sqlStore) PushSubscriptions(ctx context.Context, userID string) ([]PushSubscription, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT endpoint, p256dh, auth, created FROM push_subscriptions WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []PushSubscription
+	for rows.Next() {
+		var (
+			p       = PushSubscription{UserID: userID}
+			created int64
+		)
+		if err := rows.Scan(&p.Endpoint, &p.Keys.P256DH, &p.Keys.Auth, &created); err != nil {
+			return nil, err
+		}
+		p.Created = time.Unix(0, created).UTC()
+		subs = append(subs, p)
+	}
+	return subs, rows.Err()
+}
+
+func (s *sqlStore) DeletePushSubscription(ctx context.Context, userID, endpoint string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM push_subscriptions WHERE endpoint = ? AND user_id = ?`, endpoint, userID)
+	return err
+}
+
+func (s *sqlStore) Close() error { return s.db.Close() }
+
+func (s *sqlStore) AppendAudit(ctx context.Context, e AuditEntry) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO audit_log (room, action, actor, actor_id, target, target_id, reason, detail, at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Room, e.Action, e.Actor, e.ActorID, e.Target, e.TargetID, e.Reason, e.Detail, e.At.UnixNano())
+	return err
+}
+
+func (s *sqlStore) Audit(ctx context.Context, room string, before int64, limit int) ([]AuditEntry, error) {
+	if before == 0 {
+		before = math.MaxInt64
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, room, action, actor, actor_id, target, target_id, reason, detail, at FROM audit_log
+		WHERE id < ? AND (? = '' OR room = ?) ORDER BY id DESC LIMIT ?`,
+		before, room, room, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var (
+			e  AuditEntry
+			at int64
+		)
+		if err := rows.Scan(&e.ID, &e.Room, &e.Action, &e.Actor, &e.ActorID, &e.Target, &e.TargetID, &e.Reason, &e.Detail, &at); err != nil {
+			return nil, err
+		}
+		e.At = time.Unix(0, at).UTC()
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
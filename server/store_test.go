@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// storeBackends opens an empty store of each kind built into the test
+// binary; sqlite_driver_test.go adds SQLite under -tags sqlite.
+var storeBackends = map[string]func(t *testing.T) Store{
+	"memory": func(*testing.T) Store { return newMemoryStore(1000) },
+}
+
+// eachStore runs fn against a fresh store of every backend.
+func eachStore(t *testing.T, fn func(t *testing.T, s Store)) {
+	for name, open := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			t.Cleanup(func() { _ = s.Close() })
+			fn(t, s)
+		})
+	}
+}
+
+// storeMessages appends n messages to room, one a minute up to now.
+func storeMessages(t *testing.T, s Store, room string, n int, now time.Time) {
+	t.Helper()
+	for i := range n {
+		m := StoredMessage{
+			Room:  room,
+			ID:    fmt.Sprintf("%s-%d", room, i+1),
+			Seq:   uint64(i + 1),
+			At:    now.Add(time.Duration(i-n+1) * time.Minute),
+			Frame: []byte(fmt.Sprintf(`{"type":"chat","msg":"message %d"}`, i+1)),
+		}
+		if err := s.Append(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// storedIDs returns the ids of room's stored messages, oldest first.
+func storedIDs(t *testing.T, s Store, room string) []string {
+	t.Helper()
+	msgs, err := s.Recent(context.Background(), room, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func TestStorePrune(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		prune  func(s Store) (int, error)
+		want   int
+		wantA  int
+		wantB  int
+		oldest string
+	}{
+		{
+			name:  "by age",
+			prune: func(s Store) (int, error) { return s.Prune(context.Background(), now.Add(-150*time.Minute)) },
+			want:  49,
+			wantA: 151, wantB: 120, oldest: "a-50",
+		},
+		{
+			name:  "by count",
+			prune: func(s Store) (int, error) { return s.PruneCount(context.Background(), 150) },
+			want:  50,
+			wantA: 150, wantB: 120, oldest: "a-51",
+		},
+		{
+			name:  "by count, all under",
+			prune: func(s Store) (int, error) { return s.PruneCount(context.Background(), 500) },
+			want:  0,
+			wantA: 200, wantB: 120, oldest: "a-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachStore(t, func(t *testing.T, s Store) {
+				storeMessages(t, s, "a", 200, now)
+				storeMessages(t, s, "b", 120, now)
+				n, err := tt.prune(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != tt.want {
+					t.Errorf("pruned %d, want %d", n, tt.want)
+				}
+				a, b := storedIDs(t, s, "a"), storedIDs(t, s, "b")
+				if len(a) != tt.wantA || len(b) != tt.wantB {
+					t.Fatalf("kept %d and %d, want %d and %d", len(a), len(b), tt.wantA, tt.wantB)
+				}
+				if a[0] != tt.oldest {
+					t.Errorf("oldest kept in a = %s, want %s", a[0], tt.oldest)
+				}
+				if a[len(a)-1] != "a-200" {
+					t.Errorf("newest kept in a = %s, want a-200", a[len(a)-1])
+				}
+			})
+		})
+	}
+}
+
+func TestStorePins(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	pin := func(id string) StoredMessage {
+		return StoredMessage{Room: "1234", ID: id, At: at, Frame: []byte(`{"type":"chat","id":"` + id + `"}`)}
+	}
+	tests := []struct {
+		name  string
+		saves [][]StoredMessage
+		want  []string
+	}{
+		{name: "none saved", want: nil},
+		{name: "in pin order", saves: [][]StoredMessage{{pin("b"), pin("a"), pin("c")}}, want: []string{"b", "a", "c"}},
+		{name: "replaced", saves: [][]StoredMessage{{pin("a"), pin("b")}, {pin("c")}}, want: []string{"c"}},
+		{name: "cleared", saves: [][]StoredMessage{{pin("a")}, nil}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachStore(t, func(t *testing.T, s Store) {
+				ctx := context.Background()
+				if err := s.SavePins(ctx, "other", []StoredMessage{pin("x")}); err != nil {
+					t.Fatal(err)
+				}
+				for _, pins := range tt.saves {
+					if err := s.SavePins(ctx, "1234", pins); err != nil {
+						t.Fatal(err)
+					}
+				}
+				got, err := s.Pins(ctx, "1234")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("got %d pins, want %v", len(got), tt.want)
+				}
+				for i, m := range got {
+					if m.ID != tt.want[i] || !m.At.Equal(at) || string(m.Frame) != string(pin(m.ID).Frame) {
+						t.Errorf("pin %d = %+v, want %s", i, m, tt.want[i])
+					}
+				}
+				if other, _ := s.Pins(ctx, "other"); len(other) != 1 {
+					t.Errorf("other room has %d pins, want 1", len(other))
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// maxParentIDLen bounds a sender-chosen parent_id.
+const maxParentIDLen = 64
+
+// threadRequest asks run for the messages of the thread rooted at id, as
+// seen by client (nil for the admin). reply gets nil if there is no such
+// thread.
+type threadRequest struct {
+	id     string
+	client *Client
+	reply  chan [][]byte
+}
+
+// frameParent returns the parent_id of a stored chat frame, if any.
+func frameParent(frame []byte) string {
+	var m struct {
+		ParentID string `json:"parent_id"`
+	}
+	_ = json.Unmarshal(frame, &m)
+	return m.ParentID
+}
+
+// threadReply attaches a reply to its thread: replies to a reply join the
+// same thread, and every reply is posted to its root's channel. It reports
+// false, telling the sender why, if the parent is no longer in history.
+// Only run may call it.
+func (h *Hub) threadReply(msg *Message) bool {
+	i := h.historyIndex(msg.ParentID)
+	if i < 0 {
+		if msg.from != nil {
+			msg.from.trySend(errorFrame(codeNotFound, "no message with id "+`"`+msg.ParentID+`"`+" in history"))
+		}
+		return false
+	}
+	parent := h.history[i]
+	if parent.parent != "" {
+		msg.ParentID = parent.parent
+	}
+	msg.Channel = parent.channel
+	return true
+}
+
+// threadFrames answers a threadRequest: the root message followed by its
+// replies in seq order. Only run may call it.
+func (h *Hub) threadFrames(req threadRequest) [][]byte {
+	i := h.historyIndex(req.id)
+	if i < 0 {
+		return nil
+	}
+	root := h.history[i]
+	if root.parent != "" || (req.client != nil && !req.client.subscribed(root.channel)) {
+		return nil
+	}
+	frames := [][]byte{root.frame}
+	for _, e := range h.history[i+1:] {
+		if e.parent == req.id {
+			frames = append(frames, e.frame)
+		}
+	}
+	return frames
+}
+
+// thread fetches a thread from run, or nil if it or the room is gone.
+func (h *Hub) thread(id string, c *Client) [][]byte {
+	req := threadRequest{id: id, client: c, reply: make(chan [][]byte, 1)}
+	select {
+	case h.threads <- req:
+		return <-req.reply
+	case <-h.done:
+		return nil
+	}
+}
+
+// handleThread serves GET /api/rooms/{pin}/threads/{id}, returning a
+// thread's messages still in history. The caller needs the admin token or
+// the X-GoChat-Session header of a member following the thread's channel.
+func handleThread(manager *HubManager, adminToken string, w http.ResponseWriter, r *http.Request) {
+	pin := r.PathValue("pin")
+	var client *Client
+	if !isAdmin(adminToken, r) {
+		client = manager.sessions.get(r.Header.Get(sessionHeader))
+		if client == nil || client.hub.pin != pin {
+			writeError(w, http.StatusUnauthorized, codeInvalidSession, "not a member of this room")
+			return
+		}
+	}
+	hub := manager.lookup(pin)
+	if hub == nil {
+		writeError(w, http.StatusNotFound, codeRoomNotFound, "room not found")
+		return
+	}
+	frames := hub.thread(r.PathValue("id"), client)
+	if frames == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "thread not found")
+		return
+	}
+	messages := make([]json.RawMessage, len(frames))
+	for i, f := range frames {
+		messages[i] = f
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"pin": pin, "id": r.PathValue("id"), "count": len(messages), "messages": messages})
+}
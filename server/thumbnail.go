@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // registers GIF for image.Decode
+	"image/jpeg"
+	"image/png"
+	"regexp"
+	"strconv"
+)
+
+// defaultThumbSize is the longest side of a thumbnail, in pixels, when
+// UPLOAD_THUMB_SIZE is unset.
+const defaultThumbSize = 320
+
+// maxImagePixels bounds an uploaded image's declared size, so a small
+// file cannot decode into gigabytes.
+const maxImagePixels = 40_000_000
+
+// thumbSamples is how many source pixels, per side, are averaged into
+// each thumbnail pixel.
+const thumbSamples = 4
+
+// errBadImage rejects an upload that claims to be an image but does not
+// decode as one, or is too large to.
+var errBadImage = errors.New("not a valid image")
+
+// pdfActivePattern matches the PDF names that run code or carry other
+// files when the document is opened.
+var pdfActivePattern = regexp.MustCompile(`/(JavaScript|JS|Launch|EmbeddedFiles?|RichMedia|XFA)\b`)
+
+// pdfNameEscape is a #xx escape inside a PDF name, which hides a name
+// such as /JavaScript from a plain search.
+var pdfNameEscape = regexp.MustCompile(`#[0-9A-Fa-f]{2}`)
+
+// checkUpload refuses files whose type was accepted but whose content is
+// still unsafe to share: images that do not decode or would decode too
+// large, and PDFs with scripts, launch actions or embedded files.
+func checkUpload(data []byte, ctype string) error {
+	switch ctype {
+	case "image/png", "image/jpeg", "image/gif":
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || cfg.Width < 1 || cfg.Height < 1 {
+			return errBadImage
+		}
+		if int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+			return fmt.Errorf("image is larger than %d pixels", maxImagePixels)
+		}
+	case "application/pdf":
+		names := pdfNameEscape.ReplaceAllFunc(data, func(esc []byte) []byte {
+			b, _ := strconv.ParseUint(string(esc[1:]), 16, 8)
+			return []byte{byte(b)}
+		})
+		if pdfActivePattern.Match(names) {
+			return errors.New("PDFs with scripts, actions or attachments are not allowed")
+		}
+	}
+	return nil
+}
+
+// thumbnail is a downscaled copy of an uploaded image, encoded in ext's
+// format, with the original's dimensions.
+type thumbnail struct {
+	data          []byte
+	ext           string
+	width, height int
+}
+
+// makeThumbnail decodes an image checked by checkUpload and scales it so
+// its longest side is at most size. JPEGs stay JPEGs; PNGs and GIFs become
+// PNGs, keeping transparency, and a GIF keeps only its first frame. An
+// image that is already small enough gets no thumbnail, and nor does
+// WebP, which the standard library cannot decode; data is nil for both.
+func makeThumbnail(data []byte, ctype string, size int) (thumbnail, error) {
+	if ctype == "image/webp" {
+		return thumbnail{}, nil
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return thumbnail{}, errBadImage
+	}
+	b := src.Bounds()
+	t := thumbnail{width: b.Dx(), height: b.Dy()}
+	if size <= 0 || (t.width <= size && t.height <= size) {
+		return t, nil
+	}
+
+	dw, dh := size, t.height*size/t.width
+	if t.height > t.width {
+		dw, dh = t.width*size/t.height, size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(dw, 1), max(dh, 1)))
+	scale(dst, src)
+
+	var buf bytes.Buffer
+	if ctype == "image/jpeg" {
+		t.ext = ".jpg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80})
+	} else {
+		t.ext = ".png"
+		err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, dst)
+	}
+	if err != nil {
+		return thumbnail{}, err
+	}
+	t.data = buf.Bytes()
+	return t, nil
+}
+
+// scale fills dst from src, averaging a grid of samples from the block of
+// src each dst pixel covers. Colours are averaged premultiplied, so
+// transparent pixels do not darken their neighbours.
+func scale(dst *image.RGBA, src image.Image) {
+	sb, db := src.Bounds(), dst.Bounds()
+	sw, sh, dw, dh := sb.Dx(), sb.Dy(), db.Dx(), db.Dy()
+	for y := range dh {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := range dw {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+			var r, g, b, a, n uint64
+			for sy := range thumbSamples {
+				py := y0 + (y1-y0)*(2*sy+1)/(2*thumbSamples)
+				for sx := range thumbSamples {
+					px := x0 + (x1-x0)*(2*sx+1)/(2*thumbSamples)
+					cr, cg, cb, ca := src.At(sb.Min.X+px, sb.Min.Y+py).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: uint8(a / n >> 8),
+			})
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngHeader returns the start of a PNG that declares w by h pixels:
+// enough for image.DecodeConfig, which reads no further.
+func pngHeader(w, h uint32) []byte {
+	ihdr := binary.BigEndian.AppendUint32([]byte("IHDR"), w)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, h)
+	ihdr = append(ihdr, 8, 0, 0, 0, 0) // 8-bit grey, no interlace
+	b := []byte("\x89PNG\r\n\x1a\n")
+	b = binary.BigEndian.AppendUint32(b, uint32(len(ihdr)-4))
+	b = append(b, ihdr...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(ihdr))
+}
+
+// gifHeader returns a GIF screen descriptor declaring w by h pixels.
+func gifHeader(w, h uint16) []byte {
+	b := binary.LittleEndian.AppendUint16([]byte("GIF89a"), w)
+	b = binary.LittleEndian.AppendUint16(b, h)
+	return append(b, 0, 0, 0)
+}
+
+func TestCheckUpload(t *testing.T) {
+	var small bytes.Buffer
+	if err := png.Encode(&small, image.NewGray(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	pdf := func(body string) []byte {
+		return []byte("%PDF-1.7\n1 0 obj\n<< /Type /Catalog " + body + " >>\nendobj\n%%EOF\n")
+	}
+
+	tests := []struct {
+		name  string
+		ctype string
+		data  []byte
+		ok    bool
+	}{
+		{name: "png", ctype: "image/png", data: small.Bytes(), ok: true},
+		{name: "png at the pixel cap", ctype: "image/png", data: pngHeader(8000, 5000), ok: true},
+		{name: "png over the pixel cap", ctype: "image/png", data: pngHeader(8000, 5001)},
+		{name: "png bomb", ctype: "image/png", data: pngHeader(1<<20, 1<<20)},
+		{name: "png with no pixels", ctype: "image/png", data: pngHeader(0, 10)},
+		{name: "gif over the pixel cap", ctype: "image/gif", data: gifHeader(65535, 65535)},
+		{name: "truncated png", ctype: "image/png", data: small.Bytes()[:20]},
+		{name: "jpeg that is not one", ctype: "image/jpeg", data: []byte("\xff\xd8\xff not really")},
+		{name: "plain pdf", ctype: "application/pdf", data: pdf("/Pages 2 0 R"), ok: true},
+		{name: "pdf name like a script", ctype: "application/pdf", data: pdf("/JSONData 2 0 R"), ok: true},
+		{name: "pdf javascript", ctype: "application/pdf", data: pdf("/OpenAction << /S /JavaScript /JS (app.alert(1)) >>")},
+		{name: "pdf js", ctype: "application/pdf", data: pdf("/AA << /O << /JS 3 0 R >> >>")},
+		{name: "pdf launch", ctype: "application/pdf", data: pdf("/OpenAction << /S /Launch /F (calc.exe) >>")},
+		{name: "pdf embedded file", ctype: "application/pdf", data: pdf("/Names << /EmbeddedFiles 4 0 R >>")},
+		{name: "pdf rich media", ctype: "application/pdf", data: pdf("/Annots [ << /Subtype /RichMedia >> ]")},
+		{name: "pdf xfa form", ctype: "application/pdf", data: pdf("/AcroForm << /XFA 5 0 R >>")},
+		{name: "pdf escaped name", ctype: "application/pdf", data: pdf("/OpenAction << /S /J#61va#53cript /#4A#53 (x) >>")},
+		{name: "text", ctype: "text/plain", data: []byte("/JavaScript is just words here"), ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkUpload(tt.data, tt.ctype)
+			if tt.ok && err != nil {
+				t.Errorf("checkUpload = %v, want accepted", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("checkUpload accepted it")
+			}
+		})
+	}
+}
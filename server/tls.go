@@ -0,0 +1,25 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// autocertHook is set when the binary is built with -tags autocert. It
+// returns a TLS config that obtains and renews certificates for domains
+// from Let's Encrypt, caching them in cacheDir, and a wrapper for the
+// plain-HTTP handler that answers the ACME HTTP-01 challenge.
+var autocertHook func(domains []string, cacheDir, email string) (*tls.Config, func(http.Handler) http.Handler)
+
+// Autocert returns a TLS config that gets certificates for domains from
+// Let's Encrypt, and a wrapper for the plain-HTTP handler that answers
+// its challenges. It needs a binary built with -tags autocert, which
+// LoadConfig checks for when TLS_DOMAINS is set.
+func Autocert(domains []string, cacheDir, email string) (*tls.Config, func(http.Handler) http.Handler) {
+	return autocertHook(domains, cacheDir, email)
+}
+
+// TLSEnabled reports whether the server terminates TLS itself.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.TLSDomains) > 0
+}
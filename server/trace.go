@@ -0,0 +1,52 @@
+package server
+
+import "context"
+
+// otelHook is set when the binary is built with -tags otel. It starts
+// exporting spans over OTLP/HTTP to endpoint and returns the tracer and a
+// function that flushes and stops the exporter.
+var otelHook func(ctx context.Context, endpoint string) (spanTracer, func(context.Context) error, error)
+
+// spanTracer starts spans. The default records nothing, so untraced
+// builds pay only for an interface call.
+type spanTracer interface {
+	start(ctx context.Context, name string, attrs ...spanAttr) (context.Context, span)
+}
+
+type span interface {
+	// set adds attributes, for facts only known once the work is done.
+	set(attrs ...spanAttr)
+	// fail marks the span as failed with err.
+	fail(err error)
+	end()
+}
+
+// spanAttr is one span attribute; value is a string, bool, int or uint64.
+type spanAttr struct {
+	key   string
+	value any
+}
+
+// tracer is the process's span tracer, replaced at startup when
+// OTLP_ENDPOINT is set.
+var tracer spanTracer = noopTracer{}
+
+// startSpan starts a span under ctx, or a new trace if ctx is nil.
+func startSpan(ctx context.Context, name string, attrs ...spanAttr) (context.Context, span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return tracer.start(ctx, name, attrs...)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) start(ctx context.Context, _ string, _ ...spanAttr) (context.Context, span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) set(...spanAttr) {}
+func (noopSpan) fail(error)      {}
+func (noopSpan) end()            {}
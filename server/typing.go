@@ -0,0 +1,22 @@
+package server
+
+import "time"
+
+// typingInterval is the most often one client's typing events are relayed.
+const typingInterval = 2 * time.Second
+
+// throttleTyping reports whether a typing event from c should be
+// forwarded. Only readPump may call it.
+func (c *Client) throttleTyping(now time.Time) bool {
+	if now.Sub(c.lastTyping) < typingInterval {
+		return false
+	}
+	c.lastTyping = now
+	return true
+}
+
+// relayTyping tells everyone in the channel but the typist. Only run may
+// call it.
+func (h *Hub) relayTyping(msg *Message) {
+	h.fanOutFrom(msg.Channel, msg.User, msg.from, h.frame(&Message{Type: "typing", User: msg.User, Channel: msg.Channel}))
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultUploadTypes are the MIME types accepted when UPLOAD_TYPES is unset.
+// WebP is left out: the server cannot decode it, so checkUpload cannot
+// bound its size.
+const defaultUploadTypes = "image/png,image/jpeg,image/gif,application/pdf,text/plain"
+
+// uploadExt maps accepted types to the extension files are stored under.
+var uploadExt = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+// maxFileNameLen bounds the original file name echoed to the room.
+const maxFileNameLen = 100
+
+// BlobStore keeps uploaded files. Put stores data under name and returns
+// the URL clients fetch it from. Implementations might write to local disk
+// or an object store such as S3.
+type BlobStore interface {
+	Put(ctx context.Context, name, contentType string, data io.Reader) (url string, err error)
+}
+
+// diskBlobs stores uploads in a directory served at /uploads/.
+type diskBlobs struct {
+	dir string
+}
+
+func newDiskBlobs(dir string) (*diskBlobs, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &diskBlobs{dir: dir}, nil
+}
+
+func (d *diskBlobs) Put(_ context.Context, name, _ string, data io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(d.dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(d.dir, name)); err != nil {
+		return "", err
+	}
+	return "/uploads/" + name, nil
+}
+
+var blobNamePattern = regexp.MustCompile(`^[0-9a-f]{32}(-thumb)?\.[a-z]+$`)
+
+// serve handles GET /uploads/{name}. Files are served inert: no sniffing,
+// no scripts, and anything but an image is a download.
+func (d *diskBlobs) serve(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !blobNamePattern.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if !strings.HasPrefix(mime.TypeByExtension(filepath.Ext(name)), "image/") {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
+	http.ServeFile(w, r, filepath.Join(d.dir, name))
+}
+
+// uploader accepts files for rooms and announces them.
+type uploader struct {
+	blobs    BlobStore
+	maxBytes int64
+	types    map[string]bool
+	// thumbSize is the longest side of image thumbnails; 0 sends the
+	// original instead.
+	thumbSize int
+}
+
+var errUploadTooLarge = errors.New("file too large")
+
+// readFilePart returns the "file" part of a multipart upload, reading at
+// most max bytes of it.
+func readFilePart(r *http.Request, max int64) (filename string, data []byte, err error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return "", nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New(`missing "file" part`)
+			}
+			return "", nil, err
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(part, max+1))
+		if err != nil {
+			return "", nil, err
+		}
+		if int64(len(data)) > max {
+			return "", nil, errUploadTooLarge
+		}
+		return part.FileName(), data, nil
+	}
+}
+
+// cleanFileName reduces a client-supplied file name to a short base name.
+func cleanFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" {
+		name = ""
+	}
+	for utf8.RuneCountInString(name) > maxFileNameLen {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// serveUpload handles POST /upload?pin=... for the connected client named
+// by the X-GoChat-Session header, then posts a file message to its room.
+func (u *uploader) serveUpload(manager *HubManager, w http.ResponseWriter, r *http.Request) {
+	client := manager.sessions.get(r.Header.Get(sessionHeader))
+	if client == nil || client.hub.pin != r.URL.Query().Get("pin") {
+		writeError(w, http.StatusUnauthorized, codeInvalidSession, "not a member of this room")
+		return
+	}
+	if client.spectator {
+		writeError(w, http.StatusForbidden, codeReadOnly, "spectators cannot upload files")
+		return
+	}
+	if !manager.allowed(client, actionMsg) {
+		writeError(w, http.StatusUnauthorized, codeAuthRequired, "sign in to send messages")
+		return
+	}
+
+	// Leave room for the multipart framing around the file.
+	r.Body = http.MaxBytesReader(w, r.Body, u.maxBytes+64<<10)
+	filename, data, err := readFilePart(r, u.maxBytes)
+	var tooBig *http.MaxBytesError
+	if errors.Is(err, errUploadTooLarge) || errors.As(err, &tooBig) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("file is over %d bytes", u.maxBytes))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	// Trust the bytes, not the client's Content-Type.
+	ctype, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !u.types[ctype] {
+		writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedContentType, "file type "+ctype+" is not allowed")
+		return
+	}
+	if err := checkUpload(data, ctype); err != nil {
+		writeError(w, http.StatusUnsupportedMediaType, codeContentRejected, err.Error())
+		return
+	}
+	var thumb thumbnail
+	if strings.HasPrefix(ctype, "image/") {
+		if thumb, err = makeThumbnail(data, ctype, u.thumbSize); err != nil {
+			writeError(w, http.StatusUnsupportedMediaType, codeContentRejected, err.Error())
+			return
+		}
+	}
+
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	name := hex.EncodeToString(b[:])
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	url, err := u.blobs.Put(ctx, name+uploadExt[ctype], ctype, bytes.NewReader(data))
+	if err != nil {
+		client.log.Error("storing upload failed", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not store file")
+		return
+	}
+	// Images too small to need a thumbnail are their own.
+	var thumbURL string
+	if strings.HasPrefix(ctype, "image/") {
+		thumbURL = url
+	}
+	if thumb.data != nil {
+		thumbURL, err = u.blobs.Put(ctx, name+"-thumb"+thumb.ext, mime.TypeByExtension(thumb.ext), bytes.NewReader(thumb.data))
+		if err != nil {
+			client.log.Error("storing thumbnail failed", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "could not store file")
+			return
+		}
+	}
+
+	channel, ok := normalizeChannel(r.URL.Query().Get("channel"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid channel name")
+		return
+	}
+
+	msg := &Message{
+		Type:        "file",
+		Channel:     channel,
+		Meta:        client.meta,
+		URL:         url,
+		ThumbURL:    thumbURL,
+		Width:       thumb.width,
+		Height:      thumb.height,
+		FileName:    cleanFileName(filename),
+		Size:        int64(len(data)),
+		ContentType: ctype,
+		from:        client,
+	}
+	if !client.hub.publish(msg) {
+		writeError(w, http.StatusGone, codeRoomClosed, "room closed")
+		return
+	}
+	reply := map[string]any{"url": url, "contentType": ctype, "size": len(data)}
+	if thumbURL != "" {
+		reply["thumb_url"] = thumbURL
+	}
+	writeJSON(w, http.StatusCreated, reply)
+}
+
+// shareFile posts an uploaded file to the room if uploads are enabled.
+// Only run may call it.
+func (h *Hub) shareFile(msg *Message) {
+	if !h.features[featureUploads] {
+		msg.from.trySend(errorFrame(codeFeatureDisabled, "uploads are disabled in this room"))
+		return
+	}
+	h.broadcastChat(msg)
+}
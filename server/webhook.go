@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const (
+	webhookQueueSize       = 256
+	webhookTimeout         = 10 * time.Second
+	webhookAttempts        = 5
+	webhookBackoff         = time.Second
+	maxWebhookFilter       = 256
+	webhookSignatureHeader = "X-GoChat-Signature"
+)
+
+// WebhookSpec configures a room's outbound webhook. Filter, if set, is a
+// regular expression a message's text must match to be delivered. Secret,
+// if set, signs each delivery.
+type WebhookSpec struct {
+	URL    string `json:"url"`
+	Filter string `json:"filter"`
+	Secret string `json:"secret"`
+}
+
+func (s *WebhookSpec) validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook url must be an http(s) URL")
+	}
+	if len(s.Filter) > maxWebhookFilter {
+		return fmt.Errorf("webhook filter must be at most %d bytes", maxWebhookFilter)
+	}
+	if _, err := regexp.Compile(s.Filter); err != nil {
+		return fmt.Errorf("webhook filter: %w", err)
+	}
+	return nil
+}
+
+// webhook POSTs a room's chat to an external URL from its own goroutine,
+// retrying failed deliveries with exponential backoff. A receiver that
+// falls too far behind loses messages rather than slowing the room.
+type webhook struct {
+	url    string
+	filter *regexp.Regexp
+	secret []byte
+	pin    string
+	client *http.Client
+	queue  chan []byte
+	log    *slog.Logger
+}
+
+func newWebhook(spec *WebhookSpec, pin string) *webhook {
+	w := &webhook{
+		url:    spec.URL,
+		secret: []byte(spec.Secret),
+		pin:    pin,
+		client: &http.Client{Timeout: webhookTimeout},
+		queue:  make(chan []byte, webhookQueueSize),
+		log:    slog.With("room", pin, "webhook", spec.URL),
+	}
+	if spec.Filter != "" {
+		w.filter = regexp.MustCompile(spec.Filter)
+	}
+	return w
+}
+
+// matches reports whether m passes the filter. Encrypted bodies cannot be
+// matched, so a filtered webhook never receives them.
+func (w *webhook) matches(m *Message) bool {
+	if w.filter == nil {
+		return true
+	}
+	if m.opaque() {
+		return false
+	}
+	return w.filter.MatchString(m.Msg) || (m.FileName != "" && w.filter.MatchString(m.FileName))
+}
+
+// enqueue queues a frame for delivery without blocking.
+func (w *webhook) enqueue(frame []byte) {
+	select {
+	case w.queue <- frame:
+	default:
+		w.log.Warn("webhook queue full, dropping message")
+	}
+}
+
+// run delivers queued frames in order until done is closed and the queue
+// is empty.
+func (w *webhook) run(done <-chan struct{}) {
+	for {
+		select {
+		case frame := <-w.queue:
+			w.deliver(frame)
+		case <-done:
+			for len(w.queue) > 0 {
+				w.deliver(<-w.queue)
+			}
+			return
+		}
+	}
+}
+
+// deliver POSTs one frame, retrying network errors, 429s and 5xx
+// responses.
+func (w *webhook) deliver(frame []byte) {
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(frame)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			w.log.Warn("webhook delivery failed", "attempts", attempt, "err", err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying.
+func (w *webhook) post(frame []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(frame))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoChat-Webhook")
+	req.Header.Set("X-GoChat-Room", w.pin)
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(frame)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
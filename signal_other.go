@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// drainSignals is empty where there is no SIGUSR1; use POST /admin/drain.
+var drainSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// drainSignals start a drain instead of an immediate shutdown.
+var drainSignals = []os.Signal{syscall.SIGUSR1}
@@ -37,26 +37,96 @@ document.addEventListener('DOMContentLoaded', () => {
   let ws = null;
   let currentPin = null;
   let reconnectTimeout = null;
+  // Close codes after which reconnecting cannot help.
+  const finalCloseCodes = new Set([1000, 1008, 4001, 4002, 4003, 4005, 4006, 4007]);
   let heartbeatInterval = null;
   let retryCount = 0;
   const maxRetries = 5;
+  // Set once a WebSocket never manages to open (e.g. blocked by a proxy);
+  // later connections use Server-Sent Events instead.
+  let useSSE = false;
+
+  // SSESocket mimics the parts of WebSocket this page uses, over an
+  // EventSource for receiving and POST /sse/send for sending.
+  class SSESocket extends EventTarget {
+    constructor(url) {
+      super();
+      this.readyState = WebSocket.CONNECTING;
+      this.session = null;
+      this.es = new EventSource(url);
+      this.es.addEventListener('session', (e) => {
+        this.session = JSON.parse(e.data).session;
+        this.readyState = WebSocket.OPEN;
+        this.dispatchEvent(new Event('open'));
+      });
+      this.es.addEventListener('message', (e) => {
+        this.dispatchEvent(new MessageEvent('message', { data: e.data }));
+      });
+      this.es.addEventListener('close', (e) => {
+        const d = JSON.parse(e.data);
+        this.finish(d.code, d.reason);
+      });
+      this.es.addEventListener('error', () => {
+        this.dispatchEvent(new Event('error'));
+        this.finish(1006, '');
+      });
+    }
+
+    send(data) {
+      fetch('/sse/send', {
+        method: 'POST',
+        headers: { 'X-GoChat-Session': this.session },
+        body: data,
+      }).catch((err) => console.error('SSE send failed:', err));
+    }
+
+    close(code = 1000, reason = '') {
+      if (this.readyState === WebSocket.OPEN) this.send(JSON.stringify({ type: 'leave' }));
+      this.finish(code, reason);
+    }
+
+    finish(code, reason) {
+      if (this.readyState === WebSocket.CLOSED) return;
+      this.readyState = WebSocket.CLOSED;
+      this.es.close();
+      const ev = new Event('close');
+      ev.code = code;
+      ev.reason = reason;
+      this.dispatchEvent(ev);
+    }
+  }
 
   // Append message helpers
-  function append(text, type = 'normal') {
+  function append(text, type = 'normal', avatar = '') {
     const div = document.createElement('div');
     div.className = type === 'system' ? 'system-msg' : 'user-msg';
-    div.textContent = text;
+    // Avatars are server paths such as /avatars/… or http(s) URLs.
+    if (avatar.startsWith('/') || avatar.startsWith('http')) {
+      const img = document.createElement('img');
+      img.className = 'avatar';
+      img.src = avatar;
+      img.alt = '';
+      div.appendChild(img);
+    }
+    div.appendChild(document.createTextNode(text));
     messages.appendChild(div);
     messages.scrollTop = messages.scrollHeight;
+    return div;
   }
 
   // Prefer building URL from current origin to avoid cross-origin surprises
   function getWsUrl(pin) {
   const scheme = window.location.protocol === 'https:' ? 'wss' : 'ws';
   const host = window.location.host; // e.g. yourapp.onrender.com
-  return `${scheme}://${host}/ws?pin=${encodeURIComponent(pin)}`;
+  const name = usernameInput.value.trim();
+  return `${scheme}://${host}/ws?pin=${encodeURIComponent(pin)}&name=${encodeURIComponent(name)}`;
 }
 
+  function getSSEUrl(pin) {
+    const name = usernameInput.value.trim();
+    return `/sse?pin=${encodeURIComponent(pin)}&name=${encodeURIComponent(name)}`;
+  }
+
   function clearTimers() {
     if (reconnectTimeout) { clearTimeout(reconnectTimeout); reconnectTimeout = null; }
     if (heartbeatInterval) { clearInterval(heartbeatInterval); heartbeatInterval = null; }
@@ -92,11 +162,13 @@ document.addEventListener('DOMContentLoaded', () => {
     closeSocket();
     currentPin = pin;
 
-    const url = getWsUrl(pin);
+    const url = useSSE ? getSSEUrl(pin) : getWsUrl(pin);
     console.log(`🌐 Connecting to: ${url}`);
-    ws = new WebSocket(url);
+    ws = useSSE ? new SSESocket(url) : new WebSocket(url);
+    let opened = false;
 
     ws.addEventListener('open', () => {
+      opened = true;
       retryCount = 0;
       append(`✅ Connected to room ${pin}`, 'system');
       if (title) title.textContent = `Room ${pin}`;
@@ -112,10 +184,55 @@ document.addEventListener('DOMContentLoaded', () => {
             // Ignore heartbeat acks
             return;
           case 'system':
+          case 'idle_warning':
             append(data.msg || ev.data, 'system');
             return;
           case 'chat':
-            append(`${data.user || 'anon'}: ${data.msg ?? ''}`);
+            append(`${data.user || 'anon'}: ${data.msg ?? ''}`, 'normal', data.avatar || '');
+            return;
+          case 'file': {
+            const div = append(`${data.user || 'anon'} shared ${data.filename || 'a file'}`, 'normal', data.avatar || '');
+            const link = document.createElement('a');
+            link.href = data.url;
+            link.target = '_blank';
+            link.rel = 'noopener';
+            // Images link their thumbnail, sized up front so the list does
+            // not jump when it loads.
+            if (data.thumb_url) {
+              const img = document.createElement('img');
+              img.className = 'thumb';
+              img.src = data.thumb_url;
+              img.alt = data.filename || '';
+              img.loading = 'lazy';
+              if (data.width && data.height) {
+                const fit = Math.min(1, 320 / Math.max(data.width, data.height));
+                img.width = Math.round(data.width * fit);
+                img.height = Math.round(data.height * fit);
+              }
+              link.appendChild(img);
+            } else {
+              link.textContent = 'download';
+            }
+            div.appendChild(document.createElement('br'));
+            div.appendChild(link);
+            return;
+          }
+          case 'link_preview': {
+            const p = data.preview || {};
+            append(`🔗 ${p.title || p.url}${p.description ? ' — ' + p.description : ''}`, 'system');
+            return;
+          }
+          case 'mention':
+            append(`🔔 ${data.user} mentioned you`, 'system');
+            return;
+          case 'joined':
+            append(`${data.user} joined`, 'system');
+            return;
+          case 'left':
+            append(`${data.user} ${data.reason === 'client_leave' ? 'left' : 'disconnected'}`, 'system');
+            return;
+          case 'error':
+            append(`⚠️ ${data.detail || data.msg || data.code}`, 'system');
             return;
           default:
             append(ev.data);
@@ -127,12 +244,34 @@ document.addEventListener('DOMContentLoaded', () => {
 
     ws.addEventListener('close', (e) => {
       clearInterval(heartbeatInterval);
-      append(`⚠️ Disconnected from room ${pin} (code ${e.code})`, 'system');
+      append(`⚠️ Disconnected from room ${pin}: ${e.reason || 'connection lost'} (code ${e.code})`, 'system');
       console.log(`WebSocket closed: code=${e.code}, reason=${e.reason}`);
       ws = null;
 
+      // A WebSocket that never opened is probably blocked; retry over SSE.
+      if (!opened && !useSSE && window.EventSource) {
+        useSSE = true;
+        append('WebSocket unavailable, falling back to Server-Sent Events', 'system');
+        reconnectTimeout = setTimeout(() => connectToPin(pin), 0);
+        return;
+      }
+
+      // 1012: the server is draining; reconnect at once, with jitter so
+      // the whole room does not arrive on the next instance together.
+      if (e.code === 1012) {
+        append('Server restarting, reconnecting...', 'system');
+        reconnectTimeout = setTimeout(() => connectToPin(pin), Math.random() * 1000);
+        return;
+      }
+
+      // The server refused or removed us for good: flooding, a wrong
+      // password, a kick or ban, a taken name, a closed room or idling.
+      if (finalCloseCodes.has(e.code)) {
+        return;
+      }
+
       // Reconnect on abnormal closure
-      if (retryCount < maxRetries && e.code !== 1000) {
+      if (retryCount < maxRetries) {
         retryCount++;
         const backoffMs = Math.min(3000 * retryCount, 15000);
         append(`Reconnecting... (attempt ${retryCount}/${maxRetries})`, 'system');
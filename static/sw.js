@@ -0,0 +1,23 @@
+// Service worker for Web Push: shows mentions and dms from rooms the user
+// has left, and opens the chat page when a notification is clicked.
+self.addEventListener('push', (event) => {
+  if (!event.data) return;
+  let n;
+  try {
+    n = event.data.json();
+  } catch {
+    return;
+  }
+  const title = n.type === 'dm'
+    ? `${n.user} sent you a message`
+    : `${n.user} mentioned you in room ${n.room}`;
+  event.waitUntil(self.registration.showNotification(title, {
+    body: n.msg || '',
+    tag: `${n.type}-${n.room}`,
+  }));
+});
+
+self.addEventListener('notificationclick', (event) => {
+  event.notification.close();
+  event.waitUntil(self.clients.openWindow('/chat.html'));
+});
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// httpsRedirect sends every plain-HTTP request to the same host and path
+// over HTTPS on httpsPort.
+func httpsRedirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}